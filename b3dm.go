@@ -3,6 +3,7 @@ package tile3d
 import (
 	"encoding/binary"
 	"io"
+	"math"
 
 	"github.com/flywave/gltf"
 )
@@ -71,16 +72,23 @@ type B3dmFeatureTableView struct {
 	RtcCenter   []float64
 }
 
-func B3dmFeatureTableDecode(header map[string]interface{}, buff []byte) map[string]interface{} {
+func B3dmFeatureTableDecode(header map[string]interface{}, buff []byte) (map[string]interface{}, error) {
 	ret := make(map[string]interface{})
-	l := getIntegerScalarFeatureValue(header, buff, B3DM_PROP_BATCH_LENGTH)
+	l, err := getCountFeatureValue(header, B3DM_PROP_BATCH_LENGTH, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
 	ret[B3DM_PROP_BATCH_LENGTH] = l
-	ret[B3DM_PROP_RTC_CENTER] = getFloat64Vec3FeatureValue(header, buff, B3DM_PROP_RTC_CENTER)
-	return ret
+	rtc, err := getFloat64Vec3FeatureValue(header, buff, B3DM_PROP_RTC_CENTER)
+	if err != nil {
+		return nil, err
+	}
+	ret[B3DM_PROP_RTC_CENTER] = rtc
+	return ret, nil
 }
 
-func B3dmFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) []byte {
-	return nil
+func B3dmFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) ([]byte, error) {
+	return nil, nil
 }
 
 type B3dm struct {
@@ -121,6 +129,12 @@ func (m *B3dm) GetFeatureTableView() B3dmFeatureTableView {
 	return ret
 }
 
+// BatchLength returns the number of features in the tile.
+func (m *B3dm) BatchLength() int {
+	n, _ := m.FeatureTable.getCount(B3DM_PROP_BATCH_LENGTH)
+	return n
+}
+
 func (m *B3dm) GetHeader() Header {
 	return &m.Header
 }
@@ -133,43 +147,70 @@ func (m *B3dm) GetBatchTable() *BatchTable {
 	return &m.BatchTable
 }
 
-func (m *B3dm) CalcSize() int64 {
+func (m *B3dm) CalcSize() (int64, error) {
 	m.FeatureTable.encode = B3dmFeatureTableEncode
-	return m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader()) + calcGltfSize(m.Model, 8)
+	gltfSize, err := calcGltfSize(m.Model, 8)
+	if err != nil {
+		return 0, newTileError(B3DM_MAGIC, "glTF", -1, err)
+	}
+	return m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader()) + gltfSize, nil
 }
 
 func (m *B3dm) Read(reader io.ReadSeeker) error {
-
+	start, _ := reader.Seek(0, io.SeekCurrent)
 	err := binary.Read(reader, littleEndian, &m.Header)
 	if err != nil {
+		return newTileError(B3DM_MAGIC, "header", start, err)
+	}
+	if err := checkMagic(B3DM_MAGIC, start, m.Header.Magic); err != nil {
+		return err
+	}
+	if err := checkVersion(B3DM_MAGIC, start, m.Header.Version); err != nil {
+		return err
+	}
+	if err := checkByteLength(reader, B3DM_MAGIC, start, m.GetHeader()); err != nil {
 		return err
 	}
 
 	m.FeatureTable.decode = B3dmFeatureTableDecode
 
 	if err := m.FeatureTable.Read(reader, m.GetHeader()); err != nil {
-		return err
+		return wrapTileError(B3DM_MAGIC, "featureTable", 0, err)
 	}
 
-	if err := m.BatchTable.Read(reader, m.GetHeader(), m.FeatureTable.GetBatchLength()); err != nil {
-		return err
+	if err := m.BatchTable.Read(reader, m.GetHeader(), m.BatchLength()); err != nil {
+		return wrapTileError(B3DM_MAGIC, "batchTable", 0, err)
 	}
 
-	var err1 error
-	if m.Model, err1 = loadGltfFromByte(reader); err1 != nil {
-		return err1
+	offset, _ := reader.Seek(0, io.SeekCurrent)
+	glb, err := readTileBody(reader, B3DM_MAGIC, "glTF", start, m.Header.ByteLength)
+	if err != nil {
+		return err
+	}
+	if m.Model, err = loadGltfFromByte(glb); err != nil {
+		return newTileError(B3DM_MAGIC, "glTF", offset, err)
 	}
 
 	return nil
 }
 
+// ReadFromBytes reads a tile from data. Binary arrays are views over data
+// where possible, so data must not be modified while m is in use.
+func (m *B3dm) ReadFromBytes(data []byte) error {
+	return m.Read(newSliceReader(data))
+}
+
 func (m *B3dm) Write(writer io.Writer) error {
+	copy(m.Header.Magic[:], B3DM_MAGIC)
+	m.Header.Version = 1
 	m.FeatureTable.encode = B3dmFeatureTableEncode
-	_ = B3dmFeatureTableEncode(m.FeatureTable.Header, m.FeatureTable.Data)
+	if _, err := B3dmFeatureTableEncode(m.FeatureTable.Header, m.FeatureTable.Data); err != nil {
+		return wrapTileError(B3DM_MAGIC, "featureTable", -1, err)
+	}
 
 	buf, err := getGltfBinary(m.Model, 8)
 	if err != nil {
-		return err
+		return newTileError(B3DM_MAGIC, "glTF", -1, err)
 	}
 
 	si := m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader()) + int64(len(buf))
@@ -182,12 +223,12 @@ func (m *B3dm) Write(writer io.Writer) error {
 		return err
 	}
 
-	if err := m.FeatureTable.Write(writer, nil); err != nil {
-		return err
+	if err := m.FeatureTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(B3DM_MAGIC, "featureTable", -1, err)
 	}
 
-	if err := m.BatchTable.Write(writer, nil); err != nil {
-		return err
+	if err := m.BatchTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(B3DM_MAGIC, "batchTable", -1, err)
 	}
 
 	if _, err := writer.Write(buf); err != nil {
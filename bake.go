@@ -0,0 +1,441 @@
+package tile3d
+
+import (
+	"reflect"
+
+	"github.com/flywave/gltf"
+	"github.com/flywave/gltf/modeler"
+)
+
+// I3dmBakeOptions controls the baking of i3dm instances into b3dm tiles.
+type I3dmBakeOptions struct {
+	// MaxVertices limits the number of vertices of every b3dm tile, zero
+	// means no limit. An instance is never split, so a tile holds at least
+	// one instance.
+	MaxVertices int
+}
+
+func NewI3dmBakeOptions() *I3dmBakeOptions {
+	return &I3dmBakeOptions{}
+}
+
+// bakedPrimitive is the geometry of a primitive of the embedded model
+// placed by the global matrix of its node.
+type bakedPrimitive struct {
+	shape    *batchedMesh
+	mode     gltf.PrimitiveMode
+	material *uint32
+	matrix   [16]float64
+}
+
+// bakedGroupKey identifies the primitives merged into one output primitive.
+type bakedGroupKey struct {
+	mode                       gltf.PrimitiveMode
+	material                   int
+	normals, texcoords, colors bool
+}
+
+func (p *bakedPrimitive) key() bakedGroupKey {
+	k := bakedGroupKey{
+		mode:      p.mode,
+		material:  -1,
+		normals:   len(p.shape.normals) > 0,
+		texcoords: len(p.shape.texcoords) > 0,
+		colors:    len(p.shape.colors) > 0,
+	}
+	if p.material != nil {
+		k.material = int(*p.material)
+	}
+	return k
+}
+
+// readBakedShape reads the attributes of p used by a baked mesh. Strips
+// and fans become triangle lists and missing indices are generated.
+func readBakedShape(doc *gltf.Document, p *gltf.Primitive) (*batchedMesh, gltf.PrimitiveMode, error) {
+	accessor := func(name string, index uint32) (*gltf.Accessor, error) {
+		if int(index) >= len(doc.Accessors) {
+			return nil, newTileError("", name, -1, ErrBadReference)
+		}
+		return doc.Accessors[index], nil
+	}
+	pos, ok := p.Attributes[gltf.POSITION]
+	if !ok {
+		return nil, 0, newTileError("", gltf.POSITION, -1, ErrBadReference)
+	}
+	acr, err := accessor(gltf.POSITION, pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	g := &batchedMesh{}
+	if g.positions, err = modeler.ReadPosition(doc, acr, nil); err != nil {
+		return nil, 0, newTileError("", gltf.POSITION, -1, err)
+	}
+	n := len(g.positions)
+	if index, ok := p.Attributes[gltf.NORMAL]; ok {
+		if acr, err = accessor(gltf.NORMAL, index); err != nil {
+			return nil, 0, err
+		}
+		if g.normals, err = modeler.ReadNormal(doc, acr, nil); err != nil || len(g.normals) != n {
+			return nil, 0, newTileError("", gltf.NORMAL, -1, ErrBadValue)
+		}
+	}
+	if index, ok := p.Attributes[gltf.TEXCOORD_0]; ok {
+		if acr, err = accessor(gltf.TEXCOORD_0, index); err != nil {
+			return nil, 0, err
+		}
+		if g.texcoords, err = modeler.ReadTextureCoord(doc, acr, nil); err != nil || len(g.texcoords) != n {
+			return nil, 0, newTileError("", gltf.TEXCOORD_0, -1, ErrBadValue)
+		}
+	}
+	if index, ok := p.Attributes[gltf.COLOR_0]; ok {
+		if acr, err = accessor(gltf.COLOR_0, index); err != nil {
+			return nil, 0, err
+		}
+		if g.colors, err = modeler.ReadColor(doc, acr, nil); err != nil || len(g.colors) != n {
+			return nil, 0, newTileError("", gltf.COLOR_0, -1, ErrBadValue)
+		}
+	}
+
+	var indices []uint32
+	if p.Indices != nil {
+		if acr, err = accessor("indices", *p.Indices); err != nil {
+			return nil, 0, err
+		}
+		if indices, err = modeler.ReadIndices(doc, acr, nil); err != nil {
+			return nil, 0, newTileError("", "indices", -1, err)
+		}
+		for _, i := range indices {
+			if int(i) >= n {
+				return nil, 0, newTileError("", "indices", -1, ErrBadReference)
+			}
+		}
+	} else {
+		indices = make([]uint32, n)
+		for i := range indices {
+			indices[i] = uint32(i)
+		}
+	}
+
+	var mode gltf.PrimitiveMode
+	g.indices, mode, err = listIndices(indices, p.Mode)
+	if err != nil {
+		return nil, 0, err
+	}
+	return g, mode, nil
+}
+
+// listIndices returns the indices of a primitive of the given mode as a
+// list of triangles, lines or points. Strips and fans become triangles.
+func listIndices(indices []uint32, mode gltf.PrimitiveMode) ([]uint32, gltf.PrimitiveMode, error) {
+	var ret []uint32
+	switch mode {
+	case gltf.PrimitiveTriangles:
+		ret = indices[:len(indices)-len(indices)%3]
+	case gltf.PrimitiveLines:
+		ret = indices[:len(indices)-len(indices)%2]
+	case gltf.PrimitivePoints:
+		ret = indices
+	case gltf.PrimitiveTriangleStrip:
+		mode = gltf.PrimitiveTriangles
+		for i := 2; i < len(indices); i++ {
+			if i%2 == 0 {
+				ret = append(ret, indices[i-2], indices[i-1], indices[i])
+			} else {
+				ret = append(ret, indices[i-1], indices[i-2], indices[i])
+			}
+		}
+	case gltf.PrimitiveTriangleFan:
+		mode = gltf.PrimitiveTriangles
+		for i := 2; i < len(indices); i++ {
+			ret = append(ret, indices[0], indices[i-1], indices[i])
+		}
+	default:
+		return nil, 0, newTileError("", "mode", -1, ErrBadValue)
+	}
+	return ret, mode, nil
+}
+
+// bakedPrimitives returns the primitives of the scene of doc with the
+// global matrices of their nodes.
+func bakedPrimitives(doc *gltf.Document) ([]*bakedPrimitive, error) {
+	var ret []*bakedPrimitive
+	shapes := make(map[*gltf.Primitive]*bakedPrimitive)
+	for _, mn := range gltfMeshNodes(doc) {
+		if int(mn.mesh) >= len(doc.Meshes) {
+			return nil, newTileError("", "mesh", -1, ErrBadReference)
+		}
+		for _, p := range doc.Meshes[mn.mesh].Primitives {
+			bp, ok := shapes[p]
+			if !ok {
+				shape, mode, err := readBakedShape(doc, p)
+				if err != nil {
+					return nil, err
+				}
+				bp = &bakedPrimitive{shape: shape, mode: mode, material: p.Material}
+				shapes[p] = bp
+			}
+			ret = append(ret, &bakedPrimitive{shape: bp.shape, mode: bp.mode, material: bp.material, matrix: mn.matrix})
+		}
+	}
+	return ret, nil
+}
+
+// resetGltfBuffers removes the accessors, buffer views and buffers of doc
+// and writes back the images stored in buffer views.
+func resetGltfBuffers(doc *gltf.Document) error {
+	images := make([][]byte, len(doc.Images))
+	for i, img := range doc.Images {
+		if img.BufferView == nil {
+			continue
+		}
+		if int(*img.BufferView) >= len(doc.BufferViews) {
+			return newTileError("", "image", -1, ErrBadReference)
+		}
+		var err error
+		if images[i], err = modeler.ReadBufferView(doc, doc.BufferViews[*img.BufferView]); err != nil {
+			return err
+		}
+	}
+	doc.Accessors = nil
+	doc.BufferViews = nil
+	doc.Buffers = nil
+	for i, img := range doc.Images {
+		if img.BufferView != nil {
+			img.BufferView = gltf.Index(modeler.WriteBufferView(doc, gltf.TargetNone, images[i]))
+		}
+	}
+	return nil
+}
+
+// newBakedGltf returns a copy of model whose scene is a single mesh made
+// of the primitives placed by every transform of instances with the batch
+// id of the instance. Materials, textures and images of model are kept.
+func newBakedGltf(model *gltf.Document, prims []*bakedPrimitive, transforms [][16]float64, ids []uint32) (*gltf.Document, error) {
+	doc, err := copyGltf(model)
+	if err != nil {
+		return nil, err
+	}
+	if err := resetGltfBuffers(doc); err != nil {
+		return nil, err
+	}
+
+	var keys []bakedGroupKey
+	groups := make(map[bakedGroupKey]*batchedMesh)
+	for i, t := range transforms {
+		for _, p := range prims {
+			k := p.key()
+			g, ok := groups[k]
+			if !ok {
+				g = &batchedMesh{}
+				groups[k] = g
+				keys = append(keys, k)
+			}
+			m := mat4Mul(t, p.matrix)
+			base, det := g.addVertices(p.shape, m, float32(ids[i]))
+			// Mirroring transforms reverse the winding of triangles.
+			flip := p.mode == gltf.PrimitiveTriangles && det < 0
+			idx := p.shape.indices
+			for j, v := range idx {
+				if flip && j%3 == 1 {
+					v = idx[j+1]
+				} else if flip && j%3 == 2 {
+					v = idx[j-1]
+				}
+				g.indices = append(g.indices, base+v)
+			}
+		}
+	}
+
+	mesh := &gltf.Mesh{}
+	for _, k := range keys {
+		prim := groups[k].primitive(doc, k.mode)
+		prim.Material = nil
+		if k.material >= 0 {
+			prim.Material = gltf.Index(uint32(k.material))
+		}
+		mesh.Primitives = append(mesh.Primitives, prim)
+	}
+	doc.Meshes = []*gltf.Mesh{mesh}
+	doc.Nodes = []*gltf.Node{{Mesh: gltf.Index(0)}}
+	doc.Scenes = []*gltf.Scene{{Nodes: []uint32{0}}}
+	doc.Scene = gltf.Index(0)
+	doc.Skins = nil
+	doc.Animations = nil
+	doc.Cameras = nil
+	removeGltfExtensions(doc, EXT_MESH_GPU_INSTANCING)
+	return doc, nil
+}
+
+// subsetBatchTable returns the rows of t in order. The instances of
+// 3DTILES_batch_table_hierarchy are reordered the same way, followed by
+// the instances that are not features.
+func subsetBatchTable(t *BatchTable, rows []uint32, count int) (*BatchTable, error) {
+	ret := &BatchTable{Header: make(map[string]interface{}), Data: make(map[string]interface{})}
+	for k, v := range t.Header {
+		switch ref := v.(type) {
+		case BinaryBodyReference:
+			size := ContainerTypeSize(ref.ContainerType)
+			values := reflect.ValueOf(t.Data[k])
+			if size == 0 || values.Kind() != reflect.Slice || values.Len() < count*size {
+				return nil, newTileError("", k, -1, ErrBadValue)
+			}
+			data := reflect.MakeSlice(values.Type(), 0, len(rows)*size)
+			for _, r := range rows {
+				data = reflect.AppendSlice(data, values.Slice(int(r)*size, int(r+1)*size))
+			}
+			ref.ByteOffset = 0
+			ret.Header[k] = ref
+			ret.Data[k] = data.Interface()
+		case []interface{}:
+			if len(ref) < count {
+				return nil, newTileError("", k, -1, ErrBadValue)
+			}
+			values := make([]interface{}, len(rows))
+			for i, r := range rows {
+				values[i] = ref[r]
+			}
+			ret.Header[k] = values
+		case map[string]interface{}:
+			if k != "extensions" {
+				ret.Header[k] = v
+			}
+		default:
+			ret.Header[k] = v
+		}
+	}
+
+	if exts, ok := t.Header["extensions"].(map[string]interface{}); ok {
+		rest := make(map[string]interface{})
+		for e, v := range exts {
+			if e != BATCH_TABLE_HIERARCHY {
+				rest[e] = v
+			}
+		}
+		if len(rest) > 0 {
+			ret.Header["extensions"] = rest
+		}
+	}
+	h, err := t.Hierarchy()
+	if err != nil {
+		return nil, err
+	}
+	if h != nil {
+		if h, err = h.subset(rows, count); err != nil {
+			return nil, err
+		}
+		if err := ret.SetHierarchy(h); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Bake applies every instance transform of m to a copy of the geometry of
+// the embedded model and returns the merged meshes as a b3dm. The batch id
+// of an instance becomes the _BATCHID of its vertices, so the batch table
+// of m is kept as is. When the vertices exceed opts.MaxVertices, the
+// instances are split into several b3dm tiles of a cmpt, each with the
+// rows of the batch table its instances reference.
+func (m *I3dm) Bake(opts *I3dmBakeOptions) (TileModel, error) {
+	if opts == nil {
+		opts = NewI3dmBakeOptions()
+	}
+	if m.Model == nil {
+		return nil, newTileError(I3DM_MAGIC, "glTF", -1, ErrMissingModel)
+	}
+	transforms, err := m.InstanceTransforms()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := m.BatchIds()
+	if err != nil {
+		return nil, err
+	}
+	batchLength := m.BatchLength()
+	if ids == nil {
+		ids = make([]uint32, len(transforms))
+		for i := range ids {
+			ids[i] = uint32(i)
+		}
+	}
+	prims, err := bakedPrimitives(m.Model)
+	if err != nil {
+		return nil, wrapTileError(I3DM_MAGIC, "glTF", -1, err)
+	}
+
+	// The baked vertices are relative to the center like the instances.
+	center := m.center()
+	var rtc []float64
+	if center != [3]float64{} {
+		rtc = center[:]
+	}
+	for i := range transforms {
+		for j := 0; j < 3; j++ {
+			transforms[i][12+j] -= center[j]
+		}
+		transforms[i] = mat4Mul(zUpToYUp, mat4Mul(transforms[i], yUpToZUp))
+	}
+
+	chunk := len(transforms)
+	if opts.MaxVertices > 0 {
+		vertices := 0
+		for _, p := range prims {
+			vertices += len(p.shape.positions)
+		}
+		if vertices > 0 {
+			chunk = opts.MaxVertices / vertices
+		}
+		if chunk < 1 {
+			chunk = 1
+		}
+	}
+	if chunk >= len(transforms) {
+		doc, err := newBakedGltf(m.Model, prims, transforms, ids)
+		if err != nil {
+			return nil, wrapTileError(B3DM_MAGIC, "glTF", -1, err)
+		}
+		b := NewB3dm()
+		b.Model = doc
+		b.BatchTable = m.BatchTable
+		b.SetFeatureTable(B3dmFeatureTableView{BatchLength: batchLength, RtcCenter: rtc})
+		return b, nil
+	}
+
+	c := NewCmpt()
+	for start := 0; start < len(transforms); start += chunk {
+		end := start + chunk
+		if end > len(transforms) {
+			end = len(transforms)
+		}
+		// Batch ids are renumbered in the order instances reference them.
+		var rows []uint32
+		local := make(map[uint32]uint32)
+		chunkIds := make([]uint32, end-start)
+		for i, id := range ids[start:end] {
+			l, ok := local[id]
+			if !ok {
+				l = uint32(len(rows))
+				local[id] = l
+				rows = append(rows, id)
+			}
+			chunkIds[i] = l
+		}
+		doc, err := newBakedGltf(m.Model, prims, transforms[start:end], chunkIds)
+		if err != nil {
+			return nil, wrapTileError(B3DM_MAGIC, "glTF", -1, err)
+		}
+		b := NewB3dm()
+		b.Model = doc
+		if len(m.BatchTable.Header) > 0 {
+			t, err := subsetBatchTable(&m.BatchTable, rows, batchLength)
+			if err != nil {
+				return nil, wrapTileError(B3DM_MAGIC, "batchTable", -1, err)
+			}
+			b.BatchTable = *t
+		}
+		b.SetFeatureTable(B3dmFeatureTableView{BatchLength: len(rows), RtcCenter: rtc})
+		c.Tiles = append(c.Tiles, b)
+	}
+	c.Header.TilesLength = uint32(len(c.Tiles))
+	return c, nil
+}
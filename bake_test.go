@@ -0,0 +1,166 @@
+package tile3d
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/flywave/gltf"
+	"github.com/flywave/gltf/modeler"
+)
+
+func newBakeI3dm() *I3dm {
+	m := &I3dm{Model: openGltf("./data/box.glb")}
+	m.Header.GltfFormat = I3DM_GLTF_EMBEDDED
+	m.SetFeatureTable(I3dmFeatureTableView{
+		Position:        [][3]float32{{10, 0, 0}, {0, 20, 0}, {0, 0, 30}},
+		RtcCenter:       []float64{100, 200, 300},
+		ScaleNONUniform: [][3]float32{{-1, 1, 1}, {1, 1, 1}, {2, 2, 2}},
+		BatchId:         []uint16{2, 0, 2},
+		InstanceLength:  3,
+	})
+	m.BatchTable.Header = map[string]interface{}{
+		"name":   []interface{}{"a", "b", "c"},
+		"height": BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC2},
+	}
+	m.BatchTable.Data = map[string]interface{}{"height": []float32{1, 2, 3, 4, 5, 6}}
+	return m
+}
+
+func bakedVertices(t *testing.T, m *B3dm) ([][3]float32, []float32) {
+	t.Helper()
+	var positions [][3]float32
+	var ids []float32
+	for _, p := range m.Model.Meshes[0].Primitives {
+		pos, err := modeler.ReadPosition(m.Model, m.Model.Accessors[p.Attributes[gltf.POSITION]], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := modeler.ReadAccessor(m.Model, m.Model.Accessors[p.Attributes[GLTF_ATTR_BATCHID]], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions = append(positions, pos...)
+		ids = append(ids, data.([]float32)...)
+	}
+	return positions, ids
+}
+
+func TestI3dmBake(t *testing.T) {
+	m := newBakeI3dm()
+	prims, err := bakedPrimitives(m.Model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var src [][3]float32
+	for _, p := range prims {
+		src = append(src, p.shape.positions...)
+	}
+
+	tile, err := m.Bake(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, ok := tile.(*B3dm)
+	if !ok {
+		t.Fatalf("tile %T", tile)
+	}
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := NewB3dm()
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if r.BatchLength() != 3 {
+		t.Errorf("batch length %d", r.BatchLength())
+	}
+	if rtc, ok := r.FeatureTable.getVec3(B3DM_PROP_RTC_CENTER); !ok || rtc != [3]float64{100, 200, 300} {
+		t.Errorf("rtc %v", rtc)
+	}
+	if names := r.BatchTable.Header["name"].([]interface{}); len(names) != 3 {
+		t.Errorf("names %v", names)
+	}
+
+	positions, ids := bakedVertices(t, r)
+	if len(positions) != 3*len(src) {
+		t.Fatalf("vertices %d, want %d", len(positions), 3*len(src))
+	}
+	// The first instance mirrors x and is offset by 10 along x, which is x
+	// in y-up too. The third doubles the model and is offset by 30 along z,
+	// which is y in y-up.
+	n := len(src)
+	for i, p := range src {
+		if ids[i] != 2 || ids[n+i] != 0 || ids[2*n+i] != 2 {
+			t.Fatalf("batch ids %v %v %v", ids[i], ids[n+i], ids[2*n+i])
+		}
+		want := [][3]float32{
+			{10 - p[0], p[1], p[2]},
+			{p[0], p[1], p[2] - 20},
+			{2 * p[0], 2*p[1] + 30, 2 * p[2]},
+		}
+		for j, w := range want {
+			got := positions[j*n+i]
+			for k := range w {
+				if math.Abs(float64(got[k]-w[k])) > 1e-4 {
+					t.Fatalf("instance %d vertex %d: %v != %v", j, i, got, w)
+				}
+			}
+		}
+	}
+}
+
+func TestI3dmBakeSplit(t *testing.T) {
+	m := newBakeI3dm()
+	prims, err := bakedPrimitives(m.Model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vertices := 0
+	for _, p := range prims {
+		vertices += len(p.shape.positions)
+	}
+
+	tile, err := m.Bake(&I3dmBakeOptions{MaxVertices: 2 * vertices})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := tile.(*Cmpt)
+	if !ok {
+		t.Fatalf("tile %T", tile)
+	}
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := NewCmpt()
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Tiles) != 2 {
+		t.Fatalf("tiles %d", len(r.Tiles))
+	}
+
+	// Instances 0 and 1 reference rows 2 and 0, instance 2 row 2.
+	first, second := r.Tiles[0].(*B3dm), r.Tiles[1].(*B3dm)
+	if first.BatchLength() != 2 || second.BatchLength() != 1 {
+		t.Errorf("batch lengths %d %d", first.BatchLength(), second.BatchLength())
+	}
+	if names := first.BatchTable.Header["name"].([]interface{}); names[0] != "c" || names[1] != "a" {
+		t.Errorf("names %v", names)
+	}
+	if h := first.BatchTable.Data["height"].([]float32); len(h) != 4 || h[0] != 5 || h[2] != 1 {
+		t.Errorf("height %v", h)
+	}
+	if h := second.BatchTable.Data["height"].([]float32); len(h) != 2 || h[1] != 6 {
+		t.Errorf("height %v", h)
+	}
+	_, ids := bakedVertices(t, first)
+	if len(ids) != 2*vertices || ids[0] != 0 || ids[vertices] != 1 {
+		t.Errorf("batch ids %v", ids)
+	}
+	if _, ids := bakedVertices(t, second); len(ids) != vertices || ids[0] != 0 {
+		t.Errorf("batch ids %v", ids)
+	}
+}
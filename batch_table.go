@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"sort"
 )
 
 const (
@@ -27,19 +28,25 @@ type BatchTable struct {
 	Data   map[string]interface{}
 }
 
-func transformBinaryBodyReference(m map[string]interface{}) map[string]interface{} {
+func transformBinaryBodyReference(m map[string]interface{}) (map[string]interface{}, error) {
 	ref := make(map[string]interface{})
 	for k, v := range m {
 		switch tp := v.(type) {
 		case map[string]interface{}:
+			if _, ok := tp[REF_PROP_BYTE_OFFSET]; !ok {
+				ref[k] = v
+				continue
+			}
 			r := new(BinaryBodyReference)
-			r.FromMap(tp)
+			if err := r.FromMap(tp); err != nil {
+				return nil, newTileError("", k, -1, err)
+			}
 			ref[k] = *r
 		default:
 			ref[k] = v
 		}
 	}
-	return ref
+	return ref, nil
 }
 
 func (t *BatchTable) readJSONHeader(data io.Reader) error {
@@ -48,8 +55,9 @@ func (t *BatchTable) readJSONHeader(data io.Reader) error {
 	if err := dec.Decode(&t.Header); err != nil {
 		return err
 	}
-	t.Header = transformBinaryBodyReference(t.Header)
-	return nil
+	var err error
+	t.Header, err = transformBinaryBodyReference(t.Header)
+	return err
 }
 
 func (t *BatchTable) writeJSONHeader(wr io.Writer) error {
@@ -76,46 +84,23 @@ func (h *BatchTable) CalcSize(header Header) int64 {
 	return int64(w.GetSize())
 }
 
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetProperty returns the value of property for the feature batchId, or nil
+// when either does not exist. See BatchTableColumn.Value for the types.
 func (h *BatchTable) GetProperty(property string, batchId int) interface{} {
-	ret := h.Data[property]
-	if ret == nil {
+	v, err := h.Value(property, batchId)
+	if err != nil {
 		return nil
 	}
-	switch t := h.Data[property].(type) {
-	case byte:
-		return t
-	case []byte:
-		return t
-	case int8:
-		return t
-	case []int8:
-		return t
-	case int16:
-		return t
-	case []int16:
-		return t
-	case uint16:
-		return t
-	case []uint16:
-		return t
-	case int32:
-		return t
-	case []int32:
-		return t
-	case uint32:
-		return t
-	case []uint32:
-		return t
-	case float32:
-		return t
-	case []float32:
-		return t
-	case float64:
-		return t
-	case []float64:
-		return t
-	}
-	return nil
+	return v
 }
 
 func (h *BatchTable) Read(reader io.ReadSeeker, header Header, batchLength int) error {
@@ -130,32 +115,47 @@ func (h *BatchTable) Read(reader io.ReadSeeker, header Header, batchLength int)
 		return nil
 	}
 
-	jsonb := make([]byte, jsonLen)
-	if _, err := reader.Read(jsonb); err != nil {
-		return err
+	offset, _ := reader.Seek(0, io.SeekCurrent)
+	jsonb, err := readSection(reader, int(jsonLen))
+	if err != nil {
+		return newTileError("", "batchTable JSON", offset, err)
 	}
 
 	jsonr := bytes.NewReader(jsonb)
 	if err := h.readJSONHeader(jsonr); err != nil {
-		return err
+		return wrapTileError("", "batchTable JSON", offset, err)
 	}
 
-	batchdata := make([]byte, header.GetBatchTableBinaryByteLength())
-	if _, err := reader.Read(batchdata); err != nil {
-		return err
+	offset += int64(jsonLen)
+	batchdata, err := readSection(reader, int(header.GetBatchTableBinaryByteLength()))
+	if err != nil {
+		return newTileError("", "batchTable binary", offset, err)
+	}
+	compressed, err := getDracoProperties(h.Header)
+	if err != nil {
+		return wrapTileError("", "batchTable JSON", offset, err)
 	}
 	h.Data = make(map[string]interface{})
 	for k, v := range h.Header {
 		switch t := v.(type) {
 		case BinaryBodyReference:
-			h.Data[k] = getBatchTableValuesFromRef(&t, batchdata, k, batchLength)
+			if _, ok := compressed[k]; ok {
+				continue
+			}
+			value, err := getBatchTableValuesFromRef(&t, batchdata, k, batchLength)
+			if err != nil {
+				return wrapTileError("", k, offset, err)
+			}
+			h.Data[k] = value
 		case []interface{}:
 			h.Data[k] = t
 		default:
 			continue
 		}
 	}
-
+	if err := h.readHierarchy(batchdata); err != nil {
+		return wrapTileError("", BATCH_TABLE_HIERARCHY, offset, err)
+	}
 	return nil
 }
 
@@ -164,16 +164,37 @@ func (h *BatchTable) Write(writer io.Writer, header Header) error {
 	JSONLenght := 0
 	offset := 0
 	outJSONHeader := make(map[string]interface{})
-	for k, v := range h.Header {
-		switch t := v.(type) {
+	compressed, err := getDracoProperties(h.Header)
+	if err != nil {
+		return err
+	}
+	// Keys are sorted so the padding between binary properties, and so the
+	// size, is the same on every call.
+	for _, k := range sortedKeys(h.Header) {
+		switch t := h.Header[k].(type) {
 		case BinaryBodyReference:
+			if _, ok := compressed[k]; ok {
+				t.ByteOffset = 0
+				outJSONHeader[k] = t.GetMap()
+				continue
+			}
+			bts, err := getBatchTableBinaryByte(&t, h.Data[k])
+			if err != nil {
+				return wrapTileError("", k, -1, err)
+			}
+			// Properties start at a multiple of their component size.
+			if size := ComponentTypeSize(t.ComponentType); size > 1 {
+				if pad := int(calcPadding(uint32(offset), uint32(size))); pad > 0 {
+					outBinaryBytes = append(outBinaryBytes, make([]byte, pad))
+					offset += pad
+				}
+			}
 			t.ByteOffset = uint32(offset)
 			outJSONHeader[k] = t.GetMap()
-			bts := getBatchTableBinaryByte(&t, h.Data[k])
 			offset += len(bts)
 			outBinaryBytes = append(outBinaryBytes, bts)
 		default:
-			outJSONHeader[k] = v
+			outJSONHeader[k] = h.Header[k]
 		}
 	}
 	var BinaryLenght int
@@ -188,6 +209,10 @@ func (h *BatchTable) Write(writer io.Writer, header Header) error {
 			}
 			JSONLenght = len(bts)
 		}
+		// The binary body ends on an 8 byte boundary like the JSON header.
+		if pad := calcPadding(uint32(offset), 8); offset > 0 && pad > 0 {
+			outBinaryBytes = append(outBinaryBytes, make([]byte, pad))
+		}
 		for i := range outBinaryBytes {
 			BinaryLenght += len(outBinaryBytes[i])
 			if _, err := writer.Write(outBinaryBytes[i]); err != nil {
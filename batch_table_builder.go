@@ -0,0 +1,217 @@
+package tile3d
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+)
+
+const BATCH_TABLE_TAG = "batch"
+
+// BatchTableBuilder assembles a batch table of a fixed number of features
+// column by column. Numbers and fixed size arrays of 2 to 4 numbers are
+// stored in the binary body with the smallest component type holding all
+// values, anything else is stored as JSON.
+type BatchTableBuilder struct {
+	count int
+	table BatchTable
+}
+
+func NewBatchTableBuilder(count int) *BatchTableBuilder {
+	return &BatchTableBuilder{
+		count: count,
+		table: BatchTable{Header: make(map[string]interface{}), Data: make(map[string]interface{})},
+	}
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// binaryContainerType returns the container type of the elements of a
+// slice of type tp, or "" when they are not stored in the binary body.
+func binaryContainerType(tp reflect.Type) string {
+	elem := tp.Elem()
+	if isNumberKind(elem.Kind()) {
+		return CONTAINER_TYPE_SCALAR
+	}
+	if elem.Kind() != reflect.Array || !isNumberKind(elem.Elem().Kind()) {
+		return ""
+	}
+	switch elem.Len() {
+	case 2:
+		return CONTAINER_TYPE_VEC2
+	case 3:
+		return CONTAINER_TYPE_VEC3
+	case 4:
+		return CONTAINER_TYPE_VEC4
+	}
+	return ""
+}
+
+// smallestComponentType returns the smallest component type holding the
+// numbers of kind k in [min, max]. Floats stay floats and integers out of
+// the 32 bit range become DOUBLE.
+func smallestComponentType(k reflect.Kind, min, max float64, float32Exact bool) string {
+	switch {
+	case k == reflect.Float32 || (k == reflect.Float64 && float32Exact):
+		return COMPONENT_TYPE_FLOAT
+	case k == reflect.Float64:
+		return COMPONENT_TYPE_DOUBLE
+	case min >= 0 && max <= math.MaxUint8:
+		return COMPONENT_TYPE_UNSIGNED_BYTE
+	case min >= math.MinInt8 && max <= math.MaxInt8:
+		return COMPONENT_TYPE_BYTE
+	case min >= 0 && max <= math.MaxUint16:
+		return COMPONENT_TYPE_UNSIGNED_SHORT
+	case min >= math.MinInt16 && max <= math.MaxInt16:
+		return COMPONENT_TYPE_SHORT
+	case min >= 0 && max <= math.MaxUint32:
+		return COMPONENT_TYPE_UNSIGNED_INT
+	case min >= math.MinInt32 && max <= math.MaxInt32:
+		return COMPONENT_TYPE_INT
+	}
+	return COMPONENT_TYPE_DOUBLE
+}
+
+// AddColumn adds the property name with one value per feature. values is
+// a slice, like []uint16, [][3]float32 or []string.
+func (b *BatchTableBuilder) AddColumn(name string, values interface{}) error {
+	return b.addColumn(name, values, false)
+}
+
+// AddJSONColumn adds the property name with one value per feature stored
+// as JSON whatever its type.
+func (b *BatchTableBuilder) AddJSONColumn(name string, values interface{}) error {
+	return b.addColumn(name, values, true)
+}
+
+func (b *BatchTableBuilder) addColumn(name string, values interface{}, asJSON bool) error {
+	rv := reflect.ValueOf(values)
+	if name == "" || b.table.Header[name] != nil || !rv.IsValid() || rv.Kind() != reflect.Slice {
+		return newTileError("", name, -1, ErrBadValue)
+	}
+	if rv.Len() != b.count {
+		return newTileError("", name, -1, ErrBadValue)
+	}
+	containerType := binaryContainerType(rv.Type())
+	if asJSON || containerType == "" {
+		// Values go through JSON so they have the types read from a tile.
+		buf, err := json.Marshal(values)
+		if err != nil {
+			return newTileError("", name, -1, err)
+		}
+		var column []interface{}
+		if err := json.Unmarshal(buf, &column); err != nil {
+			return newTileError("", name, -1, err)
+		}
+		b.table.Header[name] = column
+		b.table.Data[name] = column
+		return nil
+	}
+
+	size := ContainerTypeSize(containerType)
+	numbers := make([]float64, 0, rv.Len()*size)
+	for i := 0; i < rv.Len(); i++ {
+		e := rv.Index(i)
+		if size == 1 {
+			numbers = append(numbers, e.Convert(float64Type).Float())
+			continue
+		}
+		for j := 0; j < size; j++ {
+			numbers = append(numbers, e.Index(j).Convert(float64Type).Float())
+		}
+	}
+	min, max := 0.0, 0.0
+	if len(numbers) > 0 {
+		min, max = numbers[0], numbers[0]
+	}
+	float32Exact := true
+	for _, f := range numbers {
+		min, max = math.Min(min, f), math.Max(max, f)
+		if float64(float32(f)) != f && !math.IsNaN(f) {
+			float32Exact = false
+		}
+	}
+	kind := rv.Type().Elem().Kind()
+	if size > 1 {
+		kind = rv.Type().Elem().Elem().Kind()
+	}
+	componentType := smallestComponentType(kind, min, max, float32Exact)
+	data := reflect.MakeSlice(componentSliceType(componentType), len(numbers), len(numbers))
+	elem := data.Type().Elem()
+	for i, f := range numbers {
+		data.Index(i).Set(reflect.ValueOf(f).Convert(elem))
+	}
+	b.table.Header[name] = BinaryBodyReference{ComponentType: componentType, ContainerType: containerType}
+	b.table.Data[name] = data.Interface()
+	return nil
+}
+
+// AddStructs adds a column for every exported field of rows, a slice of
+// structs or of pointers to structs with one element per feature. The
+// batch tag of a field sets its property name, "-" skips the field and the
+// json option stores it as JSON:
+//
+//	Height float32 `batch:"height"`
+//	Tags   []int   `batch:"tags,json"`
+func (b *BatchTableBuilder) AddStructs(rows interface{}) error {
+	rv := reflect.ValueOf(rows)
+	if !rv.IsValid() || rv.Kind() != reflect.Slice {
+		return newTileError("", "rows", -1, ErrBadValue)
+	}
+	tp := rv.Type().Elem()
+	ptr := tp.Kind() == reflect.Ptr
+	if ptr {
+		tp = tp.Elem()
+	}
+	if tp.Kind() != reflect.Struct {
+		return newTileError("", "rows", -1, ErrBadValue)
+	}
+	for f := 0; f < tp.NumField(); f++ {
+		field := tp.Field(f)
+		if !field.IsExported() {
+			continue
+		}
+		name, asJSON := field.Name, false
+		if tag, ok := field.Tag.Lookup(BATCH_TABLE_TAG); ok {
+			opts := strings.Split(tag, ",")
+			if opts[0] == "-" {
+				continue
+			}
+			if opts[0] != "" {
+				name = opts[0]
+			}
+			for _, o := range opts[1:] {
+				asJSON = asJSON || o == "json"
+			}
+		}
+		column := reflect.MakeSlice(reflect.SliceOf(field.Type), rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			row := rv.Index(i)
+			if ptr {
+				if row.IsNil() {
+					return newTileError("", name, -1, ErrBadValue)
+				}
+				row = row.Elem()
+			}
+			column.Index(i).Set(row.Field(f))
+		}
+		if err := b.addColumn(name, column.Interface(), asJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Build returns the batch table. The builder must not be used afterwards.
+func (b *BatchTableBuilder) Build() *BatchTable {
+	return &b.table
+}
@@ -0,0 +1,84 @@
+package tile3d
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBatchTableBuilder(t *testing.T) {
+	type room struct {
+		Name   string     `batch:"name"`
+		Area   float64    `batch:"area"`
+		Level  int        `batch:"level"`
+		Center [3]float32 `batch:"center"`
+		Doors  []int      `batch:"doors"`
+		Code   int        `batch:"code,json"`
+		Note   string     `batch:"-"`
+		Id     uint32
+		secret int
+	}
+	rooms := []*room{
+		{Name: "a", Area: 10.5, Level: -1, Center: [3]float32{1, 2, 3}, Doors: []int{1}, Code: 7, Id: 70000},
+		{Name: "b", Area: 0.1, Level: 3, Center: [3]float32{4, 5, 6}, Doors: []int{}, Code: 8, Id: 1},
+	}
+	b := NewBatchTableBuilder(2)
+	if err := b.AddStructs(rooms); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddColumn("score", []uint16{1, 300}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddColumn("short", []int{1}); !errors.Is(err, ErrBadValue) {
+		t.Errorf("short column %v", err)
+	}
+	if err := b.AddColumn("name", []string{"x", "y"}); !errors.Is(err, ErrBadValue) {
+		t.Errorf("duplicate column %v", err)
+	}
+	bt := b.Build()
+
+	wantTypes := map[string]string{
+		"area":   COMPONENT_TYPE_DOUBLE,
+		"level":  COMPONENT_TYPE_BYTE,
+		"center": COMPONENT_TYPE_FLOAT,
+		"Id":     COMPONENT_TYPE_UNSIGNED_INT,
+		"score":  COMPONENT_TYPE_UNSIGNED_SHORT,
+	}
+	for k, v := range bt.Header {
+		ref, ok := v.(BinaryBodyReference)
+		if ok != (wantTypes[k] != "") || (ok && ref.ComponentType != wantTypes[k]) {
+			t.Errorf("%s: %v", k, v)
+		}
+	}
+	if _, ok := bt.Header["Note"]; ok || len(bt.Header) != 8 {
+		t.Errorf("columns %v", bt.Header)
+	}
+
+	m := NewB3dm()
+	m.Model = openGltf("./data/box.glb")
+	m.SetFeatureTable(B3dmFeatureTableView{BatchLength: 2})
+	m.BatchTable = *bt
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := NewB3dm()
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	f := r.BatchTable.Feature(0)
+	want := map[string]interface{}{
+		"name":   "a",
+		"area":   10.5,
+		"level":  int8(-1),
+		"center": []float32{1, 2, 3},
+		"doors":  []interface{}{1.0},
+		"code":   7.0,
+		"Id":     uint32(70000),
+		"score":  uint16(1),
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("feature %v", f)
+	}
+}
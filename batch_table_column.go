@@ -0,0 +1,296 @@
+package tile3d
+
+import (
+	"math"
+	"reflect"
+)
+
+// BatchTableColumn is a per-feature view of a batch table property, stored
+// either in the binary body or as a JSON array.
+type BatchTableColumn struct {
+	Name string
+	// ComponentType and ContainerType describe a binary property, they are
+	// empty for a JSON property.
+	ComponentType string
+	ContainerType string
+
+	values reflect.Value
+	size   int
+}
+
+// IsBinary reports whether the property is stored in the binary body.
+func (c *BatchTableColumn) IsBinary() bool {
+	return c.ContainerType != ""
+}
+
+// Len returns the number of features of the column.
+func (c *BatchTableColumn) Len() int {
+	return c.values.Len() / c.size
+}
+
+// Value returns the value of feature id. Binary scalars are returned as
+// their component type and binary vectors as a slice of it, like []float32
+// for a VEC3 of FLOAT. JSON values are returned as decoded.
+func (c *BatchTableColumn) Value(id int) (interface{}, error) {
+	if id < 0 || id >= c.Len() {
+		return nil, newTileError("", c.Name, -1, ErrBadReference)
+	}
+	if c.size == 1 {
+		return c.values.Index(id).Interface(), nil
+	}
+	return c.values.Slice(id*c.size, (id+1)*c.size).Interface(), nil
+}
+
+// Float64s returns the components of feature id as float64, for a number
+// or an array of numbers.
+func (c *BatchTableColumn) Float64s(id int) ([]float64, bool) {
+	v, err := c.Value(id)
+	if err != nil {
+		return nil, false
+	}
+	if n, ok := v.(float64); ok {
+		return []float64{n}, true
+	}
+	if a, ok := v.([]interface{}); ok {
+		ret := make([]float64, len(a))
+		for i := range a {
+			if ret[i], ok = a[i].(float64); !ok {
+				return nil, false
+			}
+		}
+		return ret, true
+	}
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil, false
+	}
+	if rv.Kind() != reflect.Slice {
+		rv = reflect.Append(reflect.MakeSlice(reflect.SliceOf(rv.Type()), 0, 1), rv)
+	}
+	ret := make([]float64, rv.Len())
+	for i := range ret {
+		e := rv.Index(i)
+		if !e.CanConvert(float64Type) {
+			return nil, false
+		}
+		ret[i] = e.Convert(float64Type).Float()
+	}
+	return ret, true
+}
+
+// Float64 returns the value of feature id for a numeric scalar property.
+func (c *BatchTableColumn) Float64(id int) (float64, bool) {
+	v, ok := c.Float64s(id)
+	if !ok || len(v) != 1 {
+		return 0, false
+	}
+	return v[0], true
+}
+
+// String returns the value of feature id for a string property.
+func (c *BatchTableColumn) String(id int) (string, bool) {
+	v, err := c.Value(id)
+	if err != nil {
+		return "", false
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+var float64Type = reflect.TypeOf(float64(0))
+
+// componentSliceType returns the Go slice type of a binary component type.
+func componentSliceType(componentType string) reflect.Type {
+	switch componentType {
+	case COMPONENT_TYPE_BYTE:
+		return reflect.TypeOf([]int8{})
+	case COMPONENT_TYPE_UNSIGNED_BYTE:
+		return reflect.TypeOf([]uint8{})
+	case COMPONENT_TYPE_SHORT:
+		return reflect.TypeOf([]int16{})
+	case COMPONENT_TYPE_UNSIGNED_SHORT:
+		return reflect.TypeOf([]uint16{})
+	case COMPONENT_TYPE_INT:
+		return reflect.TypeOf([]int32{})
+	case COMPONENT_TYPE_UNSIGNED_INT:
+		return reflect.TypeOf([]uint32{})
+	case COMPONENT_TYPE_FLOAT:
+		return reflect.TypeOf([]float32{})
+	case COMPONENT_TYPE_DOUBLE:
+		return reflect.TypeOf([]float64{})
+	}
+	return nil
+}
+
+// Column returns the property name of the batch table.
+func (t *BatchTable) Column(name string) (*BatchTableColumn, error) {
+	switch v := t.Header[name].(type) {
+	case BinaryBodyReference:
+		size := ContainerTypeSize(v.ContainerType)
+		tp := componentSliceType(v.ComponentType)
+		if size == 0 || tp == nil {
+			return nil, newTileError("", name, -1, ErrBadReference)
+		}
+		values := reflect.ValueOf(t.Data[name])
+		if !values.IsValid() || values.Type() != tp || values.Len()%size != 0 {
+			return nil, newTileError("", name, -1, ErrBadValue)
+		}
+		return &BatchTableColumn{
+			Name:          name,
+			ComponentType: v.ComponentType,
+			ContainerType: v.ContainerType,
+			values:        values,
+			size:          size,
+		}, nil
+	case []interface{}:
+		return &BatchTableColumn{Name: name, values: reflect.ValueOf(v), size: 1}, nil
+	}
+	return nil, newTileError("", name, -1, ErrBadReference)
+}
+
+// Columns returns the per-feature properties of the batch table, without
+// extensions and extras.
+func (t *BatchTable) Columns() []*BatchTableColumn {
+	var ret []*BatchTableColumn
+	for _, k := range sortedKeys(t.Header) {
+		if c, err := t.Column(k); err == nil {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// Len returns the number of features of the batch table, the length of its
+// longest column.
+func (t *BatchTable) Len() int {
+	n := 0
+	for _, c := range t.Columns() {
+		if c.Len() > n {
+			n = c.Len()
+		}
+	}
+	return n
+}
+
+// Value returns the value of property name for feature id, see
+// BatchTableColumn.Value.
+func (t *BatchTable) Value(name string, id int) (interface{}, error) {
+	c, err := t.Column(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Value(id)
+}
+
+// Feature returns the values of every property of feature id.
+func (t *BatchTable) Feature(id int) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for _, c := range t.Columns() {
+		if v, err := c.Value(id); err == nil {
+			ret[c.Name] = v
+		}
+	}
+	return ret
+}
+
+// ForEachFeature calls fn with the values of every feature in order until
+// fn returns false.
+func (t *BatchTable) ForEachFeature(fn func(id int, feature map[string]interface{}) bool) {
+	cols := t.Columns()
+	for id, n := 0, t.Len(); id < n; id++ {
+		feature := make(map[string]interface{}, len(cols))
+		for _, c := range cols {
+			if v, err := c.Value(id); err == nil {
+				feature[c.Name] = v
+			}
+		}
+		if !fn(id, feature) {
+			return
+		}
+	}
+}
+
+// jsonValue returns the value of feature id as it is read from JSON.
+func (c *BatchTableColumn) jsonValue(id int) interface{} {
+	if !c.IsBinary() {
+		v, _ := c.Value(id)
+		return v
+	}
+	v, _ := c.Float64s(id)
+	if c.size == 1 {
+		return v[0]
+	}
+	a := make([]interface{}, len(v))
+	for j := range v {
+		a[j] = v[j]
+	}
+	return a
+}
+
+// ToJSON moves the binary property name to the JSON header. Numbers become
+// float64 and vectors arrays of them, as they are read from JSON.
+func (t *BatchTable) ToJSON(name string) error {
+	c, err := t.Column(name)
+	if err != nil {
+		return err
+	}
+	if !c.IsBinary() {
+		return nil
+	}
+	values := make([]interface{}, c.Len())
+	for i := range values {
+		values[i] = c.jsonValue(i)
+	}
+	t.Header[name] = values
+	if t.Data == nil {
+		t.Data = make(map[string]interface{})
+	}
+	t.Data[name] = values
+	return nil
+}
+
+// ToBinary moves the JSON property name to the binary body with the given
+// component and container types. Every value must be a number, or an array
+// of numbers for vectors, representable in the component type.
+func (t *BatchTable) ToBinary(name, componentType, containerType string) error {
+	c, err := t.Column(name)
+	if err != nil {
+		return err
+	}
+	size := ContainerTypeSize(containerType)
+	tp := componentSliceType(componentType)
+	if size == 0 || tp == nil {
+		return newTileError("", name, -1, ErrBadValue)
+	}
+	values := reflect.MakeSlice(tp, 0, c.Len()*size)
+	elem := reflect.New(tp.Elem()).Elem()
+	for i := 0; i < c.Len(); i++ {
+		v, ok := c.Float64s(i)
+		if !ok || len(v) != size {
+			return newTileError("", name, -1, ErrBadValue)
+		}
+		for _, f := range v {
+			switch elem.Kind() {
+			case reflect.Float32, reflect.Float64:
+				elem.SetFloat(f)
+			case reflect.Int8, reflect.Int16, reflect.Int32:
+				if f != math.Trunc(f) || f < math.MinInt32 || f > math.MaxInt32 || elem.OverflowInt(int64(f)) {
+					return newTileError("", name, -1, ErrBadValue)
+				}
+				elem.SetInt(int64(f))
+			default:
+				if f != math.Trunc(f) || f < 0 || f > math.MaxUint32 || elem.OverflowUint(uint64(f)) {
+					return newTileError("", name, -1, ErrBadValue)
+				}
+				elem.SetUint(uint64(f))
+			}
+			values = reflect.Append(values, elem)
+		}
+	}
+	t.Header[name] = BinaryBodyReference{ComponentType: componentType, ContainerType: containerType}
+	if t.Data == nil {
+		t.Data = make(map[string]interface{})
+	}
+	t.Data[name] = values.Interface()
+	return nil
+}
@@ -0,0 +1,611 @@
+package tile3d
+
+import (
+	"math"
+	"reflect"
+)
+
+// HierarchyClass is a class of 3DTILES_batch_table_hierarchy. Instances
+// holds for every property one value per instance of the class.
+type HierarchyClass struct {
+	Name      string
+	Length    int
+	Instances map[string][]interface{}
+}
+
+// BatchTableHierarchy is the 3DTILES_batch_table_hierarchy extension of a
+// batch table. The first BATCH_LENGTH instances are the features of the
+// tile. ParentCounts may be nil, then every instance has exactly one
+// parent in ParentIds. An instance that is its own parent is a root.
+// Queries cache an index of the instances, which only AddInstance and
+// Encode update.
+type BatchTableHierarchy struct {
+	Classes      []*HierarchyClass
+	ClassIds     []uint32
+	ParentCounts []uint32
+	ParentIds    []uint32
+
+	classIndexes  []int
+	parentOffsets []int
+}
+
+func NewBatchTableHierarchy() *BatchTableHierarchy {
+	return &BatchTableHierarchy{}
+}
+
+// InstancesLength returns the number of instances of all classes.
+func (h *BatchTableHierarchy) InstancesLength() int {
+	return len(h.ClassIds)
+}
+
+// AddClass adds an empty class and returns its index.
+func (h *BatchTableHierarchy) AddClass(name string) uint32 {
+	h.Classes = append(h.Classes, &HierarchyClass{Name: name, Instances: make(map[string][]interface{})})
+	return uint32(len(h.Classes) - 1)
+}
+
+// AddInstance adds an instance of class with the given property values and
+// parents, and returns its id. Parents may be instances added later.
+// Properties of the class the instance has no value for are null.
+func (h *BatchTableHierarchy) AddInstance(class uint32, values map[string]interface{}, parents ...uint32) uint32 {
+	c := h.Classes[class]
+	for k, v := range values {
+		if _, ok := c.Instances[k]; !ok {
+			c.Instances[k] = make([]interface{}, c.Length)
+		}
+		c.Instances[k] = append(c.Instances[k], v)
+	}
+	for k := range c.Instances {
+		if len(c.Instances[k]) == c.Length {
+			c.Instances[k] = append(c.Instances[k], nil)
+		}
+	}
+	c.Length++
+
+	if h.ParentCounts == nil && len(parents) != 1 {
+		h.ParentCounts = make([]uint32, len(h.ClassIds))
+		for i := range h.ParentCounts {
+			h.ParentCounts[i] = 1
+		}
+	}
+	if h.ParentCounts != nil {
+		h.ParentCounts = append(h.ParentCounts, uint32(len(parents)))
+	}
+	h.ParentIds = append(h.ParentIds, parents...)
+	h.ClassIds = append(h.ClassIds, class)
+	h.classIndexes = nil
+	return uint32(len(h.ClassIds) - 1)
+}
+
+// index validates h and caches the index of every instance in its class
+// and the offset of its parents.
+func (h *BatchTableHierarchy) index() error {
+	if h.classIndexes != nil {
+		return nil
+	}
+	bad := newTileError("", BATCH_TABLE_HIERARCHY, -1, ErrBadValue)
+	n := len(h.ClassIds)
+	classIndexes := make([]int, n)
+	counts := make([]int, len(h.Classes))
+	for i, c := range h.ClassIds {
+		if int(c) >= len(h.Classes) {
+			return bad
+		}
+		classIndexes[i] = counts[c]
+		counts[c]++
+	}
+	for i, c := range h.Classes {
+		if c.Length != counts[i] {
+			return bad
+		}
+		for _, values := range c.Instances {
+			if len(values) != c.Length {
+				return bad
+			}
+		}
+	}
+	parentOffsets := make([]int, n+1)
+	for i := 0; i < n; i++ {
+		switch {
+		case h.ParentCounts != nil:
+			if len(h.ParentCounts) != n {
+				return bad
+			}
+			parentOffsets[i+1] = parentOffsets[i] + int(h.ParentCounts[i])
+		case h.ParentIds != nil:
+			parentOffsets[i+1] = i + 1
+		}
+	}
+	if parentOffsets[n] != len(h.ParentIds) {
+		return bad
+	}
+	for _, p := range h.ParentIds {
+		if int(p) >= n {
+			return bad
+		}
+	}
+	h.classIndexes, h.parentOffsets = classIndexes, parentOffsets
+	return nil
+}
+
+// Parents returns the parents of instance id, without id itself.
+func (h *BatchTableHierarchy) Parents(id int) ([]uint32, error) {
+	if err := h.index(); err != nil {
+		return nil, err
+	}
+	if id < 0 || id >= len(h.ClassIds) {
+		return nil, newTileError("", BATCH_TABLE_HIERARCHY, -1, ErrBadReference)
+	}
+	var ret []uint32
+	for _, p := range h.ParentIds[h.parentOffsets[id]:h.parentOffsets[id+1]] {
+		if int(p) != id {
+			ret = append(ret, p)
+		}
+	}
+	return ret, nil
+}
+
+// Ancestors returns the ancestors of instance id breadth first, each once.
+func (h *BatchTableHierarchy) Ancestors(id int) ([]uint32, error) {
+	var ret []uint32
+	visited := map[uint32]bool{uint32(id): true}
+	for queue := []uint32{uint32(id)}; len(queue) > 0; queue = queue[1:] {
+		parents, err := h.Parents(int(queue[0]))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parents {
+			if !visited[p] {
+				visited[p] = true
+				ret = append(ret, p)
+				queue = append(queue, p)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// ClassName returns the name of the class of instance id.
+func (h *BatchTableHierarchy) ClassName(id int) (string, error) {
+	if id < 0 || id >= len(h.ClassIds) || int(h.ClassIds[id]) >= len(h.Classes) {
+		return "", newTileError("", BATCH_TABLE_HIERARCHY, -1, ErrBadReference)
+	}
+	return h.Classes[h.ClassIds[id]].Name, nil
+}
+
+// IsExactClass reports whether instance id is of the class name.
+func (h *BatchTableHierarchy) IsExactClass(id int, name string) bool {
+	c, err := h.ClassName(id)
+	return err == nil && c == name
+}
+
+// IsClass reports whether instance id or one of its ancestors is of the
+// class name.
+func (h *BatchTableHierarchy) IsClass(id int, name string) bool {
+	if h.IsExactClass(id, name) {
+		return true
+	}
+	ancestors, _ := h.Ancestors(id)
+	for _, a := range ancestors {
+		if h.IsExactClass(int(a), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Properties returns the properties of instance id with the ones it
+// inherits. A property defined on several ancestors has the value of the
+// closest one, null values are not defined.
+func (h *BatchTableHierarchy) Properties(id int) (map[string]interface{}, error) {
+	ancestors, err := h.Ancestors(id)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]interface{})
+	for _, i := range append([]uint32{uint32(id)}, ancestors...) {
+		c := h.Classes[h.ClassIds[i]]
+		for k, values := range c.Instances {
+			if _, ok := ret[k]; !ok && values[h.classIndexes[i]] != nil {
+				ret[k] = values[h.classIndexes[i]]
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Property returns the value of property name of instance id, or of its
+// closest ancestor defining it.
+func (h *BatchTableHierarchy) Property(id int, name string) (interface{}, bool) {
+	props, err := h.Properties(id)
+	if err != nil {
+		return nil, false
+	}
+	v, ok := props[name]
+	return v, ok
+}
+
+// PropertyNames returns the sorted names of the properties of all classes.
+func (h *BatchTableHierarchy) PropertyNames() []string {
+	names := make(map[string]interface{})
+	for _, c := range h.Classes {
+		for k := range c.Instances {
+			names[k] = nil
+		}
+	}
+	return sortedKeys(names)
+}
+
+// hierarchyNumbers returns the numbers of the hierarchy array name, given
+// as JSON or as a reference into the binary body of the batch table.
+func hierarchyNumbers(v interface{}, body []byte, name string, length int, componentType, containerType string) ([]float64, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return getJSONNumbers(v, name, length)
+	}
+	ref := BinaryBodyReference{ComponentType: componentType, ContainerType: containerType}
+	if err := ref.FromMap(m); err != nil {
+		return nil, newTileError("", name, -1, err)
+	}
+	values, err := getBatchTableValuesFromRef(&ref, body, name, length)
+	if err != nil {
+		return nil, err
+	}
+	rv := reflect.ValueOf(values)
+	ret := make([]float64, rv.Len())
+	for i := range ret {
+		ret[i] = rv.Index(i).Convert(float64Type).Float()
+	}
+	return ret, nil
+}
+
+func hierarchyIds(v interface{}, body []byte, name string, length int, componentType string) ([]uint32, error) {
+	numbers, err := hierarchyNumbers(v, body, name, length, componentType, CONTAINER_TYPE_SCALAR)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]uint32, len(numbers))
+	for i, f := range numbers {
+		if f < 0 || f > math.MaxUint32 || f != math.Trunc(f) {
+			return nil, newTileError("", name, -1, ErrBadValue)
+		}
+		ret[i] = uint32(f)
+	}
+	return ret, nil
+}
+
+// ParseBatchTableHierarchy parses the 3DTILES_batch_table_hierarchy
+// extension ext. Binary arrays are read from body, the binary body of the
+// batch table, and become JSON values.
+func ParseBatchTableHierarchy(ext map[string]interface{}, body []byte) (*BatchTableHierarchy, error) {
+	bad := newTileError("", BATCH_TABLE_HIERARCHY, -1, ErrBadValue)
+	instancesLength, ok := ext[HIERARCHY_INSTANCE_LENGTH].(float64)
+	if !ok || instancesLength < 0 || instancesLength > math.MaxInt32 {
+		return nil, bad
+	}
+	n := int(instancesLength)
+	h := &BatchTableHierarchy{}
+	classes, _ := ext[HIERARCHY_CLASSES].([]interface{})
+	for _, c := range classes {
+		cm, ok := c.(map[string]interface{})
+		if !ok {
+			return nil, bad
+		}
+		length, ok := cm[HIERARCHY_CLASSES_LENGTH].(float64)
+		if !ok || length < 0 || length > instancesLength {
+			return nil, bad
+		}
+		instances, ok := cm[HIERARCHY_CLASSES_INSTANCES].(map[string]interface{})
+		if !ok {
+			return nil, bad
+		}
+		class := &HierarchyClass{Length: int(length), Instances: make(map[string][]interface{})}
+		class.Name, _ = cm[HIERARCHY_CLASSE_NAME].(string)
+		for k, v := range instances {
+			switch values := v.(type) {
+			case []interface{}:
+				if len(values) != class.Length {
+					return nil, bad
+				}
+				class.Instances[k] = values
+			case map[string]interface{}:
+				var ref BinaryBodyReference
+				if err := ref.FromMap(values); err != nil {
+					return nil, newTileError("", k, -1, err)
+				}
+				size := ContainerTypeSize(ref.ContainerType)
+				numbers, err := hierarchyNumbers(values, body, k, class.Length, ref.ComponentType, ref.ContainerType)
+				if err != nil {
+					return nil, err
+				}
+				column := make([]interface{}, class.Length)
+				for i := range column {
+					if size == 1 {
+						column[i] = numbers[i]
+						continue
+					}
+					vec := make([]interface{}, size)
+					for j := range vec {
+						vec[j] = numbers[i*size+j]
+					}
+					column[i] = vec
+				}
+				class.Instances[k] = column
+			default:
+				return nil, bad
+			}
+		}
+		h.Classes = append(h.Classes, class)
+	}
+
+	var err error
+	if h.ClassIds, err = hierarchyIds(ext[HIERARCHY_CLASSIDS], body, HIERARCHY_CLASSIDS, n, COMPONENT_TYPE_UNSIGNED_SHORT); err != nil {
+		return nil, err
+	}
+	parentIds := n
+	if v, ok := ext[HIERARCHY_PARENT_COUNTS]; ok {
+		if h.ParentCounts, err = hierarchyIds(v, body, HIERARCHY_PARENT_COUNTS, n, COMPONENT_TYPE_UNSIGNED_SHORT); err != nil {
+			return nil, err
+		}
+		parentIds = 0
+		for _, c := range h.ParentCounts {
+			parentIds += int(c)
+		}
+	}
+	if v, ok := ext[HIERARCHY_PARENTIDS]; ok {
+		if h.ParentIds, err = hierarchyIds(v, body, HIERARCHY_PARENTIDS, parentIds, COMPONENT_TYPE_UNSIGNED_INT); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.index(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func jsonNumbers(values []uint32) []interface{} {
+	ret := make([]interface{}, len(values))
+	for i, v := range values {
+		ret[i] = float64(v)
+	}
+	return ret
+}
+
+// Encode returns the JSON object of the extension.
+func (h *BatchTableHierarchy) Encode() (map[string]interface{}, error) {
+	h.classIndexes = nil
+	if err := h.index(); err != nil {
+		return nil, err
+	}
+	classes := make([]interface{}, len(h.Classes))
+	for i, c := range h.Classes {
+		instances := make(map[string]interface{}, len(c.Instances))
+		for k, v := range c.Instances {
+			instances[k] = v
+		}
+		classes[i] = map[string]interface{}{
+			HIERARCHY_CLASSE_NAME:       c.Name,
+			HIERARCHY_CLASSES_LENGTH:    float64(c.Length),
+			HIERARCHY_CLASSES_INSTANCES: instances,
+		}
+	}
+	ret := map[string]interface{}{
+		HIERARCHY_CLASSES:         classes,
+		HIERARCHY_INSTANCE_LENGTH: float64(len(h.ClassIds)),
+		HIERARCHY_CLASSIDS:        jsonNumbers(h.ClassIds),
+	}
+	if h.ParentCounts != nil {
+		ret[HIERARCHY_PARENT_COUNTS] = jsonNumbers(h.ParentCounts)
+	}
+	if len(h.ParentIds) > 0 {
+		ret[HIERARCHY_PARENTIDS] = jsonNumbers(h.ParentIds)
+	}
+	return ret, nil
+}
+
+// subset returns a hierarchy whose features are the features rows of the
+// batchLength first instances of h, followed by the instances of h that
+// are not features. Parents that are dropped features are removed.
+func (h *BatchTableHierarchy) subset(rows []uint32, batchLength int) (*BatchTableHierarchy, error) {
+	if err := h.index(); err != nil {
+		return nil, err
+	}
+	n := len(h.ClassIds)
+	if batchLength > n {
+		return nil, newTileError("", BATCH_TABLE_HIERARCHY, -1, ErrBadValue)
+	}
+	order := make([]int, 0, len(rows)+n-batchLength)
+	for _, r := range rows {
+		if int(r) >= batchLength {
+			return nil, newTileError("", BATCH_TABLE_HIERARCHY, -1, ErrBadReference)
+		}
+		order = append(order, int(r))
+	}
+	for i := batchLength; i < n; i++ {
+		order = append(order, i)
+	}
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = -1
+	}
+	for j, i := range order {
+		ids[i] = j
+	}
+
+	ret := NewBatchTableHierarchy()
+	for _, c := range h.Classes {
+		ret.AddClass(c.Name)
+	}
+	for _, i := range order {
+		c := h.Classes[h.ClassIds[i]]
+		values := make(map[string]interface{}, len(c.Instances))
+		for k, v := range c.Instances {
+			values[k] = v[h.classIndexes[i]]
+		}
+		var parents []uint32
+		for _, p := range h.ParentIds[h.parentOffsets[i]:h.parentOffsets[i+1]] {
+			if ids[p] >= 0 {
+				parents = append(parents, uint32(ids[p]))
+			}
+		}
+		ret.AddInstance(h.ClassIds[i], values, parents...)
+	}
+	return ret, nil
+}
+
+// mergeBatchTableHierarchies concatenates the hierarchies hs of tiles with
+// batchLengths features. The features of every tile come first, followed
+// by the instances that are not features, and classes of the same name are
+// merged.
+func mergeBatchTableHierarchies(hs []*BatchTableHierarchy, batchLengths []int) (*BatchTableHierarchy, error) {
+	total := 0
+	for i, h := range hs {
+		if err := h.index(); err != nil {
+			return nil, err
+		}
+		if batchLengths[i] > len(h.ClassIds) {
+			return nil, newTileError("", BATCH_TABLE_HIERARCHY, -1, ErrBadValue)
+		}
+		total += batchLengths[i]
+	}
+	ids := make([][]uint32, len(hs))
+	features, others := 0, total
+	for i, h := range hs {
+		ids[i] = make([]uint32, len(h.ClassIds))
+		for j := range ids[i] {
+			if j < batchLengths[i] {
+				ids[i][j] = uint32(features)
+				features++
+			} else {
+				ids[i][j] = uint32(others)
+				others++
+			}
+		}
+	}
+	order := make([][2]int, others)
+	for i := range ids {
+		for j, id := range ids[i] {
+			order[id] = [2]int{i, j}
+		}
+	}
+
+	ret := NewBatchTableHierarchy()
+	classes := make(map[string]uint32)
+	for _, o := range order {
+		h, i := hs[o[0]], o[1]
+		c := h.Classes[h.ClassIds[i]]
+		class, ok := classes[c.Name]
+		if !ok {
+			class = ret.AddClass(c.Name)
+			classes[c.Name] = class
+		}
+		values := make(map[string]interface{}, len(c.Instances))
+		for k, v := range c.Instances {
+			values[k] = v[h.classIndexes[i]]
+		}
+		var parents []uint32
+		for _, p := range h.ParentIds[h.parentOffsets[i]:h.parentOffsets[i+1]] {
+			parents = append(parents, ids[o[0]][p])
+		}
+		ret.AddInstance(class, values, parents...)
+	}
+	return ret, nil
+}
+
+// Hierarchy returns the 3DTILES_batch_table_hierarchy extension of t, or
+// nil when t has none.
+func (t *BatchTable) Hierarchy() (*BatchTableHierarchy, error) {
+	exts, _ := t.Header["extensions"].(map[string]interface{})
+	ext, ok := exts[BATCH_TABLE_HIERARCHY].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	return ParseBatchTableHierarchy(ext, nil)
+}
+
+// SetHierarchy sets the 3DTILES_batch_table_hierarchy extension of t, or
+// removes it when h is nil.
+func (t *BatchTable) SetHierarchy(h *BatchTableHierarchy) error {
+	exts, _ := t.Header["extensions"].(map[string]interface{})
+	if h == nil {
+		delete(exts, BATCH_TABLE_HIERARCHY)
+		if len(exts) == 0 {
+			delete(t.Header, "extensions")
+		}
+		return nil
+	}
+	ext, err := h.Encode()
+	if err != nil {
+		return err
+	}
+	if t.Header == nil {
+		t.Header = make(map[string]interface{})
+	}
+	if exts == nil {
+		exts = make(map[string]interface{})
+		t.Header["extensions"] = exts
+	}
+	exts[BATCH_TABLE_HIERARCHY] = ext
+	return nil
+}
+
+// readHierarchy replaces the binary arrays of the hierarchy of t with JSON
+// arrays, so the header no longer references body.
+func (t *BatchTable) readHierarchy(body []byte) error {
+	exts, _ := t.Header["extensions"].(map[string]interface{})
+	ext, ok := exts[BATCH_TABLE_HIERARCHY].(map[string]interface{})
+	if !ok || !hasHierarchyReference(ext) {
+		return nil
+	}
+	h, err := ParseBatchTableHierarchy(ext, body)
+	if err != nil {
+		return err
+	}
+	return t.SetHierarchy(h)
+}
+
+func hasHierarchyReference(ext map[string]interface{}) bool {
+	for _, k := range []string{HIERARCHY_CLASSIDS, HIERARCHY_PARENT_COUNTS, HIERARCHY_PARENTIDS} {
+		if _, ok := ext[k].(map[string]interface{}); ok {
+			return true
+		}
+	}
+	classes, _ := ext[HIERARCHY_CLASSES].([]interface{})
+	for _, c := range classes {
+		cm, _ := c.(map[string]interface{})
+		instances, _ := cm[HIERARCHY_CLASSES_INSTANCES].(map[string]interface{})
+		for _, v := range instances {
+			if _, ok := v.(map[string]interface{}); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flattenBatchTableHierarchy returns for each property of the classes of
+// 3DTILES_batch_table_hierarchy the value every feature has or inherits
+// from its closest ancestor, or nil.
+func flattenBatchTableHierarchy(header map[string]interface{}, count int) (map[string][]interface{}, error) {
+	h, err := (&BatchTable{Header: header}).Hierarchy()
+	if err != nil || h == nil {
+		return nil, err
+	}
+	if h.InstancesLength() < count {
+		return nil, newTileError("", BATCH_TABLE_HIERARCHY, -1, ErrBadValue)
+	}
+	ret := make(map[string][]interface{})
+	for _, k := range h.PropertyNames() {
+		ret[k] = make([]interface{}, count)
+	}
+	for f := 0; f < count; f++ {
+		props, err := h.Properties(f)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range props {
+			ret[k][f] = v
+		}
+	}
+	return ret, nil
+}
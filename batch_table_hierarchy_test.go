@@ -0,0 +1,137 @@
+package tile3d
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newBuildingHierarchy returns rooms 0 to 3 on floors 4 and 5 of building 6.
+func newBuildingHierarchy() *BatchTableHierarchy {
+	h := NewBatchTableHierarchy()
+	room := h.AddClass("room")
+	floor := h.AddClass("floor")
+	building := h.AddClass("building")
+	for i := 0; i < 4; i++ {
+		values := map[string]interface{}{"area": float64(10 * (i + 1))}
+		if i == 0 {
+			values["name"] = "lobby"
+		}
+		h.AddInstance(room, values, uint32(4+i/2))
+	}
+	h.AddInstance(floor, map[string]interface{}{"level": 0.0}, 6)
+	h.AddInstance(floor, map[string]interface{}{"level": 1.0}, 6)
+	h.AddInstance(building, map[string]interface{}{"name": "tower"})
+	return h
+}
+
+func TestBatchTableHierarchy(t *testing.T) {
+	m := NewB3dm()
+	m.Model = openGltf("./data/box.glb")
+	m.SetFeatureTable(B3dmFeatureTableView{BatchLength: 4})
+	m.BatchTable.Header = map[string]interface{}{}
+	if err := m.BatchTable.SetHierarchy(newBuildingHierarchy()); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := NewB3dm()
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	h, err := r.BatchTable.Hierarchy()
+	if err != nil || h == nil {
+		t.Fatal(h, err)
+	}
+	if h.InstancesLength() != 7 || h.ParentCounts == nil {
+		t.Errorf("instances %d, parent counts %v", h.InstancesLength(), h.ParentCounts)
+	}
+
+	props, err := h.Properties(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"area": 40.0, "level": 1.0, "name": "tower"}
+	if !reflect.DeepEqual(props, want) {
+		t.Errorf("properties %v", props)
+	}
+	if name, _ := h.Property(0, "name"); name != "lobby" {
+		t.Errorf("name %v", name)
+	}
+	if ancestors, _ := h.Ancestors(1); !reflect.DeepEqual(ancestors, []uint32{4, 6}) {
+		t.Errorf("ancestors %v", ancestors)
+	}
+	if !h.IsClass(2, "building") || h.IsExactClass(2, "floor") || !h.IsExactClass(5, "floor") || h.IsClass(6, "room") {
+		t.Error("classes")
+	}
+	if _, err := h.Parents(7); !errors.Is(err, ErrBadReference) {
+		t.Errorf("parents %v", err)
+	}
+
+	flat, err := flattenBatchTableHierarchy(r.BatchTable.Header, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(flat["name"], []interface{}{"lobby", "tower", "tower", "tower"}) {
+		t.Errorf("names %v", flat["name"])
+	}
+}
+
+func TestBatchTableHierarchyBinary(t *testing.T) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, []uint16{0, 0, 1})     // classIds
+	binary.Write(&body, binary.LittleEndian, []uint16{0})           // padding
+	binary.Write(&body, binary.LittleEndian, []uint32{2, 2, 2})     // parentIds
+	binary.Write(&body, binary.LittleEndian, []float32{1, 2, 3, 4}) // wall color
+	ext := map[string]interface{}{
+		HIERARCHY_INSTANCE_LENGTH: 3.0,
+		HIERARCHY_CLASSES: []interface{}{
+			map[string]interface{}{
+				HIERARCHY_CLASSE_NAME:    "wall",
+				HIERARCHY_CLASSES_LENGTH: 2.0,
+				HIERARCHY_CLASSES_INSTANCES: map[string]interface{}{
+					"color": map[string]interface{}{"byteOffset": 20.0, "componentType": COMPONENT_TYPE_FLOAT, "type": CONTAINER_TYPE_VEC2},
+				},
+			},
+			map[string]interface{}{
+				HIERARCHY_CLASSE_NAME:       "building",
+				HIERARCHY_CLASSES_LENGTH:    1.0,
+				HIERARCHY_CLASSES_INSTANCES: map[string]interface{}{"name": []interface{}{"b"}},
+			},
+		},
+		HIERARCHY_CLASSIDS:  map[string]interface{}{"byteOffset": 0.0},
+		HIERARCHY_PARENTIDS: map[string]interface{}{"byteOffset": 8.0},
+	}
+	bt := &BatchTable{Header: map[string]interface{}{
+		"extensions": map[string]interface{}{BATCH_TABLE_HIERARCHY: ext},
+	}}
+	if err := bt.readHierarchy(body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	// The header no longer references the binary body.
+	h, err := bt.Hierarchy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h.ParentIds, []uint32{2, 2, 2}) || h.ParentCounts != nil {
+		t.Errorf("parents %v %v", h.ParentIds, h.ParentCounts)
+	}
+	if c, _ := h.Property(1, "color"); !reflect.DeepEqual(c, []interface{}{3.0, 4.0}) {
+		t.Errorf("color %v", c)
+	}
+	if name, _ := h.Property(1, "name"); name != "b" {
+		t.Errorf("name %v", name)
+	}
+	if ancestors, _ := h.Ancestors(2); len(ancestors) != 0 {
+		t.Errorf("root ancestors %v", ancestors)
+	}
+
+	ext[HIERARCHY_CLASSIDS] = []interface{}{0.0, 0.0, 2.0}
+	if _, err := ParseBatchTableHierarchy(ext, body.Bytes()); !errors.Is(err, ErrBadValue) {
+		t.Errorf("bad class id %v", err)
+	}
+}
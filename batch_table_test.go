@@ -0,0 +1,113 @@
+package tile3d
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newColumnTestB3dm() *B3dm {
+	m := NewB3dm()
+	m.Model = openGltf("./data/box.glb")
+	m.SetFeatureTable(B3dmFeatureTableView{BatchLength: 3})
+	m.BatchTable.Header = map[string]interface{}{
+		"name":   []interface{}{"a", "b", "c"},
+		"id":     BinaryBodyReference{ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE, ContainerType: CONTAINER_TYPE_SCALAR},
+		"height": BinaryBodyReference{ComponentType: COMPONENT_TYPE_DOUBLE, ContainerType: CONTAINER_TYPE_SCALAR},
+		"center": BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC3},
+	}
+	m.BatchTable.Data = map[string]interface{}{
+		"id":     []uint8{7, 8, 9},
+		"height": []float64{1.5, 2.5, 3.5},
+		"center": []float32{1, 2, 3, 4, 5, 6, 7, 8, 9},
+	}
+	return m
+}
+
+func TestBatchTableColumns(t *testing.T) {
+	m := newColumnTestB3dm()
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := NewB3dm()
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.FeatureTable.GetBatchLength(); n != 3 {
+		t.Errorf("batch length %d", n)
+	}
+	bt := &r.BatchTable
+
+	if v := bt.GetProperty("height", 1); v != 2.5 {
+		t.Errorf("height %v", v)
+	}
+	if v := bt.GetProperty("center", 2); !reflect.DeepEqual(v, []float32{7, 8, 9}) {
+		t.Errorf("center %v", v)
+	}
+	if v := bt.GetProperty("name", 0); v != "a" {
+		t.Errorf("name %v", v)
+	}
+	if v := bt.GetProperty("name", 3); v != nil {
+		t.Errorf("out of range %v", v)
+	}
+	if _, err := bt.Value("missing", 0); !errors.Is(err, ErrBadReference) {
+		t.Errorf("missing %v", err)
+	}
+
+	c, err := bt.Column("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := c.Float64(2); !c.IsBinary() || c.Len() != 3 || !ok || id != 9 {
+		t.Errorf("id %v", id)
+	}
+	if s, ok := c.String(0); ok {
+		t.Errorf("string %v", s)
+	}
+
+	var names []interface{}
+	bt.ForEachFeature(func(id int, feature map[string]interface{}) bool {
+		names = append(names, feature["name"])
+		return id < 1
+	})
+	if !reflect.DeepEqual(names, []interface{}{"a", "b"}) {
+		t.Errorf("names %v", names)
+	}
+	if f := bt.Feature(1); len(f) != 4 || f["id"] != uint8(8) {
+		t.Errorf("feature %v", f)
+	}
+}
+
+func TestBatchTableConvert(t *testing.T) {
+	m := newColumnTestB3dm()
+	bt := &m.BatchTable
+	if err := bt.ToJSON("center"); err != nil {
+		t.Fatal(err)
+	}
+	if v := bt.GetProperty("center", 1); !reflect.DeepEqual(v, []interface{}{4.0, 5.0, 6.0}) {
+		t.Errorf("center %v", v)
+	}
+	if err := bt.ToBinary("center", COMPONENT_TYPE_SHORT, CONTAINER_TYPE_VEC3); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.ToBinary("name", COMPONENT_TYPE_FLOAT, CONTAINER_TYPE_SCALAR); !errors.Is(err, ErrBadValue) {
+		t.Errorf("name %v", err)
+	}
+	if err := bt.ToBinary("height", COMPONENT_TYPE_INT, CONTAINER_TYPE_SCALAR); !errors.Is(err, ErrBadValue) {
+		t.Errorf("height %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := NewB3dm()
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if v := r.BatchTable.GetProperty("center", 2); !reflect.DeepEqual(v, []int16{7, 8, 9}) {
+		t.Errorf("center %v", v)
+	}
+}
@@ -0,0 +1,361 @@
+package tile3d
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+const BATCH_TABLE_ID_COLUMN = "batchId"
+
+// BatchTableTextOptions controls the export and import of batch tables as
+// CSV and newline delimited JSON, one row per feature.
+type BatchTableTextOptions struct {
+	// Hierarchy exports the properties features inherit from
+	// 3DTILES_batch_table_hierarchy. They are read only and skipped on
+	// import.
+	Hierarchy bool
+	// Key matches imported rows to features by the value of this property
+	// instead of the batch id.
+	Key string
+	// Patch updates only the properties of the imported rows, otherwise
+	// the properties of the table are replaced by the ones of the file.
+	Patch bool
+}
+
+func NewBatchTableTextOptions() *BatchTableTextOptions {
+	return &BatchTableTextOptions{}
+}
+
+// csvCell is an imported CSV value, typed once its column is known.
+type csvCell string
+
+// textCell formats v as a CSV value. Arrays and objects are JSON.
+func textCell(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case csvCell:
+		return string(t)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	if rv := reflect.ValueOf(v); isNumberKind(rv.Kind()) {
+		return strconv.FormatFloat(rv.Convert(float64Type).Float(), 'g', -1, 64)
+	}
+	buf, _ := json.Marshal(v)
+	return string(buf)
+}
+
+// textRows returns the names of the exported properties and the values of
+// every feature.
+func (t *BatchTable) textRows(batchLength int, opts *BatchTableTextOptions) ([]string, []map[string]interface{}, error) {
+	if opts == nil {
+		opts = NewBatchTableTextOptions()
+	}
+	var names []string
+	columns := make(map[string]func(int) interface{})
+	for _, c := range t.Columns() {
+		if c.Len() < batchLength {
+			return nil, nil, newTileError("", c.Name, -1, ErrBadValue)
+		}
+		c := c
+		names = append(names, c.Name)
+		columns[c.Name] = func(id int) interface{} {
+			v, _ := c.Value(id)
+			return v
+		}
+	}
+	if opts.Hierarchy {
+		hierarchy, err := flattenBatchTableHierarchy(t.Header, batchLength)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, k := range sortedHierarchyNames(hierarchy) {
+			if _, ok := columns[k]; !ok {
+				values := hierarchy[k]
+				names = append(names, k)
+				columns[k] = func(id int) interface{} { return values[id] }
+			}
+		}
+	}
+	rows := make([]map[string]interface{}, batchLength)
+	for id := range rows {
+		rows[id] = make(map[string]interface{}, len(names)+1)
+		rows[id][BATCH_TABLE_ID_COLUMN] = id
+		for _, k := range names {
+			rows[id][k] = columns[k](id)
+		}
+	}
+	return names, rows, nil
+}
+
+func sortedHierarchyNames(m map[string][]interface{}) []string {
+	keys := make(map[string]interface{}, len(m))
+	for k := range m {
+		keys[k] = nil
+	}
+	return sortedKeys(keys)
+}
+
+// WriteCSV writes the batchLength features of t as CSV with a header row.
+// The first column is the batch id, arrays and objects are written as
+// JSON and null values as empty cells.
+func (t *BatchTable) WriteCSV(w io.Writer, batchLength int, opts *BatchTableTextOptions) error {
+	names, rows, err := t.textRows(batchLength, opts)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{BATCH_TABLE_ID_COLUMN}, names...)); err != nil {
+		return err
+	}
+	record := make([]string, len(names)+1)
+	for id, row := range rows {
+		record[0] = strconv.Itoa(id)
+		for i, k := range names {
+			record[i+1] = textCell(row[k])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteNDJSON writes the batchLength features of t as one JSON object per
+// line with the batch id and the properties of the feature.
+func (t *BatchTable) WriteNDJSON(w io.Writer, batchLength int, opts *BatchTableTextOptions) error {
+	_, rows, err := t.textRows(batchLength, opts)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadCSV updates t from CSV rows with a header row, see ReadNDJSON. Cells
+// of string properties stay strings, other cells holding JSON are decoded
+// and empty cells are null.
+func (t *BatchTable) ReadCSV(r io.Reader, batchLength int, opts *BatchTableTextOptions) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return newTileError("", "csv", -1, err)
+	}
+	var rows []map[string]interface{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newTileError("", "csv", -1, err)
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, k := range header {
+			row[k] = csvCell(record[i])
+		}
+		rows = append(rows, row)
+	}
+	return t.importRows(rows, batchLength, opts)
+}
+
+// ReadNDJSON updates t from one JSON object per line. Rows are matched to
+// features by their batch id, or by opts.Key, and every row must match a
+// feature. Properties inherited from the hierarchy are skipped.
+func (t *BatchTable) ReadNDJSON(r io.Reader, batchLength int, opts *BatchTableTextOptions) error {
+	var rows []map[string]interface{}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, math.MaxInt32)
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var row map[string]interface{}
+		if err := json.Unmarshal(line, &row); err != nil {
+			return newTileError("", "ndjson", -1, err)
+		}
+		rows = append(rows, row)
+	}
+	if err := s.Err(); err != nil {
+		return newTileError("", "ndjson", -1, err)
+	}
+	return t.importRows(rows, batchLength, opts)
+}
+
+// featureIds returns the feature of every row.
+func (t *BatchTable) featureIds(rows []map[string]interface{}, batchLength int, key string) ([]int, error) {
+	ids := make([]int, len(rows))
+	if key == "" || key == BATCH_TABLE_ID_COLUMN {
+		for i, row := range rows {
+			f, err := strconv.ParseFloat(textCell(row[BATCH_TABLE_ID_COLUMN]), 64)
+			if err != nil || f != math.Trunc(f) || f < 0 || f >= float64(batchLength) {
+				return nil, newTileError("", BATCH_TABLE_ID_COLUMN, -1, ErrBadReference)
+			}
+			ids[i] = int(f)
+		}
+		return ids, nil
+	}
+
+	c, err := t.Column(key)
+	if err != nil {
+		return nil, err
+	}
+	features := make(map[string]int)
+	for id := 0; id < batchLength && id < c.Len(); id++ {
+		v, _ := c.Value(id)
+		k := textCell(v)
+		if _, ok := features[k]; ok {
+			features[k] = -1
+			continue
+		}
+		features[k] = id
+	}
+	for i, row := range rows {
+		id, ok := features[textCell(row[key])]
+		if !ok || id < 0 {
+			return nil, newTileError("", key, -1, ErrBadReference)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// importValue types the imported value v of a property whose current
+// values are strings when strs is true.
+func importValue(v interface{}, strs bool) interface{} {
+	cell, ok := v.(csvCell)
+	if !ok {
+		return v
+	}
+	if cell == "" {
+		return nil
+	}
+	if strs {
+		return string(cell)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(cell), &decoded); err != nil {
+		return string(cell)
+	}
+	return decoded
+}
+
+// isStringColumn reports whether the JSON column values only holds strings
+// and nulls, with at least one string.
+func isStringColumn(values []interface{}) bool {
+	strs := 0
+	for _, v := range values {
+		switch v.(type) {
+		case nil:
+		case string:
+			strs++
+		default:
+			return false
+		}
+	}
+	return strs > 0
+}
+
+func (t *BatchTable) importRows(rows []map[string]interface{}, batchLength int, opts *BatchTableTextOptions) error {
+	if opts == nil {
+		opts = NewBatchTableTextOptions()
+	}
+	ids, err := t.featureIds(rows, batchLength, opts.Key)
+	if err != nil {
+		return err
+	}
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return newTileError("", BATCH_TABLE_ID_COLUMN, -1, ErrBadValue)
+		}
+		seen[id] = true
+	}
+	hierarchy, err := flattenBatchTableHierarchy(t.Header, batchLength)
+	if err != nil {
+		return err
+	}
+
+	// The rows are imported into a copy so t is unchanged on error. Binary
+	// properties are edited as JSON and stored back with their component
+	// and container types.
+	work := &BatchTable{Header: make(map[string]interface{}), Data: make(map[string]interface{})}
+	for k, v := range t.Header {
+		work.Header[k] = v
+	}
+	for k, v := range t.Data {
+		work.Data[k] = v
+	}
+	// Whether a property holds strings is taken from its values before the
+	// import, whether they are patched or replaced.
+	binaries := make(map[string]BinaryBodyReference)
+	columns := make(map[string][]interface{})
+	strs := make(map[string]bool)
+	for _, c := range work.Columns() {
+		if c.IsBinary() {
+			binaries[c.Name] = work.Header[c.Name].(BinaryBodyReference)
+			if err := work.ToJSON(c.Name); err != nil {
+				return err
+			}
+		}
+		old, _ := work.Header[c.Name].([]interface{})
+		strs[c.Name] = isStringColumn(old)
+		if opts.Patch {
+			values := make([]interface{}, batchLength)
+			copy(values, old)
+			columns[c.Name] = values
+		} else {
+			delete(work.Header, c.Name)
+			delete(work.Data, c.Name)
+		}
+	}
+
+	for i, row := range rows {
+		for k, v := range row {
+			if k == BATCH_TABLE_ID_COLUMN {
+				continue
+			}
+			if _, ok := columns[k]; !ok {
+				if _, inherited := hierarchy[k]; inherited {
+					continue
+				}
+				columns[k] = make([]interface{}, batchLength)
+			}
+			columns[k][ids[i]] = importValue(v, strs[k])
+		}
+	}
+
+	for k, values := range columns {
+		work.Header[k] = values
+		work.Data[k] = values
+	}
+	// Replaced properties keep their binary storage when their new values
+	// fit it, patched ones must.
+	for k, ref := range binaries {
+		if _, ok := columns[k]; !ok {
+			continue
+		}
+		err := work.ToBinary(k, ref.ComponentType, ref.ContainerType)
+		if err != nil && opts.Patch {
+			return err
+		}
+	}
+	t.Header, t.Data = work.Header, work.Data
+	return nil
+}
@@ -0,0 +1,114 @@
+package tile3d
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatchTableText(t *testing.T) {
+	m := newColumnTestB3dm()
+	bt := &m.BatchTable
+
+	var csvBuf bytes.Buffer
+	if err := bt.WriteCSV(&csvBuf, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "batchId,center,height,id,name\n" +
+		"0,\"[1,2,3]\",1.5,7,a\n" +
+		"1,\"[4,5,6]\",2.5,8,b\n" +
+		"2,\"[7,8,9]\",3.5,9,c\n"
+	if csvBuf.String() != want {
+		t.Errorf("csv\n%s", csvBuf.String())
+	}
+	var ndjson bytes.Buffer
+	if err := bt.WriteNDJSON(&ndjson, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if line := strings.SplitN(ndjson.String(), "\n", 2)[0]; line != `{"batchId":0,"center":[1,2,3],"height":1.5,"id":7,"name":"a"}` {
+		t.Errorf("ndjson %s", line)
+	}
+
+	// A spreadsheet fix keyed by name keeps the binary storage.
+	fix := "name,height,id,center\nc,4.25,10,\"[0,0,1]\"\na,,7,\"[1,2,3]\"\n"
+	opts := &BatchTableTextOptions{Key: "name", Patch: true}
+	if err := bt.ReadCSV(strings.NewReader(fix), 3, opts); !errors.Is(err, ErrBadValue) {
+		t.Errorf("null height %v", err)
+	}
+	if v := bt.GetProperty("height", 0); v != 1.5 {
+		t.Errorf("failed import changed height %v", v)
+	}
+	fix = "name,height,id,center\nc,4.25,10,\"[0,0,1]\"\n"
+	if err := bt.ReadCSV(strings.NewReader(fix), 3, opts); err != nil {
+		t.Fatal(err)
+	}
+	if v := bt.GetProperty("height", 2); v != 4.25 {
+		t.Errorf("height %v", v)
+	}
+	if v := bt.GetProperty("id", 2); v != uint8(10) {
+		t.Errorf("id %v", v)
+	}
+	if v := bt.GetProperty("center", 2); !reflect.DeepEqual(v, []float32{0, 0, 1}) {
+		t.Errorf("center %v", v)
+	}
+	if v := bt.GetProperty("height", 1); v != 2.5 {
+		t.Errorf("unpatched height %v", v)
+	}
+	if err := bt.ReadCSV(strings.NewReader("name,id\nz,1\n"), 3, opts); !errors.Is(err, ErrBadReference) {
+		t.Errorf("unknown key %v", err)
+	}
+
+	// Rebuilding from NDJSON replaces the properties.
+	rows := `{"batchId":1,"name":"007","tags":["x"]}` + "\n" + `{"batchId":0,"id":3}` + "\n"
+	if err := bt.ReadNDJSON(strings.NewReader(rows), 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, c := range bt.Columns() {
+		names = append(names, c.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"id", "name", "tags"}) {
+		t.Errorf("columns %v", names)
+	}
+	if v := bt.GetProperty("name", 1); v != "007" {
+		t.Errorf("name %v", v)
+	}
+	if v := bt.GetProperty("tags", 2); v != nil {
+		t.Errorf("missing row %v", v)
+	}
+	if err := bt.ReadNDJSON(strings.NewReader(`{"batchId":3}`), 3, nil); !errors.Is(err, ErrBadReference) {
+		t.Errorf("batch id %v", err)
+	}
+
+	// String columns stay strings and hierarchy properties are read only.
+	if err := bt.SetHierarchy(newBuildingHierarchy()); err != nil {
+		t.Fatal(err)
+	}
+	csvBuf.Reset()
+	if err := bt.WriteCSV(&csvBuf, 3, &BatchTableTextOptions{Hierarchy: true}); err != nil {
+		t.Fatal(err)
+	}
+	if line := strings.SplitN(csvBuf.String(), "\n", 2)[0]; line != "batchId,id,name,tags,area,level" {
+		t.Errorf("header %s", line)
+	}
+	if err := bt.ReadCSV(bytes.NewReader(csvBuf.Bytes()), 3, &BatchTableTextOptions{Patch: true}); err != nil {
+		t.Fatal(err)
+	}
+	if v := bt.GetProperty("name", 1); v != "007" {
+		t.Errorf("name %v", v)
+	}
+	if _, err := bt.Column("area"); err == nil {
+		t.Error("hierarchy property imported")
+	}
+
+	// Replacing a string column keeps its values strings too.
+	bt = &BatchTable{Header: map[string]interface{}{"code": []interface{}{"1", "2", "3"}}}
+	if err := bt.ReadCSV(strings.NewReader("batchId,code\n0,12345\n1,A7\n2,true\n"), 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := bt.Header["code"]; !reflect.DeepEqual(v, []interface{}{"12345", "A7", "true"}) {
+		t.Errorf("code %#v", v)
+	}
+}
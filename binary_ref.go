@@ -3,6 +3,7 @@ package tile3d
 import (
 	"encoding/json"
 	"errors"
+	"math"
 )
 
 const (
@@ -85,16 +86,29 @@ func (r *BinaryBodyReference) GetMap() map[string]interface{} {
 	return ret
 }
 
-func (r *BinaryBodyReference) FromMap(d map[string]interface{}) {
+func (r *BinaryBodyReference) FromMap(d map[string]interface{}) error {
 	if d[REF_PROP_BYTE_OFFSET] != nil {
-		r.ByteOffset = uint32(d[REF_PROP_BYTE_OFFSET].(float64))
+		offset, ok := d[REF_PROP_BYTE_OFFSET].(float64)
+		if !ok || offset < 0 || offset > math.MaxUint32 {
+			return ErrBadReference
+		}
+		r.ByteOffset = uint32(offset)
 	}
 	if d[REF_PROP_COMPONENT_TYPE] != nil {
-		r.ComponentType = d[REF_PROP_COMPONENT_TYPE].(string)
+		ct, ok := d[REF_PROP_COMPONENT_TYPE].(string)
+		if !ok {
+			return ErrBadReference
+		}
+		r.ComponentType = ct
 	}
 	if d[REF_PROP_TYPE] != nil {
-		r.ContainerType = d[REF_PROP_TYPE].(string)
+		ct, ok := d[REF_PROP_TYPE].(string)
+		if !ok {
+			return ErrBadReference
+		}
+		r.ContainerType = ct
 	}
+	return nil
 }
 
 func createReference(offset uint32, componentType *string, containerType *string) map[string]interface{} {
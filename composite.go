@@ -63,58 +63,98 @@ func (m *Cmpt) GetHeader() Header {
 	return &m.Header
 }
 
-func (m *Cmpt) CalcSize() int64 {
-	return m.Header.CalcSize()
+func (*Cmpt) GetFeatureTable() *FeatureTable { return nil }
+func (*Cmpt) GetBatchTable() *BatchTable     { return nil }
+
+func (m *Cmpt) CalcSize() (int64, error) {
+	si := m.Header.CalcSize()
+	for i := range m.Tiles {
+		ts, err := m.Tiles[i].CalcSize()
+		if err != nil {
+			return 0, err
+		}
+		si += ts
+	}
+	return si, nil
 }
 
 func (m *Cmpt) Read(reader io.ReadSeeker) error {
+	start, _ := reader.Seek(0, io.SeekCurrent)
 	err := binary.Read(reader, littleEndian, &m.Header)
 	if err != nil {
+		return newTileError(CMPT_MAGIC, "header", start, err)
+	}
+	if err := checkMagic(CMPT_MAGIC, start, m.Header.Magic); err != nil {
 		return err
 	}
+	if err := checkVersion(CMPT_MAGIC, start, m.Header.Version); err != nil {
+		return err
+	}
+	if err := checkByteLength(reader, CMPT_MAGIC, start, m.GetHeader()); err != nil {
+		return err
+	}
+
+	end := start + int64(m.Header.ByteLength)
+	if int64(m.Header.TilesLength)*12 > end-start-m.Header.CalcSize() {
+		return newTileError(CMPT_MAGIC, "tilesLength", start+12, ErrBadValue)
+	}
 
 	for i := 0; i < int(m.Header.TilesLength); i++ {
-		magic := make([]byte, 4)
-		_, err := reader.Read(magic)
-		if err != nil {
-			return err
+		tileStart, _ := reader.Seek(0, io.SeekCurrent)
+		var tileHeader [12]byte
+		if _, err := io.ReadFull(reader, tileHeader[:]); err != nil {
+			return newTileError(CMPT_MAGIC, "tiles", tileStart, err)
+		}
+		tileLength := littleEndian.Uint32(tileHeader[8:])
+		if tileStart+int64(tileLength) > end {
+			return newTileError(CMPT_MAGIC, "tiles", tileStart+8, ErrBadValue)
 		}
-		reader.Seek(-4, io.SeekCurrent)
-		switch string(magic) {
+		if _, err := reader.Seek(tileStart, io.SeekStart); err != nil {
+			return newTileError(CMPT_MAGIC, "tiles", tileStart, err)
+		}
+		var tile TileModel
+		switch string(tileHeader[:4]) {
 		case B3DM_MAGIC:
-			b3 := new(B3dm)
-			err := b3.Read(reader)
-			if err != nil {
-				return err
-			}
-			m.Tiles = append(m.Tiles, b3)
+			tile = new(B3dm)
 		case I3DM_MAGIC:
-			i3 := new(I3dm)
-			err := i3.Read(reader)
-			if err != nil {
-				return err
-			}
-			m.Tiles = append(m.Tiles, i3)
+			tile = new(I3dm)
 		case PNTS_MAGIC:
-			pn := new(Pnts)
-			err := pn.Read(reader)
-			if err != nil {
-				return err
-			}
-			m.Tiles = append(m.Tiles, pn)
+			tile = new(Pnts)
+		case CMPT_MAGIC:
+			tile = new(Cmpt)
+		default:
+			return newTileError(CMPT_MAGIC, "tiles", tileStart, ErrBadMagic)
+		}
+		if err := tile.Read(reader); err != nil {
+			return wrapTileError(CMPT_MAGIC, "tiles", 0, err)
+		}
+		m.Tiles = append(m.Tiles, tile)
+
+		if _, err := reader.Seek(tileStart+int64(tileLength), io.SeekStart); err != nil {
+			return newTileError(CMPT_MAGIC, "tiles", tileStart+8, err)
 		}
 	}
 
 	return nil
 }
 
+// ReadFromBytes reads a tile from data. Binary arrays are views over data
+// where possible, so data must not be modified while m is in use.
+func (m *Cmpt) ReadFromBytes(data []byte) error {
+	return m.Read(newSliceReader(data))
+}
+
 func (m *Cmpt) Write(writer io.Writer) error {
+	copy(m.Header.Magic[:], CMPT_MAGIC)
+	m.Header.Version = 1
 	m.Header.TilesLength = uint32(len(m.Tiles))
-	for i := range m.Tiles {
-		m.Header.ByteLength += uint32(m.Tiles[i].CalcSize())
+	si, err := m.CalcSize()
+	if err != nil {
+		return wrapTileError(CMPT_MAGIC, "tiles", -1, err)
 	}
+	m.Header.ByteLength = uint32(si)
 
-	err := binary.Write(writer, littleEndian, m.Header)
+	err = binary.Write(writer, littleEndian, m.Header)
 
 	if err != nil {
 		return err
@@ -123,7 +163,7 @@ func (m *Cmpt) Write(writer io.Writer) error {
 	for i := range m.Tiles {
 		err := m.Tiles[i].Write(writer)
 		if err != nil {
-			return err
+			return wrapTileError(CMPT_MAGIC, "tiles", -1, err)
 		}
 	}
 
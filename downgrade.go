@@ -0,0 +1,746 @@
+package tile3d
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/flywave/gltf"
+	"github.com/flywave/gltf/modeler"
+)
+
+// DowngradeIssue is metadata of a 3D Tiles 1.1 glTF that a 1.0 tile can't
+// represent.
+type DowngradeIssue struct {
+	// Property is the metadata property, or empty for the whole content.
+	Property string
+	Reason   string
+}
+
+func (i DowngradeIssue) String() string {
+	if i.Property == "" {
+		return i.Reason
+	}
+	return i.Property + ": " + i.Reason
+}
+
+// decodeGltfExtension decodes ext into v, whether ext was read as JSON or
+// set as a typed value.
+func decodeGltfExtension(ext interface{}, v interface{}) error {
+	data, err := json.Marshal(ext)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// removeGltfExtensions removes exts from the extensions used and required
+// by doc.
+func removeGltfExtensions(doc *gltf.Document, exts ...string) {
+	filter := func(list []string) []string {
+		var ret []string
+		for _, e := range list {
+			keep := true
+			for _, x := range exts {
+				keep = keep && e != x
+			}
+			if keep {
+				ret = append(ret, e)
+			}
+		}
+		return ret
+	}
+	doc.ExtensionsUsed = filter(doc.ExtensionsUsed)
+	doc.ExtensionsRequired = filter(doc.ExtensionsRequired)
+	for _, e := range exts {
+		delete(doc.Extensions, e)
+	}
+}
+
+func legacyComponentType(componentType string) string {
+	switch componentType {
+	case METADATA_COMPONENT_INT8:
+		return COMPONENT_TYPE_BYTE
+	case METADATA_COMPONENT_UINT8:
+		return COMPONENT_TYPE_UNSIGNED_BYTE
+	case METADATA_COMPONENT_INT16:
+		return COMPONENT_TYPE_SHORT
+	case METADATA_COMPONENT_UINT16:
+		return COMPONENT_TYPE_UNSIGNED_SHORT
+	case METADATA_COMPONENT_INT32:
+		return COMPONENT_TYPE_INT
+	case METADATA_COMPONENT_UINT32:
+		return COMPONENT_TYPE_UNSIGNED_INT
+	case METADATA_COMPONENT_FLOAT32:
+		return COMPONENT_TYPE_FLOAT
+	case METADATA_COMPONENT_FLOAT64:
+		return COMPONENT_TYPE_DOUBLE
+	}
+	return ""
+}
+
+func metadataComponentSize(componentType string) int {
+	switch componentType {
+	case METADATA_COMPONENT_INT8, METADATA_COMPONENT_UINT8:
+		return 1
+	case METADATA_COMPONENT_INT16, METADATA_COMPONENT_UINT16:
+		return 2
+	case METADATA_COMPONENT_INT32, METADATA_COMPONENT_UINT32, METADATA_COMPONENT_FLOAT32:
+		return 4
+	case METADATA_COMPONENT_INT64, METADATA_COMPONENT_UINT64, METADATA_COMPONENT_FLOAT64:
+		return 8
+	}
+	return 0
+}
+
+func metadataTypeComponents(tp string) int {
+	switch tp {
+	case METADATA_TYPE_SCALAR:
+		return 1
+	case METADATA_TYPE_VEC2:
+		return 2
+	case METADATA_TYPE_VEC3:
+		return 3
+	case METADATA_TYPE_VEC4, METADATA_TYPE_MAT2:
+		return 4
+	case METADATA_TYPE_MAT3:
+		return 9
+	case METADATA_TYPE_MAT4:
+		return 16
+	}
+	return 0
+}
+
+// metadataNumber returns the i-th component in b.
+func metadataNumber(b []byte, componentType string, i int) float64 {
+	switch componentType {
+	case METADATA_COMPONENT_INT8:
+		return float64(int8(b[i]))
+	case METADATA_COMPONENT_UINT8:
+		return float64(b[i])
+	case METADATA_COMPONENT_INT16:
+		return float64(int16(binary.LittleEndian.Uint16(b[i*2:])))
+	case METADATA_COMPONENT_UINT16:
+		return float64(binary.LittleEndian.Uint16(b[i*2:]))
+	case METADATA_COMPONENT_INT32:
+		return float64(int32(binary.LittleEndian.Uint32(b[i*4:])))
+	case METADATA_COMPONENT_UINT32:
+		return float64(binary.LittleEndian.Uint32(b[i*4:]))
+	case METADATA_COMPONENT_FLOAT32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:])))
+	case METADATA_COMPONENT_INT64:
+		return float64(int64(binary.LittleEndian.Uint64(b[i*8:])))
+	case METADATA_COMPONENT_UINT64:
+		return float64(binary.LittleEndian.Uint64(b[i*8:]))
+	case METADATA_COMPONENT_FLOAT64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))
+	}
+	return 0
+}
+
+func readMetadataBufferView(doc *gltf.Document, index uint32, propName string) ([]byte, error) {
+	if int(index) >= len(doc.BufferViews) {
+		return nil, newTileError("", propName, -1, ErrBadReference)
+	}
+	data, err := modeler.ReadBufferView(doc, doc.BufferViews[index])
+	if err != nil {
+		return nil, newTileError("", propName, -1, err)
+	}
+	return data, nil
+}
+
+// readMetadataOffsets returns n+1 offsets of the given type, which are
+// increasing and at most limit.
+func readMetadataOffsets(doc *gltf.Document, index *uint32, offsetType string, n, limit int, propName string) ([]int, error) {
+	if index == nil {
+		return nil, newTileError("", propName, -1, ErrBadReference)
+	}
+	if offsetType == "" {
+		offsetType = METADATA_COMPONENT_UINT32
+	}
+	data, err := readMetadataBufferView(doc, *index, propName)
+	if err != nil {
+		return nil, err
+	}
+	size := metadataComponentSize(offsetType)
+	if size == 0 || size > 8 || offsetType == METADATA_COMPONENT_INT8 || len(data) < (n+1)*size {
+		return nil, newTileError("", propName, -1, ErrBadValue)
+	}
+	ret := make([]int, n+1)
+	for i := range ret {
+		v := metadataNumber(data, offsetType, i)
+		if v < 0 || v > float64(limit) || (i > 0 && int(v) < ret[i-1]) {
+			return nil, newTileError("", propName, -1, ErrBadValue)
+		}
+		ret[i] = int(v)
+	}
+	return ret, nil
+}
+
+// metadataNoData returns the noData value of def, with numbers as float64
+// like the values of readMetadataColumn.
+func metadataNoData(def ClassProperty) interface{} {
+	if i, ok := def.NoData.(int); ok {
+		return float64(i)
+	}
+	return def.NoData
+}
+
+// readMetadataColumn returns a property of a property table of count rows
+// as a batch table property: a binary body reference with its values for
+// scalars and vectors of 8 to 32 bit components, and JSON values
+// otherwise. Columns with noData values are JSON, with the values null.
+func readMetadataColumn(doc *gltf.Document, def ClassProperty, prop PropertyTableProperty, count int, propName string) (interface{}, interface{}, error) {
+	values, err := readMetadataBufferView(doc, prop.Values, propName)
+	if err != nil {
+		return nil, nil, err
+	}
+	comps := metadataTypeComponents(def.Type)
+	size := metadataComponentSize(def.ComponentType)
+	bad := newTileError("", propName, -1, ErrBadValue)
+	noData := metadataNoData(def)
+	hasNoData := noData != nil && comps <= 1
+
+	// Elements of arrays are counted as rows of their own.
+	elems := count
+	var arrayOffsets []int
+	switch {
+	case def.Array && def.Count > 0:
+		elems = count * int(def.Count)
+	case def.Array:
+		if arrayOffsets, err = readMetadataOffsets(doc, prop.ArrayOffsets, prop.ArrayOffsetType, count, math.MaxInt32, propName); err != nil {
+			return nil, nil, err
+		}
+		elems = arrayOffsets[count]
+	}
+
+	var elem func(i int) interface{}
+	switch def.Type {
+	case METADATA_TYPE_BOOLEAN:
+		if len(values)*8 < elems {
+			return nil, nil, bad
+		}
+		elem = func(i int) interface{} { return values[i/8]>>(i%8)&1 == 1 }
+	case METADATA_TYPE_STRING:
+		stringOffsets, err := readMetadataOffsets(doc, prop.StringOffsets, prop.StringOffsetType, elems, len(values), propName)
+		if err != nil {
+			return nil, nil, err
+		}
+		elem = func(i int) interface{} { return string(values[stringOffsets[i]:stringOffsets[i+1]]) }
+	default:
+		if comps == 0 || size == 0 || len(values) < elems*comps*size {
+			return nil, nil, bad
+		}
+		binary := !def.Array && comps <= 4 && def.Type != METADATA_TYPE_MAT2 && size <= 4
+		for i := 0; binary && hasNoData && i < count; i++ {
+			binary = metadataNumber(values, def.ComponentType, i) != noData
+		}
+		if binary {
+			ref := BinaryBodyReference{
+				ComponentType: legacyComponentType(def.ComponentType),
+				ContainerType: def.Type,
+			}
+			data, err := getBatchTableValuesFromRef(&ref, values, propName, count)
+			return ref, data, err
+		}
+		elem = func(i int) interface{} {
+			if comps == 1 {
+				return metadataNumber(values, def.ComponentType, i)
+			}
+			v := make([]interface{}, comps)
+			for c := range v {
+				v[c] = metadataNumber(values, def.ComponentType, i*comps+c)
+			}
+			return v
+		}
+	}
+
+	ret := make([]interface{}, count)
+	for i := range ret {
+		switch {
+		case !def.Array:
+			ret[i] = elem(i)
+			if hasNoData && ret[i] == noData {
+				ret[i] = nil
+			}
+			continue
+		case def.Count > 0:
+			arr := make([]interface{}, def.Count)
+			for j := range arr {
+				arr[j] = elem(i*int(def.Count) + j)
+			}
+			ret[i] = arr
+		default:
+			arr := make([]interface{}, arrayOffsets[i+1]-arrayOffsets[i])
+			for j := range arr {
+				arr[j] = elem(arrayOffsets[i] + j)
+			}
+			ret[i] = arr
+		}
+	}
+	return ret, nil, nil
+}
+
+// newBatchTableFromMetadata returns the property table of doc at index as
+// a batch table with its number of rows. Properties are named by their
+// name when it is set and by their id otherwise.
+func newBatchTableFromMetadata(doc *gltf.Document, meta *StructuralMetadata, index int) (*BatchTable, int, []DowngradeIssue, error) {
+	var issues []DowngradeIssue
+	if len(meta.PropertyTextures) > 0 || len(meta.PropertyAttributes) > 0 {
+		issues = append(issues, DowngradeIssue{Reason: "property textures and property attributes are not supported"})
+	}
+	if len(meta.PropertyTables) > 1 {
+		issues = append(issues, DowngradeIssue{Reason: "only the first property table with features is kept"})
+	}
+	if index < 0 || index >= len(meta.PropertyTables) {
+		return nil, 0, issues, nil
+	}
+	if meta.Schema == nil {
+		issues = append(issues, DowngradeIssue{Reason: "external schemas are not supported"})
+		return nil, 0, issues, nil
+	}
+	table := meta.PropertyTables[index]
+	class, ok := meta.Schema.Classes[table.Class]
+	if !ok {
+		return nil, 0, issues, newTileError("", table.Class, -1, ErrBadReference)
+	}
+
+	ids := make([]string, 0, len(table.Properties))
+	for id := range table.Properties {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	used := make(map[string]bool)
+	for _, id := range ids {
+		if def := class.Properties[id]; def.Name == "" {
+			used[id] = true
+		}
+	}
+
+	t := &BatchTable{Header: make(map[string]interface{}), Data: make(map[string]interface{})}
+	for _, id := range ids {
+		def, ok := class.Properties[id]
+		if !ok {
+			return nil, 0, issues, newTileError("", id, -1, ErrBadReference)
+		}
+		prop := table.Properties[id]
+		switch {
+		case def.Type == METADATA_TYPE_ENUM:
+			issues = append(issues, DowngradeIssue{id, "enums are not supported"})
+			continue
+		case def.Type == METADATA_TYPE_STRING && def.Array:
+			issues = append(issues, DowngradeIssue{id, "arrays of strings are not supported"})
+			continue
+		case def.Normalized || def.Offset != nil || def.Scale != nil || prop.Offset != nil || prop.Scale != nil:
+			issues = append(issues, DowngradeIssue{id, "normalization, offset and scale are not applied"})
+		case def.ComponentType == METADATA_COMPONENT_INT64 || def.ComponentType == METADATA_COMPONENT_UINT64:
+			issues = append(issues, DowngradeIssue{id, "64 bit integers are stored as JSON numbers"})
+		}
+		name := id
+		if def.Name != "" && !used[def.Name] {
+			name = def.Name
+		}
+		used[name] = true
+
+		header, data, err := readMetadataColumn(doc, def, prop, int(table.Count), id)
+		if err != nil {
+			return nil, 0, issues, err
+		}
+		t.Header[name] = header
+		if data != nil {
+			t.Data[name] = data
+		} else {
+			t.Data[name] = header
+		}
+	}
+	return t, int(table.Count), issues, nil
+}
+
+// readFeatureIds returns the values of a feature id accessor.
+func readFeatureIds(doc *gltf.Document, index uint32) ([]uint32, error) {
+	if int(index) >= len(doc.Accessors) {
+		return nil, newTileError("", GLTF_ATTR_FEATURE_ID, -1, ErrBadReference)
+	}
+	v, err := modeler.ReadAccessor(doc, doc.Accessors[index], nil)
+	if err != nil {
+		return nil, newTileError("", GLTF_ATTR_FEATURE_ID, -1, err)
+	}
+	var ret []uint32
+	switch ids := v.(type) {
+	case []uint8:
+		for _, id := range ids {
+			ret = append(ret, uint32(id))
+		}
+	case []uint16:
+		for _, id := range ids {
+			ret = append(ret, uint32(id))
+		}
+	case []uint32:
+		ret = ids
+	case []float32:
+		for _, id := range ids {
+			ret = append(ret, uint32(id))
+		}
+	default:
+		return nil, newTileError("", GLTF_ATTR_FEATURE_ID, -1, ErrBadValue)
+	}
+	return ret, nil
+}
+
+// featureIdAttribute returns the name of the attribute of a feature id set.
+func featureIdAttribute(fid FeatureId) string {
+	return "_FEATURE_ID_" + strconv.Itoa(int(*fid.Attribute))
+}
+
+// selectFeatureId returns the feature id set of sets to keep, the first
+// one with a property table, and reports the others.
+func selectFeatureId(sets []FeatureId, issues *[]DowngradeIssue) (FeatureId, bool) {
+	if len(sets) == 0 {
+		return FeatureId{}, false
+	}
+	ret := sets[0]
+	for _, s := range sets {
+		if s.PropertyTable != nil {
+			ret = s
+			break
+		}
+	}
+	if len(sets) > 1 {
+		*issues = append(*issues, DowngradeIssue{Reason: "only one feature id set is kept"})
+	}
+	if ret.Texture != nil {
+		*issues = append(*issues, DowngradeIssue{Reason: "feature id textures are not supported"})
+		return FeatureId{}, false
+	}
+	if ret.NullFeatureId != nil {
+		*issues = append(*issues, DowngradeIssue{Reason: "null feature ids are kept as batch ids"})
+	}
+	return ret, true
+}
+
+// downgradeMetadata decodes EXT_structural_metadata and CESIUM_RTC of doc
+// and removes them with the feature extensions.
+func downgradeMetadata(doc *gltf.Document) (*StructuralMetadata, []float64, error) {
+	var meta *StructuralMetadata
+	if ext, ok := doc.Extensions[EXT_STRUCTURAL_METADATA]; ok {
+		meta = &StructuralMetadata{}
+		if err := decodeGltfExtension(ext, meta); err != nil {
+			return nil, nil, newTileError("", EXT_STRUCTURAL_METADATA, -1, err)
+		}
+	}
+	var rtc []float64
+	if ext, ok := doc.Extensions[CESIUM_RTC]; ok {
+		var c CesiumRTC
+		if err := decodeGltfExtension(ext, &c); err != nil {
+			return nil, nil, newTileError("", CESIUM_RTC, -1, err)
+		}
+		rtc = c.Center[:]
+	}
+	removeGltfExtensions(doc, EXT_STRUCTURAL_METADATA, EXT_MESH_FEATURES, EXT_INSTANCE_FEATURES, EXT_MESH_GPU_INSTANCING, CESIUM_RTC)
+	return meta, rtc, nil
+}
+
+// DowngradeB3dm converts a 3D Tiles 1.1 glTF to a b3dm for 1.0 runtimes.
+// Feature ids of EXT_mesh_features become _BATCHID and the property table
+// they refer to in EXT_structural_metadata becomes the batch table. It
+// returns the metadata that a batch table can't represent.
+func DowngradeB3dm(doc *gltf.Document) (*B3dm, []DowngradeIssue, error) {
+	doc, err := copyGltf(doc)
+	if err != nil {
+		return nil, nil, newTileError(B3DM_MAGIC, "glTF", -1, err)
+	}
+	meta, rtc, err := downgradeMetadata(doc)
+	if err != nil {
+		return nil, nil, wrapTileError(B3DM_MAGIC, "glTF", -1, err)
+	}
+
+	var issues []DowngradeIssue
+	batchLength, tableIndex := 0, -1
+	for _, mesh := range doc.Meshes {
+		for _, p := range mesh.Primitives {
+			ext, ok := p.Extensions[EXT_MESH_FEATURES]
+			if !ok {
+				continue
+			}
+			delete(p.Extensions, EXT_MESH_FEATURES)
+			var features MeshFeatures
+			if err := decodeGltfExtension(ext, &features); err != nil {
+				return nil, nil, newTileError(B3DM_MAGIC, EXT_MESH_FEATURES, -1, err)
+			}
+			fid, ok := selectFeatureId(features.FeatureIds, &issues)
+			if !ok {
+				continue
+			}
+			if fid.Attribute != nil {
+				index, ok := p.Attributes[featureIdAttribute(fid)]
+				if !ok {
+					return nil, nil, newTileError(B3DM_MAGIC, featureIdAttribute(fid), -1, ErrBadReference)
+				}
+				delete(p.Attributes, featureIdAttribute(fid))
+				p.Attributes[GLTF_ATTR_BATCHID] = index
+			} else {
+				// Feature ids are the vertex indices.
+				pos, ok := p.Attributes[gltf.POSITION]
+				if !ok || int(pos) >= len(doc.Accessors) {
+					return nil, nil, newTileError(B3DM_MAGIC, gltf.POSITION, -1, ErrBadReference)
+				}
+				ids := make([]uint32, doc.Accessors[pos].Count)
+				for i := range ids {
+					ids[i] = uint32(i)
+				}
+				i, err := writeFeatureIds(doc, gltf.TargetArrayBuffer, ids)
+				if err != nil {
+					return nil, nil, err
+				}
+				p.Attributes[GLTF_ATTR_BATCHID] = i
+			}
+			if int(fid.FeatureCount) > batchLength {
+				batchLength = int(fid.FeatureCount)
+			}
+			if fid.PropertyTable != nil && tableIndex < 0 {
+				tableIndex = int(*fid.PropertyTable)
+			}
+		}
+	}
+
+	m := NewB3dm()
+	if meta != nil {
+		if tableIndex < 0 {
+			tableIndex = 0
+		}
+		t, count, tableIssues, err := newBatchTableFromMetadata(doc, meta, tableIndex)
+		issues = append(issues, tableIssues...)
+		if err != nil {
+			return nil, issues, wrapTileError(B3DM_MAGIC, EXT_STRUCTURAL_METADATA, -1, err)
+		}
+		if t != nil {
+			m.BatchTable = *t
+			batchLength = count
+		}
+	}
+	m.SetFeatureTable(B3dmFeatureTableView{BatchLength: batchLength, RtcCenter: rtc})
+	m.Model = doc
+	return m, issues, nil
+}
+
+// DowngradeI3dm converts a 3D Tiles 1.1 glTF with a node instanced by
+// EXT_mesh_gpu_instancing to an i3dm for 1.0 runtimes. The instance
+// transforms become POSITION, NORMAL_UP, NORMAL_RIGHT and SCALE or
+// SCALE_NON_UNIFORM, feature ids of EXT_instance_features become BATCH_ID
+// and their property table becomes the batch table. The model is the mesh
+// of the first instanced node; other mesh nodes are reported and dropped.
+func DowngradeI3dm(doc *gltf.Document) (*I3dm, []DowngradeIssue, error) {
+	doc, err := copyGltf(doc)
+	if err != nil {
+		return nil, nil, newTileError(I3DM_MAGIC, "glTF", -1, err)
+	}
+
+	type meshNode struct {
+		node   *gltf.Node
+		matrix [16]float64
+	}
+	var instanced []meshNode
+	dropped := false
+	var walk func(index uint32, parent [16]float64, depth int)
+	walk = func(index uint32, parent [16]float64, depth int) {
+		if int(index) >= len(doc.Nodes) || depth > len(doc.Nodes) {
+			return
+		}
+		node := doc.Nodes[index]
+		matrix := mat4Mul(parent, nodeMatrix(node))
+		if node.Mesh != nil {
+			if _, ok := node.Extensions[EXT_MESH_GPU_INSTANCING]; ok && len(instanced) == 0 {
+				instanced = append(instanced, meshNode{node, matrix})
+			} else {
+				dropped = true
+			}
+		}
+		for _, c := range node.Children {
+			walk(c, matrix, depth+1)
+		}
+	}
+	identity := [16]float64{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+	for _, root := range gltfScene(doc).Nodes {
+		walk(root, identity, 0)
+	}
+	var issues []DowngradeIssue
+	if dropped {
+		issues = append(issues, DowngradeIssue{Reason: "only the first instanced mesh is kept"})
+	}
+	if len(instanced) == 0 {
+		return nil, issues, newTileError(I3DM_MAGIC, EXT_MESH_GPU_INSTANCING, -1, ErrMissingModel)
+	}
+	node := instanced[0].node
+
+	var instancing MeshGpuInstancing
+	if err := decodeGltfExtension(node.Extensions[EXT_MESH_GPU_INSTANCING], &instancing); err != nil {
+		return nil, nil, newTileError(I3DM_MAGIC, EXT_MESH_GPU_INSTANCING, -1, err)
+	}
+	attrs := make(map[string]interface{})
+	n := -1
+	for k, index := range instancing.Attributes {
+		if int(index) >= len(doc.Accessors) || (n >= 0 && int(doc.Accessors[index].Count) != n) {
+			return nil, nil, newTileError(I3DM_MAGIC, k, -1, ErrBadReference)
+		}
+		n = int(doc.Accessors[index].Count)
+		if attrs[k], err = modeler.ReadAccessor(doc, doc.Accessors[index], nil); err != nil {
+			return nil, nil, newTileError(I3DM_MAGIC, k, -1, err)
+		}
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	translations, _ := attrs["TRANSLATION"].([][3]float32)
+	scales, _ := attrs["SCALE"].([][3]float32)
+	rotations := make([][4]float64, n)
+	switch r := attrs["ROTATION"].(type) {
+	case [][4]float32:
+		for i, q := range r {
+			rotations[i] = [4]float64{float64(q[0]), float64(q[1]), float64(q[2]), float64(q[3])}
+		}
+	case [][4]int8:
+		for i, q := range r {
+			for j := range q {
+				rotations[i][j] = math.Max(float64(q[j])/127, -1)
+			}
+		}
+	case [][4]int16:
+		for i, q := range r {
+			for j := range q {
+				rotations[i][j] = math.Max(float64(q[j])/32767, -1)
+			}
+		}
+	case nil:
+		for i := range rotations {
+			rotations[i][3] = 1
+		}
+	default:
+		return nil, nil, newTileError(I3DM_MAGIC, "ROTATION", -1, ErrBadValue)
+	}
+	if (attrs["TRANSLATION"] != nil && translations == nil) || (attrs["SCALE"] != nil && scales == nil) {
+		return nil, nil, newTileError(I3DM_MAGIC, EXT_MESH_GPU_INSTANCING, -1, ErrBadValue)
+	}
+
+	view := I3dmFeatureTableView{InstanceLength: n, Position: make([][3]float32, n)}
+	rights, ups := make([][3]float32, n), make([][3]float32, n)
+	scaleNonUniform := make([][3]float32, n)
+	rotated, scaled, uniform := false, false, true
+	for i := 0; i < n; i++ {
+		inst := &gltf.Node{Rotation: [4]float32{float32(rotations[i][0]), float32(rotations[i][1]), float32(rotations[i][2]), float32(rotations[i][3])}}
+		if translations != nil {
+			inst.Translation = translations[i]
+		}
+		if scales != nil {
+			inst.Scale = scales[i]
+		}
+		// The instance transform of the i3dm applies to the model after it
+		// is converted to z-up.
+		m := mat4Mul(yUpToZUp, mat4Mul(instanced[0].matrix, mat4Mul(nodeMatrix(inst), zUpToYUp)))
+		var s [3]float64
+		var axes [3][3]float64
+		for c := 0; c < 3; c++ {
+			s[c] = math.Sqrt(m[c*4]*m[c*4] + m[c*4+1]*m[c*4+1] + m[c*4+2]*m[c*4+2])
+			for r := 0; r < 3; r++ {
+				if s[c] != 0 {
+					axes[c][r] = m[c*4+r] / s[c]
+				}
+			}
+		}
+		if d := cross64(axes[0], axes[1]); d[0]*axes[2][0]+d[1]*axes[2][1]+d[2]*axes[2][2] < 0 {
+			s[2] = -s[2]
+		}
+		view.Position[i] = [3]float32{float32(m[12]), float32(m[13]), float32(m[14])}
+		rights[i] = [3]float32{float32(axes[0][0]), float32(axes[0][1]), float32(axes[0][2])}
+		ups[i] = [3]float32{float32(axes[1][0]), float32(axes[1][1]), float32(axes[1][2])}
+		scaleNonUniform[i] = [3]float32{float32(s[0]), float32(s[1]), float32(s[2])}
+		rotated = rotated || rights[i] != [3]float32{1, 0, 0} || ups[i] != [3]float32{0, 1, 0}
+		scaled = scaled || scaleNonUniform[i] != [3]float32{1, 1, 1}
+		uniform = uniform && s[0] == s[1] && s[0] == s[2]
+	}
+	if rotated {
+		view.NormalRight, view.NormalUp = rights, ups
+	}
+	if scaled && uniform {
+		view.Scale = make([]float32, n)
+		for i, s := range scaleNonUniform {
+			view.Scale[i] = s[0]
+		}
+	} else if scaled {
+		view.ScaleNONUniform = scaleNonUniform
+	}
+
+	tableIndex := -1
+	if ext, ok := node.Extensions[EXT_INSTANCE_FEATURES]; ok {
+		var features InstanceFeatures
+		if err := decodeGltfExtension(ext, &features); err != nil {
+			return nil, nil, newTileError(I3DM_MAGIC, EXT_INSTANCE_FEATURES, -1, err)
+		}
+		if fid, ok := selectFeatureId(features.FeatureIds, &issues); ok {
+			if fid.Attribute != nil {
+				index, ok := instancing.Attributes[featureIdAttribute(fid)]
+				if !ok {
+					return nil, nil, newTileError(I3DM_MAGIC, featureIdAttribute(fid), -1, ErrBadReference)
+				}
+				ids, err := readFeatureIds(doc, index)
+				if err != nil {
+					return nil, nil, wrapTileError(I3DM_MAGIC, EXT_INSTANCE_FEATURES, -1, err)
+				}
+				view.BatchId = ids
+				max := uint32(0)
+				for _, id := range ids {
+					if id > max {
+						max = id
+					}
+				}
+				if max <= math.MaxUint16 {
+					short := make([]uint16, len(ids))
+					for i, id := range ids {
+						short[i] = uint16(id)
+					}
+					view.BatchId = short
+				}
+			}
+			if fid.PropertyTable != nil {
+				tableIndex = int(*fid.PropertyTable)
+			}
+		}
+	}
+	for _, mesh := range doc.Meshes {
+		for _, p := range mesh.Primitives {
+			if _, ok := p.Extensions[EXT_MESH_FEATURES]; ok {
+				delete(p.Extensions, EXT_MESH_FEATURES)
+				issues = append(issues, DowngradeIssue{Reason: "feature ids of primitives are not supported"})
+			}
+		}
+	}
+
+	meta, rtc, err := downgradeMetadata(doc)
+	if err != nil {
+		return nil, nil, wrapTileError(I3DM_MAGIC, "glTF", -1, err)
+	}
+	view.RtcCenter = rtc
+	m := &I3dm{}
+	if meta != nil && tableIndex >= 0 {
+		t, _, tableIssues, err := newBatchTableFromMetadata(doc, meta, tableIndex)
+		issues = append(issues, tableIssues...)
+		if err != nil {
+			return nil, issues, wrapTileError(I3DM_MAGIC, EXT_STRUCTURAL_METADATA, -1, err)
+		}
+		if t != nil {
+			m.BatchTable = *t
+		}
+	}
+	m.SetFeatureTable(view)
+
+	doc.Nodes = []*gltf.Node{{Mesh: node.Mesh}}
+	doc.Scenes = []*gltf.Scene{{Nodes: []uint32{0}}}
+	doc.Scene = gltf.Index(0)
+	doc.Skins = nil
+	doc.Animations = nil
+	m.Header.GltfFormat = I3DM_GLTF_EMBEDDED
+	m.Model = doc
+	return m, issues, nil
+}
@@ -0,0 +1,189 @@
+package tile3d
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/flywave/gltf"
+)
+
+func TestDowngradeB3dm(t *testing.T) {
+	g := &batchedMesh{}
+	identity := [16]float64{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+	g.add(newGeomUnitBox(), identity, 0)
+	g.add(newGeomUnitBox(), identity, 1)
+	m := NewB3dm()
+	m.Model = gltf.NewDocument()
+	setBatchedMesh(m.Model, g.primitive(m.Model, gltf.PrimitiveTriangles))
+	m.SetFeatureTable(B3dmFeatureTableView{BatchLength: 2, RtcCenter: []float64{1, 2, 3}})
+	m.BatchTable.Header = map[string]interface{}{
+		"floor name": []interface{}{"ground", nil},
+		"floors":     []interface{}{3.0, nil},
+		"sizes":      []interface{}{[]interface{}{1.0, 2.0}, []interface{}{3.0}},
+		"height":     BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC2},
+	}
+	m.BatchTable.Data = map[string]interface{}{"height": []float32{1, 2, 3, 4}}
+
+	doc, err := m.Upgrade(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, issues, err := DowngradeB3dm(upgradedGltf(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 0 {
+		t.Errorf("issues %v", issues)
+	}
+	if r.BatchLength() != 2 {
+		t.Errorf("batch length %d", r.BatchLength())
+	}
+	if rtc, ok := r.FeatureTable.getVec3(B3DM_PROP_RTC_CENTER); !ok || rtc != [3]float64{1, 2, 3} {
+		t.Errorf("rtc %v", rtc)
+	}
+	if _, ok := r.Model.Meshes[0].Primitives[0].Attributes[GLTF_ATTR_BATCHID]; !ok {
+		t.Error("missing _BATCHID")
+	}
+	if len(r.Model.ExtensionsUsed) != 0 || len(r.Model.Extensions) != 0 {
+		t.Errorf("extensions %v", r.Model.ExtensionsUsed)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b := NewB3dm()
+	if err := b.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	names := b.BatchTable.Header["floor name"].([]interface{})
+	if names[0] != "ground" || names[1] != nil {
+		t.Errorf("names %v", names)
+	}
+	if floors, ok := b.BatchTable.Header["floors"].([]interface{}); !ok || floors[0] != 3.0 || floors[1] != nil {
+		t.Errorf("floors %v", b.BatchTable.Header["floors"])
+	}
+	if sizes := b.BatchTable.Header["sizes"].([]interface{}); len(sizes[0].([]interface{})) != 2 {
+		t.Errorf("sizes %v", sizes)
+	}
+	ref := b.BatchTable.Header["height"].(BinaryBodyReference)
+	if ref.ContainerType != CONTAINER_TYPE_VEC2 || ref.ComponentType != COMPONENT_TYPE_FLOAT {
+		t.Errorf("height %+v", ref)
+	}
+	if h := b.BatchTable.Data["height"].([]float32); h[3] != 4 {
+		t.Errorf("height %v", h)
+	}
+}
+
+func TestDowngradeIssues(t *testing.T) {
+	doc := gltf.NewDocument()
+	g := &batchedMesh{}
+	g.add(newGeomUnitBox(), [16]float64{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}, 0)
+	setBatchedMesh(doc, g.primitive(doc, gltf.PrimitiveTriangles))
+	prim := doc.Meshes[0].Primitives[0]
+	delete(prim.Attributes, GLTF_ATTR_BATCHID)
+	prim.Extensions = gltf.Extensions{EXT_MESH_FEATURES: &MeshFeatures{
+		FeatureIds: []FeatureId{{FeatureCount: 1, PropertyTable: gltf.Index(0)}},
+	}}
+	values := writeMetadataBufferView(doc, []byte{0})
+	doc.Extensions = gltf.Extensions{EXT_STRUCTURAL_METADATA: &StructuralMetadata{
+		Schema: &MetadataSchema{Id: "s", Classes: map[string]MetadataClass{"c": {Properties: map[string]ClassProperty{
+			"kind":  {Type: METADATA_TYPE_ENUM, EnumType: "kinds"},
+			"tags":  {Type: METADATA_TYPE_STRING, Array: true, Count: 1},
+			"level": {Type: METADATA_TYPE_SCALAR, ComponentType: METADATA_COMPONENT_UINT8},
+		}}}},
+		PropertyTables: []PropertyTable{{Class: "c", Count: 1, Properties: map[string]PropertyTableProperty{
+			"kind":  {Values: values},
+			"tags":  {Values: values},
+			"level": {Values: values},
+		}}},
+	}}
+
+	m, issues, err := DowngradeB3dm(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := map[string]bool{}
+	for _, i := range issues {
+		reported[i.Property] = true
+	}
+	if len(issues) != 2 || !reported["kind"] || !reported["tags"] {
+		t.Errorf("issues %v", issues)
+	}
+	if _, ok := m.BatchTable.Header["level"]; !ok || len(m.BatchTable.Header) != 1 {
+		t.Errorf("batch table %v", m.BatchTable.Header)
+	}
+	// Implicit feature ids become the vertex index.
+	if _, ok := m.Model.Meshes[0].Primitives[0].Attributes[GLTF_ATTR_BATCHID]; !ok {
+		t.Error("missing _BATCHID")
+	}
+}
+
+func TestDowngradeI3dm(t *testing.T) {
+	m := &I3dm{Model: openGltf("./data/box.glb")}
+	m.Header.GltfFormat = I3DM_GLTF_EMBEDDED
+	m.SetFeatureTable(I3dmFeatureTableView{
+		Position:        [][3]float32{{10, 0, 0}, {0, 20, 0}},
+		RtcCenter:       []float64{100, 200, 300},
+		NormalUp:        [][3]float32{{0, 0, 1}, {0, 0, 1}},
+		NormalRight:     [][3]float32{{0, 1, 0}, {0, 1, 0}},
+		ScaleNONUniform: [][3]float32{{1, 2, 3}, {1, 1, 1}},
+		BatchId:         []uint16{1, 0},
+		InstanceLength:  2,
+	})
+	m.BatchTable.Header = map[string]interface{}{"name": []interface{}{"x", "y"}}
+
+	doc, err := m.Upgrade(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, issues, err := DowngradeI3dm(upgradedGltf(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 0 {
+		t.Errorf("issues %v", issues)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b := &I3dm{}
+	if err := b.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := m.InstanceTransforms()
+	got, err := b.InstanceTransforms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(got[i][j]-want[i][j]) > 1e-5 {
+				t.Fatalf("instance %d: %v != %v", i, got[i], want[i])
+			}
+		}
+	}
+	ids, _ := b.BatchIds()
+	if len(ids) != 2 || ids[0] != 1 {
+		t.Errorf("batch ids %v", ids)
+	}
+	if names := b.BatchTable.Header["name"].([]interface{}); names[1] != "y" {
+		t.Errorf("names %v", names)
+	}
+
+	// Ids above 65535 are kept whatever the number of instances.
+	m.FeatureTable.Data[I3DM_PROP_BATCH_ID] = []uint32{70000, 0}
+	m.BatchTable.Header = map[string]interface{}{}
+	if doc, err = m.Upgrade(nil); err != nil {
+		t.Fatal(err)
+	}
+	if r, _, err = DowngradeI3dm(upgradedGltf(t, doc)); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := r.BatchIds(); len(ids) != 2 || ids[0] != 70000 {
+		t.Errorf("large batch ids %v", ids)
+	}
+}
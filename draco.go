@@ -0,0 +1,1181 @@
+package tile3d
+
+import (
+	"math"
+)
+
+// Point cloud decoding and encoding of the Draco bit stream, as used by
+// 3DTILES_draco_point_compression. Meshes are not supported.
+
+const (
+	DRACO_MAGIC = "DRACO"
+)
+
+const (
+	dracoPointCloud        = 0
+	dracoSequentialMethod  = 0
+	dracoKdTreeMethod      = 1
+	dracoMetadataFlag      = 0x8000
+	dracoMaxBitstreamMinor = 3
+)
+
+const (
+	dracoAttributePosition = 0
+	dracoAttributeNormal   = 1
+	dracoAttributeColor    = 2
+	dracoAttributeTexCoord = 3
+	dracoAttributeGeneric  = 4
+)
+
+const (
+	dracoInt8    = 1
+	dracoUint8   = 2
+	dracoInt16   = 3
+	dracoUint16  = 4
+	dracoInt32   = 5
+	dracoUint32  = 6
+	dracoInt64   = 7
+	dracoUint64  = 8
+	dracoFloat32 = 9
+	dracoFloat64 = 10
+	dracoBool    = 11
+)
+
+var dracoDataTypeSize = []int{0, 1, 1, 2, 2, 4, 4, 8, 8, 4, 8, 1}
+
+const (
+	dracoDecoderGeneric      = 0
+	dracoDecoderInteger      = 1
+	dracoDecoderQuantization = 2
+	dracoDecoderNormals      = 3
+)
+
+const (
+	dracoPredictionNone       = -2
+	dracoPredictionDifference = 0
+
+	dracoTransformWrap                          = 1
+	dracoTransformNormalOctahedron              = 2
+	dracoTransformNormalOctahedronCanonicalized = 3
+)
+
+const (
+	dracoSymbolCodingTagged = 0
+	dracoSymbolCodingRaw    = 1
+)
+
+type dracoAttribute struct {
+	Type          uint8
+	DataType      uint8
+	NumComponents int
+	Normalized    bool
+	UniqueId      uint32
+
+	// QuantizationBits is the number of bits float values were quantized
+	// to, 0 when they are stored as is.
+	QuantizationBits int
+	// Values holds NumComponents values per point.
+	Values []float64
+}
+
+func (a *dracoAttribute) isFloat() bool {
+	return a.DataType == dracoFloat32 || a.DataType == dracoFloat64
+}
+
+func (a *dracoAttribute) isSigned() bool {
+	return a.DataType == dracoInt8 || a.DataType == dracoInt16 || a.DataType == dracoInt32 || a.DataType == dracoInt64
+}
+
+type dracoPointCloudData struct {
+	NumPoints  int
+	Attributes []*dracoAttribute
+}
+
+func (pc *dracoPointCloudData) getAttribute(uniqueId uint32) *dracoAttribute {
+	for _, a := range pc.Attributes {
+		if a.UniqueId == uniqueId {
+			return a
+		}
+	}
+	return nil
+}
+
+type dracoReader struct {
+	data    []byte
+	pos     int
+	version int
+}
+
+func (r *dracoReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *dracoReader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > r.remaining() {
+		return nil, ErrTruncated
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *dracoReader) uint8() (uint8, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *dracoReader) uint16() (uint16, error) {
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return littleEndian.Uint16(b), nil
+}
+
+func (r *dracoReader) uint32() (uint32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return littleEndian.Uint32(b), nil
+}
+
+func (r *dracoReader) uint64() (uint64, error) {
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return littleEndian.Uint64(b), nil
+}
+
+func (r *dracoReader) float32() (float32, error) {
+	v, err := r.uint32()
+	return math.Float32frombits(v), err
+}
+
+func (r *dracoReader) varint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.uint8()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, ErrBadValue
+}
+
+func (r *dracoReader) varint32() (uint32, error) {
+	v, err := r.varint()
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxUint32 {
+		return 0, ErrBadValue
+	}
+	return uint32(v), nil
+}
+
+func dracoSymbolToSigned(v uint32) int32 {
+	if v&1 == 0 {
+		return int32(v >> 1)
+	}
+	return -int32(v>>1) - 1
+}
+
+func dracoSignedToSymbol(v int32) uint32 {
+	if v >= 0 {
+		return uint32(v) << 1
+	}
+	return uint32(-(v+1))<<1 | 1
+}
+
+func dracoMostSignificantBit(v uint32) int {
+	n := -1
+	for ; v != 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// decodeDraco decodes a Draco point cloud of numPoints points.
+func decodeDraco(data []byte, numPoints int) (*dracoPointCloudData, error) {
+	r := &dracoReader{data: data}
+	magic, err := r.bytes(5)
+	if err != nil {
+		return nil, err
+	}
+	if string(magic) != DRACO_MAGIC {
+		return nil, ErrBadMagic
+	}
+	major, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	minor, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	if major != 2 || minor > dracoMaxBitstreamMinor {
+		return nil, ErrUnsupportedVersion
+	}
+	r.version = int(major)<<8 | int(minor)
+	encoderType, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	if encoderType != dracoPointCloud {
+		return nil, ErrBadValue
+	}
+	method, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	if method != dracoSequentialMethod && method != dracoKdTreeMethod {
+		return nil, ErrBadValue
+	}
+	if method == dracoKdTreeMethod && r.version < 0x0203 {
+		return nil, ErrUnsupportedVersion
+	}
+	flags, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	if flags&dracoMetadataFlag != 0 {
+		if err := skipDracoGeometryMetadata(r); err != nil {
+			return nil, err
+		}
+	}
+
+	n, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) != int64(numPoints) {
+		return nil, ErrBadValue
+	}
+	pc := &dracoPointCloudData{NumPoints: numPoints}
+
+	numDecoders, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	decoders := make([][]*dracoAttribute, numDecoders)
+	decoderTypes := make([][]uint8, numDecoders)
+	for i := range decoders {
+		if decoders[i], err = decodeDracoAttributesHeader(r); err != nil {
+			return nil, err
+		}
+		pc.Attributes = append(pc.Attributes, decoders[i]...)
+		if method == dracoKdTreeMethod {
+			continue
+		}
+		decoderTypes[i] = make([]uint8, len(decoders[i]))
+		for j := range decoders[i] {
+			if decoderTypes[i][j], err = r.uint8(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i, atts := range decoders {
+		if method == dracoKdTreeMethod {
+			err = decodeDracoKdTreeAttributes(r, atts, numPoints)
+		} else {
+			err = decodeDracoSequentialAttributes(r, atts, decoderTypes[i], numPoints)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pc, nil
+}
+
+func skipDracoName(r *dracoReader) error {
+	n, err := r.uint8()
+	if err != nil {
+		return err
+	}
+	_, err = r.bytes(int(n))
+	return err
+}
+
+func skipDracoMetadata(r *dracoReader, depth int) error {
+	if depth > 32 {
+		return ErrBadValue
+	}
+	entries, err := r.varint32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < entries; i++ {
+		if err := skipDracoName(r); err != nil {
+			return err
+		}
+		size, err := r.varint32()
+		if err != nil {
+			return err
+		}
+		if _, err := r.bytes(int(size)); err != nil {
+			return err
+		}
+	}
+	subs, err := r.varint32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < subs; i++ {
+		if err := skipDracoName(r); err != nil {
+			return err
+		}
+		if err := skipDracoMetadata(r, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func skipDracoGeometryMetadata(r *dracoReader) error {
+	n, err := r.varint32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		if _, err := r.varint32(); err != nil {
+			return err
+		}
+		if err := skipDracoMetadata(r, 0); err != nil {
+			return err
+		}
+	}
+	return skipDracoMetadata(r, 0)
+}
+
+func decodeDracoAttributesHeader(r *dracoReader) ([]*dracoAttribute, error) {
+	n, err := r.varint32()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 || int64(n) > 5*int64(r.remaining()) {
+		return nil, ErrBadValue
+	}
+	atts := make([]*dracoAttribute, n)
+	for i := range atts {
+		b, err := r.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] > dracoAttributeGeneric || b[1] == 0 || b[1] > dracoBool || b[2] == 0 {
+			return nil, ErrBadValue
+		}
+		a := &dracoAttribute{Type: b[0], DataType: b[1], NumComponents: int(b[2]), Normalized: b[3] > 0}
+		if a.UniqueId, err = r.varint32(); err != nil {
+			return nil, err
+		}
+		atts[i] = a
+	}
+	return atts, nil
+}
+
+type dracoPortableAttribute struct {
+	values        []int32
+	numComponents int
+}
+
+func decodeDracoSequentialAttributes(r *dracoReader, atts []*dracoAttribute, types []uint8, numPoints int) error {
+	portable := make([]dracoPortableAttribute, len(atts))
+	for i, a := range atts {
+		var err error
+		switch types[i] {
+		case dracoDecoderGeneric:
+			err = decodeDracoGenericValues(r, a, numPoints)
+		case dracoDecoderInteger:
+			if a.isFloat() || a.DataType > dracoUint32 {
+				return ErrBadValue
+			}
+			portable[i], err = decodeDracoIntegerValues(r, numPoints, a.NumComponents, false)
+		case dracoDecoderQuantization:
+			if a.DataType != dracoFloat32 {
+				return ErrBadValue
+			}
+			portable[i], err = decodeDracoIntegerValues(r, numPoints, a.NumComponents, false)
+		case dracoDecoderNormals:
+			if a.DataType != dracoFloat32 || a.NumComponents != 3 {
+				return ErrBadValue
+			}
+			portable[i], err = decodeDracoIntegerValues(r, numPoints, 2, true)
+		default:
+			return ErrBadValue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, a := range atts {
+		switch types[i] {
+		case dracoDecoderQuantization:
+			mins, scale, err := decodeDracoQuantization(r, a)
+			if err != nil {
+				return err
+			}
+			a.Values = make([]float64, len(portable[i].values))
+			for j, q := range portable[i].values {
+				c := j % a.NumComponents
+				a.Values[j] = float64(float32(q)*scale + mins[c])
+			}
+		case dracoDecoderNormals:
+			bits, err := r.uint8()
+			if err != nil {
+				return err
+			}
+			if bits < 2 || bits > 30 {
+				return ErrBadValue
+			}
+			a.QuantizationBits = int(bits)
+			a.Values = make([]float64, numPoints*3)
+			oct := newDracoOctahedron(int(bits))
+			for j := 0; j < numPoints; j++ {
+				n := oct.toUnitVector(portable[i].values[j*2], portable[i].values[j*2+1])
+				a.Values[j*3] = float64(n[0])
+				a.Values[j*3+1] = float64(n[1])
+				a.Values[j*3+2] = float64(n[2])
+			}
+		case dracoDecoderInteger:
+			a.Values = make([]float64, len(portable[i].values))
+			for j, v := range portable[i].values {
+				a.Values[j] = dracoCastInteger(a.DataType, int64(v))
+			}
+		}
+	}
+	return nil
+}
+
+// dracoCastInteger converts v to the data type of an attribute the way a
+// C cast would.
+func dracoCastInteger(dataType uint8, v int64) float64 {
+	switch dataType {
+	case dracoInt8:
+		return float64(int8(v))
+	case dracoUint8, dracoBool:
+		return float64(uint8(v))
+	case dracoInt16:
+		return float64(int16(v))
+	case dracoUint16:
+		return float64(uint16(v))
+	case dracoInt32:
+		return float64(int32(v))
+	case dracoUint32:
+		return float64(uint32(v))
+	}
+	return float64(v)
+}
+
+func decodeDracoQuantization(r *dracoReader, a *dracoAttribute) ([]float32, float32, error) {
+	mins := make([]float32, a.NumComponents)
+	for i := range mins {
+		var err error
+		if mins[i], err = r.float32(); err != nil {
+			return nil, 0, err
+		}
+	}
+	rng, err := r.float32()
+	if err != nil {
+		return nil, 0, err
+	}
+	bits, err := r.uint8()
+	if err != nil {
+		return nil, 0, err
+	}
+	if bits < 1 || bits > 30 {
+		return nil, 0, ErrBadValue
+	}
+	a.QuantizationBits = int(bits)
+	return mins, rng / float32(uint32(1)<<bits-1), nil
+}
+
+func decodeDracoGenericValues(r *dracoReader, a *dracoAttribute, numPoints int) error {
+	size := dracoDataTypeSize[a.DataType]
+	n := numPoints * a.NumComponents
+	b, err := r.bytes(n * size)
+	if err != nil {
+		return err
+	}
+	a.Values = make([]float64, n)
+	for i := range a.Values {
+		v := b[i*size:]
+		switch a.DataType {
+		case dracoInt8:
+			a.Values[i] = float64(int8(v[0]))
+		case dracoUint8, dracoBool:
+			a.Values[i] = float64(v[0])
+		case dracoInt16:
+			a.Values[i] = float64(int16(littleEndian.Uint16(v)))
+		case dracoUint16:
+			a.Values[i] = float64(littleEndian.Uint16(v))
+		case dracoInt32:
+			a.Values[i] = float64(int32(littleEndian.Uint32(v)))
+		case dracoUint32:
+			a.Values[i] = float64(littleEndian.Uint32(v))
+		case dracoInt64:
+			a.Values[i] = float64(int64(littleEndian.Uint64(v)))
+		case dracoUint64:
+			a.Values[i] = float64(littleEndian.Uint64(v))
+		case dracoFloat32:
+			a.Values[i] = float64(math.Float32frombits(littleEndian.Uint32(v)))
+		case dracoFloat64:
+			a.Values[i] = math.Float64frombits(littleEndian.Uint64(v))
+		}
+	}
+	return nil
+}
+
+func decodeDracoIntegerValues(r *dracoReader, numPoints int, numComponents int, normals bool) (dracoPortableAttribute, error) {
+	ret := dracoPortableAttribute{numComponents: numComponents}
+	method, err := r.uint8()
+	if err != nil {
+		return ret, err
+	}
+	transform := int8(-1)
+	if int8(method) != dracoPredictionNone {
+		if int8(method) < dracoPredictionDifference {
+			return ret, ErrBadValue
+		}
+		t, err := r.uint8()
+		if err != nil {
+			return ret, err
+		}
+		transform = int8(t)
+		if normals && transform != dracoTransformNormalOctahedron && transform != dracoTransformNormalOctahedronCanonicalized {
+			return ret, ErrBadValue
+		}
+		if !normals && transform != dracoTransformWrap {
+			return ret, ErrBadValue
+		}
+	}
+
+	numValues := numPoints * numComponents
+	symbols := make([]uint32, numValues)
+	compressed, err := r.uint8()
+	if err != nil {
+		return ret, err
+	}
+	if compressed > 0 {
+		if err := decodeDracoSymbols(r, symbols, numComponents); err != nil {
+			return ret, err
+		}
+	} else {
+		size, err := r.uint8()
+		if err != nil {
+			return ret, err
+		}
+		if size == 0 || size > 4 {
+			return ret, ErrBadValue
+		}
+		b, err := r.bytes(numValues * int(size))
+		if err != nil {
+			return ret, err
+		}
+		for i := range symbols {
+			var v uint32
+			for j := int(size) - 1; j >= 0; j-- {
+				v = v<<8 | uint32(b[i*int(size)+j])
+			}
+			symbols[i] = v
+		}
+	}
+
+	ret.values = make([]int32, numValues)
+	if transform == dracoTransformNormalOctahedron || transform == dracoTransformNormalOctahedronCanonicalized {
+		for i, s := range symbols {
+			ret.values[i] = int32(s)
+		}
+	} else {
+		for i, s := range symbols {
+			ret.values[i] = dracoSymbolToSigned(s)
+		}
+	}
+
+	switch transform {
+	case dracoTransformWrap:
+		minValue, err := r.uint32()
+		if err != nil {
+			return ret, err
+		}
+		maxValue, err := r.uint32()
+		if err != nil {
+			return ret, err
+		}
+		w, err := newDracoWrap(int32(minValue), int32(maxValue))
+		if err != nil {
+			return ret, err
+		}
+		w.decode(ret.values, numComponents)
+	case dracoTransformNormalOctahedron, dracoTransformNormalOctahedronCanonicalized:
+		maxQuantized, err := r.uint32()
+		if err != nil {
+			return ret, err
+		}
+		if _, err := r.uint32(); err != nil {
+			return ret, err
+		}
+		if maxQuantized%2 == 0 || maxQuantized > math.MaxInt32 {
+			return ret, ErrBadValue
+		}
+		bits := dracoMostSignificantBit(maxQuantized) + 1
+		if bits < 2 || bits > 30 {
+			return ret, ErrBadValue
+		}
+		oct := newDracoOctahedron(bits)
+		oct.decodeDelta(ret.values, transform == dracoTransformNormalOctahedronCanonicalized)
+	}
+	return ret, nil
+}
+
+func decodeDracoSymbols(r *dracoReader, out []uint32, numComponents int) error {
+	if len(out) == 0 {
+		return nil
+	}
+	scheme, err := r.uint8()
+	if err != nil {
+		return err
+	}
+	switch scheme {
+	case dracoSymbolCodingTagged:
+		return decodeDracoTaggedSymbols(r, out, numComponents)
+	case dracoSymbolCodingRaw:
+		bits, err := r.uint8()
+		if err != nil {
+			return err
+		}
+		if bits < 1 || bits > 18 {
+			return ErrBadValue
+		}
+		var dec dracoRAnsSymbolDecoder
+		if err := dec.create(r, int(bits)); err != nil {
+			return err
+		}
+		if len(dec.probs) == 0 {
+			return ErrBadValue
+		}
+		if err := dec.start(r); err != nil {
+			return err
+		}
+		for i := range out {
+			out[i] = dec.decode()
+		}
+		return nil
+	}
+	return ErrBadValue
+}
+
+func decodeDracoTaggedSymbols(r *dracoReader, out []uint32, numComponents int) error {
+	var tags dracoRAnsSymbolDecoder
+	if err := tags.create(r, 5); err != nil {
+		return err
+	}
+	if err := tags.start(r); err != nil {
+		return err
+	}
+	if len(tags.probs) == 0 {
+		return ErrBadValue
+	}
+	data := r.data[r.pos:]
+	var bit uint64
+	for i := 0; i < len(out); i += numComponents {
+		length := tags.decode()
+		if length > 32 {
+			return ErrBadValue
+		}
+		for j := 0; j < numComponents && i+j < len(out); j++ {
+			var v uint32
+			for b := uint32(0); b < length; b++ {
+				if byteIdx := bit >> 3; byteIdx < uint64(len(data)) {
+					v |= uint32(data[byteIdx]>>(bit&7)&1) << b
+				}
+				bit++
+			}
+			out[i+j] = v
+		}
+	}
+	n := (bit + 7) / 8
+	if n > uint64(len(data)) {
+		return ErrTruncated
+	}
+	r.pos += int(n)
+	return nil
+}
+
+// dracoWrap is the wrap prediction transform of integer attributes.
+type dracoWrap struct {
+	min, max      int32
+	dif           int32
+	minCorrection int32
+	maxCorrection int32
+}
+
+func newDracoWrap(min, max int32) (*dracoWrap, error) {
+	dif := int64(max) - int64(min)
+	if dif < 0 || dif >= math.MaxInt32 {
+		return nil, ErrBadValue
+	}
+	w := &dracoWrap{min: min, max: max, dif: int32(dif) + 1}
+	w.maxCorrection = w.dif / 2
+	w.minCorrection = -w.maxCorrection
+	if w.dif&1 == 0 {
+		w.maxCorrection--
+	}
+	return w, nil
+}
+
+func (w *dracoWrap) clamp(v int32) int32 {
+	if v > w.max {
+		return w.max
+	}
+	if v < w.min {
+		return w.min
+	}
+	return v
+}
+
+// decode replaces the corrections in values by the original values, each
+// predicted by the value of the previous point.
+func (w *dracoWrap) decode(values []int32, numComponents int) {
+	for i := range values {
+		var pred int32
+		if i >= numComponents {
+			pred = values[i-numComponents]
+		}
+		v := w.clamp(pred) + values[i]
+		if v > w.max {
+			v -= w.dif
+		} else if v < w.min {
+			v += w.dif
+		}
+		values[i] = v
+	}
+}
+
+func (w *dracoWrap) encode(values []int32, numComponents int) []int32 {
+	corr := make([]int32, len(values))
+	for i := range values {
+		var pred int32
+		if i >= numComponents {
+			pred = values[i-numComponents]
+		}
+		c := values[i] - w.clamp(pred)
+		if c < w.minCorrection {
+			c += w.dif
+		} else if c > w.maxCorrection {
+			c -= w.dif
+		}
+		corr[i] = c
+	}
+	return corr
+}
+
+// dracoOctahedron maps octahedral coordinates quantized to bits bits to
+// unit vectors.
+type dracoOctahedron struct {
+	maxQuantized int32
+	maxValue     int32
+	center       int32
+}
+
+func newDracoOctahedron(bits int) *dracoOctahedron {
+	o := &dracoOctahedron{maxQuantized: int32(1)<<uint(bits) - 1}
+	o.maxValue = o.maxQuantized - 1
+	o.center = o.maxValue / 2
+	return o
+}
+
+func (o *dracoOctahedron) toUnitVector(s, t int32) [3]float32 {
+	scale := 2 / float32(o.maxValue)
+	y := float32(s)*scale - 1
+	z := float32(t)*scale - 1
+	x := 1 - float32(math.Abs(float64(y))) - float32(math.Abs(float64(z)))
+	offset := -x
+	if offset < 0 {
+		offset = 0
+	}
+	if y < 0 {
+		y += offset
+	} else {
+		y -= offset
+	}
+	if z < 0 {
+		z += offset
+	} else {
+		z -= offset
+	}
+	norm := x*x + y*y + z*z
+	if norm < 1e-6 {
+		return [3]float32{}
+	}
+	d := 1 / float32(math.Sqrt(float64(norm)))
+	return [3]float32{x * d, y * d, z * d}
+}
+
+func (o *dracoOctahedron) isInDiamond(s, t int32) bool {
+	return abs32(s)+abs32(t) <= o.center
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (o *dracoOctahedron) invertDiamond(s, t int32) (int32, int32) {
+	var signS, signT int32
+	if s >= 0 && t >= 0 {
+		signS, signT = 1, 1
+	} else if s <= 0 && t <= 0 {
+		signS, signT = -1, -1
+	} else {
+		signS, signT = -1, -1
+		if s > 0 {
+			signS = 1
+		}
+		if t > 0 {
+			signT = 1
+		}
+	}
+	cornerS := signS * o.center
+	cornerT := signT * o.center
+	us := uint32(s)
+	ut := uint32(t)
+	us = us + us - uint32(cornerS)
+	ut = ut + ut - uint32(cornerT)
+	if signS*signT >= 0 {
+		us, ut = -ut, -us
+	} else {
+		us, ut = ut, us
+	}
+	us += uint32(cornerS)
+	ut += uint32(cornerT)
+	return int32(us) / 2, int32(ut) / 2
+}
+
+func (o *dracoOctahedron) modMax(v int32) int32 {
+	if v > o.center {
+		return v - o.maxQuantized
+	}
+	if v < -o.center {
+		return v + o.maxQuantized
+	}
+	return v
+}
+
+func dracoRotationCount(x, y int32) int {
+	switch {
+	case x == 0:
+		if y == 0 {
+			return 0
+		} else if y > 0 {
+			return 3
+		}
+		return 1
+	case x > 0:
+		if y >= 0 {
+			return 2
+		}
+		return 1
+	default:
+		if y <= 0 {
+			return 0
+		}
+		return 3
+	}
+}
+
+func dracoRotatePoint(x, y int32, count int) (int32, int32) {
+	switch count {
+	case 1:
+		return y, -x
+	case 2:
+		return -x, -y
+	case 3:
+		return -y, x
+	}
+	return x, y
+}
+
+// decodeDelta replaces the corrections in values, pairs of octahedral
+// coordinates, by the original coordinates.
+func (o *dracoOctahedron) decodeDelta(values []int32, canonicalized bool) {
+	for i := 0; i+1 < len(values); i += 2 {
+		var ps, pt int32
+		if i >= 2 {
+			ps, pt = values[i-2], values[i-1]
+		}
+		ps -= o.center
+		pt -= o.center
+		inDiamond := o.isInDiamond(ps, pt)
+		if !inDiamond {
+			ps, pt = o.invertDiamond(ps, pt)
+		}
+		var s, t int32
+		if canonicalized {
+			bottomLeft := (ps == 0 && pt == 0) || (ps < 0 && pt <= 0)
+			rotation := dracoRotationCount(ps, pt)
+			if !bottomLeft {
+				ps, pt = dracoRotatePoint(ps, pt, rotation)
+			}
+			s, t = o.modMax(ps+values[i]), o.modMax(pt+values[i+1])
+			if !bottomLeft {
+				s, t = dracoRotatePoint(s, t, (4-rotation)%4)
+			}
+		} else {
+			s, t = o.modMax(ps+values[i]), o.modMax(pt+values[i+1])
+		}
+		if !inDiamond {
+			s, t = o.invertDiamond(s, t)
+		}
+		values[i] = s + o.center
+		values[i+1] = t + o.center
+	}
+}
+
+func decodeDracoKdTreeAttributes(r *dracoReader, atts []*dracoAttribute, numPoints int) error {
+	level, err := r.uint8()
+	if err != nil {
+		return err
+	}
+	if level > 6 {
+		return ErrBadValue
+	}
+	dimension := 0
+	for _, a := range atts {
+		if a.DataType > dracoUint32 && a.DataType != dracoFloat32 {
+			return ErrBadValue
+		}
+		dimension += a.NumComponents
+	}
+
+	points, err := decodeDracoKdTree(r, int(level), dimension, numPoints)
+	if err != nil {
+		return err
+	}
+
+	offset := 0
+	for _, a := range atts {
+		a.Values = make([]float64, numPoints*a.NumComponents)
+		for i := 0; i < numPoints; i++ {
+			for c := 0; c < a.NumComponents; c++ {
+				a.Values[i*a.NumComponents+c] = float64(points[i*dimension+offset+c])
+			}
+		}
+		offset += a.NumComponents
+	}
+
+	for _, a := range atts {
+		if a.DataType != dracoFloat32 {
+			continue
+		}
+		mins := make([]float32, a.NumComponents)
+		for i := range mins {
+			if mins[i], err = r.float32(); err != nil {
+				return err
+			}
+		}
+		rng, err := r.float32()
+		if err != nil {
+			return err
+		}
+		bits, err := r.uint8()
+		if err != nil {
+			return err
+		}
+		if bits < 1 || bits > 31 {
+			return ErrBadValue
+		}
+		a.QuantizationBits = int(bits)
+		scale := rng / float32(uint32(1)<<bits-1)
+		for i, q := range a.Values {
+			a.Values[i] = float64(float32(uint32(q))*scale + mins[i%a.NumComponents])
+		}
+	}
+	for _, a := range atts {
+		if !a.isSigned() {
+			if !a.isFloat() {
+				for i, v := range a.Values {
+					a.Values[i] = dracoCastInteger(a.DataType, int64(v))
+				}
+			}
+			continue
+		}
+		mins := make([]int32, a.NumComponents)
+		for i := range mins {
+			v, err := r.varint32()
+			if err != nil {
+				return err
+			}
+			mins[i] = dracoSymbolToSigned(v)
+		}
+		for i, v := range a.Values {
+			u := dracoCastInteger(a.DataType+1, int64(v))
+			a.Values[i] = dracoCastInteger(a.DataType, int64(u)+int64(mins[i%a.NumComponents]))
+		}
+	}
+	return nil
+}
+
+func newDracoKdTreeDecoders(level int) (numbers, remaining, axis, half dracoBitDecoder) {
+	switch {
+	case level >= 4:
+		numbers = &dracoFoldedBitDecoder{}
+	case level >= 2:
+		numbers = &dracoRAnsBitDecoder{}
+	default:
+		numbers = &dracoDirectBitDecoder{}
+	}
+	return numbers, &dracoDirectBitDecoder{}, &dracoDirectBitDecoder{}, &dracoDirectBitDecoder{}
+}
+
+type dracoKdTreeStatus struct {
+	numPoints uint32
+	lastAxis  int
+	stackPos  int
+}
+
+// decodeDracoKdTree decodes the integer points of a kd-tree coded point
+// cloud, dimension values per point.
+func decodeDracoKdTree(r *dracoReader, level int, dimension int, numPoints int) ([]uint32, error) {
+	bitLength, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if bitLength > 32 {
+		return nil, ErrBadValue
+	}
+	n, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) != int64(numPoints) {
+		return nil, ErrBadValue
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if dimension == 0 {
+		return nil, ErrBadValue
+	}
+	numbers, remaining, axisDec, half := newDracoKdTreeDecoders(level)
+	for _, d := range []dracoBitDecoder{numbers, remaining, axisDec, half} {
+		if err := d.start(r); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]uint32, 0, numPoints*dimension)
+	stackSize := 32*dimension + 1
+	bases := make([][]uint32, stackSize)
+	levels := make([][]uint32, stackSize)
+	for i := range bases {
+		bases[i] = make([]uint32, dimension)
+		levels[i] = make([]uint32, dimension)
+	}
+	p := make([]uint32, dimension)
+	stack := []dracoKdTreeStatus{{numPoints: n}}
+	for len(stack) > 0 {
+		st := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		remainingPoints := st.numPoints
+		base := bases[st.stackPos]
+		lv := levels[st.stackPos]
+		if int(remainingPoints) > numPoints-len(out)/dimension {
+			return nil, ErrBadValue
+		}
+
+		var axis int
+		if level < 6 {
+			axis = (st.lastAxis + 1) % dimension
+		} else if remainingPoints < 64 {
+			for a := 1; a < dimension; a++ {
+				if lv[axis] > lv[a] {
+					axis = a
+				}
+			}
+		} else {
+			v, err := axisDec.decodeBits(4)
+			if err != nil {
+				return nil, err
+			}
+			axis = int(v)
+		}
+		if axis >= dimension {
+			return nil, ErrBadValue
+		}
+
+		curLevel := lv[axis]
+		if bitLength-curLevel == 0 {
+			for i := uint32(0); i < remainingPoints; i++ {
+				out = append(out, base...)
+			}
+			continue
+		}
+
+		if remainingPoints <= 2 {
+			for i := uint32(0); i < remainingPoints; i++ {
+				for j := 0; j < dimension; j++ {
+					a := (axis + j) % dimension
+					p[a] = 0
+					if bits := bitLength - lv[a]; bits > 0 {
+						v, err := remaining.decodeBits(int(bits))
+						if err != nil {
+							return nil, err
+						}
+						p[a] = v
+					}
+					p[a] |= base[a]
+				}
+				out = append(out, p...)
+			}
+			continue
+		}
+
+		if st.stackPos+1 >= stackSize {
+			return nil, ErrBadValue
+		}
+		modifier := uint32(1) << (bitLength - curLevel - 1)
+		next := bases[st.stackPos+1]
+		copy(next, base)
+		next[axis] += modifier
+
+		number, err := numbers.decodeBits(dracoMostSignificantBit(remainingPoints))
+		if err != nil {
+			return nil, err
+		}
+		firstHalf := remainingPoints / 2
+		if number > firstHalf {
+			return nil, ErrBadValue
+		}
+		firstHalf -= number
+		secondHalf := remainingPoints - firstHalf
+		if firstHalf != secondHalf && !half.decodeBit() {
+			firstHalf, secondHalf = secondHalf, firstHalf
+		}
+
+		lv[axis]++
+		copy(levels[st.stackPos+1], lv)
+		if firstHalf > 0 {
+			stack = append(stack, dracoKdTreeStatus{numPoints: firstHalf, lastAxis: axis, stackPos: st.stackPos})
+		}
+		if secondHalf > 0 {
+			stack = append(stack, dracoKdTreeStatus{numPoints: secondHalf, lastAxis: axis, stackPos: st.stackPos + 1})
+		}
+	}
+	if len(out) != numPoints*dimension {
+		return nil, ErrBadValue
+	}
+	return out, nil
+}
@@ -0,0 +1,430 @@
+package tile3d
+
+// rANS coders used by the Draco bit stream. Symbols are encoded in reverse
+// order so the decoder, which reads the buffer backwards, gets them in
+// order.
+
+const (
+	ansLBase  = 4096
+	ansIOBase = 256
+	ansP8Bits = 256
+)
+
+func ansReadInit(buf []byte, fourBytes bool, lBase uint32) (uint32, int, error) {
+	offset := len(buf)
+	if offset < 1 {
+		return 0, 0, ErrTruncated
+	}
+	var state uint32
+	switch buf[offset-1] >> 6 {
+	case 0:
+		offset -= 1
+		state = uint32(buf[offset]) & 0x3f
+	case 1:
+		if offset < 2 {
+			return 0, 0, ErrTruncated
+		}
+		offset -= 2
+		state = uint32(buf[offset]) | uint32(buf[offset+1])<<8
+		state &= 0x3fff
+	case 2:
+		if offset < 3 {
+			return 0, 0, ErrTruncated
+		}
+		offset -= 3
+		state = uint32(buf[offset]) | uint32(buf[offset+1])<<8 | uint32(buf[offset+2])<<16
+		state &= 0x3fffff
+	default:
+		if !fourBytes || offset < 4 {
+			return 0, 0, ErrBadValue
+		}
+		offset -= 4
+		state = littleEndian.Uint32(buf[offset:]) & 0x3fffffff
+	}
+	state += lBase
+	if state >= lBase*ansIOBase {
+		return 0, 0, ErrBadValue
+	}
+	return state, offset, nil
+}
+
+func ansWriteEnd(buf []byte, state uint32, lBase uint32) []byte {
+	state -= lBase
+	switch {
+	case state < 1<<6:
+		return append(buf, byte(state))
+	case state < 1<<14:
+		state += 1 << 14
+		return append(buf, byte(state), byte(state>>8))
+	case state < 1<<22:
+		state += 2 << 22
+		return append(buf, byte(state), byte(state>>8), byte(state>>16))
+	default:
+		state += 3 << 30
+		return append(buf, byte(state), byte(state>>8), byte(state>>16), byte(state>>24))
+	}
+}
+
+// dracoBitDecoder is one of the bit decoders of the kd-tree point coder.
+type dracoBitDecoder interface {
+	start(r *dracoReader) error
+	decodeBit() bool
+	decodeBits(nbits int) (uint32, error)
+}
+
+type dracoRAnsBitDecoder struct {
+	probZero uint8
+	buf      []byte
+	offset   int
+	state    uint32
+}
+
+func (d *dracoRAnsBitDecoder) start(r *dracoReader) error {
+	var err error
+	if d.probZero, err = r.uint8(); err != nil {
+		return err
+	}
+	var size uint32
+	if r.version < 0x0202 {
+		size, err = r.uint32()
+	} else {
+		size, err = r.varint32()
+	}
+	if err != nil {
+		return err
+	}
+	if d.buf, err = r.bytes(int(size)); err != nil {
+		return err
+	}
+	d.state, d.offset, err = ansReadInit(d.buf, false, ansLBase)
+	return err
+}
+
+func (d *dracoRAnsBitDecoder) decodeBit() bool {
+	p := uint32(ansP8Bits - uint32(d.probZero))
+	if d.state < ansLBase && d.offset > 0 {
+		d.offset--
+		d.state = d.state*ansIOBase + uint32(d.buf[d.offset])
+	}
+	quot := d.state / ansP8Bits
+	rem := d.state % ansP8Bits
+	xn := quot * p
+	if rem < p {
+		d.state = xn + rem
+		return true
+	}
+	d.state = d.state - xn - p
+	return false
+}
+
+func (d *dracoRAnsBitDecoder) decodeBits(nbits int) (uint32, error) {
+	var v uint32
+	for ; nbits > 0; nbits-- {
+		v <<= 1
+		if d.decodeBit() {
+			v |= 1
+		}
+	}
+	return v, nil
+}
+
+type dracoDirectBitDecoder struct {
+	words []uint32
+	pos   int
+	used  uint
+}
+
+func (d *dracoDirectBitDecoder) start(r *dracoReader) error {
+	size, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	if size == 0 || size&3 != 0 {
+		return ErrBadValue
+	}
+	b, err := r.bytes(int(size))
+	if err != nil {
+		return err
+	}
+	d.words = make([]uint32, size/4)
+	for i := range d.words {
+		d.words[i] = littleEndian.Uint32(b[i*4:])
+	}
+	return nil
+}
+
+func (d *dracoDirectBitDecoder) decodeBit() bool {
+	if d.pos >= len(d.words) {
+		return false
+	}
+	bit := d.words[d.pos]&(1<<(31-d.used)) != 0
+	d.used++
+	if d.used == 32 {
+		d.pos++
+		d.used = 0
+	}
+	return bit
+}
+
+func (d *dracoDirectBitDecoder) decodeBits(nbits int) (uint32, error) {
+	if nbits <= 0 || nbits > 32 || d.pos >= len(d.words) {
+		return 0, ErrBadValue
+	}
+	remaining := 32 - int(d.used)
+	if nbits <= remaining {
+		v := (d.words[d.pos] << d.used) >> uint(32-nbits)
+		d.used += uint(nbits)
+		if d.used == 32 {
+			d.pos++
+			d.used = 0
+		}
+		return v, nil
+	}
+	if d.pos+1 >= len(d.words) {
+		return 0, ErrBadValue
+	}
+	left := d.words[d.pos] << d.used
+	d.used = uint(nbits - remaining)
+	d.pos++
+	right := d.words[d.pos] >> (32 - d.used)
+	return (left >> (32 - d.used - uint(remaining))) | right, nil
+}
+
+// dracoFoldedBitDecoder codes bit i of every number with its own rANS bit
+// coder.
+type dracoFoldedBitDecoder struct {
+	folded [32]dracoRAnsBitDecoder
+	bit    dracoRAnsBitDecoder
+}
+
+func (d *dracoFoldedBitDecoder) start(r *dracoReader) error {
+	for i := range d.folded {
+		if err := d.folded[i].start(r); err != nil {
+			return err
+		}
+	}
+	return d.bit.start(r)
+}
+
+func (d *dracoFoldedBitDecoder) decodeBit() bool {
+	return d.bit.decodeBit()
+}
+
+func (d *dracoFoldedBitDecoder) decodeBits(nbits int) (uint32, error) {
+	if nbits > 32 {
+		return 0, ErrBadValue
+	}
+	var v uint32
+	for i := 0; i < nbits; i++ {
+		v <<= 1
+		if d.folded[i].decodeBit() {
+			v |= 1
+		}
+	}
+	return v, nil
+}
+
+func dracoRAnsPrecisionBits(symbolBits int) uint {
+	p := 3 * symbolBits / 2
+	if p < 12 {
+		p = 12
+	}
+	if p > 20 {
+		p = 20
+	}
+	return uint(p)
+}
+
+type dracoRAnsSymbolDecoder struct {
+	precisionBits uint
+	probs         []uint32
+	cums          []uint32
+	lut           []uint32
+	buf           []byte
+	offset        int
+	state         uint32
+}
+
+func (d *dracoRAnsSymbolDecoder) create(r *dracoReader, symbolBits int) error {
+	d.precisionBits = dracoRAnsPrecisionBits(symbolBits)
+	numSymbols, err := r.varint32()
+	if err != nil {
+		return err
+	}
+	if int(numSymbols/64) > r.remaining() {
+		return ErrTruncated
+	}
+	d.probs = make([]uint32, numSymbols)
+	for i := uint32(0); i < numSymbols; i++ {
+		data, err := r.uint8()
+		if err != nil {
+			return err
+		}
+		token := data & 3
+		if token == 3 {
+			offset := uint32(data >> 2)
+			if i+offset >= numSymbols {
+				return ErrBadValue
+			}
+			i += offset
+			continue
+		}
+		prob := uint32(data >> 2)
+		for b := 0; b < int(token); b++ {
+			eb, err := r.uint8()
+			if err != nil {
+				return err
+			}
+			prob |= uint32(eb) << uint(8*(b+1)-2)
+		}
+		d.probs[i] = prob
+	}
+	if numSymbols == 0 {
+		return nil
+	}
+
+	precision := uint32(1) << d.precisionBits
+	d.cums = make([]uint32, numSymbols)
+	d.lut = make([]uint32, precision)
+	var cum uint32
+	for i, p := range d.probs {
+		d.cums[i] = cum
+		if p > precision-cum {
+			return ErrBadValue
+		}
+		for j := cum; j < cum+p; j++ {
+			d.lut[j] = uint32(i)
+		}
+		cum += p
+	}
+	if cum != precision {
+		return ErrBadValue
+	}
+	return nil
+}
+
+func (d *dracoRAnsSymbolDecoder) start(r *dracoReader) error {
+	var size uint64
+	var err error
+	if r.version < 0x0200 {
+		size, err = r.uint64()
+	} else {
+		size, err = r.varint()
+	}
+	if err != nil {
+		return err
+	}
+	if size > uint64(r.remaining()) {
+		return ErrTruncated
+	}
+	if d.buf, err = r.bytes(int(size)); err != nil {
+		return err
+	}
+	d.state, d.offset, err = ansReadInit(d.buf, true, 4<<d.precisionBits)
+	return err
+}
+
+func (d *dracoRAnsSymbolDecoder) decode() uint32 {
+	lBase := uint32(4) << d.precisionBits
+	for d.state < lBase && d.offset > 0 {
+		d.offset--
+		d.state = d.state*ansIOBase + uint32(d.buf[d.offset])
+	}
+	quo := d.state >> d.precisionBits
+	rem := d.state & (1<<d.precisionBits - 1)
+	sym := d.lut[rem]
+	d.state = quo*d.probs[sym] + rem - d.cums[sym]
+	return sym
+}
+
+// encodeRAnsSymbols writes the probability table and the rANS data of
+// symbols, whose largest value is maxSymbol.
+func encodeRAnsSymbols(w *dracoWriter, symbols []uint32, maxSymbol uint32, symbolBits int) {
+	precisionBits := dracoRAnsPrecisionBits(symbolBits)
+	precision := uint32(1) << precisionBits
+
+	freqs := make([]uint64, maxSymbol+1)
+	for _, s := range symbols {
+		freqs[s]++
+	}
+	probs := make([]uint32, len(freqs))
+	var sum uint32
+	most := 0
+	for i, f := range freqs {
+		if f == 0 {
+			continue
+		}
+		p := uint32(float64(f)*float64(precision)/float64(len(symbols)) + 0.5)
+		if p == 0 {
+			p = 1
+		}
+		probs[i] = p
+		sum += p
+		if f > freqs[most] {
+			most = i
+		}
+	}
+	if sum < precision {
+		probs[most] += precision - sum
+	}
+	for sum > precision {
+		largest := 0
+		for i, p := range probs {
+			if p > probs[largest] {
+				largest = i
+			}
+		}
+		d := sum - precision
+		if d > probs[largest]-1 {
+			d = probs[largest] - 1
+		}
+		probs[largest] -= d
+		sum -= d
+	}
+
+	w.varint(uint64(len(probs)))
+	for i := 0; i < len(probs); i++ {
+		p := probs[i]
+		if p == 0 {
+			offset := 0
+			for offset < 63 && i+offset+1 < len(probs) && probs[i+offset+1] == 0 {
+				offset++
+			}
+			w.uint8(uint8(offset<<2 | 3))
+			i += offset
+			continue
+		}
+		extra := 0
+		if p >= 1<<6 {
+			extra++
+			if p >= 1<<14 {
+				extra++
+			}
+		}
+		w.uint8(uint8(p<<2) | uint8(extra))
+		for b := 0; b < extra; b++ {
+			w.uint8(uint8(p >> uint(8*(b+1)-2)))
+		}
+	}
+
+	cums := make([]uint32, len(probs))
+	var cum uint32
+	for i, p := range probs {
+		cums[i] = cum
+		cum += p
+	}
+	lBase := uint32(4) << precisionBits
+	var buf []byte
+	state := lBase
+	for i := len(symbols) - 1; i >= 0; i-- {
+		p := probs[symbols[i]]
+		for state >= lBase/precision*ansIOBase*p {
+			buf = append(buf, byte(state%ansIOBase))
+			state /= ansIOBase
+		}
+		state = (state/p)*precision + state%p + cums[symbols[i]]
+	}
+	buf = ansWriteEnd(buf, state, lBase)
+	w.varint(uint64(len(buf)))
+	w.Write(buf)
+}
@@ -0,0 +1,269 @@
+package tile3d
+
+import (
+	"bytes"
+	"math"
+)
+
+type dracoWriter struct {
+	bytes.Buffer
+}
+
+func (w *dracoWriter) uint8(v uint8) {
+	w.WriteByte(v)
+}
+
+func (w *dracoWriter) uint16(v uint16) {
+	w.Write([]byte{byte(v), byte(v >> 8)})
+}
+
+func (w *dracoWriter) uint32(v uint32) {
+	var b [4]byte
+	littleEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *dracoWriter) float32(v float32) {
+	w.uint32(math.Float32bits(v))
+}
+
+func (w *dracoWriter) varint(v uint64) {
+	for v >= 0x80 {
+		w.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	w.WriteByte(byte(v))
+}
+
+// encodeDraco encodes pc with the sequential point cloud coder. Float
+// attributes with QuantizationBits set are quantized, other float
+// attributes are stored as is and integer attributes are stored losslessly.
+func encodeDraco(pc *dracoPointCloudData) ([]byte, error) {
+	w := &dracoWriter{}
+	w.WriteString(DRACO_MAGIC)
+	w.uint8(2)
+	w.uint8(2)
+	w.uint8(dracoPointCloud)
+	w.uint8(dracoSequentialMethod)
+	w.uint16(0)
+	w.uint32(uint32(pc.NumPoints))
+
+	w.uint8(1)
+	w.varint(uint64(len(pc.Attributes)))
+	types := make([]uint8, len(pc.Attributes))
+	for i, a := range pc.Attributes {
+		if len(a.Values) != pc.NumPoints*a.NumComponents || a.DataType == 0 || a.DataType > dracoFloat64 {
+			return nil, ErrBadValue
+		}
+		normalized := uint8(0)
+		if a.Normalized {
+			normalized = 1
+		}
+		w.Write([]byte{a.Type, a.DataType, uint8(a.NumComponents), normalized})
+		w.varint(uint64(a.UniqueId))
+		switch {
+		case a.DataType == dracoFloat32 && a.QuantizationBits > 0:
+			if a.QuantizationBits > 30 {
+				return nil, ErrBadValue
+			}
+			types[i] = dracoDecoderQuantization
+		case a.isFloat() || a.DataType == dracoInt64 || a.DataType == dracoUint64:
+			types[i] = dracoDecoderGeneric
+		default:
+			types[i] = dracoDecoderInteger
+		}
+	}
+	w.Write(types)
+
+	type quantization struct {
+		mins []float32
+		rng  float32
+	}
+	quant := make([]quantization, len(pc.Attributes))
+	for i, a := range pc.Attributes {
+		switch types[i] {
+		case dracoDecoderGeneric:
+			encodeDracoGenericValues(w, a)
+		case dracoDecoderInteger:
+			values := make([]int32, len(a.Values))
+			for j, v := range a.Values {
+				values[j] = int32(int64(v))
+			}
+			encodeDracoIntegerValues(w, values, a.NumComponents)
+		case dracoDecoderQuantization:
+			q := quantization{mins: make([]float32, a.NumComponents)}
+			maxs := make([]float32, a.NumComponents)
+			for c := range q.mins {
+				q.mins[c] = float32(math.Inf(1))
+				maxs[c] = float32(math.Inf(-1))
+			}
+			for j, v := range a.Values {
+				c := j % a.NumComponents
+				f := float32(v)
+				if f < q.mins[c] {
+					q.mins[c] = f
+				}
+				if f > maxs[c] {
+					maxs[c] = f
+				}
+			}
+			for c := range q.mins {
+				if pc.NumPoints == 0 {
+					q.mins[c], maxs[c] = 0, 0
+				}
+				if d := maxs[c] - q.mins[c]; d > q.rng {
+					q.rng = d
+				}
+			}
+			if q.rng == 0 {
+				q.rng = 1
+			}
+			maxQuantized := float32(uint32(1)<<uint(a.QuantizationBits) - 1)
+			inverse := maxQuantized / q.rng
+			values := make([]int32, len(a.Values))
+			for j, v := range a.Values {
+				f := (float32(v) - q.mins[j%a.NumComponents]) * inverse
+				values[j] = int32(math.Floor(float64(f) + 0.5))
+			}
+			quant[i] = q
+			encodeDracoIntegerValues(w, values, a.NumComponents)
+		}
+	}
+	for i, a := range pc.Attributes {
+		if types[i] != dracoDecoderQuantization {
+			continue
+		}
+		for _, m := range quant[i].mins {
+			w.float32(m)
+		}
+		w.float32(quant[i].rng)
+		w.uint8(uint8(a.QuantizationBits))
+	}
+	return w.Bytes(), nil
+}
+
+func encodeDracoGenericValues(w *dracoWriter, a *dracoAttribute) {
+	for _, v := range a.Values {
+		switch a.DataType {
+		case dracoInt8, dracoUint8, dracoBool:
+			w.uint8(uint8(int64(v)))
+		case dracoInt16, dracoUint16:
+			w.uint16(uint16(int64(v)))
+		case dracoInt32, dracoUint32:
+			w.uint32(uint32(int64(v)))
+		case dracoInt64:
+			var b [8]byte
+			littleEndian.PutUint64(b[:], uint64(int64(v)))
+			w.Write(b[:])
+		case dracoUint64:
+			var b [8]byte
+			littleEndian.PutUint64(b[:], uint64(v))
+			w.Write(b[:])
+		case dracoFloat32:
+			w.float32(float32(v))
+		case dracoFloat64:
+			var b [8]byte
+			littleEndian.PutUint64(b[:], math.Float64bits(v))
+			w.Write(b[:])
+		}
+	}
+}
+
+// encodeDracoIntegerValues writes values with difference prediction and
+// rANS coded corrections.
+func encodeDracoIntegerValues(w *dracoWriter, values []int32, numComponents int) {
+	var wrap *dracoWrap
+	if len(values) > 0 {
+		minValue, maxValue := values[0], values[0]
+		for _, v := range values {
+			if v < minValue {
+				minValue = v
+			}
+			if v > maxValue {
+				maxValue = v
+			}
+		}
+		wrap, _ = newDracoWrap(minValue, maxValue)
+	}
+	corr := values
+	if wrap != nil {
+		w.uint8(uint8(dracoPredictionDifference))
+		w.uint8(dracoTransformWrap)
+		corr = wrap.encode(values, numComponents)
+	} else {
+		none := int8(dracoPredictionNone)
+		w.uint8(uint8(none))
+	}
+
+	symbols := make([]uint32, len(corr))
+	var maxSymbol uint32
+	for i, v := range corr {
+		symbols[i] = dracoSignedToSymbol(v)
+		if symbols[i] > maxSymbol {
+			maxSymbol = symbols[i]
+		}
+	}
+	w.uint8(1)
+	if len(symbols) > 0 {
+		if maxSymbol < 1<<18 {
+			seen := make(map[uint32]struct{})
+			for _, s := range symbols {
+				seen[s] = struct{}{}
+			}
+			bits := dracoMostSignificantBit(uint32(len(seen))) + 1
+			if bits > 18 {
+				bits = 18
+			}
+			w.uint8(dracoSymbolCodingRaw)
+			w.uint8(uint8(bits))
+			encodeRAnsSymbols(w, symbols, maxSymbol, bits)
+		} else {
+			encodeDracoTaggedSymbols(w, symbols, numComponents)
+		}
+	}
+	if wrap != nil {
+		w.uint32(uint32(wrap.min))
+		w.uint32(uint32(wrap.max))
+	}
+}
+
+func encodeDracoTaggedSymbols(w *dracoWriter, symbols []uint32, numComponents int) {
+	var tags []uint32
+	var maxTag uint32
+	for i := 0; i < len(symbols); i += numComponents {
+		var m uint32
+		for j := 0; j < numComponents && i+j < len(symbols); j++ {
+			if symbols[i+j] > m {
+				m = symbols[i+j]
+			}
+		}
+		tag := uint32(1)
+		if m > 0 {
+			tag = uint32(dracoMostSignificantBit(m) + 1)
+		}
+		tags = append(tags, tag)
+		if tag > maxTag {
+			maxTag = tag
+		}
+	}
+	w.uint8(dracoSymbolCodingTagged)
+	encodeRAnsSymbols(w, tags, maxTag, 5)
+
+	var bits []byte
+	var pos uint
+	for i, tag := range tags {
+		for j := 0; j < numComponents && i*numComponents+j < len(symbols); j++ {
+			v := symbols[i*numComponents+j]
+			for b := uint32(0); b < tag; b++ {
+				if pos%8 == 0 {
+					bits = append(bits, 0)
+				}
+				if v>>b&1 != 0 {
+					bits[pos/8] |= 1 << (pos % 8)
+				}
+				pos++
+			}
+		}
+	}
+	w.Write(bits)
+}
@@ -0,0 +1,392 @@
+package tile3d
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type dracoBitEncoder interface {
+	encodeBit(bit bool)
+	encodeBits(nbits int, value uint32)
+	end(w *dracoWriter)
+}
+
+type dracoRAnsBitEncoder struct {
+	bits   []bool
+	counts [2]int
+}
+
+func (e *dracoRAnsBitEncoder) encodeBit(bit bool) {
+	e.bits = append(e.bits, bit)
+	if bit {
+		e.counts[1]++
+	} else {
+		e.counts[0]++
+	}
+}
+
+func (e *dracoRAnsBitEncoder) encodeBits(nbits int, value uint32) {
+	for i := nbits - 1; i >= 0; i-- {
+		e.encodeBit(value>>uint(i)&1 != 0)
+	}
+}
+
+func (e *dracoRAnsBitEncoder) end(w *dracoWriter) {
+	total := e.counts[0] + e.counts[1]
+	if total == 0 {
+		total = 1
+	}
+	raw := int(float64(e.counts[0])/float64(total)*256 + 0.5)
+	probZero := uint32(255)
+	if raw < 255 {
+		probZero = uint32(raw)
+	}
+	if probZero == 0 {
+		probZero = 1
+	}
+	p := ansP8Bits - probZero
+
+	var buf []byte
+	state := uint32(ansLBase)
+	for i := len(e.bits) - 1; i >= 0; i-- {
+		ls := probZero
+		if e.bits[i] {
+			ls = p
+		}
+		if state >= ansLBase/ansP8Bits*ansIOBase*ls {
+			buf = append(buf, byte(state%ansIOBase))
+			state /= ansIOBase
+		}
+		quot, rem := state/ls, state%ls
+		state = quot*ansP8Bits + rem
+		if !e.bits[i] {
+			state += p
+		}
+	}
+	buf = ansWriteEnd(buf, state, ansLBase)
+	w.uint8(uint8(probZero))
+	w.varint(uint64(len(buf)))
+	w.Write(buf)
+}
+
+type dracoDirectBitEncoder struct {
+	words []uint32
+	local uint32
+	used  uint
+}
+
+func (e *dracoDirectBitEncoder) encodeBit(bit bool) {
+	if bit {
+		e.local |= 1 << (31 - e.used)
+	}
+	e.used++
+	if e.used == 32 {
+		e.words = append(e.words, e.local)
+		e.local = 0
+		e.used = 0
+	}
+}
+
+func (e *dracoDirectBitEncoder) encodeBits(nbits int, value uint32) {
+	for i := nbits - 1; i >= 0; i-- {
+		e.encodeBit(value>>uint(i)&1 != 0)
+	}
+}
+
+func (e *dracoDirectBitEncoder) end(w *dracoWriter) {
+	words := append(e.words, e.local)
+	w.uint32(uint32(len(words) * 4))
+	for _, v := range words {
+		w.uint32(v)
+	}
+}
+
+type dracoFoldedBitEncoder struct {
+	folded [32]dracoRAnsBitEncoder
+	bit    dracoRAnsBitEncoder
+}
+
+func (e *dracoFoldedBitEncoder) encodeBit(bit bool) {
+	e.bit.encodeBit(bit)
+}
+
+func (e *dracoFoldedBitEncoder) encodeBits(nbits int, value uint32) {
+	for i := 0; i < nbits; i++ {
+		e.folded[i].encodeBit(value>>uint(nbits-1-i)&1 != 0)
+	}
+}
+
+func (e *dracoFoldedBitEncoder) end(w *dracoWriter) {
+	for i := range e.folded {
+		e.folded[i].end(w)
+	}
+	e.bit.end(w)
+}
+
+// encodeDracoKdTree writes points of dimension components the way the
+// kd-tree point coder does for compression levels below 6.
+func encodeDracoKdTree(w *dracoWriter, level int, points [][]uint32, dimension int) {
+	var maxValue uint32
+	for _, p := range points {
+		for _, v := range p {
+			if v > maxValue {
+				maxValue = v
+			}
+		}
+	}
+	bitLength := uint32(dracoMostSignificantBit(maxValue) + 1)
+	var numbers dracoBitEncoder
+	switch {
+	case level >= 4:
+		numbers = &dracoFoldedBitEncoder{}
+	case level >= 2:
+		numbers = &dracoRAnsBitEncoder{}
+	default:
+		numbers = &dracoDirectBitEncoder{}
+	}
+	remaining, axisEnc, half := &dracoDirectBitEncoder{}, &dracoDirectBitEncoder{}, &dracoDirectBitEncoder{}
+
+	type status struct {
+		points   [][]uint32
+		lastAxis int
+		base     []uint32
+		levels   []uint32
+	}
+	stack := []status{{points: points, base: make([]uint32, dimension), levels: make([]uint32, dimension)}}
+	for len(stack) > 0 {
+		st := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n := uint32(len(st.points))
+		axis := (st.lastAxis + 1) % dimension
+		if bitLength-st.levels[axis] == 0 {
+			continue
+		}
+		if n <= 2 {
+			for _, p := range st.points {
+				for j := 0; j < dimension; j++ {
+					a := (axis + j) % dimension
+					if bits := bitLength - st.levels[a]; bits > 0 {
+						remaining.encodeBits(int(bits), p[a]&(1<<bits-1))
+					}
+				}
+			}
+			continue
+		}
+		modifier := uint32(1) << (bitLength - st.levels[axis] - 1)
+		var lo, hi [][]uint32
+		for _, p := range st.points {
+			if p[axis] < st.base[axis]+modifier {
+				lo = append(lo, p)
+			} else {
+				hi = append(hi, p)
+			}
+		}
+		first, second := uint32(len(lo)), uint32(len(hi))
+		left := first < second
+		if first != second {
+			half.encodeBit(left)
+		}
+		if left {
+			numbers.encodeBits(dracoMostSignificantBit(n), n/2-first)
+		} else {
+			numbers.encodeBits(dracoMostSignificantBit(n), n/2-second)
+		}
+		levels := append([]uint32(nil), st.levels...)
+		levels[axis]++
+		base := append([]uint32(nil), st.base...)
+		base[axis] += modifier
+		if len(lo) > 0 {
+			stack = append(stack, status{lo, axis, st.base, levels})
+		}
+		if len(hi) > 0 {
+			stack = append(stack, status{hi, axis, base, levels})
+		}
+	}
+
+	w.uint32(bitLength)
+	w.uint32(uint32(len(points)))
+	numbers.end(w)
+	remaining.end(w)
+	axisEnc.end(w)
+	half.end(w)
+}
+
+func dracoTestPointCloud(n int) *dracoPointCloudData {
+	rnd := rand.New(rand.NewSource(1))
+	pos := &dracoAttribute{Type: dracoAttributePosition, DataType: dracoFloat32, NumComponents: 3, QuantizationBits: 14}
+	color := &dracoAttribute{Type: dracoAttributeColor, DataType: dracoUint8, NumComponents: 3, Normalized: true, UniqueId: 1}
+	generic := &dracoAttribute{Type: dracoAttributeGeneric, DataType: dracoInt32, NumComponents: 1, UniqueId: 2}
+	raw := &dracoAttribute{Type: dracoAttributeGeneric, DataType: dracoFloat64, NumComponents: 1, UniqueId: 3}
+	for i := 0; i < n; i++ {
+		pos.Values = append(pos.Values, rnd.Float64()*100, rnd.Float64()*10-5, float64(i))
+		color.Values = append(color.Values, float64(rnd.Intn(256)), float64(i%256), 7)
+		generic.Values = append(generic.Values, float64(rnd.Int31()-math.MaxInt32/2))
+		raw.Values = append(raw.Values, rnd.NormFloat64())
+	}
+	return &dracoPointCloudData{NumPoints: n, Attributes: []*dracoAttribute{pos, color, generic, raw}}
+}
+
+func TestDracoRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 300} {
+		pc := dracoTestPointCloud(n)
+		data, err := encodeDraco(pc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := decodeDraco(data, n)
+		if err != nil {
+			t.Fatalf("%d points: %v", n, err)
+		}
+		if len(out.Attributes) != len(pc.Attributes) {
+			t.Fatalf("%d attributes", len(out.Attributes))
+		}
+		for i, a := range pc.Attributes {
+			b := out.Attributes[i]
+			if b.UniqueId != a.UniqueId || b.DataType != a.DataType || b.NumComponents != a.NumComponents || len(b.Values) != len(a.Values) {
+				t.Fatalf("attribute %d: %+v", i, b)
+			}
+			tolerance := 0.0
+			if a.QuantizationBits > 0 {
+				tolerance = float64(n) / (1 << 14)
+			}
+			for j := range a.Values {
+				if math.Abs(a.Values[j]-b.Values[j]) > tolerance {
+					t.Fatalf("attribute %d value %d: %v != %v", i, j, b.Values[j], a.Values[j])
+				}
+			}
+		}
+	}
+
+	if _, err := decodeDraco([]byte("DRACO\x02\x02\x00\x00\x00\x00"), 1); err == nil {
+		t.Error("truncated data decoded")
+	}
+}
+
+func TestDracoKdTree(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	n := 200
+	var points [][]uint32
+	for i := 0; i < n; i++ {
+		points = append(points, []uint32{uint32(rnd.Intn(1 << 10)), uint32(rnd.Intn(1 << 10)), uint32(rnd.Intn(1 << 10)), uint32(rnd.Intn(50))})
+	}
+	for _, level := range []int{0, 2, 5} {
+		w := &dracoWriter{}
+		w.WriteString(DRACO_MAGIC)
+		w.Write([]byte{2, 3, dracoPointCloud, dracoKdTreeMethod})
+		w.uint16(0)
+		w.uint32(uint32(n))
+		w.uint8(1)
+		w.varint(2)
+		w.Write([]byte{dracoAttributePosition, dracoFloat32, 3, 0})
+		w.varint(0)
+		w.Write([]byte{dracoAttributeGeneric, dracoInt16, 1, 0})
+		w.varint(1)
+		w.uint8(uint8(level))
+		encodeDracoKdTree(w, level, points, 4)
+		for _, m := range []float32{1, 2, 3} {
+			w.float32(m)
+		}
+		w.float32(1023)
+		w.uint8(10)
+		w.varint(uint64(dracoSignedToSymbol(-20)))
+
+		pc, err := decodeDraco(w.Bytes(), n)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		// The kd-tree coder reorders points, so compare sorted keys.
+		want := make(map[[4]float64]int)
+		for _, p := range points {
+			want[[4]float64{float64(p[0]) + 1, float64(p[1]) + 2, float64(p[2]) + 3, float64(p[3]) - 20}]++
+		}
+		pos, generic := pc.Attributes[0], pc.Attributes[1]
+		for i := 0; i < n; i++ {
+			k := [4]float64{pos.Values[i*3], pos.Values[i*3+1], pos.Values[i*3+2], generic.Values[i]}
+			if want[k] == 0 {
+				t.Fatalf("level %d: unexpected point %v", level, k)
+			}
+			want[k]--
+		}
+		if pos.QuantizationBits != 10 {
+			t.Errorf("quantization bits %d", pos.QuantizationBits)
+		}
+	}
+}
+
+// TestDracoReferenceTiles decodes the pnts tiles in data/draco, compressed
+// by the reference Draco encoder with the sequential and kd-tree coders,
+// and compares their points with the values in the JSON file of the same
+// name: {"positions": [[x, y, z]], "colors": [[r, g, b, a]], "batchIds":
+// [id], "tolerance": t}. The kd-tree coder reorders points, so points are
+// matched in any order.
+func TestDracoReferenceTiles(t *testing.T) {
+	paths, _ := filepath.Glob("./data/draco/*.pnts")
+	if len(paths) == 0 {
+		t.Skip("no reference tiles in data/draco")
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(strings.TrimSuffix(path, ".pnts") + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want struct {
+			Positions [][3]float64 `json:"positions"`
+			Colors    [][4]uint8   `json:"colors"`
+			BatchIds  []uint32     `json:"batchIds"`
+			Tolerance float64      `json:"tolerance"`
+		}
+		if err := json.Unmarshal(b, &want); err != nil {
+			t.Fatal(err)
+		}
+		if b, err = os.ReadFile(path); err != nil {
+			t.Fatal(err)
+		}
+		m := NewPnts()
+		if err := m.ReadFromBytes(b); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if m.Draco == nil {
+			t.Errorf("%s is not compressed", path)
+		}
+		positions, err := m.Positions()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		colors, err := m.Colors()
+		if err != nil && want.Colors != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		ids, err := m.BatchIds()
+		if err != nil && want.BatchIds != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(positions) != len(want.Positions) {
+			t.Fatalf("%s: %d points, want %d", path, len(positions), len(want.Positions))
+		}
+		used := make([]bool, len(positions))
+		for i, p := range want.Positions {
+			found := false
+			for j, q := range positions {
+				if used[j] || math.Abs(p[0]-q[0]) > want.Tolerance || math.Abs(p[1]-q[1]) > want.Tolerance || math.Abs(p[2]-q[2]) > want.Tolerance {
+					continue
+				}
+				if want.Colors != nil && colors[j] != want.Colors[i] {
+					continue
+				}
+				if want.BatchIds != nil && ids[j] != want.BatchIds[i] {
+					continue
+				}
+				used[j], found = true, true
+				break
+			}
+			if !found {
+				t.Errorf("%s: no point matches point %d %v", path, i, p)
+			}
+		}
+	}
+}
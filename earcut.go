@@ -0,0 +1,213 @@
+package tile3d
+
+import (
+	"math"
+	"sort"
+)
+
+func earcutCross(o, a, b [2]float64) float64 {
+	return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+}
+
+func earcutArea(ring [][2]float64) float64 {
+	var area float64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		area += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+func earcutInTriangle(a, b, c, p [2]float64) bool {
+	return earcutCross(a, b, p) >= 0 && earcutCross(b, c, p) >= 0 && earcutCross(c, a, p) >= 0
+}
+
+// earcut triangulates a polygon given as an outer ring followed by holes.
+// Rings must not repeat their first vertex. The returned indices refer to
+// the vertices of all rings in order and wind counter-clockwise.
+func earcut(rings [][][2]float64) []uint32 {
+	if len(rings) == 0 {
+		return nil
+	}
+	var verts [][2]float64
+	ringIndices := make([][]int, len(rings))
+	for r, ring := range rings {
+		ringIndices[r] = make([]int, len(ring))
+		for i := range ring {
+			ringIndices[r][i] = len(verts) + i
+		}
+		// The outer ring winds counter-clockwise and holes clockwise.
+		if area := earcutArea(ring); (r == 0 && area < 0) || (r > 0 && area > 0) {
+			for i, j := 0, len(ringIndices[r])-1; i < j; i, j = i+1, j-1 {
+				ringIndices[r][i], ringIndices[r][j] = ringIndices[r][j], ringIndices[r][i]
+			}
+		}
+		verts = append(verts, ring...)
+	}
+	if len(ringIndices[0]) < 3 {
+		return nil
+	}
+
+	holes := make([][]int, 0, len(rings)-1)
+	for _, h := range ringIndices[1:] {
+		if len(h) >= 3 {
+			holes = append(holes, h)
+		}
+	}
+	maxX := func(h []int) int {
+		m := 0
+		for i := range h {
+			if verts[h[i]][0] > verts[h[m]][0] {
+				m = i
+			}
+		}
+		return m
+	}
+	sort.SliceStable(holes, func(i, j int) bool {
+		return verts[holes[i][maxX(holes[i])]][0] > verts[holes[j][maxX(holes[j])]][0]
+	})
+
+	poly := ringIndices[0]
+	for _, h := range holes {
+		poly = earcutBridge(verts, poly, h, maxX(h))
+	}
+	return earcutClip(verts, poly)
+}
+
+// earcutBridge joins hole into poly by connecting the rightmost vertex of
+// the hole to a vertex of poly visible from it.
+func earcutBridge(verts [][2]float64, poly, hole []int, m int) []int {
+	mp := verts[hole[m]]
+	best := -1
+	bestX := math.Inf(1)
+	for i := range poly {
+		a, b := verts[poly[i]], verts[poly[(i+1)%len(poly)]]
+		if (a[1] > mp[1]) == (b[1] > mp[1]) && a[1] != mp[1] && b[1] != mp[1] {
+			continue
+		}
+		var x float64
+		switch {
+		case a[1] == b[1]:
+			if a[1] != mp[1] {
+				continue
+			}
+			x = math.Min(a[0], b[0])
+		default:
+			x = a[0] + (mp[1]-a[1])*(b[0]-a[0])/(b[1]-a[1])
+		}
+		if x < mp[0] || x >= bestX {
+			continue
+		}
+		bestX = x
+		switch {
+		case a == [2]float64{x, mp[1]}:
+			best = i
+		case b == [2]float64{x, mp[1]}:
+			best = (i + 1) % len(poly)
+		case a[0] > b[0]:
+			best = i
+		default:
+			best = (i + 1) % len(poly)
+		}
+	}
+	if best < 0 {
+		return poly
+	}
+
+	// A reflex vertex inside the triangle formed by the hole vertex, the
+	// intersection and the candidate would block the bridge, so the one
+	// closest in angle to the ray is used instead.
+	ip := [2]float64{bestX, mp[1]}
+	pp := verts[poly[best]]
+	if pp != ip {
+		tri := [3][2]float64{mp, ip, pp}
+		if earcutCross(tri[0], tri[1], tri[2]) < 0 {
+			tri[1], tri[2] = tri[2], tri[1]
+		}
+		bestAngle := math.Atan2(math.Abs(pp[1]-mp[1]), pp[0]-mp[0])
+		for i := range poly {
+			v := verts[poly[i]]
+			if i == best || v == pp {
+				continue
+			}
+			prev, next := verts[poly[(i+len(poly)-1)%len(poly)]], verts[poly[(i+1)%len(poly)]]
+			if earcutCross(prev, v, next) >= 0 || !earcutInTriangle(tri[0], tri[1], tri[2], v) {
+				continue
+			}
+			if angle := math.Atan2(math.Abs(v[1]-mp[1]), v[0]-mp[0]); angle < bestAngle {
+				bestAngle = angle
+				best = i
+			}
+		}
+	}
+
+	ret := make([]int, 0, len(poly)+len(hole)+2)
+	ret = append(ret, poly[:best+1]...)
+	for i := 0; i <= len(hole); i++ {
+		ret = append(ret, hole[(m+i)%len(hole)])
+	}
+	ret = append(ret, poly[best:]...)
+	return ret
+}
+
+func earcutClip(verts [][2]float64, poly []int) []uint32 {
+	var ret []uint32
+	poly = append([]int(nil), poly...)
+	isEar := func(i int) bool {
+		a, b, c := verts[poly[(i+len(poly)-1)%len(poly)]], verts[poly[i]], verts[poly[(i+1)%len(poly)]]
+		if earcutCross(a, b, c) <= 0 {
+			return false
+		}
+		for _, j := range poly {
+			p := verts[j]
+			if p == a || p == b || p == c {
+				continue
+			}
+			if earcutInTriangle(a, b, c, p) {
+				return false
+			}
+		}
+		return true
+	}
+	emit := func(i int) {
+		ret = append(ret, uint32(poly[(i+len(poly)-1)%len(poly)]), uint32(poly[i]), uint32(poly[(i+1)%len(poly)]))
+		poly = append(poly[:i], poly[i+1:]...)
+	}
+
+	i, stalled := 0, 0
+	for len(poly) > 3 {
+		if i >= len(poly) {
+			i = 0
+		}
+		if isEar(i) {
+			emit(i)
+			stalled = 0
+			continue
+		}
+		i++
+		stalled++
+		if stalled < len(poly) {
+			continue
+		}
+		// No ear was found in a full pass, which happens with collinear or
+		// self touching rings. Degenerate vertices are dropped first and
+		// otherwise a triangle is forced so the loop always terminates.
+		stalled = 0
+		dropped := false
+		for j := range poly {
+			a, b, c := verts[poly[(j+len(poly)-1)%len(poly)]], verts[poly[j]], verts[poly[(j+1)%len(poly)]]
+			if earcutCross(a, b, c) == 0 {
+				poly = append(poly[:j], poly[j+1:]...)
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			emit(0)
+		}
+	}
+	if len(poly) == 3 && earcutCross(verts[poly[0]], verts[poly[1]], verts[poly[2]]) != 0 {
+		ret = append(ret, uint32(poly[0]), uint32(poly[1]), uint32(poly[2]))
+	}
+	return ret
+}
@@ -0,0 +1,51 @@
+package tile3d
+
+import (
+	"math"
+	"testing"
+)
+
+func earcutTrianglesArea(rings [][][2]float64, indices []uint32) float64 {
+	var verts [][2]float64
+	for _, r := range rings {
+		verts = append(verts, r...)
+	}
+	var area float64
+	for i := 0; i < len(indices); i += 3 {
+		a := earcutCross(verts[indices[i]], verts[indices[i+1]], verts[indices[i+2]]) / 2
+		if a < 0 {
+			return -1
+		}
+		area += a
+	}
+	return area
+}
+
+func TestEarcut(t *testing.T) {
+	tests := []struct {
+		name  string
+		rings [][][2]float64
+		area  float64
+	}{
+		{"square", [][][2]float64{{{0, 0}, {4, 0}, {4, 4}, {0, 4}}}, 16},
+		{"clockwise", [][][2]float64{{{0, 0}, {0, 4}, {4, 4}, {4, 0}}}, 16},
+		{"concave", [][][2]float64{{{0, 0}, {4, 0}, {4, 1}, {1, 1}, {1, 4}, {0, 4}}}, 7},
+		{"hole", [][][2]float64{{{0, 0}, {4, 0}, {4, 4}, {0, 4}}, {{1, 1}, {3, 1}, {3, 3}, {1, 3}}}, 12},
+		{"holes", [][][2]float64{
+			{{0, 0}, {10, 0}, {10, 10}, {0, 10}},
+			{{1, 1}, {3, 1}, {3, 3}, {1, 3}},
+			{{6, 6}, {6, 8}, {8, 8}, {8, 6}},
+			{{6, 1}, {8, 1}, {7, 3}},
+		}, 100 - 4 - 4 - 2},
+		{"collinear", [][][2]float64{{{0, 0}, {2, 0}, {4, 0}, {4, 4}, {0, 4}}}, 16},
+	}
+	for _, tt := range tests {
+		indices := earcut(tt.rings)
+		if len(indices)%3 != 0 {
+			t.Fatalf("%s: %d indices", tt.name, len(indices))
+		}
+		if area := earcutTrianglesArea(tt.rings, indices); math.Abs(area-tt.area) > 1e-9 {
+			t.Errorf("%s: area %v, want %v", tt.name, area, tt.area)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package tile3d
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrBadMagic           = errors.New("bad magic")
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	ErrTruncated          = errors.New("truncated data")
+	ErrBadReference       = errors.New("bad binary body reference")
+	ErrBadGltfFormat      = errors.New("gltfFormat must 0 or 1")
+	ErrBadValue           = errors.New("bad value")
+	ErrMissingModel       = errors.New("missing gltf model")
+	ErrUnresolvedUri      = errors.New("cannot resolve gltf uri")
+	ErrBadExpression      = errors.New("bad styling expression")
+)
+
+// TileError describes a failure while reading or writing a tile. Offset is
+// the byte offset in the tile where the problem was found, or -1 when the
+// failure is not tied to a location, and Name is the semantic or section
+// being processed. Err is one of the ErrXxx values above or an underlying
+// I/O error, so callers can test it with errors.Is.
+type TileError struct {
+	Format string
+	Name   string
+	Offset int64
+	Err    error
+}
+
+func (e *TileError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("%s: %s: %v", e.Format, e.Name, e.Err)
+	}
+	return fmt.Sprintf("%s: %s at offset %d: %v", e.Format, e.Name, e.Offset, e.Err)
+}
+
+func (e *TileError) Unwrap() error {
+	return e.Err
+}
+
+func newTileError(format string, name string, offset int64, err error) *TileError {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrTruncated
+	}
+	return &TileError{Format: format, Name: name, Offset: offset, Err: err}
+}
+
+// wrapTileError fills in the tile format of an error produced by a helper
+// and shifts its offset by base, the position of the section the helper
+// was working on. Other errors are wrapped with base as their offset.
+func wrapTileError(format string, name string, base int64, err error) error {
+	if err == nil {
+		return nil
+	}
+	var te *TileError
+	if errors.As(err, &te) {
+		if te.Format == "" {
+			te.Format = format
+		}
+		if te.Offset >= 0 && base > 0 {
+			te.Offset += base
+		}
+		return te
+	}
+	return newTileError(format, name, base, err)
+}
+
+func checkMagic(format string, start int64, magic [4]byte) error {
+	if string(magic[:]) != format {
+		return newTileError(format, "magic", start, ErrBadMagic)
+	}
+	return nil
+}
+
+func checkVersion(format string, start int64, version uint32) error {
+	if version != 1 {
+		return newTileError(format, "version", start+4, ErrUnsupportedVersion)
+	}
+	return nil
+}
+
+// readTileBody reads what is left of a tile of byteLength bytes that
+// started at start, typically the embedded glb.
+func readTileBody(reader io.ReadSeeker, format string, name string, start int64, byteLength uint32) ([]byte, error) {
+	offset, _ := reader.Seek(0, io.SeekCurrent)
+	size := int64(byteLength) - (offset - start)
+	if size < 0 {
+		return nil, newTileError(format, "byteLength", start+8, ErrBadValue)
+	}
+	buf, err := readSection(reader, int(size))
+	if err != nil {
+		return nil, newTileError(format, name, offset, err)
+	}
+	return buf, nil
+}
+
+// checkByteLength makes sure the byte lengths found in a tile header fit
+// in what is left of the stream, so the sections that follow can be
+// allocated safely. sections lists extra lengths some formats carry after
+// the batch table.
+func checkByteLength(reader io.ReadSeeker, format string, start int64, header Header, sections ...uint32) error {
+	offset, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return newTileError(format, "byteLength", start+8, err)
+	}
+	end, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return newTileError(format, "byteLength", start+8, err)
+	}
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		return newTileError(format, "byteLength", start+8, err)
+	}
+	byteLength := int64(header.GetByteLength())
+	if byteLength > end-start {
+		return newTileError(format, "byteLength", start+8, ErrTruncated)
+	}
+	si := header.CalcSize() + int64(header.GetFeatureTableJSONByteLength()) + int64(header.GetFeatureTableBinaryByteLength()) +
+		int64(header.GetBatchTableJSONByteLength()) + int64(header.GetBatchTableBinaryByteLength())
+	for _, s := range sections {
+		si += int64(s)
+	}
+	if si > byteLength {
+		return newTileError(format, "byteLength", start+8, ErrBadValue)
+	}
+	return nil
+}
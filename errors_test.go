@@ -0,0 +1,84 @@
+package tile3d
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func writeTestPnts(t *testing.T, featureJSON string, body []byte) []byte {
+	for len(featureJSON)%8 != 4 {
+		featureJSON += " "
+	}
+	h := PntsHeader{
+		Version:                      1,
+		ByteLength:                   uint32(28 + len(featureJSON) + len(body)),
+		FeatureTableJSONByteLength:   uint32(len(featureJSON)),
+		FeatureTableBinaryByteLength: uint32(len(body)),
+	}
+	copy(h.Magic[:], PNTS_MAGIC)
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, littleEndian, h); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString(featureJSON)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func TestReadErrors(t *testing.T) {
+	good := writeTestPnts(t, `{"POINTS_LENGTH":1,"POSITION":{"byteOffset":0}}`, make([]byte, 16))
+	if err := (&Pnts{}).Read(bytes.NewReader(good)); err != nil {
+		t.Fatal(err)
+	}
+
+	badMagic := append([]byte{}, good...)
+	copy(badMagic, "b3dm")
+	err := (&Pnts{}).Read(bytes.NewReader(badMagic))
+	var te *TileError
+	if !errors.Is(err, ErrBadMagic) || !errors.As(err, &te) || te.Offset != 0 {
+		t.Errorf("bad magic: %v", err)
+	}
+
+	badVersion := append([]byte{}, good...)
+	badVersion[4] = 2
+	err = (&Pnts{}).Read(bytes.NewReader(badVersion))
+	if !errors.Is(err, ErrUnsupportedVersion) || !errors.As(err, &te) || te.Offset != 4 {
+		t.Errorf("bad version: %v", err)
+	}
+
+	err = (&Pnts{}).Read(bytes.NewReader(good[:40]))
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("truncated: %v", err)
+	}
+
+	badRef := writeTestPnts(t, `{"POINTS_LENGTH":2,"POSITION":{"byteOffset":8}}`, make([]byte, 16))
+	err = (&Pnts{}).Read(bytes.NewReader(badRef))
+	if !errors.Is(err, ErrBadReference) || !errors.As(err, &te) || te.Name != PNTS_PROP_POSITION || te.Offset != 28+52+8 {
+		t.Errorf("bad reference: %v", err)
+	}
+}
+
+func TestReadOversized(t *testing.T) {
+	tile := writeTestPnts(t, `{"POINTS_LENGTH":1,"POSITION":{"byteOffset":0}}`, make([]byte, 16))
+	littleEndian.PutUint32(tile[8:], 1<<31)
+	if err := (&Pnts{}).Read(bytes.NewReader(tile)); !errors.Is(err, ErrTruncated) {
+		t.Errorf("byteLength: %v", err)
+	}
+
+	tile = writeTestPnts(t, `{"POINTS_LENGTH":1e12,"POSITION":{"byteOffset":0}}`, make([]byte, 16))
+	if err := (&Pnts{}).Read(bytes.NewReader(tile)); !errors.Is(err, ErrBadValue) {
+		t.Errorf("POINTS_LENGTH: %v", err)
+	}
+
+	cmpt := NewCmpt()
+	cmpt.Header.TilesLength = 1 << 30
+	cmpt.Header.Version = 1
+	cmpt.Header.ByteLength = 16
+	var buf bytes.Buffer
+	binary.Write(&buf, littleEndian, cmpt.Header)
+	if err := (&Cmpt{}).Read(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrBadValue) {
+		t.Errorf("tilesLength: %v", err)
+	}
+}
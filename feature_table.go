@@ -6,8 +6,8 @@ import (
 	"io"
 )
 
-type featureTableDecode func(header map[string]interface{}, buff []byte) map[string]interface{}
-type featureTableEncode func(header map[string]interface{}, data map[string]interface{}) []byte
+type featureTableDecode func(header map[string]interface{}, buff []byte) (map[string]interface{}, error)
+type featureTableEncode func(header map[string]interface{}, data map[string]interface{}) ([]byte, error)
 
 type FeatureTable struct {
 	Header map[string]interface{}
@@ -18,17 +18,22 @@ type FeatureTable struct {
 }
 
 func (t *FeatureTable) readJSONHeader(data io.ReadSeeker, jsonLength int) error {
-	jdata := make([]byte, jsonLength)
-	_, err := data.Read(jdata)
-	dec := json.NewDecoder(bytes.NewBuffer(jdata))
+	offset, _ := data.Seek(0, io.SeekCurrent)
+	jdata, err := readSection(data, jsonLength)
 	if err != nil {
-		return nil
+		return newTileError("", "featureTable JSON", offset, err)
 	}
 	t.Header = make(map[string]interface{})
+	if jsonLength == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewBuffer(jdata))
 	if err := dec.Decode(&t.Header); err != nil {
-		return err
+		return newTileError("", "featureTable JSON", offset, err)
+	}
+	if t.Header, err = transformBinaryBodyReference(t.Header); err != nil {
+		return wrapTileError("", "featureTable JSON", offset, err)
 	}
-	t.Header = transformBinaryBodyReference(t.Header)
 	return nil
 }
 
@@ -64,32 +69,69 @@ func (h *FeatureTable) CalcSize(header Header) int64 {
 }
 
 func (h *FeatureTable) GetBatchLength() int {
-	if h.Data["BATCH_LENGTH"] != nil {
-		switch d := h.Data["BATCH_LENGTH"].(type) {
-		case int:
-			return d
-		case float64:
-			return int(d)
-		}
-	}
-	return 0
+	n, _ := h.getCount("BATCH_LENGTH")
+	return n
+}
+
+// getCount returns an integer property, decoded into Data by Read or set
+// in Header by SetFeatureTable.
+func (h *FeatureTable) getCount(propName string) (int, bool) {
+	v := h.Data[propName]
+	if v == nil {
+		v = h.Header[propName]
+	}
+	switch t := v.(type) {
+	case int:
+		return t, true
+	case int32:
+		return int(t), true
+	case uint32:
+		return int(t), true
+	case float64:
+		return int(t), true
+	}
+	return 0, false
+}
+
+// getVec3 returns a VEC3 property, decoded into Data by Read or set in
+// Header by SetFeatureTable.
+func (h *FeatureTable) getVec3(propName string) ([3]float64, bool) {
+	switch t := h.Data[propName].(type) {
+	case [3]float64:
+		return t, true
+	case [3]float32:
+		return [3]float64{float64(t[0]), float64(t[1]), float64(t[2])}, true
+	}
+	if h.Header[propName] == nil {
+		return [3]float64{}, false
+	}
+	v, err := getFloat64Vec3FeatureValue(h.Header, nil, propName)
+	return v, err == nil
 }
 
 func (h *FeatureTable) readData(reader io.ReadSeeker, buffLength int) error {
-	if buffLength == 0 {
+	offset, _ := reader.Seek(0, io.SeekCurrent)
+	bdata, err := readSection(reader, buffLength)
+	if err != nil {
+		return newTileError("", "featureTable binary", offset, err)
+	}
+	if h.decode == nil {
 		return nil
 	}
-	bdata := make([]byte, buffLength)
-	_, err := reader.Read(bdata)
-	if err != nil {
-		return err
+	if h.Data, err = h.decode(h.Header, bdata); err != nil {
+		return wrapTileError("", "featureTable binary", offset, err)
 	}
-	h.Data = h.decode(h.Header, bdata)
 	return nil
 }
 
 func (h *FeatureTable) writeData(wr io.Writer) (int, error) {
-	buff := h.encode(h.Header, h.Data)
+	if h.encode == nil {
+		return 0, nil
+	}
+	buff, err := h.encode(h.Header, h.Data)
+	if err != nil {
+		return 0, err
+	}
 	if buff != nil {
 		n, err := wr.Write(buff)
 		if err != nil {
@@ -112,23 +154,48 @@ func (h *FeatureTable) Read(reader io.ReadSeeker, header Header) error {
 	return nil
 }
 
+// copyHeader returns a copy of Header for encoders to write into.
+func (h *FeatureTable) copyHeader() map[string]interface{} {
+	ret := make(map[string]interface{}, len(h.Header))
+	for k, v := range h.Header {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Write writes the JSON header and the binary body. The binary body is
+// encoded first, into a copy of Header, so the JSON header references it,
+// and both sections are padded to end on 8 byte boundaries of the tile.
 func (h *FeatureTable) Write(writer io.Writer, header Header) error {
-	JSONLength, err := h.writeJSONHeader(writer)
+	t := &FeatureTable{Header: h.copyHeader(), Data: h.Data, encode: h.encode}
+	var bin bytes.Buffer
+	BinaryLength, err := t.writeData(&bin)
 	if err != nil {
 		return err
 	}
 
+	JSONLength, err := t.writeJSONHeader(writer)
+	if err != nil {
+		return err
+	}
 	if JSONLength > 0 {
-		padding := createPaddingBytes(make([]byte, 0, 7), 28+uint32(JSONLength), 8, 0x20)
+		start := uint32(28)
+		if header != nil {
+			start = uint32(header.CalcSize())
+		}
+		padding := createPaddingBytes(make([]byte, 0, 7), start+uint32(JSONLength), 8, 0x20)
 		if _, err := writer.Write(padding); err != nil {
 			return err
 		}
 		JSONLength += len(padding)
 	}
 
-	BinaryLength, err := h.writeData(writer)
-	if err != nil {
-		return err
+	if BinaryLength > 0 {
+		bin.Write(make([]byte, calcPadding(uint32(BinaryLength), 8)))
+		BinaryLength = bin.Len()
+		if _, err := writer.Write(bin.Bytes()); err != nil {
+			return err
+		}
 	}
 	if header != nil {
 		header.SetFeatureTableJSONByteLength(uint32(JSONLength))
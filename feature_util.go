@@ -4,186 +4,252 @@ import (
 	"bytes"
 	"encoding/binary"
 	"math"
-	"unsafe"
 )
 
 var (
 	littleEndian = binary.LittleEndian
 )
 
-func getUnsignedShortBatchIDs(header map[string]interface{}, buff []byte, propName string, length int) []uint16 {
+func getBinaryBody(buff []byte, ref BinaryBodyReference, propName string, size int) ([]byte, error) {
+	offset := int(ref.ByteOffset)
+	if size < 0 || offset > len(buff) || size > len(buff)-offset {
+		return nil, newTileError("", propName, int64(offset), ErrBadReference)
+	}
+	return buff[offset : offset+size], nil
+}
+
+func readBinaryArray[T any](buff []byte, ref BinaryBodyReference, propName string, length int, elemSize int) ([]T, error) {
+	if length < 0 {
+		return nil, newTileError("", propName, -1, ErrBadValue)
+	}
+	body, err := getBinaryBody(buff, ref, propName, length*elemSize)
+	if err != nil {
+		return nil, err
+	}
+	if view, ok := viewBinaryArray[T](body, length); ok {
+		return view, nil
+	}
+	ret := make([]T, length)
+	if err := binary.Read(bytes.NewReader(body), littleEndian, ret); err != nil {
+		return nil, newTileError("", propName, int64(ref.ByteOffset), err)
+	}
+	return ret, nil
+}
+
+func getJSONNumbers(value interface{}, propName string, length int) ([]float64, error) {
+	if length < 0 {
+		return nil, newTileError("", propName, -1, ErrBadValue)
+	}
+	switch oref := value.(type) {
+	case []float64:
+		if len(oref) < length {
+			return nil, newTileError("", propName, -1, ErrBadValue)
+		}
+		return oref[:length], nil
+	case []float32:
+		if len(oref) < length {
+			return nil, newTileError("", propName, -1, ErrBadValue)
+		}
+		ret := make([]float64, length)
+		for i := range ret {
+			ret[i] = float64(oref[i])
+		}
+		return ret, nil
+	case []interface{}:
+		if len(oref) < length {
+			return nil, newTileError("", propName, -1, ErrBadValue)
+		}
+		ret := make([]float64, length)
+		for i := range ret {
+			f, ok := oref[i].(float64)
+			if !ok {
+				return nil, newTileError("", propName, -1, ErrBadValue)
+			}
+			ret[i] = f
+		}
+		return ret, nil
+	}
+	return nil, newTileError("", propName, -1, ErrBadValue)
+}
+
+func getUnsignedShortBatchIDs(header map[string]interface{}, buff []byte, propName string, length int) ([]uint16, error) {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
 	case BinaryBodyReference:
-		offset := oref.ByteOffset
-		buf := bytes.NewBuffer(buff[offset:])
 		switch oref.ComponentType {
-		case "UNSIGNED_SHORT":
-			ret := make([]uint16, length)
-			err := binary.Read(buf, littleEndian, ret)
-			if err != nil {
-				return nil
-			}
-			return ret
+		case "", COMPONENT_TYPE_UNSIGNED_SHORT:
+			return readBinaryArray[uint16](buff, oref, propName, length, 2)
+		default:
+			return nil, newTileError("", propName, int64(oref.ByteOffset), ErrBadReference)
 		}
 	}
-	return nil
+	return nil, nil
 }
 
-func getBatchLength(header map[string]interface{}, buff []byte, length int) interface{} {
+func getBatchLength(header map[string]interface{}, buff []byte, length int) (interface{}, error) {
 	objValue := header["BATCH_ID"]
 	switch oref := objValue.(type) {
 	case BinaryBodyReference:
-		offset := oref.ByteOffset
-		buf := bytes.NewBuffer(buff[offset:])
 		switch oref.ComponentType {
-		case "UNSIGNED_BYTE":
-			ret := make([]uint8, length)
-			err := binary.Read(buf, littleEndian, ret)
-			if err != nil {
-				return nil
-			}
-			return ret
-		case "UNSIGNED_SHORT":
-			ret := make([]uint16, length)
-			err := binary.Read(buf, littleEndian, ret)
-			if err != nil {
-				return nil
-			}
-			return ret
-		case "UNSIGNED_INT":
-			ret := make([]uint32, length)
-			err := binary.Read(buf, littleEndian, ret)
-			if err != nil {
-				return nil
-			}
-			return ret
+		case COMPONENT_TYPE_UNSIGNED_BYTE:
+			return readBinaryArray[uint8](buff, oref, "BATCH_ID", length, 1)
+		case "", COMPONENT_TYPE_UNSIGNED_SHORT:
+			return readBinaryArray[uint16](buff, oref, "BATCH_ID", length, 2)
+		case COMPONENT_TYPE_UNSIGNED_INT:
+			return readBinaryArray[uint32](buff, oref, "BATCH_ID", length, 4)
+		default:
+			return nil, newTileError("", "BATCH_ID", int64(oref.ByteOffset), ErrBadReference)
 		}
 	}
-	return nil
+	return nil, nil
 }
 
-func getUnsignedByteArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) []byte {
+func getUnsignedByteArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) ([]byte, error) {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
 	case BinaryBodyReference:
-		offset := int(oref.ByteOffset)
-		return buff[offset : offset+length]
+		return getBinaryBody(buff, oref, propName, length)
 	case []byte:
-		return oref
+		if len(oref) < length {
+			return nil, newTileError("", propName, -1, ErrBadValue)
+		}
+		return oref, nil
+	case nil:
+		return nil, nil
 	}
-	return nil
+	nums, err := getJSONNumbers(objValue, propName, length)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]byte, length)
+	for i := range ret {
+		ret[i] = byte(nums[i])
+	}
+	return ret, nil
 }
 
-func getShortArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) []int16 {
+func getShortArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) ([]int16, error) {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
 	case BinaryBodyReference:
-		buf := bytes.NewBuffer(buff[oref.ByteOffset:])
-		ret := make([]int16, length)
-		err := binary.Read(buf, littleEndian, ret)
-		if err != nil {
-			return nil
-		}
-		return ret
-	case []float64:
-		ret := make([]int16, length)
-		for i := 0; i < length; i++ {
-			ret[i] = int16(oref[i])
-		}
-		return ret
+		return readBinaryArray[int16](buff, oref, propName, length, 2)
+	case nil:
+		return nil, nil
 	}
-	return nil
+	nums, err := getJSONNumbers(objValue, propName, length)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int16, length)
+	for i := range ret {
+		ret[i] = int16(nums[i])
+	}
+	return ret, nil
 }
 
-func getUnsignedShortArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) []uint16 {
+func getUnsignedShortArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) ([]uint16, error) {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
 	case BinaryBodyReference:
-		buf := bytes.NewBuffer(buff[oref.ByteOffset:])
-		ret := make([]uint16, length)
-		err := binary.Read(buf, littleEndian, ret)
-		if err != nil {
-			return nil
-		}
-		return ret
-	case []float64:
-		ret := make([]uint16, length)
-		for i := 0; i < length; i++ {
-			ret[i] = uint16(oref[i])
-		}
-		return ret
+		return readBinaryArray[uint16](buff, oref, propName, length, 2)
+	case nil:
+		return nil, nil
 	}
-	return nil
+	nums, err := getJSONNumbers(objValue, propName, length)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]uint16, length)
+	for i := range ret {
+		ret[i] = uint16(nums[i])
+	}
+	return ret, nil
 }
 
-func getUnsignedIntArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) []uint32 {
+func getUnsignedIntArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) ([]uint32, error) {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
 	case BinaryBodyReference:
-		buf := bytes.NewBuffer(buff[oref.ByteOffset:])
-		ret := make([]uint32, length)
-		err := binary.Read(buf, littleEndian, ret)
-		if err != nil {
-			return nil
-		}
-		return ret
-	case []float64:
-		ret := make([]uint32, length)
-		for i := 0; i < length; i++ {
-			ret[i] = uint32(oref[i])
-		}
-		return ret
+		return readBinaryArray[uint32](buff, oref, propName, length, 4)
 	case []uint32:
-		return oref
+		if len(oref) < length {
+			return nil, newTileError("", propName, -1, ErrBadValue)
+		}
+		return oref, nil
+	case nil:
+		return nil, nil
 	}
-	return nil
+	nums, err := getJSONNumbers(objValue, propName, length)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]uint32, length)
+	for i := range ret {
+		ret[i] = uint32(nums[i])
+	}
+	return ret, nil
 }
 
-func getFloatVec3ArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) [][3]float32 {
+func getFloatVec3ArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) ([][3]float32, error) {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
 	case BinaryBodyReference:
-		buf := bytes.NewBuffer(buff[oref.ByteOffset:])
-		ret := make([][3]float32, length)
-		err := binary.Read(buf, littleEndian, ret)
-		if err != nil {
-			return nil
-		}
-		return ret
-	case []float64:
-		ret := make([][3]float32, length)
-		for i := 0; i < length; i++ {
-			ret[i][0] = float32(oref[i*3])
-			ret[i][1] = float32(oref[i*3+1])
-			ret[i][2] = float32(oref[i*3+2])
-		}
-		return ret
+		return readBinaryArray[[3]float32](buff, oref, propName, length, 12)
+	case nil:
+		return nil, nil
 	}
-	return nil
+	nums, err := getJSONNumbers(objValue, propName, length*3)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([][3]float32, length)
+	for i := 0; i < length; i++ {
+		ret[i][0] = float32(nums[i*3])
+		ret[i][1] = float32(nums[i*3+1])
+		ret[i][2] = float32(nums[i*3+2])
+	}
+	return ret, nil
 }
 
-func getUnsignedShortVec3ArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) [][3]uint16 {
+func getUnsignedShortVec2ArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) ([][2]uint16, error) {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
 	case BinaryBodyReference:
-		buf := bytes.NewBuffer(buff[oref.ByteOffset:])
-		ret := make([][3]uint16, length)
-		err := binary.Read(buf, littleEndian, ret)
-		if err != nil {
-			return nil
-		}
-		return ret
-	case []float64:
-		ret := make([][3]uint16, length)
-		for i := 0; i < length; i++ {
-			ret[i][0] = uint16(oref[i*3])
-			ret[i][1] = uint16(oref[i*3+1])
-			ret[i][2] = uint16(oref[i*3+2])
-		}
-		return ret
+		return readBinaryArray[[2]uint16](buff, oref, propName, length, 4)
+	case nil:
+		return nil, nil
 	}
-	return nil
+	nums, err := getJSONNumbers(objValue, propName, length*2)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([][2]uint16, length)
+	for i := 0; i < length; i++ {
+		ret[i][0] = uint16(nums[i*2])
+		ret[i][1] = uint16(nums[i*2+1])
+	}
+	return ret, nil
+}
+
+func getUnsignedShortVec3ArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) ([][3]uint16, error) {
+	objValue := header[propName]
+	switch oref := objValue.(type) {
+	case BinaryBodyReference:
+		return readBinaryArray[[3]uint16](buff, oref, propName, length, 6)
+	case nil:
+		return nil, nil
+	}
+	nums, err := getJSONNumbers(objValue, propName, length*3)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([][3]uint16, length)
+	for i := 0; i < length; i++ {
+		ret[i][0] = uint16(nums[i*3])
+		ret[i][1] = uint16(nums[i*3+1])
+		ret[i][2] = uint16(nums[i*3+2])
+	}
+	return ret, nil
 }
 
 func getUnsignedIntegerScalarFeatureValue(header map[string]interface{}, buff []byte, propName string) uint32 {
@@ -204,6 +270,21 @@ func getIntegerScalarFeatureValue(header map[string]interface{}, buff []byte, pr
 	return 0
 }
 
+// getCountFeatureValue reads a *_LENGTH semantic. Counts that are not
+// whole numbers or exceed limit are rejected so they can't drive oversized
+// allocations later on.
+func getCountFeatureValue(header map[string]interface{}, propName string, limit int) (int32, error) {
+	objValue, ok := header[propName]
+	if !ok {
+		return 0, nil
+	}
+	n, ok := objValue.(float64)
+	if !ok || n < 0 || n > float64(limit) || n > math.MaxInt32 || n != math.Trunc(n) {
+		return 0, newTileError("", propName, -1, ErrBadValue)
+	}
+	return int32(n), nil
+}
+
 func getLongScalarFeatureValue(header map[string]interface{}, buff []byte, propName string) int64 {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
@@ -231,112 +312,171 @@ func getDoubleScalarFeatureValue(header map[string]interface{}, buff []byte, pro
 	return 0
 }
 
-func getUnsignedByteVec3FeatureValue(header map[string]interface{}, buff []byte, propName string) [3]uint8 {
+func getUnsignedByteVec3FeatureValue(header map[string]interface{}, buff []byte, propName string) ([3]uint8, error) {
+	ret := [3]uint8{}
 	objValue := header[propName]
-	switch oref := objValue.(type) {
-	case []float64:
-		ret := [3]uint8{}
-		for i := 0; i < 3; i++ {
-			ret[i] = uint8(oref[i])
-		}
-		return ret
+	if objValue == nil {
+		return ret, nil
+	}
+	nums, err := getJSONNumbers(objValue, propName, 3)
+	if err != nil {
+		return ret, err
+	}
+	for i := 0; i < 3; i++ {
+		ret[i] = uint8(nums[i])
 	}
-	return [3]uint8{0, 0, 0}
+	return ret, nil
 }
 
-func getUnsignedByteVec4FeatureValue(header map[string]interface{}, buff []byte, propName string) [4]uint8 {
+func getUnsignedByteVec4FeatureValue(header map[string]interface{}, buff []byte, propName string) ([4]uint8, error) {
+	ret := [4]uint8{}
 	objValue := header[propName]
 	switch oref := objValue.(type) {
-	case []float64:
-		ret := [4]uint8{}
-		for i := 0; i < 4; i++ {
-			ret[i] = uint8(oref[i])
+	case nil:
+		return ret, nil
+	case BinaryBodyReference:
+		body, err := getBinaryBody(buff, oref, propName, 4)
+		if err != nil {
+			return ret, err
+		}
+		copy(ret[:], body)
+		return ret, nil
+	case []uint8:
+		if len(oref) < 4 {
+			return ret, newTileError("", propName, -1, ErrBadValue)
 		}
-		return ret
+		copy(ret[:], oref)
+		return ret, nil
 	}
-	return [4]uint8{0, 0, 0, 0}
+	nums, err := getJSONNumbers(objValue, propName, 4)
+	if err != nil {
+		return ret, err
+	}
+	for i := 0; i < 4; i++ {
+		ret[i] = uint8(nums[i])
+	}
+	return ret, nil
 }
 
-func getFloatVec3FeatureValue(header map[string]interface{}, buff []byte, propName string) [3]float32 {
+func getFloatVec3FeatureValue(header map[string]interface{}, buff []byte, propName string) ([3]float32, error) {
+	ret := [3]float32{}
 	objValue := header[propName]
-	switch oref := objValue.(type) {
-	case []float64:
-		ret := [3]float32{}
-		for i := 0; i < 3; i++ {
-			ret[i] = float32(oref[i])
-		}
-		return ret
-	case []interface{}:
-		ret := [3]float32{}
-		for i := 0; i < 3; i++ {
-			ret[i] = float32(oref[i].(float64))
+	if objValue == nil {
+		return ret, nil
+	}
+	if ref, ok := objValue.(BinaryBodyReference); ok {
+		arr, err := readBinaryArray[[3]float32](buff, ref, propName, 1, 12)
+		if err != nil {
+			return ret, err
 		}
-		return ret
+		return arr[0], nil
+	}
+	nums, err := getJSONNumbers(objValue, propName, 3)
+	if err != nil {
+		return ret, err
 	}
-	return [3]float32{0, 0, 0}
+	for i := 0; i < 3; i++ {
+		ret[i] = float32(nums[i])
+	}
+	return ret, nil
 }
 
-func getFloat64Vec3FeatureValue(header map[string]interface{}, buff []byte, propName string) [3]float64 {
+func getFloat64Vec3FeatureValue(header map[string]interface{}, buff []byte, propName string) ([3]float64, error) {
+	ret := [3]float64{}
 	objValue := header[propName]
-	switch oref := objValue.(type) {
-	case []float64:
-		ret := [3]float64{}
-		for i := 0; i < 3; i++ {
-			ret[i] = oref[i]
+	if objValue == nil {
+		return ret, nil
+	}
+	if ref, ok := objValue.(BinaryBodyReference); ok {
+		if ref.ComponentType == COMPONENT_TYPE_DOUBLE {
+			arr, err := readBinaryArray[[3]float64](buff, ref, propName, 1, 24)
+			if err != nil {
+				return ret, err
+			}
+			return arr[0], nil
+		}
+		arr, err := readBinaryArray[[3]float32](buff, ref, propName, 1, 12)
+		if err != nil {
+			return ret, err
 		}
-		return ret
-	case []interface{}:
-		ret := [3]float64{}
 		for i := 0; i < 3; i++ {
-			ret[i] = oref[i].(float64)
+			ret[i] = float64(arr[0][i])
 		}
-		return ret
+		return ret, nil
 	}
-	return [3]float64{0, 0, 0}
+	nums, err := getJSONNumbers(objValue, propName, 3)
+	if err != nil {
+		return ret, err
+	}
+	copy(ret[:], nums)
+	return ret, nil
 }
 
-func getFloatVec4FeatureValue(header map[string]interface{}, buff []byte, propName string) [4]float32 {
+func getFloatVec4FeatureValue(header map[string]interface{}, buff []byte, propName string) ([4]float32, error) {
+	ret := [4]float32{}
+	objValue := header[propName]
+	if objValue == nil {
+		return ret, nil
+	}
+	nums, err := getJSONNumbers(objValue, propName, 4)
+	if err != nil {
+		return ret, err
+	}
+	for i := 0; i < 4; i++ {
+		ret[i] = float32(nums[i])
+	}
+	return ret, nil
+}
+
+func getFloatArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) ([]float32, error) {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
-	case []float64:
-		ret := [4]float32{}
-		for i := 0; i < 4; i++ {
-			ret[i] = float32(oref[i])
-		}
-		return ret
+	case BinaryBodyReference:
+		return readBinaryArray[float32](buff, oref, propName, length, 4)
+	case float64:
+		ret := make([]float32, 1)
+		ret[0] = float32(oref)
+		return ret, nil
+	case nil:
+		return nil, nil
 	}
-	return [4]float32{0, 0, 0, 0}
+	nums, err := getJSONNumbers(objValue, propName, length)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]float32, length)
+	for i := range ret {
+		ret[i] = float32(nums[i])
+	}
+	return ret, nil
 }
 
-func getFloatArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) []float32 {
+func getDoubleArrayFeatureValue(header map[string]interface{}, buff []byte, propName string, length int) ([]float64, error) {
 	objValue := header[propName]
 	switch oref := objValue.(type) {
 	case BinaryBodyReference:
-		buf := bytes.NewBuffer(buff[oref.ByteOffset:])
-		ret := make([]float32, length)
-		err := binary.Read(buf, littleEndian, ret)
-		if err != nil {
-			return nil
+		if oref.ComponentType == COMPONENT_TYPE_DOUBLE {
+			return readBinaryArray[float64](buff, oref, propName, length, 8)
 		}
-		return ret
-	case []float64:
-		ret := make([]float32, length)
-		for i := 0; i < length; i++ {
-			ret[i] = float32(oref[i])
+		arr, err := readBinaryArray[float32](buff, oref, propName, length, 4)
+		if err != nil {
+			return nil, err
 		}
-		return ret
-	case []interface{}:
-		ret := make([]float32, length)
-		for i := 0; i < length; i++ {
-			ret[i] = float32(oref[i].(float64))
+		ret := make([]float64, length)
+		for i := range ret {
+			ret[i] = float64(arr[i])
 		}
-		return ret
-	case float64:
-		ret := make([]float32, 1)
-		ret[0] = float32(oref)
-		return ret
+		return ret, nil
+	case nil:
+		return nil, nil
 	}
-	return nil
+	nums, err := getJSONNumbers(objValue, propName, length)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]float64, length)
+	copy(ret, nums)
+	return ret, nil
 }
 
 func getBinaryBodyReference(header map[string]interface{}, propName string) *BinaryBodyReference {
@@ -345,200 +485,117 @@ func getBinaryBodyReference(header map[string]interface{}, propName string) *Bin
 		return nil
 	}
 	switch t := objValue.(type) {
+	case BinaryBodyReference:
+		return &t
+	case *BinaryBodyReference:
+		return t
 	case map[string]interface{}:
 		bt := new(BinaryBodyReference)
-		offset := t[REF_PROP_BYTE_OFFSET]
-		if offset == nil {
+		if t[REF_PROP_BYTE_OFFSET] == nil {
 			return nil
 		}
-		bt.ByteOffset = offset.(uint32)
-		oct := t[REF_PROP_COMPONENT_TYPE]
-		if oct != nil {
-			bt.ComponentType = oct.(string)
-		}
-		oct = t[REF_PROP_TYPE]
-		if oct != nil {
-			bt.ContainerType = oct.(string)
+		if err := bt.FromMap(t); err != nil {
+			return nil
 		}
 		return bt
 	}
 	return nil
 }
 
-func getBatchTableValue(header map[string]interface{}, buff []byte, propName string, batchLength int) interface{} {
+func getBatchTableValue(header map[string]interface{}, buff []byte, propName string, batchLength int) (interface{}, error) {
 	ref := getBinaryBodyReference(header, propName)
 	return getBatchTableValuesFromRef(ref, buff, propName, batchLength)
 }
 
-func getBatchTableValuesFromRef(ref *BinaryBodyReference, buff []byte, propName string, batchLength int) interface{} {
-	if ref != nil {
-		offset := int(ref.ByteOffset)
-		containerSize := ContainerTypeSize(ref.ContainerType)
-		switch ref.ComponentType {
-		case COMPONENT_TYPE_BYTE:
-			if containerSize == 1 {
-				return buff[offset+batchLength]
-			}
-			return buff[offset+batchLength*containerSize : offset+(batchLength+1)*containerSize]
-		case COMPONENT_TYPE_UNSIGNED_BYTE:
-			if containerSize == 1 {
-				return uint8(buff[offset+batchLength])
-			}
-			out := make([]uint8, containerSize)
-			for i := 0; i < containerSize; i++ {
-				out[i] = uint8(buff[offset+batchLength*containerSize+i])
-			}
-			return out
-		case COMPONENT_TYPE_SHORT:
-			if containerSize == 1 {
-				return int16(littleEndian.Uint16(buff[offset+batchLength : offset+batchLength+2]))
-			}
-			out := make([]int16, containerSize)
-			for i := 0; i < containerSize; i++ {
-				out[i] = int16(littleEndian.Uint16(buff[offset+batchLength*containerSize+i : offset+batchLength*containerSize+i+2]))
-			}
-			return out
-		case COMPONENT_TYPE_UNSIGNED_SHORT:
-			if containerSize == 1 {
-				return littleEndian.Uint16(buff[offset+batchLength : offset+batchLength+2])
-			}
-			out := make([]uint16, containerSize)
-			for i := 0; i < containerSize; i++ {
-				out[i] = littleEndian.Uint16(buff[offset+batchLength*containerSize+i : offset+batchLength*containerSize+i+2])
-			}
-			return out
-		case COMPONENT_TYPE_INT:
-			if containerSize == 1 {
-				return int32(littleEndian.Uint32(buff[offset+batchLength : offset+batchLength+4]))
-			}
-			out := make([]int32, containerSize)
-			for i := 0; i < containerSize; i++ {
-				out[i] = int32(littleEndian.Uint32(buff[offset+batchLength*containerSize+i : offset+batchLength*containerSize+i+4]))
-			}
-			return out
-		case COMPONENT_TYPE_UNSIGNED_INT:
-			if containerSize == 1 {
-				return littleEndian.Uint32(buff[offset+batchLength : offset+batchLength+4])
-			}
-			out := make([]uint32, containerSize)
-			for i := 0; i < containerSize; i++ {
-				out[i] = littleEndian.Uint32(buff[offset+batchLength*containerSize+i : offset+batchLength*containerSize+i+4])
-			}
-			return out
-		case COMPONENT_TYPE_FLOAT:
-			if containerSize == 1 {
-				i := littleEndian.Uint32(buff[offset+batchLength : offset+batchLength+4])
-				return math.Float32frombits(i)
-			}
-			out := make([]float32, containerSize)
-			for i := 0; i < containerSize; i++ {
-				inte := littleEndian.Uint32(buff[offset+batchLength*containerSize+i : offset+batchLength*containerSize+i+4])
-				out[i] = math.Float32frombits(inte)
-			}
-			return out
-		case COMPONENT_TYPE_DOUBLE:
-			if containerSize == 1 {
-				i := littleEndian.Uint64(buff[offset+batchLength : offset+batchLength+8])
-				return math.Float64frombits(i)
-			}
-			out := make([]float64, containerSize)
-			for i := 0; i < containerSize; i++ {
-				inte := littleEndian.Uint64(buff[offset+batchLength*containerSize+i : offset+batchLength*containerSize+i+8])
-				out[i] = math.Float64frombits(inte)
-			}
-			return out
-		}
+// getBatchTableValuesFromRef reads a binary batch table property as a flat
+// slice of batchLength times the container size components.
+func getBatchTableValuesFromRef(ref *BinaryBodyReference, buff []byte, propName string, batchLength int) (interface{}, error) {
+	if ref == nil {
+		return nil, nil
 	}
-	return nil
+	containerSize := ContainerTypeSize(ref.ContainerType)
+	componentSize := ComponentTypeSize(ref.ComponentType)
+	if containerSize == 0 || componentSize == 0 || batchLength < 0 {
+		return nil, newTileError("", propName, int64(ref.ByteOffset), ErrBadReference)
+	}
+	if ref.ByteOffset%uint32(componentSize) != 0 {
+		return nil, newTileError("", propName, int64(ref.ByteOffset), ErrBadReference)
+	}
+	n := batchLength * containerSize
+	switch ref.ComponentType {
+	case COMPONENT_TYPE_BYTE:
+		return readBinaryArray[int8](buff, *ref, propName, n, 1)
+	case COMPONENT_TYPE_UNSIGNED_BYTE:
+		return readBinaryArray[uint8](buff, *ref, propName, n, 1)
+	case COMPONENT_TYPE_SHORT:
+		return readBinaryArray[int16](buff, *ref, propName, n, 2)
+	case COMPONENT_TYPE_UNSIGNED_SHORT:
+		return readBinaryArray[uint16](buff, *ref, propName, n, 2)
+	case COMPONENT_TYPE_INT:
+		return readBinaryArray[int32](buff, *ref, propName, n, 4)
+	case COMPONENT_TYPE_UNSIGNED_INT:
+		return readBinaryArray[uint32](buff, *ref, propName, n, 4)
+	case COMPONENT_TYPE_FLOAT:
+		return readBinaryArray[float32](buff, *ref, propName, n, 4)
+	case COMPONENT_TYPE_DOUBLE:
+		return readBinaryArray[float64](buff, *ref, propName, n, 8)
+	}
+	return nil, newTileError("", propName, int64(ref.ByteOffset), ErrBadReference)
 }
 
-func getBatchTableBinaryByte(ref *BinaryBodyReference, data interface{}) []byte {
-	if ref != nil {
-		switch ref.ComponentType {
-		case COMPONENT_TYPE_BYTE:
-			switch d := data.(type) {
-			case byte:
-			case int8:
-				return []byte{byte(d)}
-			case []byte:
-				return d
-			case []int8:
-				return *(*[]byte)(unsafe.Pointer(&d[0]))
-			}
-		case COMPONENT_TYPE_UNSIGNED_BYTE:
-			switch d := data.(type) {
-			case uint8:
-				return []byte{byte(d)}
-			case []uint8:
-				return d
-			}
-		case COMPONENT_TYPE_SHORT:
-			switch d := data.(type) {
-			case int16:
-				ret := make([]byte, 2)
-				littleEndian.PutUint16(ret, uint16(d))
-				return ret
-			case []int16:
-				ret := make([]byte, 2*len(d))
-				binary.Read(bytes.NewBuffer(ret), littleEndian, d)
-				return ret
-			}
-		case COMPONENT_TYPE_UNSIGNED_SHORT:
-			switch d := data.(type) {
-			case uint16:
-				ret := make([]byte, 2)
-				littleEndian.PutUint16(ret, uint16(d))
-				return ret
-			case []uint16:
-				ret := make([]byte, 2*len(d))
-				binary.Read(bytes.NewBuffer(ret), littleEndian, d)
-				return ret
-			}
-		case COMPONENT_TYPE_INT:
-			switch d := data.(type) {
-			case int32:
-				ret := make([]byte, 4)
-				littleEndian.PutUint32(ret, uint32(d))
-				return ret
-			case []int32:
-				ret := make([]byte, 4*len(d))
-				binary.Read(bytes.NewBuffer(ret), littleEndian, d)
-				return ret
-			}
-		case COMPONENT_TYPE_UNSIGNED_INT:
-			switch d := data.(type) {
-			case uint32:
-				ret := make([]byte, 4)
-				littleEndian.PutUint32(ret, d)
-				return ret
-			case []uint32:
-				ret := make([]byte, 4*len(d))
-				binary.Read(bytes.NewBuffer(ret), littleEndian, d)
-				return ret
-			}
-		case COMPONENT_TYPE_FLOAT:
-			switch d := data.(type) {
-			case float32:
-				ret := make([]byte, 4)
-				littleEndian.PutUint32(ret, math.Float32bits(d))
-				return ret
-			case []float32:
-				ret := make([]byte, 4*len(d))
-				binary.Read(bytes.NewBuffer(ret), littleEndian, d)
-				return ret
-			}
-		case COMPONENT_TYPE_DOUBLE:
-			switch d := data.(type) {
-			case float64:
-				ret := make([]byte, 8)
-				littleEndian.PutUint64(ret, math.Float64bits(d))
-				return ret
-			case []float64:
-				ret := make([]byte, 8*len(d))
-				binary.Read(bytes.NewBuffer(ret), littleEndian, d)
-				return ret
-			}
+func getBatchTableBinaryByte(ref *BinaryBodyReference, data interface{}) ([]byte, error) {
+	if ref == nil {
+		return nil, nil
+	}
+	var expect bool
+	switch ref.ComponentType {
+	case COMPONENT_TYPE_BYTE:
+		switch data.(type) {
+		case int8, []int8, uint8, []uint8:
+			expect = true
+		}
+	case COMPONENT_TYPE_UNSIGNED_BYTE:
+		switch data.(type) {
+		case uint8, []uint8:
+			expect = true
+		}
+	case COMPONENT_TYPE_SHORT:
+		switch data.(type) {
+		case int16, []int16:
+			expect = true
+		}
+	case COMPONENT_TYPE_UNSIGNED_SHORT:
+		switch data.(type) {
+		case uint16, []uint16:
+			expect = true
+		}
+	case COMPONENT_TYPE_INT:
+		switch data.(type) {
+		case int32, []int32:
+			expect = true
+		}
+	case COMPONENT_TYPE_UNSIGNED_INT:
+		switch data.(type) {
+		case uint32, []uint32:
+			expect = true
+		}
+	case COMPONENT_TYPE_FLOAT:
+		switch data.(type) {
+		case float32, []float32:
+			expect = true
+		}
+	case COMPONENT_TYPE_DOUBLE:
+		switch data.(type) {
+		case float64, []float64:
+			expect = true
 		}
 	}
-	return nil
+	if !expect {
+		return nil, newTileError("", ref.ComponentType, -1, ErrBadValue)
+	}
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, littleEndian, data); err != nil {
+		return nil, newTileError("", ref.ComponentType, -1, err)
+	}
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,715 @@
+package tile3d
+
+import (
+	"math"
+	"reflect"
+
+	"github.com/flywave/gltf"
+	"github.com/flywave/gltf/modeler"
+)
+
+// FilterFeatures removes the features of tile keep returns false for and
+// renumbers the remaining ones in order. The geometry of a b3dm, the
+// instances of an i3dm, the points of a pnts and the polygons, polylines
+// and points of a vctr that belong to removed features are dropped, and
+// the batch table keeps the rows of the remaining features. tile is
+// unchanged on error.
+func FilterFeatures(tile TileModel, keep func(id int) bool) error {
+	switch m := tile.(type) {
+	case *B3dm:
+		return m.filterFeatures(keep)
+	case *I3dm:
+		return m.filterFeatures(keep)
+	case *Pnts:
+		return m.filterFeatures(keep)
+	case *Vctr:
+		return m.filterFeatures(keep)
+	}
+	return newTileError("", "tile", -1, ErrBadValue)
+}
+
+// featureRemap returns the new id of each of count features, -1 for the
+// removed ones, and the old ids of the remaining ones.
+func featureRemap(count int, keep func(id int) bool) ([]int, []uint32) {
+	remap := make([]int, count)
+	var rows []uint32
+	for id := range remap {
+		remap[id] = -1
+		if keep(id) {
+			remap[id] = len(rows)
+			rows = append(rows, uint32(id))
+		}
+	}
+	return remap, rows
+}
+
+// selectRows returns the elements of the slice v at rows.
+func selectRows(v interface{}, rows []uint32) interface{} {
+	rv := reflect.ValueOf(v)
+	ret := reflect.MakeSlice(rv.Type(), len(rows), len(rows))
+	for i, r := range rows {
+		ret.Index(i).Set(rv.Index(int(r)))
+	}
+	return ret.Interface()
+}
+
+// filterBatchTable returns the rows of the batch table t of count features.
+func filterBatchTable(t *BatchTable, rows []uint32, count int) (*BatchTable, error) {
+	if len(t.Header) == 0 {
+		return &BatchTable{Header: t.Header, Data: t.Data}, nil
+	}
+	return subsetBatchTable(t, rows, count)
+}
+
+// Properties of i3dm and pnts feature tables with an element per instance
+// or per point.
+var (
+	i3dmInstanceProperties = []string{
+		I3DM_PROP_POSITION, I3DM_PROP_POSITION_QUANTIZED,
+		I3DM_PROP_NORMAL_UP, I3DM_PROP_NORMAL_RIGHT, I3DM_PROP_NORMAL_UP_OCT32P, I3DM_PROP_NORMAL_RIGHT_OCT32P,
+		I3DM_PROP_SCALE, I3DM_PROP_SCALE_NON_UNIFORM, I3DM_PROP_BATCH_ID,
+	}
+	pntsPointProperties = []string{
+		PNTS_PROP_POSITION, PNTS_PROP_POSITION_QUANTIZED,
+		PNTS_PROP_RGBA, PNTS_PROP_RGB, PNTS_PROP_RGB565,
+		PNTS_PROP_NORMAL, PNTS_PROP_NORMAL_OCT16P, PNTS_PROP_BATCH_ID,
+	}
+)
+
+// filterFeatureTable returns a copy of t with the rows of its properties
+// names of n elements.
+func filterFeatureTable(t *FeatureTable, names []string, n int, rows []uint32) *FeatureTable {
+	ret := &FeatureTable{Header: make(map[string]interface{}), Data: make(map[string]interface{}), decode: t.decode, encode: t.encode}
+	for k, v := range t.Header {
+		ret.Header[k] = v
+	}
+	for k, v := range t.Data {
+		ret.Data[k] = v
+	}
+	for _, k := range names {
+		v, ok := t.Data[k]
+		if !ok {
+			continue
+		}
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.Len() == n {
+			ret.Data[k] = selectRows(v, rows)
+		}
+	}
+	return ret
+}
+
+// setFeatureTableCount sets the integer property name of t in its header
+// and, once read, in its decoded data.
+func setFeatureTableCount(t *FeatureTable, name string, n int) {
+	t.Header[name] = n
+	if t.Data != nil {
+		t.Data[name] = n
+	}
+}
+
+// remapBatchIds returns the batch ids ids, a slice of unsigned integers,
+// renumbered by remap in a slice of the same type.
+func remapBatchIds(ids interface{}, remap []int, name string) (interface{}, error) {
+	rv := reflect.ValueOf(ids)
+	ret := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		id := rv.Index(i).Uint()
+		if id >= uint64(len(remap)) || remap[id] < 0 {
+			return nil, newTileError("", name, -1, ErrBadReference)
+		}
+		ret.Index(i).SetUint(uint64(remap[id]))
+	}
+	return ret.Interface(), nil
+}
+
+func (m *B3dm) filterFeatures(keep func(id int) bool) error {
+	ret, err := m.subset(keep)
+	if err != nil {
+		return err
+	}
+	*m = *ret
+	return nil
+}
+
+// subset returns a copy of m with the features keep returns true for,
+// renumbered in order.
+func (m *B3dm) subset(keep func(id int) bool) (*B3dm, error) {
+	if m.Model == nil {
+		return nil, newTileError(B3DM_MAGIC, "glTF", -1, ErrMissingModel)
+	}
+	n := m.BatchLength()
+	remap, rows := featureRemap(n, keep)
+	doc, err := filterGltfFeatures(m.Model, remap)
+	if err != nil {
+		return nil, wrapTileError(B3DM_MAGIC, "glTF", -1, err)
+	}
+	bt, err := filterBatchTable(&m.BatchTable, rows, n)
+	if err != nil {
+		return nil, wrapTileError(B3DM_MAGIC, "batchTable", -1, err)
+	}
+	ret := &B3dm{Header: m.Header, BatchTable: *bt, Model: doc}
+	ret.FeatureTable = FeatureTable{Header: make(map[string]interface{}), decode: m.FeatureTable.decode, encode: m.FeatureTable.encode}
+	for k, v := range m.FeatureTable.Header {
+		ret.FeatureTable.Header[k] = v
+	}
+	if m.FeatureTable.Data != nil {
+		ret.FeatureTable.Data = make(map[string]interface{})
+		for k, v := range m.FeatureTable.Data {
+			ret.FeatureTable.Data[k] = v
+		}
+	}
+	setFeatureTableCount(&ret.FeatureTable, B3DM_PROP_BATCH_LENGTH, len(rows))
+	return ret, nil
+}
+
+func (m *I3dm) filterFeatures(keep func(id int) bool) error {
+	n := m.InstancesLength()
+	batchLength := m.BatchLength()
+	ids, err := m.BatchIds()
+	if err != nil {
+		return err
+	}
+	remap, rows := featureRemap(batchLength, keep)
+	var instances []uint32
+	for i := 0; i < n; i++ {
+		id := i
+		if ids != nil {
+			id = int(ids[i])
+		}
+		if remap[id] >= 0 {
+			instances = append(instances, uint32(i))
+		}
+	}
+
+	ft := filterFeatureTable(&m.FeatureTable, i3dmInstanceProperties, n, instances)
+	if ids != nil {
+		if ft.Data[I3DM_PROP_BATCH_ID], err = remapBatchIds(ft.Data[I3DM_PROP_BATCH_ID], remap, I3DM_PROP_BATCH_ID); err != nil {
+			return wrapTileError(I3DM_MAGIC, "featureTable", -1, err)
+		}
+	}
+	setFeatureTableCount(ft, I3DM_PROP_INSTANCES_LENGTH, len(instances))
+	bt, err := filterBatchTable(&m.BatchTable, rows, batchLength)
+	if err != nil {
+		return wrapTileError(I3DM_MAGIC, "batchTable", -1, err)
+	}
+	m.FeatureTable = *ft
+	m.BatchTable = *bt
+	return nil
+}
+
+func (m *Pnts) filterFeatures(keep func(id int) bool) error {
+	n := m.PointsLength()
+	batchLength := m.BatchLength()
+	ids, err := m.BatchIds()
+	if err != nil {
+		return err
+	}
+	remap, rows := featureRemap(batchLength, keep)
+	var points []uint32
+	for i := 0; i < n; i++ {
+		id := i
+		if ids != nil {
+			id = int(ids[i])
+		}
+		if id >= len(remap) {
+			return newTileError(PNTS_MAGIC, PNTS_PROP_BATCH_ID, -1, ErrBadReference)
+		}
+		if remap[id] >= 0 {
+			points = append(points, uint32(i))
+		}
+	}
+
+	ft := filterFeatureTable(&m.FeatureTable, pntsPointProperties, n, points)
+	if ids != nil {
+		if ft.Data[PNTS_PROP_BATCH_ID], err = remapBatchIds(ft.Data[PNTS_PROP_BATCH_ID], remap, PNTS_PROP_BATCH_ID); err != nil {
+			return wrapTileError(PNTS_MAGIC, "featureTable", -1, err)
+		}
+		setFeatureTableCount(ft, PNTS_PROP_BATCH_LENGTH, len(rows))
+	}
+	setFeatureTableCount(ft, PNTS_PROP_POINTS_LENGTH, len(points))
+	bt, err := filterBatchTable(&m.BatchTable, rows, batchLength)
+	if err != nil {
+		return wrapTileError(PNTS_MAGIC, "batchTable", -1, err)
+	}
+	m.FeatureTable = *ft
+	m.BatchTable = *bt
+	return nil
+}
+
+func (m *Vctr) filterFeatures(keep func(id int) bool) error {
+	view := m.GetFeatureTableView()
+	polygonIds, polylineIds, pointIds, err := m.BatchIds()
+	if err != nil {
+		return err
+	}
+	batchLength := m.BatchLength()
+	remap, rows := featureRemap(batchLength, keep)
+	kept := func(ids []uint16) ([]uint32, []uint16, error) {
+		var ret []uint32
+		var newIds []uint16
+		for i, id := range ids {
+			if int(id) >= len(remap) {
+				return nil, nil, newTileError(VCTR_MAGIC, "batchIds", -1, ErrBadReference)
+			}
+			if remap[id] >= 0 {
+				ret = append(ret, uint32(i))
+				newIds = append(newIds, uint16(remap[id]))
+			}
+		}
+		return ret, newIds, nil
+	}
+	ft := filterFeatureTable(&m.FeatureTable, nil, 0, nil)
+	for _, k := range []string{VCTR_PROP_POLYGON_COUNT, VCTR_PROP_POLYGON_INDEX_COUNT, VCTR_PROP_POLYLINE_COUNT} {
+		delete(ft.Header, k)
+		delete(ft.Data, k)
+	}
+	explicit := view.PolygonBatchId != nil || view.PolylineBatchId != nil || view.PointBatchId != nil
+	set := func(name string, values interface{}) {
+		ft.Header[name] = BinaryBodyReference{}
+		ft.Data[name] = values
+	}
+
+	var indices VctrIndices
+	var polygons VctrPolygons
+	polygonRows, polygonBatchIds, err := kept(polygonIds)
+	if err != nil {
+		return err
+	}
+	if n := len(polygonIds); n > 0 {
+		counts, err := m.getCounts(VCTR_PROP_POLYGON_COUNTS, VCTR_PROP_POLYGON_COUNT, n)
+		if err != nil {
+			return err
+		}
+		indexCounts, err := m.getCounts(VCTR_PROP_POLYGON_INDEX_COUNTS, VCTR_PROP_POLYGON_INDEX_COUNT, n)
+		if err != nil {
+			return err
+		}
+		offsets, indexOffsets := make([]int, n+1), make([]int, n+1)
+		for i := 0; i < n; i++ {
+			offsets[i+1] = offsets[i] + int(counts[i])
+			indexOffsets[i+1] = indexOffsets[i] + int(indexCounts[i])
+			if indexCounts[i]%3 != 0 {
+				return newTileError(VCTR_MAGIC, "polygonIndices", -1, ErrBadValue)
+			}
+		}
+		if offsets[n] > len(m.Polygons.p) || indexOffsets[n]/3 > len(m.Indices.p) {
+			return newTileError(VCTR_MAGIC, "polygonPositions", -1, ErrBadValue)
+		}
+		if view.PolygonMinimumHeight != nil && len(view.PolygonMinimumHeight) < n {
+			return newTileError(VCTR_MAGIC, VCTR_PROP_POLYGON_MINIMUM_HEIGHTS, -1, ErrBadValue)
+		}
+		if view.PolygonMaximumHeight != nil && len(view.PolygonMaximumHeight) < n {
+			return newTileError(VCTR_MAGIC, VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS, -1, ErrBadValue)
+		}
+		for _, r := range polygonRows {
+			shift := uint32(offsets[r] - len(polygons.p))
+			polygons.p = append(polygons.p, m.Polygons.p[offsets[r]:offsets[r+1]]...)
+			for _, tri := range m.Indices.p[indexOffsets[r]/3 : indexOffsets[r+1]/3] {
+				indices.Add([3]uint32{tri[0] - shift, tri[1] - shift, tri[2] - shift})
+			}
+		}
+		set(VCTR_PROP_POLYGON_COUNTS, selectRows(counts, polygonRows))
+		set(VCTR_PROP_POLYGON_INDEX_COUNTS, selectRows(indexCounts, polygonRows))
+		if view.PolygonMinimumHeight != nil {
+			set(VCTR_PROP_POLYGON_MINIMUM_HEIGHTS, selectRows(view.PolygonMinimumHeight[:n], polygonRows))
+		}
+		if view.PolygonMaximumHeight != nil {
+			set(VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS, selectRows(view.PolygonMaximumHeight[:n], polygonRows))
+		}
+		if explicit {
+			set(VCTR_PROP_POLYGON_BATCH_IDS, polygonBatchIds)
+		}
+	}
+
+	var polylines VctrPolylines
+	polylineRows, polylineBatchIds, err := kept(polylineIds)
+	if err != nil {
+		return err
+	}
+	if n := len(polylineIds); n > 0 {
+		counts, err := m.getCounts(VCTR_PROP_POLYLINE_COUNTS, VCTR_PROP_POLYLINE_COUNT, n)
+		if err != nil {
+			return err
+		}
+		offsets := make([]int, n+1)
+		for i := 0; i < n; i++ {
+			offsets[i+1] = offsets[i] + int(counts[i])
+		}
+		if offsets[n] > len(m.Polylines.p) {
+			return newTileError(VCTR_MAGIC, "polylinePositions", -1, ErrBadValue)
+		}
+		if view.PolylineWidths != nil && len(view.PolylineWidths) < n {
+			return newTileError(VCTR_MAGIC, VCTR_PROP_POLYLINE_WIDTHS, -1, ErrBadValue)
+		}
+		for _, r := range polylineRows {
+			polylines.p = append(polylines.p, m.Polylines.p[offsets[r]:offsets[r+1]]...)
+		}
+		set(VCTR_PROP_POLYLINE_COUNTS, selectRows(counts, polylineRows))
+		if view.PolylineWidths != nil {
+			set(VCTR_PROP_POLYLINE_WIDTHS, selectRows(view.PolylineWidths[:n], polylineRows))
+		}
+		if explicit {
+			set(VCTR_PROP_POLYLINE_BATCH_IDS, polylineBatchIds)
+		}
+	}
+
+	var points VctrPoints
+	pointRows, pointBatchIds, err := kept(pointIds)
+	if err != nil {
+		return err
+	}
+	if n := len(pointIds); n > 0 {
+		if n > len(m.Points.p) {
+			return newTileError(VCTR_MAGIC, "pointPositions", -1, ErrBadValue)
+		}
+		points.p = selectRows(m.Points.p[:n], pointRows).([][3]int)
+		if explicit {
+			set(VCTR_PROP_POINT_BATCH_IDS, pointBatchIds)
+		}
+	}
+
+	setFeatureTableCount(ft, VCTR_PROP_POLYGONS_LENGTH, len(polygonRows))
+	setFeatureTableCount(ft, VCTR_PROP_POLYLINES_LENGTH, len(polylineRows))
+	setFeatureTableCount(ft, VCTR_PROP_POINTS_LENGTH, len(pointRows))
+	bt, err := filterBatchTable(&m.BatchTable, rows, batchLength)
+	if err != nil {
+		return wrapTileError(VCTR_MAGIC, "batchTable", -1, err)
+	}
+	m.FeatureTable = *ft
+	m.BatchTable = *bt
+	m.Indices, m.Polygons, m.Polylines, m.Points = indices, polygons, polylines, points
+	return nil
+}
+
+// filterGltfFeatures returns a copy of model without the vertices whose
+// _BATCHID is removed by remap, and with the other ids renumbered.
+// Primitives and meshes left empty are removed and the buffers only keep
+// the data still referenced.
+func filterGltfFeatures(model *gltf.Document, remap []int) (*gltf.Document, error) {
+	for _, e := range model.ExtensionsUsed {
+		switch e {
+		case "KHR_draco_mesh_compression", "EXT_meshopt_compression", EXT_MESH_GPU_INSTANCING:
+			// These reference buffer data compactGltf does not know about.
+			return nil, newTileError("", e, -1, ErrBadValue)
+		}
+	}
+	doc, err := copyGltf(model)
+	if err != nil {
+		return nil, err
+	}
+	cache := make(map[[2]uint32]uint32)
+	meshes := make([]int, len(doc.Meshes))
+	var kept []*gltf.Mesh
+	for i, mesh := range doc.Meshes {
+		var prims []*gltf.Primitive
+		for _, p := range mesh.Primitives {
+			ok, err := filterGltfPrimitive(doc, p, remap, cache)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				prims = append(prims, p)
+			}
+		}
+		meshes[i] = -1
+		if len(prims) > 0 {
+			mesh.Primitives = prims
+			meshes[i] = len(kept)
+			kept = append(kept, mesh)
+		}
+	}
+	doc.Meshes = kept
+	for _, n := range doc.Nodes {
+		if n.Mesh == nil {
+			continue
+		}
+		if int(*n.Mesh) >= len(meshes) {
+			return nil, newTileError("", "mesh", -1, ErrBadReference)
+		}
+		i := meshes[*n.Mesh]
+		n.Mesh = nil
+		if i >= 0 {
+			n.Mesh = gltf.Index(uint32(i))
+		} else {
+			n.Skin, n.Weights = nil, nil
+		}
+	}
+	if err := compactGltf(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// filterGltfPrimitive removes from p the vertices whose _BATCHID is
+// removed by remap and the triangles, lines and points using them. It
+// returns false when p is left without vertices. Attributes filtered with
+// the same batch ids share their new accessors through cache.
+func filterGltfPrimitive(doc *gltf.Document, p *gltf.Primitive, remap []int, cache map[[2]uint32]uint32) (bool, error) {
+	name := GLTF_ATTR_BATCHID
+	index, ok := p.Attributes[name]
+	if !ok {
+		name = "BATCHID"
+		if index, ok = p.Attributes[name]; !ok {
+			return true, nil
+		}
+	}
+	ids, err := readFeatureIds(doc, index)
+	if err != nil {
+		return false, err
+	}
+	vertices := make([]int, len(ids))
+	var rows []uint32
+	for v, id := range ids {
+		if int(id) >= len(remap) {
+			return false, newTileError("", name, -1, ErrBadReference)
+		}
+		vertices[v] = -1
+		if remap[id] >= 0 {
+			vertices[v] = len(rows)
+			rows = append(rows, uint32(v))
+		}
+	}
+	if len(rows) == 0 {
+		return false, nil
+	}
+
+	var indices []uint32
+	if p.Indices != nil {
+		if int(*p.Indices) >= len(doc.Accessors) {
+			return false, newTileError("", "indices", -1, ErrBadReference)
+		}
+		if indices, err = modeler.ReadIndices(doc, doc.Accessors[*p.Indices], nil); err != nil {
+			return false, newTileError("", "indices", -1, err)
+		}
+	} else {
+		indices = make([]uint32, len(ids))
+		for i := range indices {
+			indices[i] = uint32(i)
+		}
+	}
+	list, mode, err := listIndices(indices, p.Mode)
+	if err != nil {
+		return false, err
+	}
+	size := 1
+	switch mode {
+	case gltf.PrimitiveTriangles:
+		size = 3
+	case gltf.PrimitiveLines:
+		size = 2
+	}
+	var filtered []uint32
+	for i := 0; i < len(list); i += size {
+		ok := true
+		for _, v := range list[i : i+size] {
+			if int(v) >= len(vertices) {
+				return false, newTileError("", "indices", -1, ErrBadReference)
+			}
+			ok = ok && vertices[v] >= 0
+		}
+		if !ok {
+			continue
+		}
+		for _, v := range list[i : i+size] {
+			filtered = append(filtered, uint32(vertices[v]))
+		}
+	}
+	if len(filtered) == 0 {
+		return false, nil
+	}
+
+	filter := func(a uint32) (uint32, error) {
+		k := [2]uint32{index, a}
+		if i, ok := cache[k]; ok {
+			return i, nil
+		}
+		i, err := filterGltfAccessor(doc, a, rows)
+		if err != nil {
+			return 0, err
+		}
+		cache[k] = i
+		return i, nil
+	}
+	for k, a := range p.Attributes {
+		if k == name {
+			continue
+		}
+		if p.Attributes[k], err = filter(a); err != nil {
+			return false, err
+		}
+	}
+	for _, t := range p.Targets {
+		for k, a := range t {
+			if t[k], err = filter(a); err != nil {
+				return false, err
+			}
+		}
+	}
+	// The renumbered ids keep the component type of the attribute.
+	data, err := modeler.ReadAccessor(doc, doc.Accessors[index], nil)
+	if err != nil {
+		return false, newTileError("", name, -1, err)
+	}
+	data = selectRows(data, rows)
+	rv := reflect.ValueOf(data)
+	for i, v := range rows {
+		if e := rv.Index(i); e.CanFloat() {
+			e.SetFloat(float64(remap[ids[v]]))
+		} else {
+			e.SetUint(uint64(remap[ids[v]]))
+		}
+	}
+	delete(p.Attributes, name)
+	p.Attributes[GLTF_ATTR_BATCHID] = modeler.WriteAccessor(doc, gltf.TargetArrayBuffer, data)
+	if len(rows) < math.MaxUint16 {
+		short := make([]uint16, len(filtered))
+		for i, v := range filtered {
+			short[i] = uint16(v)
+		}
+		p.Indices = gltf.Index(modeler.WriteIndices(doc, short))
+	} else {
+		p.Indices = gltf.Index(modeler.WriteIndices(doc, filtered))
+	}
+	p.Mode = mode
+	return true, nil
+}
+
+// filterGltfAccessor writes the elements rows of accessor index as a new
+// accessor and returns its index.
+func filterGltfAccessor(doc *gltf.Document, index uint32, rows []uint32) (uint32, error) {
+	if int(index) >= len(doc.Accessors) {
+		return 0, newTileError("", "accessor", -1, ErrBadReference)
+	}
+	acr := doc.Accessors[index]
+	data, err := modeler.ReadAccessor(doc, acr, nil)
+	if err != nil {
+		return 0, newTileError("", "accessor", -1, err)
+	}
+	if data == nil {
+		// Accessors without data are zeros.
+		ret := *acr
+		ret.Count = uint32(len(rows))
+		doc.Accessors = append(doc.Accessors, &ret)
+		return uint32(len(doc.Accessors) - 1), nil
+	}
+	data = selectRows(data, rows)
+	i := modeler.WriteAccessor(doc, gltf.TargetArrayBuffer, data)
+	ret := doc.Accessors[i]
+	ret.Name, ret.Normalized, ret.Extras = acr.Name, acr.Normalized, acr.Extras
+	if acr.Min != nil || acr.Max != nil {
+		ret.Min, ret.Max = accessorBounds(data)
+	}
+	return i, nil
+}
+
+// accessorBounds returns the minimum and maximum of each component of the
+// elements of data, a slice of numbers or of arrays of numbers.
+func accessorBounds(data interface{}) (min, max []float32) {
+	rv := reflect.ValueOf(data)
+	for i := 0; i < rv.Len(); i++ {
+		e := rv.Index(i)
+		n := 1
+		if e.Kind() == reflect.Array {
+			n = e.Len()
+		}
+		if min == nil {
+			min, max = make([]float32, n), make([]float32, n)
+		}
+		for j := 0; j < n; j++ {
+			c := e
+			if e.Kind() == reflect.Array {
+				c = e.Index(j)
+			}
+			f := float32(c.Convert(float64Type).Float())
+			if i == 0 || f < min[j] {
+				min[j] = f
+			}
+			if i == 0 || f > max[j] {
+				max[j] = f
+			}
+		}
+	}
+	return min, max
+}
+
+// visitGltfAccessors calls fn for every accessor referenced by the meshes,
+// skins and animations of doc with the target of its data, and replaces
+// the reference with the result.
+func visitGltfAccessors(doc *gltf.Document, fn func(index uint32, target gltf.Target) uint32) {
+	for _, mesh := range doc.Meshes {
+		for _, p := range mesh.Primitives {
+			for k, a := range p.Attributes {
+				p.Attributes[k] = fn(a, gltf.TargetArrayBuffer)
+			}
+			if p.Indices != nil {
+				p.Indices = gltf.Index(fn(*p.Indices, gltf.TargetElementArrayBuffer))
+			}
+			for _, t := range p.Targets {
+				for k, a := range t {
+					t[k] = fn(a, gltf.TargetArrayBuffer)
+				}
+			}
+		}
+	}
+	for _, s := range doc.Skins {
+		if s.InverseBindMatrices != nil {
+			s.InverseBindMatrices = gltf.Index(fn(*s.InverseBindMatrices, gltf.TargetNone))
+		}
+	}
+	for _, a := range doc.Animations {
+		for _, s := range a.Samplers {
+			s.Input = fn(s.Input, gltf.TargetNone)
+			s.Output = fn(s.Output, gltf.TargetNone)
+		}
+	}
+}
+
+// compactGltf rewrites the buffers of doc with only the accessors its
+// meshes, skins and animations reference and the images.
+func compactGltf(doc *gltf.Document) error {
+	var order []uint32
+	targets := make(map[uint32]gltf.Target)
+	bad := false
+	visitGltfAccessors(doc, func(index uint32, target gltf.Target) uint32 {
+		if int(index) >= len(doc.Accessors) {
+			bad = true
+		} else if _, ok := targets[index]; !ok {
+			targets[index] = target
+			order = append(order, index)
+		}
+		return index
+	})
+	if bad {
+		return newTileError("", "accessor", -1, ErrBadReference)
+	}
+	data := make([]interface{}, len(order))
+	for i, index := range order {
+		var err error
+		if data[i], err = modeler.ReadAccessor(doc, doc.Accessors[index], nil); err != nil {
+			return newTileError("", "accessor", -1, err)
+		}
+	}
+	accessors := doc.Accessors
+	if err := resetGltfBuffers(doc); err != nil {
+		return err
+	}
+
+	indexes := make(map[uint32]uint32, len(order))
+	for i, index := range order {
+		acr := accessors[index]
+		indexes[index] = uint32(len(doc.Accessors))
+		if data[i] == nil {
+			ret := *acr
+			ret.BufferView, ret.ByteOffset, ret.Sparse = nil, 0, nil
+			doc.Accessors = append(doc.Accessors, &ret)
+			continue
+		}
+		ret := doc.Accessors[modeler.WriteAccessor(doc, targets[index], data[i])]
+		ret.Name, ret.Normalized, ret.Extras, ret.Extensions = acr.Name, acr.Normalized, acr.Extras, acr.Extensions
+		ret.Min, ret.Max = acr.Min, acr.Max
+	}
+	visitGltfAccessors(doc, func(index uint32, target gltf.Target) uint32 {
+		return indexes[index]
+	})
+	return nil
+}
@@ -0,0 +1,132 @@
+package tile3d
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilterFeatures(t *testing.T) {
+	baked, err := newBakeI3dm().Bake(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := baked.(*B3dm)
+	size := len(b.Model.Buffers[0].Data)
+	positions, _ := bakedVertices(t, b)
+	if err := FilterFeatures(b, func(id int) bool { return id != 2 }); err != nil {
+		t.Fatal(err)
+	}
+	r := newSampleTile(t, writeTile(t, b)).(*B3dm)
+	filtered, ids := bakedVertices(t, r)
+	if len(filtered) != len(positions)/3 || r.BatchLength() != 2 {
+		t.Errorf("vertices %d of %d, batch length %d", len(filtered), len(positions), r.BatchLength())
+	}
+	for _, id := range ids {
+		if id != 0 {
+			t.Fatalf("batch id %v", id)
+		}
+	}
+	if n := len(r.Model.Buffers[0].Data); n > size/2 {
+		t.Errorf("buffer of %d bytes, was %d", n, size)
+	}
+	if v := r.BatchTable.GetProperty("name", 1); v != "b" {
+		t.Errorf("name %v", v)
+	}
+
+	i := newBakeI3dm()
+	if err := FilterFeatures(i, func(id int) bool { return id == 2 }); err != nil {
+		t.Fatal(err)
+	}
+	ri := newSampleTile(t, writeTile(t, i)).(*I3dm)
+	if ids, _ := ri.BatchIds(); !reflect.DeepEqual(ids, []uint32{0, 0}) {
+		t.Errorf("instance batch ids %v", ids)
+	}
+	if p, _ := ri.Positions(); len(p) != 2 || p[1] != [3]float64{100, 200, 330} {
+		t.Errorf("positions %v", p)
+	}
+	if v := ri.BatchTable.GetProperty("height", 0); !reflect.DeepEqual(v, []float32{5, 6}) {
+		t.Errorf("height %v", v)
+	}
+
+	batchLength := uint32(4)
+	p := NewPnts()
+	p.SetFeatureTable(PntsFeatureTableView{
+		Position:     [][3]float32{{0, 0, 0}, {1, 0, 0}, {2, 0, 0}, {3, 0, 0}, {4, 0, 0}},
+		BatchId:      []uint8{0, 1, 2, 3, 3},
+		BatchLength:  &batchLength,
+		PointsLength: 5,
+	})
+	p.BatchTable.Header = map[string]interface{}{}
+	p.BatchTable.SetHierarchy(newBuildingHierarchy())
+	if err := FilterFeatures(p, func(id int) bool { return id%2 == 1 }); err != nil {
+		t.Fatal(err)
+	}
+	rp := newSampleTile(t, writeTile(t, p)).(*Pnts)
+	if ids, _ := rp.BatchIds(); !reflect.DeepEqual(ids, []uint32{0, 1, 1}) || rp.BatchLength() != 2 {
+		t.Errorf("point batch ids %v, batch length %d", ids, rp.BatchLength())
+	}
+	h, err := rp.BatchTable.Hierarchy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, err := h.Properties(1)
+	if err != nil || h.InstancesLength() != 5 {
+		t.Fatal(h.InstancesLength(), err)
+	}
+	if !reflect.DeepEqual(props, map[string]interface{}{"area": 40.0, "level": 1.0, "name": "tower"}) {
+		t.Errorf("properties %v", props)
+	}
+
+	src := `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {"n": 0}, "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}},
+		{"type": "Feature", "properties": {"n": 1}, "geometry": {"type": "Polygon", "coordinates": [[[2, 2], [3, 2], [3, 3], [2, 3], [2, 2]]]}},
+		{"type": "Feature", "properties": {"n": 2}, "geometry": {"type": "MultiLineString", "coordinates": [[[0, 0, 5], [1, 1, 15]], [[1, 0, 5], [0, 1, 15], [0, 2, 5]]]}},
+		{"type": "Feature", "properties": {"n": 3}, "geometry": {"type": "MultiPoint", "coordinates": [[0.5, 0.5, 10], [0.1, 0.9, 10]]}}
+	]}`
+	v, err := NewVctrFromGeoJSON(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, err := v.Features()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := FilterFeatures(v, func(id int) bool { return id%2 == 1 }); err != nil {
+		t.Fatal(err)
+	}
+	got, err := newSampleTile(t, writeTile(t, v)).(*Vctr).Features()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []VctrFeature
+	for _, f := range features {
+		if f.BatchId%2 == 1 {
+			f.BatchId /= 2
+			want = append(want, f)
+		}
+	}
+	if len(want) != 3 || !reflect.DeepEqual(got, want) {
+		t.Errorf("features\n%v\nwant\n%v", got, want)
+	}
+
+	if err := FilterFeatures(NewCmpt(), func(int) bool { return true }); !errors.Is(err, ErrBadValue) {
+		t.Errorf("cmpt %v", err)
+	}
+
+	// Only per point properties are filtered, whatever the length of others.
+	ft := filterFeatureTable(&FeatureTable{
+		Header: map[string]interface{}{PNTS_PROP_QUANTIZED_VOLUME_OFFSET: BinaryBodyReference{}},
+		Data: map[string]interface{}{
+			PNTS_PROP_POSITION_QUANTIZED:      [][3]uint16{{0, 0, 0}, {1, 1, 1}, {2, 2, 2}},
+			PNTS_PROP_QUANTIZED_VOLUME_OFFSET: []float32{1, 2, 3},
+		},
+	}, pntsPointProperties, 3, []uint32{2, 0})
+	if !reflect.DeepEqual(ft.Data[PNTS_PROP_POSITION_QUANTIZED], [][3]uint16{{2, 2, 2}, {0, 0, 0}}) {
+		t.Errorf("positions %v", ft.Data[PNTS_PROP_POSITION_QUANTIZED])
+	}
+	if !reflect.DeepEqual(ft.Data[PNTS_PROP_QUANTIZED_VOLUME_OFFSET], []float32{1, 2, 3}) {
+		t.Errorf("volume offset %v", ft.Data[PNTS_PROP_QUANTIZED_VOLUME_OFFSET])
+	}
+}
@@ -0,0 +1,93 @@
+package tile3d
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func addFuzzSeeds(f *testing.F, pattern string) {
+	files, _ := filepath.Glob(pattern)
+	for _, p := range files {
+		if b, err := os.ReadFile(p); err == nil {
+			f.Add(b)
+		}
+	}
+}
+
+func addFuzzTile(f *testing.F, m TileModel) {
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err == nil {
+		f.Add(buf.Bytes())
+	}
+}
+
+func FuzzB3dm(f *testing.F) {
+	addFuzzSeeds(f, "data/*.b3dm")
+	b3dm := NewB3dm()
+	b3dm.Model = openGltf("data/box.glb")
+	addFuzzTile(f, b3dm)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		(&B3dm{}).Read(bytes.NewReader(data))
+	})
+}
+
+func FuzzI3dm(f *testing.F) {
+	addFuzzSeeds(f, "data/*.i3dm")
+	i3dm := &I3dm{Model: openGltf("data/box.glb")}
+	i3dm.Header.GltfFormat = I3DM_GLTF_EMBEDDED
+	i3dm.SetFeatureTable(I3dmFeatureTableView{Position: [][3]float32{{0, 0, 0}, {1, 1, 1}}, InstanceLength: 2})
+	addFuzzTile(f, i3dm)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		(&I3dm{}).Read(bytes.NewReader(data))
+	})
+}
+
+func FuzzPnts(f *testing.F) {
+	addFuzzSeeds(f, "data/*.pnts")
+	pnts := NewPnts()
+	pnts.SetFeatureTable(PntsFeatureTableView{Position: [][3]float32{{0, 0, 0}, {1, 1, 1}}, RGB: [][3]uint8{{255, 0, 0}, {0, 255, 0}}, PointsLength: 2})
+	addFuzzTile(f, pnts)
+	pnts.Draco = NewPntsDraco()
+	addFuzzTile(f, pnts)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		(&Pnts{}).Read(bytes.NewReader(data))
+	})
+}
+
+func FuzzGeom(f *testing.F) {
+	addFuzzSeeds(f, "data/*.geom")
+	geom := &Geom{}
+	geom.SetFeatureTable(GeomFeatureTableView{Spheres: []GeomSphere{{0, 0, 0, 1}}, SphereBatchId: []uint16{0}})
+	addFuzzTile(f, geom)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		(&Geom{}).Read(bytes.NewReader(data))
+	})
+}
+
+func FuzzVctr(f *testing.F) {
+	addFuzzSeeds(f, "data/*.vctr")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := &Vctr{}
+		if m.Read(bytes.NewReader(data)) == nil {
+			m.Features()
+		}
+	})
+}
+
+func FuzzCmpt(f *testing.F) {
+	addFuzzSeeds(f, "data/*.cmpt")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		(&Cmpt{}).Read(bytes.NewReader(data))
+	})
+}
+
+func FuzzTileInfo(f *testing.F) {
+	for _, p := range []string{"data/*.b3dm", "data/*.i3dm", "data/*.cmpt", "data/*.vctr"} {
+		addFuzzSeeds(f, p)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ReadTileInfo(bytes.NewReader(data))
+	})
+}
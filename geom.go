@@ -76,14 +76,62 @@ func (h *GeomHeader) SetBatchTableBinaryByteLength(n uint32) {
 	h.BatchTableBinaryByteLength = n
 }
 
-type GeomBox [16]float32
+// GeomBox is a unit cube centered at the origin, scaled by Dimensions and
+// placed by ModelMatrix.
+type GeomBox [19]float32
 
-type GeomCylinder [16]float32
+func (b GeomBox) Dimensions() [3]float32 {
+	return [3]float32{b[0], b[1], b[2]}
+}
+
+func (b GeomBox) ModelMatrix() [16]float32 {
+	var m [16]float32
+	copy(m[:], b[3:])
+	return m
+}
+
+// GeomCylinder is a cylinder along the z axis centered at the origin,
+// placed by ModelMatrix.
+type GeomCylinder [18]float32
 
-type GeomEllipsoid [16]float32
+func (c GeomCylinder) Radius() float32 {
+	return c[0]
+}
+
+func (c GeomCylinder) Length() float32 {
+	return c[1]
+}
+
+func (c GeomCylinder) ModelMatrix() [16]float32 {
+	var m [16]float32
+	copy(m[:], c[2:])
+	return m
+}
+
+// GeomEllipsoid is an ellipsoid centered at the origin, placed by
+// ModelMatrix.
+type GeomEllipsoid [19]float32
+
+func (e GeomEllipsoid) Radii() [3]float32 {
+	return [3]float32{e[0], e[1], e[2]}
+}
+
+func (e GeomEllipsoid) ModelMatrix() [16]float32 {
+	var m [16]float32
+	copy(m[:], e[3:])
+	return m
+}
 
 type GeomSphere [4]float32
 
+func (s GeomSphere) Radius() float32 {
+	return s[0]
+}
+
+func (s GeomSphere) Center() [3]float32 {
+	return [3]float32{s[1], s[2], s[3]}
+}
+
 type GeomFeatureTableView struct {
 	Boxs             []GeomBox
 	BoxBatchId       interface{}
@@ -96,103 +144,183 @@ type GeomFeatureTableView struct {
 	RtcCenter        [3]float64
 }
 
-func GeomFeatureTableDecode(header map[string]interface{}, buff []byte) map[string]interface{} {
+func GeomFeatureTableDecode(header map[string]interface{}, buff []byte) (map[string]interface{}, error) {
 	ret := make(map[string]interface{})
-	boxsLength := getIntegerScalarFeatureValue(header, buff, GEOM_PROP_BOXES_LENGTH)
-	cylindersLength := getIntegerScalarFeatureValue(header, buff, GEOM_PROP_CYLINDERS_LENGTH)
-	ellipsoidsLength := getIntegerScalarFeatureValue(header, buff, GEOM_PROP_ELLIPSOIDS_LENGTH)
-	spheresLength := getIntegerScalarFeatureValue(header, buff, GEOM_PROP_SPHERES_LENGTH)
+	boxsLength, err := getCountFeatureValue(header, GEOM_PROP_BOXES_LENGTH, len(buff))
+	if err != nil {
+		return nil, err
+	}
+	cylindersLength, err := getCountFeatureValue(header, GEOM_PROP_CYLINDERS_LENGTH, len(buff))
+	if err != nil {
+		return nil, err
+	}
+	ellipsoidsLength, err := getCountFeatureValue(header, GEOM_PROP_ELLIPSOIDS_LENGTH, len(buff))
+	if err != nil {
+		return nil, err
+	}
+	spheresLength, err := getCountFeatureValue(header, GEOM_PROP_SPHERES_LENGTH, len(buff))
+	if err != nil {
+		return nil, err
+	}
+	ret[GEOM_PROP_BOXES_LENGTH] = boxsLength
+	ret[GEOM_PROP_CYLINDERS_LENGTH] = cylindersLength
+	ret[GEOM_PROP_ELLIPSOIDS_LENGTH] = ellipsoidsLength
+	ret[GEOM_PROP_SPHERES_LENGTH] = spheresLength
 
-	ret[GEOM_PROP_RTC_CENTER] = getFloatVec3FeatureValue(header, buff, GEOM_PROP_RTC_CENTER)
+	if _, ok := header[GEOM_PROP_RTC_CENTER]; ok {
+		if ret[GEOM_PROP_RTC_CENTER], err = getFloat64Vec3FeatureValue(header, buff, GEOM_PROP_RTC_CENTER); err != nil {
+			return nil, err
+		}
+	}
 
 	if boxsLength > 0 {
-		ret[GEOM_PROP_BOX_BATCH_IDS] = getUnsignedShortBatchIDs(header, buff, GEOM_PROP_BOX_BATCH_IDS, int(boxsLength))
-		box := getFloatArrayFeatureValue(header, buff, GEOM_PROP_BOXES, int(boxsLength*6))
-		ret[GEOM_PROP_BOXES] = box
+		if ret[GEOM_PROP_BOX_BATCH_IDS], err = getUnsignedShortBatchIDs(header, buff, GEOM_PROP_BOX_BATCH_IDS, int(boxsLength)); err != nil {
+			return nil, err
+		}
+		ref := getBinaryBodyReference(header, GEOM_PROP_BOXES)
+		if ref == nil {
+			return nil, newTileError("", GEOM_PROP_BOXES, -1, ErrBadValue)
+		}
+		if ret[GEOM_PROP_BOXES], err = readBinaryArray[GeomBox](buff, *ref, GEOM_PROP_BOXES, int(boxsLength), 19*4); err != nil {
+			return nil, err
+		}
 	}
 
 	if cylindersLength > 0 {
-		ret[GEOM_PROP_CYLINDER_BATCH_IDS] = getUnsignedShortBatchIDs(header, buff, GEOM_PROP_CYLINDER_BATCH_IDS, int(cylindersLength))
-		cylinder := getFloatArrayFeatureValue(header, buff, GEOM_PROP_CYLINDERS, int(cylindersLength*6))
-		ret[GEOM_PROP_CYLINDERS] = cylinder
+		if ret[GEOM_PROP_CYLINDER_BATCH_IDS], err = getUnsignedShortBatchIDs(header, buff, GEOM_PROP_CYLINDER_BATCH_IDS, int(cylindersLength)); err != nil {
+			return nil, err
+		}
+		ref := getBinaryBodyReference(header, GEOM_PROP_CYLINDERS)
+		if ref == nil {
+			return nil, newTileError("", GEOM_PROP_CYLINDERS, -1, ErrBadValue)
+		}
+		if ret[GEOM_PROP_CYLINDERS], err = readBinaryArray[GeomCylinder](buff, *ref, GEOM_PROP_CYLINDERS, int(cylindersLength), 18*4); err != nil {
+			return nil, err
+		}
 	}
 
 	if ellipsoidsLength > 0 {
-		ret[GEOM_PROP_ELLIPSOID_BATCH_IDS] = getUnsignedShortBatchIDs(header, buff, GEOM_PROP_ELLIPSOID_BATCH_IDS, int(ellipsoidsLength))
-		ellipsoid := getFloatArrayFeatureValue(header, buff, GEOM_PROP_ELLIPSOIDS, int(ellipsoidsLength*6))
-		ret[GEOM_PROP_ELLIPSOIDS] = ellipsoid
+		if ret[GEOM_PROP_ELLIPSOID_BATCH_IDS], err = getUnsignedShortBatchIDs(header, buff, GEOM_PROP_ELLIPSOID_BATCH_IDS, int(ellipsoidsLength)); err != nil {
+			return nil, err
+		}
+		ref := getBinaryBodyReference(header, GEOM_PROP_ELLIPSOIDS)
+		if ref == nil {
+			return nil, newTileError("", GEOM_PROP_ELLIPSOIDS, -1, ErrBadValue)
+		}
+		if ret[GEOM_PROP_ELLIPSOIDS], err = readBinaryArray[GeomEllipsoid](buff, *ref, GEOM_PROP_ELLIPSOIDS, int(ellipsoidsLength), 19*4); err != nil {
+			return nil, err
+		}
 	}
 
 	if spheresLength > 0 {
-		ret[GEOM_PROP_SPHERE_BATCH_IDS] = getUnsignedShortBatchIDs(header, buff, GEOM_PROP_SPHERE_BATCH_IDS, int(spheresLength))
-		sphere := getFloatArrayFeatureValue(header, buff, GEOM_PROP_SPHERES, int(spheresLength*4))
-		ret[GEOM_PROP_SPHERES] = sphere
+		if ret[GEOM_PROP_SPHERE_BATCH_IDS], err = getUnsignedShortBatchIDs(header, buff, GEOM_PROP_SPHERE_BATCH_IDS, int(spheresLength)); err != nil {
+			return nil, err
+		}
+		ref := getBinaryBodyReference(header, GEOM_PROP_SPHERES)
+		if ref == nil {
+			return nil, newTileError("", GEOM_PROP_SPHERES, -1, ErrBadValue)
+		}
+		if ret[GEOM_PROP_SPHERES], err = readBinaryArray[GeomSphere](buff, *ref, GEOM_PROP_SPHERES, int(spheresLength), 4*4); err != nil {
+			return nil, err
+		}
 	}
-	return ret
+	for _, k := range []string{GEOM_PROP_BOX_BATCH_IDS, GEOM_PROP_CYLINDER_BATCH_IDS, GEOM_PROP_ELLIPSOID_BATCH_IDS, GEOM_PROP_SPHERE_BATCH_IDS} {
+		if ids, ok := ret[k].([]uint16); ok && ids == nil {
+			delete(ret, k)
+		}
+	}
+	return ret, nil
 }
 
-func GeomFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) []byte {
+func GeomFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) ([]byte, error) {
 	var out []byte
 	buf := bytes.NewBuffer(out)
 	offset := 0
 
 	if t := data[GEOM_PROP_BOX_BATCH_IDS]; t != nil {
-		dt := t.([]uint16)
+		dt, ok := t.([]uint16)
+		if !ok {
+			return nil, newTileError("", GEOM_PROP_BOX_BATCH_IDS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[GEOM_PROP_BOX_BATCH_IDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 2)
 	}
 
-	if t := data[GEOM_PROP_BOXES]; t != nil {
-		dt := t.([]float32)
-		binary.Write(buf, littleEndian, dt)
-		header[GEOM_PROP_BOXES] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
-		offset += (len(dt) * 4)
-	}
-
 	if t := data[GEOM_PROP_CYLINDER_BATCH_IDS]; t != nil {
-		dt := t.([]uint16)
+		dt, ok := t.([]uint16)
+		if !ok {
+			return nil, newTileError("", GEOM_PROP_CYLINDER_BATCH_IDS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[GEOM_PROP_CYLINDER_BATCH_IDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 2)
 	}
 
-	if t := data[GEOM_PROP_CYLINDERS]; t != nil {
-		dt := t.([]float32)
+	if t := data[GEOM_PROP_ELLIPSOID_BATCH_IDS]; t != nil {
+		dt, ok := t.([]uint16)
+		if !ok {
+			return nil, newTileError("", GEOM_PROP_ELLIPSOID_BATCH_IDS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
-		header[GEOM_PROP_CYLINDERS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
-		offset += (len(dt) * 4)
+		header[GEOM_PROP_ELLIPSOID_BATCH_IDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
+		offset += (len(dt) * 2)
 	}
 
-	if t := data[GEOM_PROP_ELLIPSOID_BATCH_IDS]; t != nil {
-		dt := t.([]uint16)
+	if t := data[GEOM_PROP_SPHERE_BATCH_IDS]; t != nil {
+		dt, ok := t.([]uint16)
+		if !ok {
+			return nil, newTileError("", GEOM_PROP_SPHERE_BATCH_IDS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
-		header[GEOM_PROP_ELLIPSOID_BATCH_IDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
+		header[GEOM_PROP_SPHERE_BATCH_IDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 2)
 	}
 
-	if t := data[GEOM_PROP_ELLIPSOIDS]; t != nil {
-		dt := t.([]float32)
+	buf.Write(createPaddingBytes(nil, uint32(offset), 4, 0))
+	offset = buf.Len()
+
+	if t := data[GEOM_PROP_BOXES]; t != nil {
+		dt, ok := t.([]GeomBox)
+		if !ok {
+			return nil, newTileError("", GEOM_PROP_BOXES, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
-		header[GEOM_PROP_ELLIPSOIDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
-		offset += (len(dt) * 4)
+		header[GEOM_PROP_BOXES] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
+		offset += (len(dt) * 19 * 4)
 	}
 
-	if t := data[GEOM_PROP_SPHERE_BATCH_IDS]; t != nil {
-		dt := t.([]uint16)
+	if t := data[GEOM_PROP_CYLINDERS]; t != nil {
+		dt, ok := t.([]GeomCylinder)
+		if !ok {
+			return nil, newTileError("", GEOM_PROP_CYLINDERS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
-		header[GEOM_PROP_SPHERE_BATCH_IDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
-		offset += (len(dt) * 2)
+		header[GEOM_PROP_CYLINDERS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
+		offset += (len(dt) * 18 * 4)
+	}
+
+	if t := data[GEOM_PROP_ELLIPSOIDS]; t != nil {
+		dt, ok := t.([]GeomEllipsoid)
+		if !ok {
+			return nil, newTileError("", GEOM_PROP_ELLIPSOIDS, -1, ErrBadValue)
+		}
+		binary.Write(buf, littleEndian, dt)
+		header[GEOM_PROP_ELLIPSOIDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
+		offset += (len(dt) * 19 * 4)
 	}
 
 	if t := data[GEOM_PROP_SPHERES]; t != nil {
-		dt := t.([]float32)
+		dt, ok := t.([]GeomSphere)
+		if !ok {
+			return nil, newTileError("", GEOM_PROP_SPHERES, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[GEOM_PROP_SPHERES] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
-		offset += (len(dt) * 4)
+		offset += (len(dt) * 4 * 4)
 	}
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 type Geom struct {
@@ -201,6 +329,13 @@ type Geom struct {
 	BatchTable   BatchTable
 }
 
+func NewGeom() *Geom {
+	m := &Geom{}
+	m.FeatureTable.Header = make(map[string]interface{})
+	copy(m.Header.Magic[:], GEOM_MAGIC)
+	return m
+}
+
 func (m *Geom) SetFeatureTable(view GeomFeatureTableView) {
 	if m.FeatureTable.Header == nil {
 		m.FeatureTable.Header = make(map[string]interface{})
@@ -223,11 +358,7 @@ func (m *Geom) SetFeatureTable(view GeomFeatureTableView) {
 
 	if view.Boxs != nil {
 		m.FeatureTable.Header[GEOM_PROP_BOXES] = BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
-		raw := make([]float32, len(view.Boxs)*6)
-		for i := 0; i < len(view.Boxs); i++ {
-			copy(raw[i*6:i*6+6], view.Boxs[i][:])
-		}
-		m.FeatureTable.Data[GEOM_PROP_BOXES] = raw
+		m.FeatureTable.Data[GEOM_PROP_BOXES] = view.Boxs
 	}
 
 	if view.CylinderBatchId != nil {
@@ -237,11 +368,7 @@ func (m *Geom) SetFeatureTable(view GeomFeatureTableView) {
 
 	if view.Cylinders != nil {
 		m.FeatureTable.Header[GEOM_PROP_CYLINDERS] = BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
-		raw := make([]float32, len(view.Cylinders)*6)
-		for i := 0; i < len(view.Cylinders); i++ {
-			copy(raw[i*6:i*6+6], view.Cylinders[i][:])
-		}
-		m.FeatureTable.Data[GEOM_PROP_CYLINDERS] = raw
+		m.FeatureTable.Data[GEOM_PROP_CYLINDERS] = view.Cylinders
 	}
 
 	if view.EllipsoidBatchId != nil {
@@ -251,11 +378,7 @@ func (m *Geom) SetFeatureTable(view GeomFeatureTableView) {
 
 	if view.Ellipsoids != nil {
 		m.FeatureTable.Header[GEOM_PROP_ELLIPSOIDS] = BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
-		raw := make([]float32, len(view.Ellipsoids)*6)
-		for i := 0; i < len(view.Ellipsoids); i++ {
-			copy(raw[i*6:i*6+6], view.Ellipsoids[i][:])
-		}
-		m.FeatureTable.Data[GEOM_PROP_ELLIPSOIDS] = raw
+		m.FeatureTable.Data[GEOM_PROP_ELLIPSOIDS] = view.Ellipsoids
 	}
 
 	if view.SphereBatchId != nil {
@@ -265,90 +388,104 @@ func (m *Geom) SetFeatureTable(view GeomFeatureTableView) {
 
 	if view.Spheres != nil {
 		m.FeatureTable.Header[GEOM_PROP_SPHERES] = BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
-		raw := make([]float32, len(view.Spheres)*6)
-		for i := 0; i < len(view.Spheres); i++ {
-			copy(raw[i*4:i*4+4], view.Spheres[i][:])
-		}
-		m.FeatureTable.Data[GEOM_PROP_SPHERES] = raw
+		m.FeatureTable.Data[GEOM_PROP_SPHERES] = view.Spheres
 	}
 }
 
 func (m *Geom) GetFeatureTableView() GeomFeatureTableView {
 	ret := GeomFeatureTableView{}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_RTC_CENTER]; t != nil {
-		copy(ret.RtcCenter[:], t.([]float64))
+	switch t := m.FeatureTable.Data[GEOM_PROP_RTC_CENTER].(type) {
+	case [3]float64:
+		ret.RtcCenter = t
+	case nil:
+		if m.FeatureTable.Header[GEOM_PROP_RTC_CENTER] != nil {
+			ret.RtcCenter, _ = getFloat64Vec3FeatureValue(m.FeatureTable.Header, nil, GEOM_PROP_RTC_CENTER)
+		}
 	}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_BOXES_LENGTH]; t != nil {
-		ret.Boxs = make([]GeomBox, t.(uint32))
+	if t, ok := m.FeatureTable.Data[GEOM_PROP_BOXES].([]GeomBox); ok {
+		ret.Boxs = t
 	}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_BOXES]; t != nil {
-		boxarr := t.([]float32)
-		for i := 0; i < len(ret.Boxs); i++ {
-			copy(ret.Boxs[i][:], boxarr[i*6:i*6+6])
-		}
+	if t, ok := m.FeatureTable.Data[GEOM_PROP_BOX_BATCH_IDS].([]uint16); ok {
+		ret.BoxBatchId = t
 	}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_BOX_BATCH_IDS]; t != nil {
-		ret.BoxBatchId = t.([]uint16)
+	if t, ok := m.FeatureTable.Data[GEOM_PROP_CYLINDERS].([]GeomCylinder); ok {
+		ret.Cylinders = t
 	}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_CYLINDERS_LENGTH]; t != nil {
-		ret.Cylinders = make([]GeomCylinder, t.(uint32))
+	if t, ok := m.FeatureTable.Data[GEOM_PROP_CYLINDER_BATCH_IDS].([]uint16); ok {
+		ret.CylinderBatchId = t
 	}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_CYLINDERS]; t != nil {
-		cylinderarr := t.([]float32)
-		for i := 0; i < len(ret.Cylinders); i++ {
-			copy(ret.Cylinders[i][:], cylinderarr[i*6:i*6+6])
-		}
+	if t, ok := m.FeatureTable.Data[GEOM_PROP_ELLIPSOIDS].([]GeomEllipsoid); ok {
+		ret.Ellipsoids = t
 	}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_CYLINDER_BATCH_IDS]; t != nil {
-		ret.CylinderBatchId = t.([]uint16)
+	if t, ok := m.FeatureTable.Data[GEOM_PROP_ELLIPSOID_BATCH_IDS].([]uint16); ok {
+		ret.EllipsoidBatchId = t
 	}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_ELLIPSOIDS_LENGTH]; t != nil {
-		ret.Ellipsoids = make([]GeomEllipsoid, t.(uint32))
+	if t, ok := m.FeatureTable.Data[GEOM_PROP_SPHERES].([]GeomSphere); ok {
+		ret.Spheres = t
 	}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_ELLIPSOIDS]; t != nil {
-		ellipsoidarr := t.([]float32)
-		for i := 0; i < len(ret.Ellipsoids); i++ {
-			copy(ret.Ellipsoids[i][:], ellipsoidarr[i*6:i*6+6])
-		}
+	if t, ok := m.FeatureTable.Data[GEOM_PROP_SPHERE_BATCH_IDS].([]uint16); ok {
+		ret.SphereBatchId = t
 	}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_ELLIPSOID_BATCH_IDS]; t != nil {
-		ret.EllipsoidBatchId = t.([]uint16)
-	}
+	return ret
+}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_SPHERES_LENGTH]; t != nil {
-		ret.Spheres = make([]GeomSphere, t.(uint32))
-	}
+// BatchLength returns the number of rows in the batch table, one per
+// primitive.
+func (m *Geom) BatchLength() int {
+	view := m.GetFeatureTableView()
+	return len(view.Boxs) + len(view.Cylinders) + len(view.Ellipsoids) + len(view.Spheres)
+}
 
-	if t := m.FeatureTable.Data[GEOM_PROP_SPHERES]; t != nil {
-		spherearr := t.([]float32)
-		for i := 0; i < len(ret.Spheres); i++ {
-			copy(ret.Spheres[i][:], spherearr[i*4:i*4+4])
+// BatchIds returns the batch ids of boxes, cylinders, ellipsoids and
+// spheres. Primitives without batch ids are numbered in that order.
+func (m *Geom) BatchIds() (boxes, cylinders, ellipsoids, spheres []uint16, err error) {
+	view := m.GetFeatureTableView()
+	next := 0
+	ids := func(propName string, explicit interface{}, n int) ([]uint16, error) {
+		defer func() { next += n }()
+		if explicit != nil {
+			t, ok := explicit.([]uint16)
+			if !ok || len(t) < n {
+				return nil, newTileError(GEOM_MAGIC, propName, -1, ErrBadValue)
+			}
+			return t[:n], nil
 		}
+		ret := make([]uint16, n)
+		for i := range ret {
+			ret[i] = uint16(next + i)
+		}
+		return ret, nil
 	}
-
-	if t := m.FeatureTable.Data[GEOM_PROP_SPHERE_BATCH_IDS]; t != nil {
-		ret.SphereBatchId = t.([]uint16)
+	if boxes, err = ids(GEOM_PROP_BOX_BATCH_IDS, view.BoxBatchId, len(view.Boxs)); err != nil {
+		return
 	}
-
-	return ret
+	if cylinders, err = ids(GEOM_PROP_CYLINDER_BATCH_IDS, view.CylinderBatchId, len(view.Cylinders)); err != nil {
+		return
+	}
+	if ellipsoids, err = ids(GEOM_PROP_ELLIPSOID_BATCH_IDS, view.EllipsoidBatchId, len(view.Ellipsoids)); err != nil {
+		return
+	}
+	spheres, err = ids(GEOM_PROP_SPHERE_BATCH_IDS, view.SphereBatchId, len(view.Spheres))
+	return
 }
 
 func (m *Geom) GetHeader() Header {
 	return &m.Header
 }
 
-func (m *Geom) CalcSize() int64 {
-	return m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader())
+func (m *Geom) CalcSize() (int64, error) {
+	m.FeatureTable.encode = GeomFeatureTableEncode
+	return m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader()), nil
 }
 
 func (m *Geom) GetFeatureTable() *FeatureTable {
@@ -360,27 +497,47 @@ func (m *Geom) GetBatchTable() *BatchTable {
 }
 
 func (m *Geom) Read(reader io.ReadSeeker) error {
+	start, _ := reader.Seek(0, io.SeekCurrent)
 	err := binary.Read(reader, littleEndian, &m.Header)
 	if err != nil {
+		return newTileError(GEOM_MAGIC, "header", start, err)
+	}
+	if err := checkMagic(GEOM_MAGIC, start, m.Header.Magic); err != nil {
+		return err
+	}
+	if err := checkVersion(GEOM_MAGIC, start, m.Header.Version); err != nil {
+		return err
+	}
+	if err := checkByteLength(reader, GEOM_MAGIC, start, m.GetHeader()); err != nil {
 		return err
 	}
 
-	m.FeatureTable.decode = PntsFeatureTableDecode
+	m.FeatureTable.decode = GeomFeatureTableDecode
 
 	if err := m.FeatureTable.Read(reader, m.GetHeader()); err != nil {
-		return err
+		return wrapTileError(GEOM_MAGIC, "featureTable", 0, err)
 	}
 
-	if err := m.BatchTable.Read(reader, m.GetHeader(), m.FeatureTable.GetBatchLength()); err != nil {
-		return err
+	if err := m.BatchTable.Read(reader, m.GetHeader(), m.BatchLength()); err != nil {
+		return wrapTileError(GEOM_MAGIC, "batchTable", 0, err)
 	}
 
 	return nil
 }
 
+// ReadFromBytes reads a tile from data. Binary arrays are views over data
+// where possible, so data must not be modified while m is in use.
+func (m *Geom) ReadFromBytes(data []byte) error {
+	return m.Read(newSliceReader(data))
+}
+
 func (m *Geom) Write(writer io.Writer) error {
+	copy(m.Header.Magic[:], GEOM_MAGIC)
+	m.Header.Version = 1
 	m.FeatureTable.encode = GeomFeatureTableEncode
-	_ = GeomFeatureTableEncode(m.FeatureTable.Header, m.FeatureTable.Data)
+	if _, err := GeomFeatureTableEncode(m.FeatureTable.Header, m.FeatureTable.Data); err != nil {
+		return wrapTileError(GEOM_MAGIC, "featureTable", -1, err)
+	}
 	si := m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader())
 
 	m.Header.ByteLength = uint32(si)
@@ -391,12 +548,12 @@ func (m *Geom) Write(writer io.Writer) error {
 		return err
 	}
 
-	if err := m.FeatureTable.Write(writer, nil); err != nil {
-		return err
+	if err := m.FeatureTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(GEOM_MAGIC, "featureTable", -1, err)
 	}
 
-	if err := m.BatchTable.Write(writer, nil); err != nil {
-		return err
+	if err := m.BatchTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(GEOM_MAGIC, "batchTable", -1, err)
 	}
 
 	return nil
@@ -0,0 +1,321 @@
+package tile3d
+
+import (
+	"math"
+
+	"github.com/flywave/gltf"
+	"github.com/flywave/gltf/modeler"
+)
+
+const (
+	GLTF_ATTR_BATCHID = "_BATCHID"
+)
+
+// GeomTessellation sets the number of segments geom primitives are
+// tessellated with.
+type GeomTessellation struct {
+	// Slices is the number of segments around cylinders, ellipsoids and
+	// spheres.
+	Slices int
+	// Stacks is the number of segments from pole to pole of ellipsoids
+	// and spheres.
+	Stacks int
+}
+
+func NewGeomTessellation() *GeomTessellation {
+	return &GeomTessellation{Slices: 32, Stacks: 16}
+}
+
+type batchedMesh struct {
+	positions [][3]float32
+	normals   [][3]float32
+	texcoords [][2]float32
+	colors    [][4]uint8
+	batchIds  []float32
+	indices   []uint32
+}
+
+// add appends a unit shape placed by the column major matrix m.
+func (g *batchedMesh) add(shape *batchedMesh, m [16]float64, batchId uint16) {
+	base, det := g.addVertices(shape, m, float32(batchId))
+	for i := 0; i < len(shape.indices); i += 3 {
+		if det < 0 {
+			g.indices = append(g.indices, base+shape.indices[i], base+shape.indices[i+2], base+shape.indices[i+1])
+		} else {
+			g.indices = append(g.indices, base+shape.indices[i], base+shape.indices[i+1], base+shape.indices[i+2])
+		}
+	}
+}
+
+// addVertices appends the vertices of shape placed by the column major
+// matrix m. It returns the index of the first vertex and the determinant
+// of m, which is negative when the winding of triangles must be flipped.
+func (g *batchedMesh) addVertices(shape *batchedMesh, m [16]float64, batchId float32) (uint32, float64) {
+	// Normals are transformed by the cofactor matrix, which is the inverse
+	// transpose scaled by the determinant.
+	var cof [9]float64
+	a := func(r, c int) float64 { return m[c*4+r] }
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			r1, r2 := (r+1)%3, (r+2)%3
+			c1, c2 := (c+1)%3, (c+2)%3
+			cof[r*3+c] = a(r1, c1)*a(r2, c2) - a(r1, c2)*a(r2, c1)
+		}
+	}
+	det := a(0, 0)*cof[0] + a(0, 1)*cof[1] + a(0, 2)*cof[2]
+
+	base := uint32(len(g.positions))
+	for i, p := range shape.positions {
+		var v [3]float64
+		for r := 0; r < 3; r++ {
+			v[r] = a(r, 0)*float64(p[0]) + a(r, 1)*float64(p[1]) + a(r, 2)*float64(p[2]) + a(r, 3)
+		}
+		g.positions = append(g.positions, [3]float32{float32(v[0]), float32(v[1]), float32(v[2])})
+		g.batchIds = append(g.batchIds, batchId)
+		if len(shape.normals) == 0 {
+			continue
+		}
+		var n [3]float64
+		sn := shape.normals[i]
+		for r := 0; r < 3; r++ {
+			n[r] = cof[r*3]*float64(sn[0]) + cof[r*3+1]*float64(sn[1]) + cof[r*3+2]*float64(sn[2])
+		}
+		n = normalize64(n)
+		if det < 0 {
+			n = [3]float64{-n[0], -n[1], -n[2]}
+		}
+		g.normals = append(g.normals, [3]float32{float32(n[0]), float32(n[1]), float32(n[2])})
+	}
+	g.texcoords = append(g.texcoords, shape.texcoords...)
+	g.colors = append(g.colors, shape.colors...)
+	return base, det
+}
+
+// toYUp converts positions and normals from the z-up axis of 3D Tiles to
+// the y-up axis of glTF.
+func (g *batchedMesh) toYUp() {
+	for i, p := range g.positions {
+		g.positions[i] = [3]float32{p[0], p[2], -p[1]}
+	}
+	for i, n := range g.normals {
+		g.normals[i] = [3]float32{n[0], n[2], -n[1]}
+	}
+}
+
+// primitive writes g to doc as a primitive with a _BATCHID attribute and
+// the first material of doc.
+func (g *batchedMesh) primitive(doc *gltf.Document, mode gltf.PrimitiveMode) *gltf.Primitive {
+	attrs := gltf.Attribute{
+		gltf.POSITION:     modeler.WritePosition(doc, g.positions),
+		GLTF_ATTR_BATCHID: modeler.WriteAccessor(doc, gltf.TargetArrayBuffer, g.batchIds),
+	}
+	if len(g.normals) > 0 {
+		attrs[gltf.NORMAL] = modeler.WriteNormal(doc, g.normals)
+	}
+	if len(g.texcoords) > 0 {
+		attrs[gltf.TEXCOORD_0] = modeler.WriteTextureCoord(doc, g.texcoords)
+	}
+	if len(g.colors) > 0 {
+		attrs[gltf.COLOR_0] = modeler.WriteColor(doc, g.colors)
+	}
+	prim := &gltf.Primitive{Attributes: attrs, Mode: mode, Material: gltf.Index(0)}
+	if g.indices != nil {
+		var indices interface{} = g.indices
+		if len(g.positions) <= math.MaxUint16 {
+			short := make([]uint16, len(g.indices))
+			for i, v := range g.indices {
+				short[i] = uint16(v)
+			}
+			indices = short
+		}
+		prim.Indices = gltf.Index(modeler.WriteIndices(doc, indices))
+	}
+	return prim
+}
+
+// setBatchedMesh adds a mesh of prims with a default material and a node
+// referencing it to the scene of doc.
+func setBatchedMesh(doc *gltf.Document, prims ...*gltf.Primitive) {
+	doc.Meshes = []*gltf.Mesh{{Primitives: prims}}
+	doc.Materials = []*gltf.Material{{
+		PBRMetallicRoughness: &gltf.PBRMetallicRoughness{BaseColorFactor: &[4]float32{1, 1, 1, 1}},
+	}}
+	doc.Nodes = []*gltf.Node{{Mesh: gltf.Index(0)}}
+	doc.Scenes[0].Nodes = []uint32{0}
+}
+
+// newGeomUnitBox returns a cube of side 1 centered at the origin.
+func newGeomUnitBox() *batchedMesh {
+	g := &batchedMesh{}
+	for axis := 0; axis < 3; axis++ {
+		for _, sign := range []float32{1, -1} {
+			var n [3]float32
+			n[axis] = sign
+			u, v := (axis+1)%3, (axis+2)%3
+			base := uint32(len(g.positions))
+			for _, c := range [][2]float32{{-0.5, -0.5}, {0.5, -0.5}, {0.5, 0.5}, {-0.5, 0.5}} {
+				var p [3]float32
+				p[axis] = sign * 0.5
+				p[u], p[v] = c[0], c[1]
+				g.positions = append(g.positions, p)
+				g.normals = append(g.normals, n)
+			}
+			if sign > 0 {
+				g.indices = append(g.indices, base, base+1, base+2, base, base+2, base+3)
+			} else {
+				g.indices = append(g.indices, base, base+2, base+1, base, base+3, base+2)
+			}
+		}
+	}
+	return g
+}
+
+// newGeomUnitCylinder returns a cylinder of radius 1 and length 1 along
+// the z axis, centered at the origin.
+func newGeomUnitCylinder(slices int) *batchedMesh {
+	g := &batchedMesh{}
+	for i := 0; i <= slices; i++ {
+		a := 2 * math.Pi * float64(i) / float64(slices)
+		x, y := float32(math.Cos(a)), float32(math.Sin(a))
+		g.positions = append(g.positions, [3]float32{x, y, -0.5}, [3]float32{x, y, 0.5})
+		g.normals = append(g.normals, [3]float32{x, y, 0}, [3]float32{x, y, 0})
+	}
+	for i := 0; i < slices; i++ {
+		b := uint32(i * 2)
+		g.indices = append(g.indices, b, b+2, b+3, b, b+3, b+1)
+	}
+	for _, z := range []float32{-0.5, 0.5} {
+		n := [3]float32{0, 0, z * 2}
+		center := uint32(len(g.positions))
+		g.positions = append(g.positions, [3]float32{0, 0, z})
+		g.normals = append(g.normals, n)
+		for i := 0; i < slices; i++ {
+			a := 2 * math.Pi * float64(i) / float64(slices)
+			g.positions = append(g.positions, [3]float32{float32(math.Cos(a)), float32(math.Sin(a)), z})
+			g.normals = append(g.normals, n)
+		}
+		for i := 0; i < slices; i++ {
+			p0, p1 := center+1+uint32(i), center+1+uint32((i+1)%slices)
+			if z > 0 {
+				g.indices = append(g.indices, center, p0, p1)
+			} else {
+				g.indices = append(g.indices, center, p1, p0)
+			}
+		}
+	}
+	return g
+}
+
+// newGeomUnitSphere returns a sphere of radius 1 centered at the origin.
+func newGeomUnitSphere(slices, stacks int) *batchedMesh {
+	g := &batchedMesh{}
+	for j := 0; j <= stacks; j++ {
+		phi := math.Pi * float64(j) / float64(stacks)
+		for i := 0; i <= slices; i++ {
+			theta := 2 * math.Pi * float64(i) / float64(slices)
+			p := [3]float32{
+				float32(math.Sin(phi) * math.Cos(theta)),
+				float32(math.Sin(phi) * math.Sin(theta)),
+				float32(math.Cos(phi)),
+			}
+			g.positions = append(g.positions, p)
+			g.normals = append(g.normals, p)
+		}
+	}
+	for j := 0; j < stacks; j++ {
+		for i := 0; i < slices; i++ {
+			a := uint32(j*(slices+1) + i)
+			b := a + uint32(slices+1)
+			if j > 0 {
+				g.indices = append(g.indices, a, b, a+1)
+			}
+			if j < stacks-1 {
+				g.indices = append(g.indices, a+1, b, b+1)
+			}
+		}
+	}
+	return g
+}
+
+func geomScaledMatrix(m [16]float32, s [3]float32) [16]float64 {
+	var ret [16]float64
+	for c := 0; c < 4; c++ {
+		scale := 1.0
+		if c < 3 {
+			scale = float64(s[c])
+		}
+		for r := 0; r < 4; r++ {
+			ret[c*4+r] = float64(m[c*4+r]) * scale
+		}
+	}
+	return ret
+}
+
+// ToGltf tessellates the primitives of m into a glTF mesh with a
+// _BATCHID attribute. Positions are relative to RTC_CENTER and converted
+// to the y-up axis of glTF.
+func (m *Geom) ToGltf(opts *GeomTessellation) (*gltf.Document, error) {
+	if opts == nil {
+		opts = NewGeomTessellation()
+	}
+	if opts.Slices < 3 || opts.Stacks < 2 {
+		return nil, newTileError(GEOM_MAGIC, "tessellation", -1, ErrBadValue)
+	}
+	view := m.GetFeatureTableView()
+	boxIds, cylinderIds, ellipsoidIds, sphereIds, err := m.BatchIds()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &batchedMesh{}
+	if len(view.Boxs) > 0 {
+		unit := newGeomUnitBox()
+		for i, b := range view.Boxs {
+			g.add(unit, geomScaledMatrix(b.ModelMatrix(), b.Dimensions()), boxIds[i])
+		}
+	}
+	if len(view.Cylinders) > 0 {
+		unit := newGeomUnitCylinder(opts.Slices)
+		for i, c := range view.Cylinders {
+			g.add(unit, geomScaledMatrix(c.ModelMatrix(), [3]float32{c.Radius(), c.Radius(), c.Length()}), cylinderIds[i])
+		}
+	}
+	if len(view.Ellipsoids) > 0 || len(view.Spheres) > 0 {
+		unit := newGeomUnitSphere(opts.Slices, opts.Stacks)
+		for i, e := range view.Ellipsoids {
+			g.add(unit, geomScaledMatrix(e.ModelMatrix(), e.Radii()), ellipsoidIds[i])
+		}
+		for i, s := range view.Spheres {
+			c, r := s.Center(), s.Radius()
+			translation := [16]float32{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, c[0], c[1], c[2], 1}
+			g.add(unit, geomScaledMatrix(translation, [3]float32{r, r, r}), sphereIds[i])
+		}
+	}
+
+	g.toYUp()
+
+	doc := gltf.NewDocument()
+	if len(g.positions) == 0 {
+		return doc, nil
+	}
+	setBatchedMesh(doc, g.primitive(doc, gltf.PrimitiveTriangles))
+	return doc, nil
+}
+
+// ToB3dm tessellates m into a b3dm with the same batch table.
+func (m *Geom) ToB3dm(opts *GeomTessellation) (*B3dm, error) {
+	doc, err := m.ToGltf(opts)
+	if err != nil {
+		return nil, err
+	}
+	b := NewB3dm()
+	view := B3dmFeatureTableView{BatchLength: m.BatchLength()}
+	rtc := m.GetFeatureTableView().RtcCenter
+	if rtc != [3]float64{} {
+		view.RtcCenter = rtc[:]
+	}
+	b.SetFeatureTable(view)
+	b.BatchTable = m.BatchTable
+	b.Model = doc
+	return b, nil
+}
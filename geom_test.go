@@ -0,0 +1,96 @@
+package tile3d
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGeomRoundTrip(t *testing.T) {
+	identity := [16]float32{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+	var box GeomBox
+	box[0], box[1], box[2] = 2, 3, 4
+	copy(box[3:], identity[:])
+	var cylinder GeomCylinder
+	cylinder[0], cylinder[1] = 1, 5
+	copy(cylinder[2:], identity[:])
+	var ellipsoid GeomEllipsoid
+	ellipsoid[0], ellipsoid[1], ellipsoid[2] = 1, 2, 3
+	copy(ellipsoid[3:], identity[:])
+	ellipsoid[15] = 10
+
+	m := NewGeom()
+	m.SetFeatureTable(GeomFeatureTableView{
+		Boxs:       []GeomBox{box},
+		Cylinders:  []GeomCylinder{cylinder},
+		Ellipsoids: []GeomEllipsoid{ellipsoid},
+		Spheres:    []GeomSphere{{2, 1, 1, 1}, {1, -1, -1, -1}},
+		RtcCenter:  [3]float64{100, 200, 300},
+	})
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Geom{}
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	view := r.GetFeatureTableView()
+	if len(view.Boxs) != 1 || view.Boxs[0].Dimensions() != [3]float32{2, 3, 4} {
+		t.Errorf("boxes %v", view.Boxs)
+	}
+	if len(view.Cylinders) != 1 || view.Cylinders[0].Length() != 5 {
+		t.Errorf("cylinders %v", view.Cylinders)
+	}
+	if len(view.Ellipsoids) != 1 || view.Ellipsoids[0].ModelMatrix()[12] != 10 {
+		t.Errorf("ellipsoids %v", view.Ellipsoids)
+	}
+	if len(view.Spheres) != 2 || view.Spheres[1].Center() != [3]float32{-1, -1, -1} {
+		t.Errorf("spheres %v", view.Spheres)
+	}
+	if view.RtcCenter != [3]float64{100, 200, 300} {
+		t.Errorf("rtc %v", view.RtcCenter)
+	}
+	if r.BatchLength() != 5 {
+		t.Errorf("batch length %d", r.BatchLength())
+	}
+	_, _, _, spheres, err := r.BatchIds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spheres) != 2 || spheres[0] != 3 || spheres[1] != 4 {
+		t.Errorf("sphere batch ids %v", spheres)
+	}
+
+	opts := &GeomTessellation{Slices: 8, Stacks: 4}
+	doc, err := r.ToGltf(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prim := doc.Meshes[0].Primitives[0]
+	batchIds, ok := prim.Attributes[GLTF_ATTR_BATCHID]
+	if !ok {
+		t.Fatal("missing _BATCHID")
+	}
+	cylinderVerts := (8+1)*2 + 2*(8+1)
+	sphereVerts := (4 + 1) * (8 + 1)
+	want := 24 + cylinderVerts + 3*sphereVerts
+	if n := doc.Accessors[prim.Attributes["POSITION"]].Count; int(n) != want {
+		t.Errorf("vertices %d, want %d", n, want)
+	}
+	if n := doc.Accessors[batchIds].Count; int(n) != want {
+		t.Errorf("batch ids %d, want %d", n, want)
+	}
+
+	if _, err := r.ToGltf(&GeomTessellation{Slices: 2, Stacks: 4}); err == nil {
+		t.Error("expected error for too few slices")
+	}
+
+	b3dm, err := r.ToB3dm(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b3dm.GetFeatureTableView().BatchLength != 5 {
+		t.Errorf("b3dm batch length %d", b3dm.GetFeatureTableView().BatchLength)
+	}
+}
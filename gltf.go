@@ -15,8 +15,34 @@ func openGltf(path string) *gltf.Document {
 	return doc
 }
 
-func loadGltfFromByte(reader io.Reader) (*gltf.Document, error) {
-	dec := gltf.NewDecoder(reader)
+// checkGlb walks the chunk headers of a glb so a bad chunk length is
+// reported before the decoder allocates a buffer for it.
+func checkGlb(data []byte) error {
+	if len(data) < 12 || string(data[:4]) != "glTF" {
+		return nil
+	}
+	if littleEndian.Uint32(data[4:]) != 2 {
+		return ErrUnsupportedVersion
+	}
+	length := uint64(littleEndian.Uint32(data[8:]))
+	if length > uint64(len(data)) {
+		return ErrTruncated
+	}
+	for offset := uint64(12); offset+8 <= length; {
+		chunkLength := uint64(littleEndian.Uint32(data[offset:]))
+		offset += 8 + chunkLength
+		if offset > length {
+			return ErrTruncated
+		}
+	}
+	return nil
+}
+
+func loadGltfFromByte(data []byte) (*gltf.Document, error) {
+	if err := checkGlb(data); err != nil {
+		return nil, err
+	}
+	dec := gltf.NewDecoder(bytes.NewReader(data))
 	doc := new(gltf.Document)
 	if err := dec.Decode(doc); err != nil {
 		return nil, err
@@ -29,6 +55,9 @@ func saveGltf(path string, doc *gltf.Document) error {
 }
 
 func writeGltfBinary(writer io.Writer, doc *gltf.Document) error {
+	if doc == nil {
+		return ErrMissingModel
+	}
 	enc := gltf.NewEncoder(writer)
 	enc.AsBinary = true
 	if err := enc.Encode(doc); err != nil {
@@ -62,19 +91,20 @@ func (w *calcSizeWriter) GetSize() int {
 	return len(w.Bytes())
 }
 
-func calcGltfSize(doc *gltf.Document, paddingUnit uint32) int64 {
-	w := newSizeWriter()
-	enc := gltf.NewEncoder(w.writer)
-	enc.AsBinary = true
-	if err := enc.Encode(doc); err != nil {
-		return 0
+func calcGltfSize(doc *gltf.Document, paddingUnit uint32) (int64, error) {
+	buf, err := getGltfBinary(doc, paddingUnit)
+	if err != nil {
+		return 0, err
 	}
-	return int64(calcPadding(w.Size, paddingUnit))
+	return int64(len(buf)), nil
 }
 
 func getGltfBinary(doc *gltf.Document, paddingUnit uint32) ([]byte, error) {
+	if doc == nil {
+		return nil, ErrMissingModel
+	}
 	w := newSizeWriter()
-	enc := gltf.NewEncoder(w.writer)
+	enc := gltf.NewEncoder(&w)
 	enc.AsBinary = true
 	if err := enc.Encode(doc); err != nil {
 		return nil, err
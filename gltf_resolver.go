@@ -0,0 +1,163 @@
+package tile3d
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/flywave/gltf"
+)
+
+// GltfResolver loads the glTF referenced by an i3dm stored with gltfFormat
+// 0. tilePath is the location of the tile, uri is relative to it.
+type GltfResolver interface {
+	Resolve(tilePath string, uri string) (*gltf.Document, error)
+}
+
+// FileGltfResolver loads glTF and glb files from the local file system.
+// Models are cached by path, so tiles sharing a model get the same
+// document back and must not modify it. Uris must stay inside Root, or
+// inside the directory of the tile when Root is empty, so a tile cannot
+// read other files.
+type FileGltfResolver struct {
+	Root string
+
+	mu    sync.Mutex
+	cache map[string]*gltfResolverEntry
+}
+
+type gltfResolverEntry struct {
+	mu  sync.Mutex
+	doc *gltf.Document
+}
+
+func NewFileGltfResolver() *FileGltfResolver {
+	return &FileGltfResolver{cache: make(map[string]*gltfResolverEntry)}
+}
+
+func (r *FileGltfResolver) Resolve(tilePath string, uri string) (*gltf.Document, error) {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "" && u.Scheme != "file") {
+		return nil, ErrUnresolvedUri
+	}
+	p := filepath.FromSlash(u.Path)
+	if filepath.IsAbs(p) && r.Root == "" {
+		return nil, ErrUnresolvedUri
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(filepath.Dir(tilePath), p)
+	}
+	p = filepath.Clean(p)
+	root := r.Root
+	if root == "" {
+		root = filepath.Dir(tilePath)
+	}
+	if !pathInside(root, p) {
+		return nil, ErrUnresolvedUri
+	}
+
+	r.mu.Lock()
+	e, ok := r.cache[p]
+	if !ok {
+		e = &gltfResolverEntry{}
+		r.cache[p] = e
+	}
+	r.mu.Unlock()
+
+	// Only tiles loading the same model wait for each other.
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.doc == nil {
+		if e.doc, err = gltf.Open(p); err != nil {
+			return nil, err
+		}
+	}
+	return e.doc, nil
+}
+
+// pathInside reports whether p is dir or a path below it.
+func pathInside(dir, p string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ResolveModel loads Model from GltfUri when the tile references an
+// external glTF. Tiles with an embedded glTF are left untouched.
+func (m *I3dm) ResolveModel(tilePath string, resolver GltfResolver) error {
+	if m.Header.GltfFormat != I3DM_GLTF_URI {
+		return nil
+	}
+	if m.GltfUri == "" {
+		return newTileError(I3DM_MAGIC, "glTF", -1, ErrUnresolvedUri)
+	}
+	doc, err := resolver.Resolve(tilePath, m.GltfUri)
+	if err != nil {
+		return newTileError(I3DM_MAGIC, m.GltfUri, -1, err)
+	}
+	m.Model = doc
+	return nil
+}
+
+// ExternalModelWriter saves the models of i3dm tiles as glb files in Dir
+// and switches the tiles to gltfFormat 0, so a model shared by many tiles
+// is stored once. Models are named by the hash of their glb content.
+type ExternalModelWriter struct {
+	Dir string
+
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func NewExternalModelWriter(dir string) *ExternalModelWriter {
+	return &ExternalModelWriter{Dir: dir, files: make(map[string]string)}
+}
+
+// Externalize writes the model of m unless the same model was written
+// before and points GltfUri at it, relative to tilePath.
+func (w *ExternalModelWriter) Externalize(m *I3dm, tilePath string) error {
+	glb, err := getGltfBinary(m.Model, 8)
+	if err != nil {
+		return newTileError(I3DM_MAGIC, "glTF", -1, err)
+	}
+	sum := sha1.Sum(glb)
+	key := hex.EncodeToString(sum[:])
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p, ok := w.files[key]
+	if !ok {
+		p = filepath.Join(w.Dir, key[:16]+".glb")
+		if err := os.WriteFile(p, glb, 0644); err != nil {
+			return err
+		}
+		w.files[key] = p
+	}
+
+	absTile, err := filepath.Abs(filepath.Dir(tilePath))
+	if err != nil {
+		return err
+	}
+	absModel, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(absTile, absModel)
+	if err != nil {
+		return err
+	}
+	m.Header.GltfFormat = I3DM_GLTF_URI
+	m.GltfUri = filepath.ToSlash(rel)
+	return nil
+}
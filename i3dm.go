@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"strings"
 
 	"github.com/flywave/gltf"
 )
@@ -99,68 +100,112 @@ type I3dmFeatureTableView struct {
 	EastNorthUp           *bool
 }
 
-func I3dmFeatureTableDecode(header map[string]interface{}, buff []byte) map[string]interface{} {
+func I3dmFeatureTableDecode(header map[string]interface{}, buff []byte) (map[string]interface{}, error) {
 	ret := make(map[string]interface{})
-	instanceLength := getIntegerScalarFeatureValue(header, buff, I3DM_PROP_INSTANCES_LENGTH)
+	instanceLength, err := getCountFeatureValue(header, I3DM_PROP_INSTANCES_LENGTH, len(buff))
+	if err != nil {
+		return nil, err
+	}
 	ret[I3DM_PROP_INSTANCES_LENGTH] = instanceLength
-	ret[I3DM_PROP_RTC_CENTER] = getFloat64Vec3FeatureValue(header, buff, I3DM_PROP_RTC_CENTER)
-	floatArrayValue := getFloatVec3ArrayFeatureValue(header, buff, I3DM_PROP_POSITION, int(instanceLength))
+	rtcCenter, err := getFloat64Vec3FeatureValue(header, buff, I3DM_PROP_RTC_CENTER)
+	if err != nil {
+		return nil, err
+	}
+	ret[I3DM_PROP_RTC_CENTER] = rtcCenter
+	floatArrayValue, err := getFloatVec3ArrayFeatureValue(header, buff, I3DM_PROP_POSITION, int(instanceLength))
+	if err != nil {
+		return nil, err
+	}
 	if floatArrayValue != nil {
 		ret[I3DM_PROP_POSITION] = floatArrayValue
 	}
-	unsignedShortArrayValue := getUnsignedShortVec3ArrayFeatureValue(header, buff, I3DM_PROP_POSITION_QUANTIZED, int(instanceLength))
+	unsignedShortArrayValue, err := getUnsignedShortVec3ArrayFeatureValue(header, buff, I3DM_PROP_POSITION_QUANTIZED, int(instanceLength))
+	if err != nil {
+		return nil, err
+	}
 	if unsignedShortArrayValue != nil {
 		ret[I3DM_PROP_POSITION_QUANTIZED] = unsignedShortArrayValue
-		ret[I3DM_PROP_QUANTIZED_VOLUME_OFFSET] = getFloatVec3FeatureValue(header, buff, I3DM_PROP_QUANTIZED_VOLUME_OFFSET)
-		ret[I3DM_PROP_QUANTIZED_VOLUME_SCALE] = getFloatVec3FeatureValue(header, buff, I3DM_PROP_QUANTIZED_VOLUME_SCALE)
+		if ret[I3DM_PROP_QUANTIZED_VOLUME_OFFSET], err = getFloatVec3FeatureValue(header, buff, I3DM_PROP_QUANTIZED_VOLUME_OFFSET); err != nil {
+			return nil, err
+		}
+		if ret[I3DM_PROP_QUANTIZED_VOLUME_SCALE], err = getFloatVec3FeatureValue(header, buff, I3DM_PROP_QUANTIZED_VOLUME_SCALE); err != nil {
+			return nil, err
+		}
 	}
 
-	floatArrayValue = getFloatVec3ArrayFeatureValue(header, buff, I3DM_PROP_NORMAL_UP, int(instanceLength))
+	floatArrayValue, err = getFloatVec3ArrayFeatureValue(header, buff, I3DM_PROP_NORMAL_UP, int(instanceLength))
+	if err != nil {
+		return nil, err
+	}
 	if floatArrayValue != nil {
 		ret[I3DM_PROP_NORMAL_UP] = floatArrayValue
 	}
-	floatArrayValue = getFloatVec3ArrayFeatureValue(header, buff, I3DM_PROP_NORMAL_RIGHT, int(instanceLength))
+	floatArrayValue, err = getFloatVec3ArrayFeatureValue(header, buff, I3DM_PROP_NORMAL_RIGHT, int(instanceLength))
+	if err != nil {
+		return nil, err
+	}
 	if floatArrayValue != nil {
 		ret[I3DM_PROP_NORMAL_RIGHT] = floatArrayValue
 	}
-	unsignedShortArrayValue = getUnsignedShortVec3ArrayFeatureValue(header, buff, I3DM_PROP_NORMAL_UP_OCT32P, int(instanceLength))
-	if unsignedShortArrayValue != nil {
-		ret[I3DM_PROP_NORMAL_UP_OCT32P] = unsignedShortArrayValue
+	octArrayValue, err := getUnsignedShortVec2ArrayFeatureValue(header, buff, I3DM_PROP_NORMAL_UP_OCT32P, int(instanceLength))
+	if err != nil {
+		return nil, err
 	}
-	unsignedShortArrayValue = getUnsignedShortVec3ArrayFeatureValue(header, buff, I3DM_PROP_NORMAL_RIGHT_OCT32P, int(instanceLength))
-	if unsignedShortArrayValue != nil {
-		ret[I3DM_PROP_NORMAL_RIGHT_OCT32P] = unsignedShortArrayValue
+	if octArrayValue != nil {
+		ret[I3DM_PROP_NORMAL_UP_OCT32P] = octArrayValue
+	}
+	octArrayValue, err = getUnsignedShortVec2ArrayFeatureValue(header, buff, I3DM_PROP_NORMAL_RIGHT_OCT32P, int(instanceLength))
+	if err != nil {
+		return nil, err
+	}
+	if octArrayValue != nil {
+		ret[I3DM_PROP_NORMAL_RIGHT_OCT32P] = octArrayValue
+	}
+	floatArrayValue1, err := getFloatArrayFeatureValue(header, buff, I3DM_PROP_SCALE, int(instanceLength))
+	if err != nil {
+		return nil, err
 	}
-	floatArrayValue1 := getFloatArrayFeatureValue(header, buff, I3DM_PROP_SCALE, int(instanceLength))
 	if floatArrayValue1 != nil {
 		ret[I3DM_PROP_SCALE] = floatArrayValue1
 	}
-	floatArrayValue = getFloatVec3ArrayFeatureValue(header, buff, I3DM_PROP_SCALE_NON_UNIFORM, int(instanceLength))
+	floatArrayValue, err = getFloatVec3ArrayFeatureValue(header, buff, I3DM_PROP_SCALE_NON_UNIFORM, int(instanceLength))
+	if err != nil {
+		return nil, err
+	}
 	if floatArrayValue != nil {
 		ret[I3DM_PROP_SCALE_NON_UNIFORM] = floatArrayValue
 	}
-	unsignedIntArrayValue := getBatchLength(header, buff, int(instanceLength))
+	unsignedIntArrayValue, err := getBatchLength(header, buff, int(instanceLength))
+	if err != nil {
+		return nil, err
+	}
 	if unsignedIntArrayValue != nil {
 		ret[I3DM_PROP_BATCH_ID] = unsignedIntArrayValue
 	}
 
-	return ret
+	return ret, nil
 }
 
-func I3dmFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) []byte {
+func I3dmFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) ([]byte, error) {
 	var out []byte
 	buf := bytes.NewBuffer(out)
 	offset := 0
 	if t := data[I3DM_PROP_POSITION]; t != nil {
-		dt := t.([][3]float32)
+		dt, ok := t.([][3]float32)
+		if !ok {
+			return nil, newTileError("", I3DM_PROP_POSITION, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[I3DM_PROP_POSITION] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC3}
 		offset += (len(dt) * 3 * 4)
 	}
 
 	if t := data[I3DM_PROP_POSITION_QUANTIZED]; t != nil {
-		dt := t.([][3]uint16)
-		binary.Write(buf, littleEndian, t.([][3]uint16))
+		dt, ok := t.([][3]uint16)
+		if !ok {
+			return nil, newTileError("", I3DM_PROP_POSITION_QUANTIZED, -1, ErrBadValue)
+		}
+		binary.Write(buf, littleEndian, dt)
 		header[I3DM_PROP_POSITION_QUANTIZED] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_VEC3}
 		l := (len(dt) * 3 * 2)
 		offset += l
@@ -170,48 +215,66 @@ func I3dmFeatureTableEncode(header map[string]interface{}, data map[string]inter
 	}
 
 	if t := data[I3DM_PROP_NORMAL_UP]; t != nil {
-		dt := t.([][3]float32)
+		dt, ok := t.([][3]float32)
+		if !ok {
+			return nil, newTileError("", I3DM_PROP_NORMAL_UP, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[I3DM_PROP_NORMAL_UP] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC3}
 		offset += (len(dt) * 3 * 4)
 	}
 
 	if t := data[I3DM_PROP_NORMAL_RIGHT]; t != nil {
-		dt := t.([][3]float32)
+		dt, ok := t.([][3]float32)
+		if !ok {
+			return nil, newTileError("", I3DM_PROP_NORMAL_RIGHT, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[I3DM_PROP_NORMAL_RIGHT] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC3}
 		offset += (len(dt) * 3 * 4)
 	}
 
 	if t := data[I3DM_PROP_NORMAL_UP_OCT32P]; t != nil {
-		dt := t.([][2]uint16)
+		dt, ok := t.([][2]uint16)
+		if !ok {
+			return nil, newTileError("", I3DM_PROP_NORMAL_UP_OCT32P, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[I3DM_PROP_NORMAL_UP_OCT32P] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_VEC2}
-		offset += (len(dt) * 3 * 2)
+		offset += (len(dt) * 2 * 2)
 		pad := createPaddingBytes([]byte{}, uint32(offset), 4, 0x20)
 		binary.Write(buf, littleEndian, pad)
 		offset += len(pad)
 	}
 
 	if t := data[I3DM_PROP_NORMAL_RIGHT_OCT32P]; t != nil {
-		dt := t.([][2]uint16)
+		dt, ok := t.([][2]uint16)
+		if !ok {
+			return nil, newTileError("", I3DM_PROP_NORMAL_RIGHT_OCT32P, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[I3DM_PROP_NORMAL_RIGHT_OCT32P] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_VEC2}
-		offset += (len(dt) * 3 * 2)
+		offset += (len(dt) * 2 * 2)
 		pad := createPaddingBytes([]byte{}, uint32(offset), 4, 0x20)
 		binary.Write(buf, littleEndian, pad)
 		offset += len(pad)
 	}
 
 	if t := data[I3DM_PROP_SCALE]; t != nil {
-		dt := t.([]float32)
+		dt, ok := t.([]float32)
+		if !ok {
+			return nil, newTileError("", I3DM_PROP_SCALE, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[I3DM_PROP_SCALE] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 4)
 	}
 
 	if t := data[I3DM_PROP_SCALE_NON_UNIFORM]; t != nil {
-		dt := t.([][3]float32)
+		dt, ok := t.([][3]float32)
+		if !ok {
+			return nil, newTileError("", I3DM_PROP_SCALE_NON_UNIFORM, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[I3DM_PROP_SCALE_NON_UNIFORM] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC3}
 		offset += (len(dt) * 3 * 4)
@@ -231,6 +294,8 @@ func I3dmFeatureTableEncode(header map[string]interface{}, data map[string]inter
 			binary.Write(buf, littleEndian, dt)
 			header[I3DM_PROP_BATCH_ID] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_INT}
 			offset += (len(dt) * 4)
+		default:
+			return nil, newTileError("", I3DM_PROP_BATCH_ID, -1, ErrBadValue)
 		}
 		pad := createPaddingBytes([]byte{}, uint32(offset), 4, 0x20)
 		binary.Write(buf, littleEndian, pad)
@@ -239,10 +304,10 @@ func I3dmFeatureTableEncode(header map[string]interface{}, data map[string]inter
 	}
 	out = buf.Bytes()
 	if len(out) != offset {
-		return nil
+		return nil, newTileError("", "featureTable binary", int64(len(out)), ErrBadValue)
 	}
 
-	return out
+	return out, nil
 }
 
 type I3dm struct {
@@ -414,6 +479,208 @@ func (m *I3dm) GetFeatureTableView() I3dmFeatureTableView {
 	return ret
 }
 
+// InstancesLength returns the number of instances in the tile.
+func (m *I3dm) InstancesLength() int {
+	n, _ := m.FeatureTable.getCount(I3DM_PROP_INSTANCES_LENGTH)
+	return n
+}
+
+// Positions returns the instance positions with quantization and
+// RTC_CENTER applied.
+func (m *I3dm) Positions() ([][3]float64, error) {
+	var rtc [3]float64
+	if v, ok := m.FeatureTable.getVec3(I3DM_PROP_RTC_CENTER); ok {
+		rtc = v
+	}
+	n := m.InstancesLength()
+	if t := m.FeatureTable.Data[I3DM_PROP_POSITION]; t != nil {
+		pos, ok := t.([][3]float32)
+		if !ok || len(pos) < n {
+			return nil, newTileError(I3DM_MAGIC, I3DM_PROP_POSITION, -1, ErrBadValue)
+		}
+		ret := make([][3]float64, n)
+		for i := range ret {
+			for j := 0; j < 3; j++ {
+				ret[i][j] = float64(pos[i][j]) + rtc[j]
+			}
+		}
+		return ret, nil
+	}
+	if t := m.FeatureTable.Data[I3DM_PROP_POSITION_QUANTIZED]; t != nil {
+		pos, ok := t.([][3]uint16)
+		if !ok || len(pos) < n {
+			return nil, newTileError(I3DM_MAGIC, I3DM_PROP_POSITION_QUANTIZED, -1, ErrBadValue)
+		}
+		offset, ok := m.FeatureTable.getVec3(I3DM_PROP_QUANTIZED_VOLUME_OFFSET)
+		if !ok {
+			return nil, newTileError(I3DM_MAGIC, I3DM_PROP_QUANTIZED_VOLUME_OFFSET, -1, ErrBadValue)
+		}
+		scale, ok := m.FeatureTable.getVec3(I3DM_PROP_QUANTIZED_VOLUME_SCALE)
+		if !ok {
+			return nil, newTileError(I3DM_MAGIC, I3DM_PROP_QUANTIZED_VOLUME_SCALE, -1, ErrBadValue)
+		}
+		ret := make([][3]float64, n)
+		for i := range ret {
+			for j := 0; j < 3; j++ {
+				ret[i][j] = float64(pos[i][j])*scale[j]/65535.0 + offset[j] + rtc[j]
+			}
+		}
+		return ret, nil
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return nil, newTileError(I3DM_MAGIC, I3DM_PROP_POSITION, -1, ErrBadValue)
+}
+
+// BatchIds returns the batch id of each instance, or nil when BATCH_ID is
+// not set.
+func (m *I3dm) BatchIds() ([]uint32, error) {
+	t := m.FeatureTable.Data[I3DM_PROP_BATCH_ID]
+	if t == nil {
+		return nil, nil
+	}
+	n := m.InstancesLength()
+	ret := make([]uint32, n)
+	switch ids := t.(type) {
+	case []uint8:
+		if len(ids) < n {
+			break
+		}
+		for i := range ret {
+			ret[i] = uint32(ids[i])
+		}
+		return ret, nil
+	case []uint16:
+		if len(ids) < n {
+			break
+		}
+		for i := range ret {
+			ret[i] = uint32(ids[i])
+		}
+		return ret, nil
+	case []uint32:
+		if len(ids) < n {
+			break
+		}
+		copy(ret, ids)
+		return ret, nil
+	}
+	return nil, newTileError(I3DM_MAGIC, I3DM_PROP_BATCH_ID, -1, ErrBadValue)
+}
+
+// BatchLength returns the number of rows in the batch table: one more than
+// the largest BATCH_ID, otherwise one per instance.
+func (m *I3dm) BatchLength() int {
+	ids, err := m.BatchIds()
+	if err != nil || ids == nil {
+		return m.InstancesLength()
+	}
+	n := 0
+	for _, id := range ids {
+		if int(id) >= n {
+			n = int(id) + 1
+		}
+	}
+	return n
+}
+
+func (m *I3dm) getNormals(propName, octPropName string) ([][3]float64, error) {
+	n := m.InstancesLength()
+	if t := m.FeatureTable.Data[propName]; t != nil {
+		normals, ok := t.([][3]float32)
+		if !ok || len(normals) < n {
+			return nil, newTileError(I3DM_MAGIC, propName, -1, ErrBadValue)
+		}
+		ret := make([][3]float64, n)
+		for i := range ret {
+			ret[i] = [3]float64{float64(normals[i][0]), float64(normals[i][1]), float64(normals[i][2])}
+		}
+		return ret, nil
+	}
+	if t := m.FeatureTable.Data[octPropName]; t != nil {
+		normals, ok := t.([][2]uint16)
+		if !ok || len(normals) < n {
+			return nil, newTileError(I3DM_MAGIC, octPropName, -1, ErrBadValue)
+		}
+		ret := make([][3]float64, n)
+		for i := range ret {
+			v := decodeOct32P(normals[i][0], normals[i][1])
+			ret[i] = [3]float64{float64(v[0]), float64(v[1]), float64(v[2])}
+		}
+		return ret, nil
+	}
+	return nil, nil
+}
+
+// InstanceTransforms returns the world matrix of every instance, column
+// major as in glTF. Instances are oriented by NORMAL_UP and NORMAL_RIGHT,
+// or by the WGS84 east-north-up frame at their position when EAST_NORTH_UP
+// is set, and scaled by SCALE and SCALE_NON_UNIFORM.
+func (m *I3dm) InstanceTransforms() ([][16]float64, error) {
+	positions, err := m.Positions()
+	if err != nil {
+		return nil, err
+	}
+	ups, err := m.getNormals(I3DM_PROP_NORMAL_UP, I3DM_PROP_NORMAL_UP_OCT32P)
+	if err != nil {
+		return nil, err
+	}
+	rights, err := m.getNormals(I3DM_PROP_NORMAL_RIGHT, I3DM_PROP_NORMAL_RIGHT_OCT32P)
+	if err != nil {
+		return nil, err
+	}
+	if (ups == nil) != (rights == nil) {
+		return nil, newTileError(I3DM_MAGIC, I3DM_PROP_NORMAL_RIGHT, -1, ErrBadValue)
+	}
+	enu, _ := m.FeatureTable.Header[I3DM_PROP_EAST_NORTH_UP].(bool)
+
+	n := len(positions)
+	var scale []float32
+	if t := m.FeatureTable.Data[I3DM_PROP_SCALE]; t != nil {
+		var ok bool
+		if scale, ok = t.([]float32); !ok || len(scale) < n {
+			return nil, newTileError(I3DM_MAGIC, I3DM_PROP_SCALE, -1, ErrBadValue)
+		}
+	}
+	var scaleNonUniform [][3]float32
+	if t := m.FeatureTable.Data[I3DM_PROP_SCALE_NON_UNIFORM]; t != nil {
+		var ok bool
+		if scaleNonUniform, ok = t.([][3]float32); !ok || len(scaleNonUniform) < n {
+			return nil, newTileError(I3DM_MAGIC, I3DM_PROP_SCALE_NON_UNIFORM, -1, ErrBadValue)
+		}
+	}
+
+	ret := make([][16]float64, n)
+	for i, p := range positions {
+		axes := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+		if ups != nil {
+			axes[0], axes[1] = rights[i], ups[i]
+			axes[2] = cross64(rights[i], ups[i])
+		} else if enu {
+			axes = eastNorthUp(p)
+		}
+		s := [3]float64{1, 1, 1}
+		if scale != nil {
+			for j := range s {
+				s[j] *= float64(scale[i])
+			}
+		}
+		if scaleNonUniform != nil {
+			for j := range s {
+				s[j] *= float64(scaleNonUniform[i][j])
+			}
+		}
+		for c := 0; c < 3; c++ {
+			for r := 0; r < 3; r++ {
+				ret[i][c*4+r] = axes[c][r] * s[c]
+			}
+		}
+		ret[i][12], ret[i][13], ret[i][14], ret[i][15] = p[0], p[1], p[2], 1
+	}
+	return ret, nil
+}
+
 func (m *I3dm) GetHeader() Header {
 	return &m.Header
 }
@@ -426,70 +693,97 @@ func (m *I3dm) GetBatchTable() *BatchTable {
 	return &m.BatchTable
 }
 
-func (m *I3dm) CalcSize() int64 {
-	gltfSize := 0
-	if m.Header.GltfFormat == 0 {
-		gltfSize = len(m.GltfUri)
-		gltfSize += int(calcPadding(uint32(gltfSize), 8))
-	} else if m.Header.GltfFormat == 1 && m.Model != nil {
-		gltfSize = int(calcGltfSize(m.Model, 8))
+func (m *I3dm) CalcSize() (int64, error) {
+	m.FeatureTable.encode = I3dmFeatureTableEncode
+	si := m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader())
+	if m.Header.GltfFormat == I3DM_GLTF_URI {
+		si += int64(len(m.GltfUri))
+		si += int64(calcPadding(uint32(si), 8))
+	} else if m.Header.GltfFormat == I3DM_GLTF_EMBEDDED {
+		gltfSize, err := calcGltfSize(m.Model, 8)
+		if err != nil {
+			return 0, newTileError(I3DM_MAGIC, "glTF", -1, err)
+		}
+		si += gltfSize
 	} else {
-		panic("GltfFormat must 0 or 1")
+		return 0, newTileError(I3DM_MAGIC, "gltfFormat", -1, ErrBadGltfFormat)
 	}
-	m.FeatureTable.encode = I3dmFeatureTableEncode
-	return m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader()) + int64(gltfSize)
+	return si, nil
 }
 
 func (m *I3dm) Read(reader io.ReadSeeker) error {
+	start, _ := reader.Seek(0, io.SeekCurrent)
 	err := binary.Read(reader, littleEndian, &m.Header)
 	if err != nil {
+		return newTileError(I3DM_MAGIC, "header", start, err)
+	}
+	if err := checkMagic(I3DM_MAGIC, start, m.Header.Magic); err != nil {
+		return err
+	}
+	if err := checkVersion(I3DM_MAGIC, start, m.Header.Version); err != nil {
+		return err
+	}
+	if err := checkByteLength(reader, I3DM_MAGIC, start, m.GetHeader()); err != nil {
 		return err
 	}
+	if m.Header.GltfFormat != I3DM_GLTF_URI && m.Header.GltfFormat != I3DM_GLTF_EMBEDDED {
+		return newTileError(I3DM_MAGIC, "gltfFormat", start+28, ErrBadGltfFormat)
+	}
 
 	m.FeatureTable.decode = I3dmFeatureTableDecode
 
 	if err := m.FeatureTable.Read(reader, m.GetHeader()); err != nil {
-		return err
+		return wrapTileError(I3DM_MAGIC, "featureTable", 0, err)
 	}
 
-	if err := m.BatchTable.Read(reader, m.GetHeader(), m.FeatureTable.GetBatchLength()); err != nil {
-		return err
+	if err := m.BatchTable.Read(reader, m.GetHeader(), m.BatchLength()); err != nil {
+		return wrapTileError(I3DM_MAGIC, "batchTable", 0, err)
 	}
 
-	if m.Header.GltfFormat == 0 {
-		var uri []byte
-		if _, err := io.ReadAtLeast(reader, uri, 0); err != nil {
-			return err
-		}
-		m.GltfUri = string(uri)
-	} else if m.Header.GltfFormat == 1 {
-		var err1 error
-		if m.Model, err1 = loadGltfFromByte(reader); err1 != nil {
-			return err1
-		}
+	offset, _ := reader.Seek(0, io.SeekCurrent)
+	body, err := readTileBody(reader, I3DM_MAGIC, "glTF", start, m.Header.ByteLength)
+	if err != nil {
+		return err
+	}
+	if m.Header.GltfFormat == I3DM_GLTF_URI {
+		m.GltfUri = strings.TrimRight(string(body), " \x00")
 	} else {
-		panic("GltfFormat must 0 or 1")
+		if m.Model, err = loadGltfFromByte(body); err != nil {
+			return newTileError(I3DM_MAGIC, "glTF", offset, err)
+		}
 	}
 	return nil
 }
 
+// ReadFromBytes reads a tile from data. Binary arrays are views over data
+// where possible, so data must not be modified while m is in use.
+func (m *I3dm) ReadFromBytes(data []byte) error {
+	return m.Read(newSliceReader(data))
+}
+
 func (m *I3dm) Write(writer io.Writer) error {
+	copy(m.Header.Magic[:], I3DM_MAGIC)
+	m.Header.Version = 1
 	var buf []byte
 	m.FeatureTable.encode = I3dmFeatureTableEncode
-	_ = I3dmFeatureTableEncode(m.FeatureTable.Header, m.FeatureTable.Data)
+	if _, err := I3dmFeatureTableEncode(m.FeatureTable.Header, m.FeatureTable.Data); err != nil {
+		return wrapTileError(I3DM_MAGIC, "featureTable", -1, err)
+	}
 
-	if m.Header.GltfFormat == 0 {
-		buf = createPaddingBytes([]byte(m.GltfUri), uint32(len(m.GltfUri)), 4, 0x20)
-	} else if m.Header.GltfFormat == 1 {
+	si := m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader())
+
+	if m.Header.GltfFormat == I3DM_GLTF_URI {
+		buf = createPaddingBytes([]byte(m.GltfUri), uint32(si)+uint32(len(m.GltfUri)), 8, 0x20)
+	} else if m.Header.GltfFormat == I3DM_GLTF_EMBEDDED {
 		var err1 error
 		if buf, err1 = getGltfBinary(m.Model, 8); err1 != nil {
-			return err1
+			return newTileError(I3DM_MAGIC, "glTF", -1, err1)
 		}
+	} else {
+		return newTileError(I3DM_MAGIC, "gltfFormat", -1, ErrBadGltfFormat)
 	}
 
-	si := m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader()) + int64(len(buf))
-
-	m.Header.ByteLength = uint32(si)
+	m.Header.ByteLength = uint32(si + int64(len(buf)))
 
 	err := binary.Write(writer, littleEndian, m.Header)
 
@@ -497,12 +791,12 @@ func (m *I3dm) Write(writer io.Writer) error {
 		return err
 	}
 
-	if err := m.FeatureTable.Write(writer, nil); err != nil {
-		return err
+	if err := m.FeatureTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(I3DM_MAGIC, "featureTable", -1, err)
 	}
 
-	if err := m.BatchTable.Write(writer, nil); err != nil {
-		return err
+	if err := m.BatchTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(I3DM_MAGIC, "batchTable", -1, err)
 	}
 
 	if _, err := writer.Write(buf); err != nil {
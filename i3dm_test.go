@@ -1 +1,124 @@
 package tile3d
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestI3dmExternalModel(t *testing.T) {
+	dir := t.TempDir()
+	w := NewExternalModelWriter(dir)
+	tilePath := filepath.Join(dir, "tiles", "0.i3dm")
+
+	var tiles [][]byte
+	for i := 0; i < 2; i++ {
+		m := &I3dm{Model: openGltf("./data/box.glb")}
+		m.SetFeatureTable(I3dmFeatureTableView{Position: [][3]float32{{0, 0, 0}}, InstanceLength: 1})
+		if err := w.Externalize(m, tilePath); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(m.GltfUri, "../") || !strings.HasSuffix(m.GltfUri, ".glb") {
+			t.Fatalf("uri %q", m.GltfUri)
+		}
+		var buf bytes.Buffer
+		if err := m.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len()%8 != 0 {
+			t.Errorf("byteLength %d", buf.Len())
+		}
+		tiles = append(tiles, buf.Bytes())
+	}
+	if len(w.files) != 1 {
+		t.Errorf("model written %d times", len(w.files))
+	}
+
+	// The model is outside the tile directory, so it needs a Root.
+	resolver := NewFileGltfResolver()
+	m := &I3dm{}
+	if err := m.Read(bytes.NewReader(tiles[0])); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ResolveModel(tilePath, resolver); !errors.Is(err, ErrUnresolvedUri) {
+		t.Errorf("uri outside the tile directory %v", err)
+	}
+	for _, uri := range []string{"/etc/model.glb", "../../model.glb"} {
+		if _, err := resolver.Resolve(tilePath, uri); !errors.Is(err, ErrUnresolvedUri) {
+			t.Errorf("%s: %v", uri, err)
+		}
+	}
+	resolver.Root = dir
+	if _, err := resolver.Resolve(tilePath, "../../model.glb"); !errors.Is(err, ErrUnresolvedUri) {
+		t.Errorf("uri outside the root %v", err)
+	}
+
+	var docs []*I3dm
+	for _, b := range tiles {
+		m := &I3dm{}
+		if err := m.Read(bytes.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ResolveModel(tilePath, resolver); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, m)
+	}
+	if docs[0].Model == nil || docs[0].Model != docs[1].Model {
+		t.Error("model not shared")
+	}
+}
+
+func TestI3dmInstanceTransforms(t *testing.T) {
+	m := &I3dm{Model: openGltf("./data/box.glb")}
+	m.Header.GltfFormat = I3DM_GLTF_EMBEDDED
+	m.SetFeatureTable(I3dmFeatureTableView{
+		PositionQuantized:     [][3]uint16{{0, 0, 0}, {65535, 65535, 65535}},
+		QuantizedVolumeOffset: []float32{-1, -1, -1},
+		QuantizedVolumeScale:  []float32{2, 2, 2},
+		RtcCenter:             []float64{100, 200, 300},
+		NormalUpOCT16P:        [][2]uint16{{32768, 32768}, {32768, 32768}},
+		NormalRightOCT16P:     [][2]uint16{{65535, 32768}, {65535, 32768}},
+		Scale:                 []float32{2, 3},
+		InstanceLength:        2,
+	})
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := &I3dm{}
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	mats, err := r.InstanceTransforms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// right (1,0,0), up (0,0,1) and forward right x up = (0,-1,0).
+	want := [16]float64{3, 0, 0, 0, 0, 0, 3, 0, 0, -3, 0, 0, 101, 201, 301, 1}
+	for i := range want {
+		if math.Abs(mats[1][i]-want[i]) > 1e-3 {
+			t.Fatalf("matrix %v", mats[1])
+		}
+	}
+	if mats[0][12] != 99 || mats[0][0] < 1.99 {
+		t.Errorf("matrix %v", mats[0])
+	}
+
+	enu := true
+	e := &I3dm{}
+	e.SetFeatureTable(I3dmFeatureTableView{Position: [][3]float32{{0, 0, 0}}, RtcCenter: []float64{WGS84_RADIUS_X, 0, 0}, EastNorthUp: &enu, InstanceLength: 1})
+	mats, err = e.InstanceTransforms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = [16]float64{0, 1, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, WGS84_RADIUS_X, 0, 0, 1}
+	for i := range want {
+		if math.Abs(mats[0][i]-want[i]) > 1e-9 {
+			t.Fatalf("east north up %v", mats[0])
+		}
+	}
+}
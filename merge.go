@@ -0,0 +1,510 @@
+package tile3d
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/flywave/gltf"
+	"github.com/flywave/gltf/modeler"
+)
+
+// B3dmMergeOptions controls MergeB3dm.
+type B3dmMergeOptions struct {
+	// Defaults gives the value of a property for the features of the tiles
+	// without it. Features of tiles without a property that has no default
+	// are null.
+	Defaults map[string]interface{}
+}
+
+func NewB3dmMergeOptions() *B3dmMergeOptions {
+	return &B3dmMergeOptions{}
+}
+
+// MergeB3dm merges tiles into a single b3dm centered on the mean of their
+// RTC centers. The scene of every tile is placed at the difference of its
+// center, its _BATCHID values are offset by the batch lengths of the tiles
+// before it and the batch tables are concatenated. A property stays binary
+// when every tile stores it binary with the same container type, or has a
+// default for it, in a component type holding all values. Hierarchies are
+// concatenated when every tile has one and flattened into properties
+// otherwise. Extensions of the glTF document itself are dropped.
+func MergeB3dm(tiles []*B3dm, opts *B3dmMergeOptions) (*B3dm, error) {
+	if opts == nil {
+		opts = NewB3dmMergeOptions()
+	}
+	if len(tiles) == 0 {
+		return nil, newTileError(B3DM_MAGIC, "tiles", -1, ErrBadValue)
+	}
+	centers := make([][3]float64, len(tiles))
+	var center [3]float64
+	for i, m := range tiles {
+		if m.Model == nil {
+			return nil, newTileError(B3DM_MAGIC, "glTF", -1, ErrMissingModel)
+		}
+		c, err := m.center()
+		if err != nil {
+			return nil, err
+		}
+		centers[i] = c
+		for j := range center {
+			center[j] += c[j] / float64(len(tiles))
+		}
+	}
+
+	doc := &gltf.Document{Asset: tiles[0].Model.Asset}
+	scene := &gltf.Scene{}
+	tables := make([]*BatchTable, len(tiles))
+	lengths := make([]int, len(tiles))
+	offset := 0
+	for i, m := range tiles {
+		for _, e := range m.Model.ExtensionsUsed {
+			switch e {
+			case "KHR_draco_mesh_compression", "EXT_meshopt_compression", EXT_MESH_GPU_INSTANCING:
+				// These reference buffer data appendGltf does not know about.
+				return nil, newTileError(B3DM_MAGIC, e, -1, ErrBadValue)
+			}
+		}
+		src, err := copyGltf(m.Model)
+		if err != nil {
+			return nil, newTileError(B3DM_MAGIC, "glTF", -1, err)
+		}
+		if err := offsetGltfBatchIds(src, uint32(offset)); err != nil {
+			return nil, wrapTileError(B3DM_MAGIC, "glTF", -1, err)
+		}
+		removeGltfExtensions(src, CESIUM_RTC)
+		nodes, err := appendGltf(doc, src)
+		if err != nil {
+			return nil, newTileError(B3DM_MAGIC, "glTF", -1, err)
+		}
+		d := [3]float64{centers[i][0] - center[0], centers[i][1] - center[1], centers[i][2] - center[2]}
+		if d != [3]float64{} && len(nodes) > 0 {
+			doc.Nodes = append(doc.Nodes, &gltf.Node{
+				Children:    nodes,
+				Translation: [3]float32{float32(d[0]), float32(d[2]), float32(-d[1])},
+			})
+			nodes = []uint32{uint32(len(doc.Nodes) - 1)}
+		}
+		scene.Nodes = append(scene.Nodes, nodes...)
+		tables[i] = &m.BatchTable
+		lengths[i] = m.BatchLength()
+		offset += lengths[i]
+	}
+	doc.Scenes = []*gltf.Scene{scene}
+	doc.Scene = gltf.Index(0)
+	if err := compactGltf(doc); err != nil {
+		return nil, wrapTileError(B3DM_MAGIC, "glTF", -1, err)
+	}
+
+	bt, err := mergeBatchTables(tables, lengths, opts.Defaults)
+	if err != nil {
+		return nil, wrapTileError(B3DM_MAGIC, "batchTable", -1, err)
+	}
+	ret := NewB3dm()
+	ret.Model = doc
+	ret.BatchTable = *bt
+	var rtc []float64
+	if center != [3]float64{} {
+		rtc = center[:]
+	}
+	ret.SetFeatureTable(B3dmFeatureTableView{BatchLength: offset, RtcCenter: rtc})
+	return ret, nil
+}
+
+// center returns the RTC_CENTER of m plus the center of the CESIUM_RTC
+// extension of its glTF.
+func (m *B3dm) center() ([3]float64, error) {
+	ret, _ := m.FeatureTable.getVec3(B3DM_PROP_RTC_CENTER)
+	if ext, ok := m.Model.Extensions[CESIUM_RTC]; ok {
+		var c CesiumRTC
+		if err := decodeGltfExtension(ext, &c); err != nil {
+			return ret, newTileError(B3DM_MAGIC, CESIUM_RTC, -1, err)
+		}
+		for i := range ret {
+			ret[i] += c.Center[i]
+		}
+	}
+	return ret, nil
+}
+
+// offsetGltfBatchIds adds offset to the _BATCHID of every primitive of doc.
+// Ids keep their component type unless they no longer fit it.
+func offsetGltfBatchIds(doc *gltf.Document, offset uint32) error {
+	if offset == 0 {
+		return nil
+	}
+	written := make(map[uint32]uint32)
+	for _, mesh := range doc.Meshes {
+		for _, p := range mesh.Primitives {
+			for _, name := range []string{GLTF_ATTR_BATCHID, "BATCHID"} {
+				index, ok := p.Attributes[name]
+				if !ok {
+					continue
+				}
+				if i, ok := written[index]; ok {
+					p.Attributes[name] = i
+					continue
+				}
+				ids, err := readFeatureIds(doc, index)
+				if err != nil {
+					return err
+				}
+				acr := doc.Accessors[index]
+				if ids == nil {
+					// Accessors without data are zeros.
+					ids = make([]uint32, acr.Count)
+				}
+				max := uint32(0)
+				for j := range ids {
+					ids[j] += offset
+					if ids[j] > max {
+						max = ids[j]
+					}
+				}
+				var i uint32
+				switch {
+				case acr.ComponentType == gltf.ComponentUbyte && max <= math.MaxUint8:
+					data := make([]uint8, len(ids))
+					for j, id := range ids {
+						data[j] = uint8(id)
+					}
+					i = modeler.WriteAccessor(doc, gltf.TargetArrayBuffer, data)
+				case acr.ComponentType == gltf.ComponentFloat:
+					data := make([]float32, len(ids))
+					for j, id := range ids {
+						data[j] = float32(id)
+					}
+					i = modeler.WriteAccessor(doc, gltf.TargetArrayBuffer, data)
+				default:
+					if i, err = writeFeatureIds(doc, gltf.TargetArrayBuffer, ids); err != nil {
+						return err
+					}
+				}
+				written[index] = i
+				p.Attributes[name] = i
+			}
+		}
+	}
+	return nil
+}
+
+// offsetExtensionIndexes adds n to the numbers in the JSON of the
+// extensions exts whose key, in an object of the key parent, is matched.
+// Extensions with indexes are stored back as JSON.
+func offsetExtensionIndexes(exts gltf.Extensions, n uint32, match func(parent, key string) bool) error {
+	var walk func(v interface{}, parent string) bool
+	walk = func(v interface{}, parent string) bool {
+		changed := false
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for k, c := range t {
+				if f, ok := c.(float64); ok && match(parent, k) {
+					t[k] = f + float64(n)
+					changed = true
+				} else if walk(c, k) {
+					changed = true
+				}
+			}
+		case []interface{}:
+			for _, c := range t {
+				if walk(c, parent) {
+					changed = true
+				}
+			}
+		}
+		return changed
+	}
+	for name, ext := range exts {
+		data, err := json.Marshal(ext)
+		if err != nil {
+			return err
+		}
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		if !walk(v, "") {
+			continue
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return err
+		}
+		exts[name] = json.RawMessage(data)
+	}
+	return nil
+}
+
+// appendGltf appends the objects of src to doc and returns the nodes of
+// the default scene of src as indexes of doc. src is modified. The texture
+// infos of material extensions, like those of KHR_materials_*, and the
+// images of texture extensions, like KHR_texture_basisu, are offset too.
+func appendGltf(doc, src *gltf.Document) ([]uint32, error) {
+	buffers := uint32(len(doc.Buffers))
+	views := uint32(len(doc.BufferViews))
+	accessors := uint32(len(doc.Accessors))
+	images := uint32(len(doc.Images))
+	samplers := uint32(len(doc.Samplers))
+	textures := uint32(len(doc.Textures))
+	materials := uint32(len(doc.Materials))
+	meshes := uint32(len(doc.Meshes))
+	nodes := uint32(len(doc.Nodes))
+	skins := uint32(len(doc.Skins))
+	cameras := uint32(len(doc.Cameras))
+	offset := func(i *uint32, n uint32) *uint32 {
+		if i == nil {
+			return nil
+		}
+		return gltf.Index(*i + n)
+	}
+	texture := func(t *gltf.TextureInfo) {
+		if t != nil {
+			t.Index += textures
+		}
+	}
+
+	for _, v := range src.BufferViews {
+		v.Buffer += buffers
+	}
+	for _, a := range src.Accessors {
+		a.BufferView = offset(a.BufferView, views)
+		if a.Sparse != nil {
+			a.Sparse.Indices.BufferView += views
+			a.Sparse.Values.BufferView += views
+		}
+	}
+	visitGltfAccessors(src, func(index uint32, target gltf.Target) uint32 {
+		return index + accessors
+	})
+	for _, img := range src.Images {
+		img.BufferView = offset(img.BufferView, views)
+	}
+	for _, t := range src.Textures {
+		t.Sampler = offset(t.Sampler, samplers)
+		t.Source = offset(t.Source, images)
+		err := offsetExtensionIndexes(t.Extensions, images, func(parent, key string) bool {
+			return parent == "" && key == "source"
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range src.Materials {
+		if m.PBRMetallicRoughness != nil {
+			texture(m.PBRMetallicRoughness.BaseColorTexture)
+			texture(m.PBRMetallicRoughness.MetallicRoughnessTexture)
+		}
+		if m.NormalTexture != nil {
+			m.NormalTexture.Index = offset(m.NormalTexture.Index, textures)
+		}
+		if m.OcclusionTexture != nil {
+			m.OcclusionTexture.Index = offset(m.OcclusionTexture.Index, textures)
+		}
+		texture(m.EmissiveTexture)
+		err := offsetExtensionIndexes(m.Extensions, textures, func(parent, key string) bool {
+			return strings.HasSuffix(parent, "Texture") && key == "index"
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, mesh := range src.Meshes {
+		for _, p := range mesh.Primitives {
+			p.Material = offset(p.Material, materials)
+		}
+	}
+	for _, n := range src.Nodes {
+		n.Camera = offset(n.Camera, cameras)
+		n.Skin = offset(n.Skin, skins)
+		n.Mesh = offset(n.Mesh, meshes)
+		for i := range n.Children {
+			n.Children[i] += nodes
+		}
+	}
+	for _, s := range src.Skins {
+		s.Skeleton = offset(s.Skeleton, nodes)
+		for i := range s.Joints {
+			s.Joints[i] += nodes
+		}
+	}
+	for _, a := range src.Animations {
+		for _, c := range a.Channels {
+			c.Target.Node = offset(c.Target.Node, nodes)
+		}
+	}
+
+	doc.Buffers = append(doc.Buffers, src.Buffers...)
+	doc.BufferViews = append(doc.BufferViews, src.BufferViews...)
+	doc.Accessors = append(doc.Accessors, src.Accessors...)
+	doc.Images = append(doc.Images, src.Images...)
+	doc.Samplers = append(doc.Samplers, src.Samplers...)
+	doc.Textures = append(doc.Textures, src.Textures...)
+	doc.Materials = append(doc.Materials, src.Materials...)
+	doc.Meshes = append(doc.Meshes, src.Meshes...)
+	doc.Nodes = append(doc.Nodes, src.Nodes...)
+	doc.Skins = append(doc.Skins, src.Skins...)
+	doc.Cameras = append(doc.Cameras, src.Cameras...)
+	doc.Animations = append(doc.Animations, src.Animations...)
+	for _, e := range src.ExtensionsUsed {
+		addExtensionUsed(doc, e)
+	}
+	for _, e := range src.ExtensionsRequired {
+		required := false
+		for _, r := range doc.ExtensionsRequired {
+			required = required || r == e
+		}
+		if !required {
+			doc.ExtensionsRequired = append(doc.ExtensionsRequired, e)
+		}
+	}
+
+	var ret []uint32
+	if len(src.Scenes) > 0 {
+		for _, n := range gltfScene(src).Nodes {
+			ret = append(ret, n+nodes)
+		}
+	}
+	return ret, nil
+}
+
+// mergeBatchTables concatenates the tables of tiles with batchLengths
+// features, see MergeB3dm.
+func mergeBatchTables(tables []*BatchTable, batchLengths []int, defaults map[string]interface{}) (*BatchTable, error) {
+	hierarchies := make([]*BatchTableHierarchy, len(tables))
+	shared := true
+	for i, t := range tables {
+		h, err := t.Hierarchy()
+		if err != nil {
+			return nil, err
+		}
+		hierarchies[i] = h
+		shared = shared && h != nil
+	}
+
+	names := make(map[string]interface{})
+	columns := make([]map[string]*BatchTableColumn, len(tables))
+	for i, t := range tables {
+		columns[i] = make(map[string]*BatchTableColumn)
+		for _, c := range t.Columns() {
+			if c.Len() < batchLengths[i] {
+				return nil, newTileError("", c.Name, -1, ErrBadValue)
+			}
+			columns[i][c.Name] = c
+			names[c.Name] = nil
+		}
+		if hierarchies[i] == nil || shared {
+			continue
+		}
+		inherited, err := flattenBatchTableHierarchy(t.Header, batchLengths[i])
+		if err != nil {
+			return nil, err
+		}
+		for k, values := range inherited {
+			if _, ok := columns[i][k]; !ok {
+				columns[i][k] = &BatchTableColumn{Name: k, values: reflect.ValueOf(values), size: 1}
+				names[k] = nil
+			}
+		}
+	}
+
+	ret := &BatchTable{Header: make(map[string]interface{}), Data: make(map[string]interface{})}
+	for _, k := range sortedKeys(names) {
+		def, hasDefault := defaults[k]
+		if hasDefault {
+			// Defaults go through JSON so they have the types read from a tile.
+			buf, err := json.Marshal(def)
+			if err != nil {
+				return nil, newTileError("", k, -1, err)
+			}
+			if err := json.Unmarshal(buf, &def); err != nil {
+				return nil, newTileError("", k, -1, err)
+			}
+		}
+		var values []interface{}
+		binary, containerType := true, ""
+		componentTypes := make(map[string]bool)
+		for i, cols := range columns {
+			c, ok := cols[k]
+			if !ok {
+				binary = binary && hasDefault
+				for id := 0; id < batchLengths[i]; id++ {
+					values = append(values, def)
+				}
+				continue
+			}
+			if !c.IsBinary() || (containerType != "" && c.ContainerType != containerType) {
+				binary = false
+			} else {
+				containerType = c.ContainerType
+				componentTypes[c.ComponentType] = true
+			}
+			for id := 0; id < batchLengths[i]; id++ {
+				values = append(values, c.jsonValue(id))
+			}
+		}
+		if values == nil {
+			values = []interface{}{}
+		}
+		ret.Header[k] = values
+		ret.Data[k] = values
+		if binary && containerType != "" {
+			if componentType := mergedComponentType(ret, k, componentTypes); componentType != "" {
+				// Values a binary type can't hold, like a string default,
+				// leave the property as JSON.
+				ret.ToBinary(k, componentType, containerType)
+			}
+		}
+	}
+
+	if shared {
+		h, err := mergeBatchTableHierarchies(hierarchies, batchLengths)
+		if err != nil {
+			return nil, err
+		}
+		if err := ret.SetHierarchy(h); err != nil {
+			return nil, err
+		}
+	}
+	if len(ret.Header) == 0 {
+		return &BatchTable{}, nil
+	}
+	return ret, nil
+}
+
+// mergedComponentType returns the component type of the property name of
+// t merged from properties of componentTypes, the common one or else the
+// smallest holding all values. It returns "" for non numeric values.
+func mergedComponentType(t *BatchTable, name string, componentTypes map[string]bool) string {
+	if len(componentTypes) == 1 {
+		for k := range componentTypes {
+			return k
+		}
+	}
+	c, err := t.Column(name)
+	if err != nil {
+		return ""
+	}
+	kind := reflect.Int64
+	if componentTypes[COMPONENT_TYPE_FLOAT] || componentTypes[COMPONENT_TYPE_DOUBLE] {
+		kind = reflect.Float64
+	}
+	min, max := math.Inf(1), math.Inf(-1)
+	float32Exact := true
+	for id := 0; id < c.Len(); id++ {
+		v, ok := c.Float64s(id)
+		if !ok {
+			return ""
+		}
+		for _, f := range v {
+			min, max = math.Min(min, f), math.Max(max, f)
+			float32Exact = float32Exact && float64(float32(f)) == f
+			if f != math.Trunc(f) {
+				kind = reflect.Float64
+			}
+		}
+	}
+	if c.Len() == 0 {
+		min, max = 0, 0
+	}
+	return smallestComponentType(kind, min, max, float32Exact)
+}
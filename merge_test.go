@@ -0,0 +1,140 @@
+package tile3d
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/flywave/gltf"
+)
+
+func TestMergeB3dm(t *testing.T) {
+	bake := func(rtc []float64, header, data map[string]interface{}) *B3dm {
+		i := newBakeI3dm()
+		i.FeatureTable.Header[I3DM_PROP_RTC_CENTER] = rtc
+		i.BatchTable.Header, i.BatchTable.Data = header, data
+		baked, err := i.Bake(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return baked.(*B3dm)
+	}
+	a := bake([]float64{100, 200, 300}, map[string]interface{}{
+		"name":   []interface{}{"a", "b", "c"},
+		"height": BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC2},
+		"level":  BinaryBodyReference{ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE, ContainerType: CONTAINER_TYPE_SCALAR},
+	}, map[string]interface{}{"height": []float32{1, 2, 3, 4, 5, 6}, "level": []uint8{1, 2, 3}})
+	b := bake([]float64{110, 200, 300}, map[string]interface{}{
+		"name":  []interface{}{"d", "e", "f"},
+		"level": BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR},
+		"id":    BinaryBodyReference{ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR},
+	}, map[string]interface{}{"level": []float32{0.5, 1, 1.5}, "id": []uint16{10, 11, 12}})
+	_, aIds := bakedVertices(t, a)
+
+	m, err := MergeB3dm([]*B3dm{a, b}, &B3dmMergeOptions{Defaults: map[string]interface{}{"id": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newSampleTile(t, writeTile(t, m)).(*B3dm)
+	if c, _ := r.FeatureTable.getVec3(B3DM_PROP_RTC_CENTER); c != [3]float64{105, 200, 300} || r.BatchLength() != 6 {
+		t.Errorf("center %v, batch length %d", c, r.BatchLength())
+	}
+	doc := r.Model
+	if len(doc.Buffers) != 1 || len(doc.Meshes) != 2 || len(doc.Scenes[0].Nodes) != 2 {
+		t.Fatalf("%d buffers, %d meshes, scene %v", len(doc.Buffers), len(doc.Meshes), doc.Scenes[0].Nodes)
+	}
+	for i, x := range []float32{-5, 5} {
+		n := doc.Nodes[doc.Scenes[0].Nodes[i]]
+		if n.Translation != [3]float32{x, 0, 0} {
+			t.Errorf("translation %v", n.Translation)
+		}
+	}
+	ids, err := readFeatureIds(doc, doc.Meshes[1].Primitives[0].Attributes[GLTF_ATTR_BATCHID])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		if id != uint32(aIds[i])+3 {
+			t.Fatalf("batch id %d of %v", id, aIds[i])
+		}
+	}
+
+	bt := &r.BatchTable
+	if v := bt.GetProperty("name", 4); v != "e" {
+		t.Errorf("name %v", v)
+	}
+	if v := bt.GetProperty("height", 4); v != nil {
+		t.Errorf("missing height %v", v)
+	}
+	if v := bt.GetProperty("height", 1); !reflect.DeepEqual(v, []interface{}{3.0, 4.0}) {
+		t.Errorf("height %v", v)
+	}
+	if v := bt.GetProperty("level", 3); v != float32(0.5) {
+		t.Errorf("level %#v", v)
+	}
+	if v := bt.GetProperty("level", 0); v != float32(1) {
+		t.Errorf("level %#v", v)
+	}
+	if v := bt.GetProperty("id", 0); v != uint16(0) {
+		t.Errorf("default id %#v", v)
+	}
+	if v := bt.GetProperty("id", 5); v != uint16(12) {
+		t.Errorf("id %#v", v)
+	}
+
+	// Hierarchies are concatenated with the features first.
+	a.BatchTable.SetHierarchy(newBuildingHierarchy())
+	b.BatchTable.SetHierarchy(newBuildingHierarchy())
+	m, err = MergeB3dm([]*B3dm{a, b}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := m.BatchTable.Hierarchy()
+	if err != nil || h.InstancesLength() != 14 {
+		t.Fatal(h, err)
+	}
+	want, _ := newBuildingHierarchy().Ancestors(1)
+	got, _ := h.Ancestors(4)
+	if len(got) != len(want) {
+		t.Fatalf("ancestors %v, want %v", got, want)
+	}
+	for i := range got {
+		wp, _ := newBuildingHierarchy().Properties(int(want[i]))
+		gp, _ := h.Properties(int(got[i]))
+		if !reflect.DeepEqual(gp, wp) {
+			t.Errorf("ancestor %v, want %v", gp, wp)
+		}
+	}
+
+	if _, err := MergeB3dm([]*B3dm{a, NewB3dm()}, nil); !errors.Is(err, ErrMissingModel) {
+		t.Errorf("missing model %v", err)
+	}
+
+	// Textures of material and texture extensions are offset.
+	for _, m := range []*B3dm{a, b} {
+		m.Model.Images = append(m.Model.Images, &gltf.Image{URI: "a.ktx2"})
+		m.Model.Textures = append(m.Model.Textures, &gltf.Texture{Extensions: gltf.Extensions{
+			"KHR_texture_basisu": json.RawMessage(fmt.Sprintf(`{"source":%d}`, len(m.Model.Images)-1)),
+		}})
+		m.Model.Materials = append(m.Model.Materials, &gltf.Material{Extensions: gltf.Extensions{
+			"KHR_materials_clearcoat": json.RawMessage(fmt.Sprintf(`{"clearcoatTexture":{"index":%d}}`, len(m.Model.Textures)-1)),
+		}})
+	}
+	m, err = MergeB3dm([]*B3dm{a, b}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = newSampleTile(t, writeTile(t, m)).(*B3dm).Model
+	var clearcoat struct{ ClearcoatTexture gltf.TextureInfo }
+	json.Unmarshal(doc.Materials[len(doc.Materials)-1].Extensions["KHR_materials_clearcoat"].(json.RawMessage), &clearcoat)
+	if int(clearcoat.ClearcoatTexture.Index) != len(doc.Textures)-1 {
+		t.Errorf("clearcoat texture %d of %d", clearcoat.ClearcoatTexture.Index, len(doc.Textures))
+	}
+	var basisu struct{ Source uint32 }
+	json.Unmarshal(doc.Textures[len(doc.Textures)-1].Extensions["KHR_texture_basisu"].(json.RawMessage), &basisu)
+	if int(basisu.Source) != len(doc.Images)-1 {
+		t.Errorf("basisu image %d of %d", basisu.Source, len(doc.Images))
+	}
+}
@@ -0,0 +1,514 @@
+package tile3d
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flywave/gltf"
+)
+
+const (
+	EXT_MESH_FEATURES       = "EXT_mesh_features"
+	EXT_STRUCTURAL_METADATA = "EXT_structural_metadata"
+	EXT_MESH_GPU_INSTANCING = "EXT_mesh_gpu_instancing"
+	EXT_INSTANCE_FEATURES   = "EXT_instance_features"
+	CESIUM_RTC              = "CESIUM_RTC"
+
+	GLTF_ATTR_FEATURE_ID = "_FEATURE_ID_0"
+
+	METADATA_SCHEMA_ID     = "batchTable"
+	METADATA_CLASS_FEATURE = "feature"
+)
+
+const (
+	METADATA_TYPE_SCALAR  = "SCALAR"
+	METADATA_TYPE_VEC2    = "VEC2"
+	METADATA_TYPE_VEC3    = "VEC3"
+	METADATA_TYPE_VEC4    = "VEC4"
+	METADATA_TYPE_MAT2    = "MAT2"
+	METADATA_TYPE_MAT3    = "MAT3"
+	METADATA_TYPE_MAT4    = "MAT4"
+	METADATA_TYPE_STRING  = "STRING"
+	METADATA_TYPE_BOOLEAN = "BOOLEAN"
+	METADATA_TYPE_ENUM    = "ENUM"
+)
+
+const (
+	METADATA_COMPONENT_INT8    = "INT8"
+	METADATA_COMPONENT_UINT8   = "UINT8"
+	METADATA_COMPONENT_INT16   = "INT16"
+	METADATA_COMPONENT_UINT16  = "UINT16"
+	METADATA_COMPONENT_INT32   = "INT32"
+	METADATA_COMPONENT_UINT32  = "UINT32"
+	METADATA_COMPONENT_INT64   = "INT64"
+	METADATA_COMPONENT_UINT64  = "UINT64"
+	METADATA_COMPONENT_FLOAT32 = "FLOAT32"
+	METADATA_COMPONENT_FLOAT64 = "FLOAT64"
+)
+
+// FeatureId is an entry of EXT_mesh_features and EXT_instance_features.
+type FeatureId struct {
+	FeatureCount  uint32      `json:"featureCount"`
+	NullFeatureId *uint32     `json:"nullFeatureId,omitempty"`
+	Label         string      `json:"label,omitempty"`
+	Attribute     *uint32     `json:"attribute,omitempty"`
+	Texture       interface{} `json:"texture,omitempty"`
+	PropertyTable *uint32     `json:"propertyTable,omitempty"`
+}
+
+type MeshFeatures struct {
+	FeatureIds []FeatureId `json:"featureIds"`
+}
+
+type InstanceFeatures struct {
+	FeatureIds []FeatureId `json:"featureIds"`
+}
+
+type MeshGpuInstancing struct {
+	Attributes map[string]uint32 `json:"attributes"`
+}
+
+type CesiumRTC struct {
+	Center [3]float64 `json:"center"`
+}
+
+type StructuralMetadata struct {
+	Schema             *MetadataSchema `json:"schema,omitempty"`
+	SchemaUri          string          `json:"schemaUri,omitempty"`
+	PropertyTables     []PropertyTable `json:"propertyTables,omitempty"`
+	PropertyTextures   []interface{}   `json:"propertyTextures,omitempty"`
+	PropertyAttributes []interface{}   `json:"propertyAttributes,omitempty"`
+}
+
+type MetadataSchema struct {
+	Id      string                   `json:"id"`
+	Classes map[string]MetadataClass `json:"classes,omitempty"`
+	Enums   map[string]interface{}   `json:"enums,omitempty"`
+}
+
+type MetadataClass struct {
+	Name       string                   `json:"name,omitempty"`
+	Properties map[string]ClassProperty `json:"properties,omitempty"`
+}
+
+type ClassProperty struct {
+	// Name is the batch table name of the property when it is not a valid
+	// identifier.
+	Name          string      `json:"name,omitempty"`
+	Type          string      `json:"type"`
+	ComponentType string      `json:"componentType,omitempty"`
+	EnumType      string      `json:"enumType,omitempty"`
+	Array         bool        `json:"array,omitempty"`
+	Count         uint32      `json:"count,omitempty"`
+	Normalized    bool        `json:"normalized,omitempty"`
+	Offset        interface{} `json:"offset,omitempty"`
+	Scale         interface{} `json:"scale,omitempty"`
+	NoData        interface{} `json:"noData,omitempty"`
+}
+
+type PropertyTable struct {
+	Name       string                           `json:"name,omitempty"`
+	Class      string                           `json:"class"`
+	Count      uint32                           `json:"count"`
+	Properties map[string]PropertyTableProperty `json:"properties,omitempty"`
+}
+
+type PropertyTableProperty struct {
+	Values           uint32      `json:"values"`
+	ArrayOffsets     *uint32     `json:"arrayOffsets,omitempty"`
+	StringOffsets    *uint32     `json:"stringOffsets,omitempty"`
+	ArrayOffsetType  string      `json:"arrayOffsetType,omitempty"`
+	StringOffsetType string      `json:"stringOffsetType,omitempty"`
+	Offset           interface{} `json:"offset,omitempty"`
+	Scale            interface{} `json:"scale,omitempty"`
+}
+
+func metadataComponentType(componentType string) string {
+	switch componentType {
+	case COMPONENT_TYPE_BYTE:
+		return METADATA_COMPONENT_INT8
+	case COMPONENT_TYPE_UNSIGNED_BYTE:
+		return METADATA_COMPONENT_UINT8
+	case COMPONENT_TYPE_SHORT:
+		return METADATA_COMPONENT_INT16
+	case COMPONENT_TYPE_UNSIGNED_SHORT:
+		return METADATA_COMPONENT_UINT16
+	case COMPONENT_TYPE_INT:
+		return METADATA_COMPONENT_INT32
+	case COMPONENT_TYPE_UNSIGNED_INT:
+		return METADATA_COMPONENT_UINT32
+	case COMPONENT_TYPE_FLOAT:
+		return METADATA_COMPONENT_FLOAT32
+	case COMPONENT_TYPE_DOUBLE:
+		return METADATA_COMPONENT_FLOAT64
+	}
+	return ""
+}
+
+// addExtensionUsed adds ext to the extensions used by doc once.
+func addExtensionUsed(doc *gltf.Document, ext string) {
+	for _, e := range doc.ExtensionsUsed {
+		if e == ext {
+			return
+		}
+	}
+	doc.ExtensionsUsed = append(doc.ExtensionsUsed, ext)
+}
+
+// writeMetadataBufferView appends data to the last buffer of doc at an 8
+// byte boundary, as EXT_structural_metadata requires.
+func writeMetadataBufferView(doc *gltf.Document, data []byte) uint32 {
+	if len(doc.Buffers) == 0 {
+		doc.Buffers = append(doc.Buffers, new(gltf.Buffer))
+	}
+	if len(data) == 0 {
+		// Buffer views can't be empty, which happens with empty strings.
+		data = []byte{0}
+	}
+	index := uint32(len(doc.Buffers) - 1)
+	buffer := doc.Buffers[index]
+	buffer.Data = createPaddingBytes(buffer.Data, uint32(len(buffer.Data)), 8, 0)
+	offset := uint32(len(buffer.Data))
+	buffer.Data = append(buffer.Data, data...)
+	buffer.ByteLength = uint32(len(buffer.Data))
+	doc.BufferViews = append(doc.BufferViews, &gltf.BufferView{
+		Buffer:     index,
+		ByteOffset: offset,
+		ByteLength: uint32(len(data)),
+	})
+	return uint32(len(doc.BufferViews) - 1)
+}
+
+// metadataColumn is a property of the table with its encoded buffers.
+type metadataColumn struct {
+	property      ClassProperty
+	values        []byte
+	arrayOffsets  []byte
+	stringOffsets []byte
+}
+
+func writeLittleEndian(data interface{}) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, littleEndian, data)
+	return buf.Bytes()
+}
+
+// newBinaryMetadataColumn encodes a binary batch table property, which
+// keeps its component type.
+func newBinaryMetadataColumn(ref BinaryBodyReference, data interface{}, propName string, count int) (*metadataColumn, error) {
+	componentType := metadataComponentType(ref.ComponentType)
+	size := ContainerTypeSize(ref.ContainerType)
+	if componentType == "" || size == 0 {
+		return nil, newTileError("", propName, -1, ErrBadReference)
+	}
+	values, err := getBatchTableBinaryByte(&ref, data)
+	if err != nil {
+		return nil, newTileError("", propName, -1, ErrBadValue)
+	}
+	if len(values) < count*size*ComponentTypeSize(ref.ComponentType) {
+		return nil, newTileError("", propName, -1, ErrBadValue)
+	}
+	return &metadataColumn{
+		property: ClassProperty{Type: ref.ContainerType, ComponentType: componentType},
+		values:   values[:count*size*ComponentTypeSize(ref.ComponentType)],
+	}, nil
+}
+
+func isMetadataInt32(v float64) bool {
+	return v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32
+}
+
+// newJSONMetadataColumn encodes a JSON batch table property. Numbers become
+// INT32 when they are all integers and FLOAT64 otherwise, and arrays of
+// numbers or strings become array properties. Null values are stored as
+// noData, and values of any other shape are stored as JSON strings.
+func newJSONMetadataColumn(values []interface{}) *metadataColumn {
+	var numbers, strs, bools, arrays, nulls int
+	for _, v := range values {
+		switch v.(type) {
+		case nil:
+			nulls++
+		case float64:
+			numbers++
+		case string:
+			strs++
+		case bool:
+			bools++
+		case []interface{}:
+			arrays++
+		}
+	}
+	n := len(values) - nulls
+	switch {
+	case n == 0 || numbers == n:
+		return newNumberMetadataColumn(values, nulls > 0)
+	case strs == n:
+		col := newStringMetadataColumn(values, nil)
+		if nulls > 0 {
+			col.property.NoData = ""
+		}
+		return col
+	case bools == n && nulls == 0:
+		bits := make([]byte, (len(values)+7)/8)
+		for i, v := range values {
+			if v.(bool) {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		return &metadataColumn{property: ClassProperty{Type: METADATA_TYPE_BOOLEAN}, values: bits}
+	case arrays == n:
+		if col := newArrayMetadataColumn(values); col != nil {
+			return col
+		}
+	}
+
+	strValues := make([]interface{}, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			strValues[i] = s
+		} else if b, err := json.Marshal(v); err == nil {
+			strValues[i] = string(b)
+		}
+	}
+	return newStringMetadataColumn(strValues, nil)
+}
+
+func newNumberMetadataColumn(values []interface{}, hasNull bool) *metadataColumn {
+	integers := true
+	for _, v := range values {
+		if f, ok := v.(float64); ok && !isMetadataInt32(f) {
+			integers = false
+		}
+	}
+	col := &metadataColumn{property: ClassProperty{Type: METADATA_TYPE_SCALAR}}
+	if integers {
+		col.property.ComponentType = METADATA_COMPONENT_INT32
+		data := make([]int32, len(values))
+		for i, v := range values {
+			if f, ok := v.(float64); ok {
+				data[i] = int32(f)
+			} else {
+				data[i] = math.MinInt32
+			}
+		}
+		if hasNull {
+			col.property.NoData = math.MinInt32
+		}
+		col.values = writeLittleEndian(data)
+		return col
+	}
+	col.property.ComponentType = METADATA_COMPONENT_FLOAT64
+	data := make([]float64, len(values))
+	for i, v := range values {
+		if f, ok := v.(float64); ok {
+			data[i] = f
+		} else {
+			data[i] = -math.MaxFloat64
+		}
+	}
+	if hasNull {
+		col.property.NoData = -math.MaxFloat64
+	}
+	col.values = writeLittleEndian(data)
+	return col
+}
+
+// newStringMetadataColumn encodes strings with UINT32 string offsets.
+// When counts is set, values are the flattened elements of variable length
+// arrays of strings.
+func newStringMetadataColumn(values []interface{}, counts []int) *metadataColumn {
+	var data []byte
+	offsets := make([]uint32, 0, len(values)+1)
+	for _, v := range values {
+		offsets = append(offsets, uint32(len(data)))
+		s, _ := v.(string)
+		data = append(data, s...)
+	}
+	offsets = append(offsets, uint32(len(data)))
+	col := &metadataColumn{
+		property:      ClassProperty{Type: METADATA_TYPE_STRING},
+		values:        data,
+		stringOffsets: writeLittleEndian(offsets),
+	}
+	if counts != nil {
+		col.property.Array = true
+		col.arrayOffsets = writeLittleEndian(metadataArrayOffsets(counts))
+	}
+	return col
+}
+
+// metadataArrayOffsets returns the offsets of arrays of the given lengths,
+// which are element indices.
+func metadataArrayOffsets(counts []int) []uint32 {
+	offsets := make([]uint32, 0, len(counts)+1)
+	var offset uint32
+	for _, c := range counts {
+		offsets = append(offsets, offset)
+		offset += uint32(c)
+	}
+	return append(offsets, offset)
+}
+
+// newArrayMetadataColumn encodes arrays of numbers or of strings, as fixed
+// length arrays when all arrays have the same length. It returns nil for
+// arrays of other values.
+func newArrayMetadataColumn(values []interface{}) *metadataColumn {
+	counts := make([]int, len(values))
+	var elems []interface{}
+	var numbers, strs int
+	for i, v := range values {
+		arr, _ := v.([]interface{})
+		counts[i] = len(arr)
+		for _, e := range arr {
+			switch e.(type) {
+			case float64:
+				numbers++
+			case string:
+				strs++
+			}
+		}
+		elems = append(elems, arr...)
+	}
+	fixed := true
+	for _, c := range counts {
+		if c != counts[0] || c == 0 {
+			fixed = false
+		}
+	}
+	if len(elems) == 0 || (numbers != len(elems) && strs != len(elems)) {
+		return nil
+	}
+
+	var col *metadataColumn
+	if strs == len(elems) {
+		col = newStringMetadataColumn(elems, counts)
+	} else {
+		col = newNumberMetadataColumn(elems, false)
+		col.property.Array = true
+		col.arrayOffsets = writeLittleEndian(metadataArrayOffsets(counts))
+	}
+	if fixed {
+		col.property.Count = uint32(counts[0])
+		col.arrayOffsets = nil
+	}
+	return col
+}
+
+// metadataPropertyId returns a valid identifier for name that is not in
+// used.
+func metadataPropertyId(name string, used map[string]bool) string {
+	var sb strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	id := sb.String()
+	if id == "" {
+		id = "_"
+	}
+	for base, i := id, 1; used[id]; i++ {
+		id = base + "_" + strconv.Itoa(i)
+	}
+	used[id] = true
+	return id
+}
+
+// batchTableMetadataColumns returns the columns of t for count features
+// by property name. Properties of the batch table hierarchy are flattened
+// into the features that inherit them.
+func batchTableMetadataColumns(t *BatchTable, count int) (map[string]*metadataColumn, error) {
+	ret := make(map[string]*metadataColumn)
+	for k, v := range t.Header {
+		switch ref := v.(type) {
+		case BinaryBodyReference:
+			col, err := newBinaryMetadataColumn(ref, t.Data[k], k, count)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = col
+		case []interface{}:
+			if len(ref) < count {
+				return nil, newTileError("", k, -1, ErrBadValue)
+			}
+			ret[k] = newJSONMetadataColumn(ref[:count])
+		}
+	}
+	hierarchy, err := flattenBatchTableHierarchy(t.Header, count)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range hierarchy {
+		if _, ok := ret[k]; !ok {
+			ret[k] = newJSONMetadataColumn(values)
+		}
+	}
+	return ret, nil
+}
+
+// setBatchTableMetadata adds the batch table t of count features to doc as
+// the first property table of EXT_structural_metadata. It returns false
+// when t has no properties.
+func setBatchTableMetadata(doc *gltf.Document, t *BatchTable, count int) (bool, error) {
+	cols, err := batchTableMetadataColumns(t, count)
+	if err != nil || len(cols) == 0 {
+		return false, err
+	}
+	names := make([]string, 0, len(cols))
+	for k := range cols {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	class := MetadataClass{Properties: make(map[string]ClassProperty)}
+	table := PropertyTable{
+		Class:      METADATA_CLASS_FEATURE,
+		Count:      uint32(count),
+		Properties: make(map[string]PropertyTableProperty),
+	}
+	used := make(map[string]bool)
+	for _, k := range names {
+		col := cols[k]
+		id := metadataPropertyId(k, used)
+		if id != k {
+			col.property.Name = k
+		}
+		class.Properties[id] = col.property
+		prop := PropertyTableProperty{Values: writeMetadataBufferView(doc, col.values)}
+		if col.arrayOffsets != nil {
+			prop.ArrayOffsets = gltf.Index(writeMetadataBufferView(doc, col.arrayOffsets))
+			prop.ArrayOffsetType = METADATA_COMPONENT_UINT32
+		}
+		if col.stringOffsets != nil {
+			prop.StringOffsets = gltf.Index(writeMetadataBufferView(doc, col.stringOffsets))
+			prop.StringOffsetType = METADATA_COMPONENT_UINT32
+		}
+		table.Properties[id] = prop
+	}
+
+	if doc.Extensions == nil {
+		doc.Extensions = make(gltf.Extensions)
+	}
+	doc.Extensions[EXT_STRUCTURAL_METADATA] = &StructuralMetadata{
+		Schema: &MetadataSchema{
+			Id:      METADATA_SCHEMA_ID,
+			Classes: map[string]MetadataClass{METADATA_CLASS_FEATURE: class},
+		},
+		PropertyTables: []PropertyTable{table},
+	}
+	addExtensionUsed(doc, EXT_STRUCTURAL_METADATA)
+	return true, nil
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package tile3d
+
+import (
+	"os"
+	"syscall"
+)
+
+// MmapFile maps the file at path read-only into memory, for use with the
+// ReadFromBytes methods. Tiles read from data must not be used after
+// unmap is called, and their binary arrays must not be written to.
+func MmapFile(path string) (data []byte, unmap func() error, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.Size() == 0 {
+		return []byte{}, func() error { return nil }, nil
+	}
+	data, err = syscall.Mmap(int(f.Fd()), 0, int(st.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
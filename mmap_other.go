@@ -0,0 +1,15 @@
+//go:build !linux
+
+package tile3d
+
+import "os"
+
+// MmapFile reads the file at path into memory on platforms without mmap
+// support, so callers can use it unconditionally.
+func MmapFile(path string) (data []byte, unmap func() error, err error) {
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -79,6 +79,21 @@ func decodeValue(val uint16) [3]float32 {
 	return n
 }
 
+// decodeOct32P decodes a normal stored as two 16-bit oct encoded
+// components.
+func decodeOct32P(x uint16, y uint16) [3]float32 {
+	ex := float32(x)/65535.0*2.0 - 1.0
+	ey := float32(y)/65535.0*2.0 - 1.0
+	n := [3]float32{ex, ey, 1 - float32(math.Abs(float64(ex))+math.Abs(float64(ey)))}
+	if n[2] < 0 {
+		x := n[0]
+		y := n[1]
+		n[0] = (1 - float32(math.Abs(float64(y)))) * signNotZero(x)
+		n[1] = (1 - float32(math.Abs(float64(x)))) * signNotZero(y)
+	}
+	return normalizeInPlace(n)
+}
+
 const smallMetricDistance = 1.0e-6
 
 func inverseMetricDistance(a float64) *float64 {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"math"
 )
 
 const (
@@ -18,6 +19,7 @@ const (
 	PNTS_PROP_QUANTIZED_VOLUME_OFFSET = "QUANTIZED_VOLUME_OFFSET"
 	PNTS_PROP_QUANTIZED_VOLUME_SCALE  = "QUANTIZED_VOLUME_SCALE"
 	PNTS_PROP_NORMAL                  = "NORMAL"
+	PNTS_PROP_NORMAL_OCT16P           = "NORMAL_OCT16P"
 	PNTS_PROP_NORMAL_OCT32P           = "NORMAL_OCT32P"
 	PNTS_PROP_POINTS_LENGTH           = "POINTS_LENGTH"
 	PNTS_PROP_CONSTANT_RGBA           = "CONSTANT_RGBA"
@@ -101,152 +103,234 @@ type PntsFeatureTableView struct {
 	BatchLength           *uint32
 }
 
-func PntsFeatureTableDecode(header map[string]interface{}, buff []byte) map[string]interface{} {
+func PntsFeatureTableDecode(header map[string]interface{}, buff []byte) (map[string]interface{}, error) {
+	ret, _, err := pntsFeatureTableDecode(header, buff)
+	return ret, err
+}
+
+func pntsFeatureTableDecode(header map[string]interface{}, buff []byte) (map[string]interface{}, *dracoPointCloudData, error) {
 	ret := make(map[string]interface{})
-	pointsLength := getIntegerScalarFeatureValue(header, buff, PNTS_PROP_POINTS_LENGTH)
-	ret[PNTS_PROP_POINTS_LENGTH] = pointsLength
-	if _, ok := header[PNTS_PROP_BATCH_LENGTH]; ok {
-		ret[PNTS_PROP_BATCH_LENGTH] = getFloatVec3FeatureValue(header, buff, PNTS_PROP_BATCH_LENGTH)
+	props, err := getDracoProperties(header)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	constantRgba := getUnsignedByteArrayFeatureValue(header, buff, PNTS_PROP_CONSTANT_RGBA, 4)
-	if constantRgba != nil {
-		ret[PNTS_PROP_CONSTANT_RGBA] = constantRgba
+	limit := len(buff)
+	if props != nil {
+		limit = int(math.Min(float64(len(buff))*dracoMaxPointsPerByte, math.MaxInt32))
+	}
+	pointsLength, err := getCountFeatureValue(header, PNTS_PROP_POINTS_LENGTH, limit)
+	if err != nil {
+		return nil, nil, err
 	}
+	ret[PNTS_PROP_POINTS_LENGTH] = pointsLength
 
-	rgba := getUnsignedByteArrayFeatureValue(header, buff, PNTS_PROP_RGBA, 3)
-	if rgba != nil {
-		ret[PNTS_PROP_RGBA] = rgba
+	var draco *dracoPointCloudData
+	if props != nil {
+		if draco, err = decodePntsDraco(header, buff, int(pointsLength)); err != nil {
+			return nil, nil, err
+		}
+		if err := setPntsDracoSemantics(ret, header, draco, props); err != nil {
+			return nil, nil, err
+		}
+		uncompressed := make(map[string]interface{}, len(header))
+		for k, v := range header {
+			if _, ok := props[k]; !ok {
+				uncompressed[k] = v
+			}
+		}
+		header = uncompressed
+	}
+	if _, ok := header[PNTS_PROP_BATCH_LENGTH]; ok {
+		if ret[PNTS_PROP_BATCH_LENGTH], err = getCountFeatureValue(header, PNTS_PROP_BATCH_LENGTH, math.MaxInt32); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	rgb := getUnsignedByteArrayFeatureValue(header, buff, PNTS_PROP_RGB, 3)
-	if rgb != nil {
-		ret[PNTS_PROP_RGB] = rgb
+	if _, ok := header[PNTS_PROP_RTC_CENTER]; ok {
+		if ret[PNTS_PROP_RTC_CENTER], err = getFloat64Vec3FeatureValue(header, buff, PNTS_PROP_RTC_CENTER); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	floatArrayValue := getFloatArrayFeatureValue(header, buff, PNTS_PROP_POSITION, int(pointsLength*3))
-	if floatArrayValue != nil {
-		ret[PNTS_PROP_POSITION] = floatArrayValue
+	if _, ok := header[PNTS_PROP_POSITION]; ok {
+		if ret[PNTS_PROP_POSITION], err = getFloatVec3ArrayFeatureValue(header, buff, PNTS_PROP_POSITION, int(pointsLength)); err != nil {
+			return nil, nil, err
+		}
 	}
-	unsignedShortArrayValue := getUnsignedShortArrayFeatureValue(header, buff, PNTS_PROP_POSITION_QUANTIZED, int(pointsLength*3))
-	if unsignedShortArrayValue != nil {
-		ret[PNTS_PROP_POSITION_QUANTIZED] = unsignedShortArrayValue
-		ret[PNTS_PROP_QUANTIZED_VOLUME_OFFSET] = getFloatVec3FeatureValue(header, buff, I3DM_PROP_QUANTIZED_VOLUME_OFFSET)
-		ret[PNTS_PROP_QUANTIZED_VOLUME_SCALE] = getFloatVec3FeatureValue(header, buff, I3DM_PROP_QUANTIZED_VOLUME_SCALE)
+
+	if _, ok := header[PNTS_PROP_POSITION_QUANTIZED]; ok {
+		if ret[PNTS_PROP_POSITION_QUANTIZED], err = getUnsignedShortVec3ArrayFeatureValue(header, buff, PNTS_PROP_POSITION_QUANTIZED, int(pointsLength)); err != nil {
+			return nil, nil, err
+		}
+		if header[PNTS_PROP_QUANTIZED_VOLUME_OFFSET] == nil || header[PNTS_PROP_QUANTIZED_VOLUME_SCALE] == nil {
+			return nil, nil, newTileError("", PNTS_PROP_POSITION_QUANTIZED, -1, ErrBadValue)
+		}
 	}
 	if _, ok := header[PNTS_PROP_QUANTIZED_VOLUME_OFFSET]; ok {
-		ret[PNTS_PROP_QUANTIZED_VOLUME_OFFSET] = getFloatVec3FeatureValue(header, buff, PNTS_PROP_QUANTIZED_VOLUME_OFFSET)
+		if ret[PNTS_PROP_QUANTIZED_VOLUME_OFFSET], err = getFloatVec3FeatureValue(header, buff, PNTS_PROP_QUANTIZED_VOLUME_OFFSET); err != nil {
+			return nil, nil, err
+		}
 	}
 	if _, ok := header[PNTS_PROP_QUANTIZED_VOLUME_SCALE]; ok {
-		ret[PNTS_PROP_QUANTIZED_VOLUME_SCALE] = getFloatVec3FeatureValue(header, buff, PNTS_PROP_QUANTIZED_VOLUME_SCALE)
+		if ret[PNTS_PROP_QUANTIZED_VOLUME_SCALE], err = getFloatVec3FeatureValue(header, buff, PNTS_PROP_QUANTIZED_VOLUME_SCALE); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	if _, ok := header[PNTS_PROP_RTC_CENTER]; ok {
-		ret[PNTS_PROP_RTC_CENTER] = getFloat64Vec3FeatureValue(header, buff, PNTS_PROP_QUANTIZED_VOLUME_SCALE)
-	}
-
-	reference := getBinaryBodyReference(header, PNTS_PROP_RGBA)
-	if reference != nil {
-		buf := bytes.NewBuffer(buff[reference.ByteOffset:])
-		r := make([][4]byte, pointsLength)
-		err := binary.Read(buf, littleEndian, r)
-		if err != nil {
-			return nil
-		}
-		ret[PNTS_PROP_RGBA] = r
-	} else {
-		reference = getBinaryBodyReference(header, PNTS_PROP_RGB)
-		if reference != nil {
-			buf := bytes.NewBuffer(buff[reference.ByteOffset:])
-			r := make([][3]byte, pointsLength)
-			err := binary.Read(buf, littleEndian, r)
-			if err != nil {
-				return nil
-			}
-			ret[PNTS_PROP_RGB] = r
-		} else {
-			reference = getBinaryBodyReference(header, PNTS_PROP_RGB565)
-			if reference != nil {
-				buf := bytes.NewBuffer(buff[reference.ByteOffset:])
-				r := make([]uint16, pointsLength)
-				err := binary.Read(buf, littleEndian, r)
-				if err != nil {
-					return nil
-				}
-				ret[PNTS_PROP_RGB565] = r
-			}
+	if ret[PNTS_PROP_POSITION] == nil && ret[PNTS_PROP_POSITION_QUANTIZED] == nil && pointsLength > 0 {
+		return nil, nil, newTileError("", PNTS_PROP_POSITION, -1, ErrBadValue)
+	}
+
+	if _, ok := header[PNTS_PROP_CONSTANT_RGBA]; ok {
+		if ret[PNTS_PROP_CONSTANT_RGBA], err = getUnsignedByteVec4FeatureValue(header, buff, PNTS_PROP_CONSTANT_RGBA); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	floatArrayValue = getFloatArrayFeatureValue(header, buff, PNTS_PROP_NORMAL, int(pointsLength*3))
-	if floatArrayValue != nil {
-		ret[PNTS_PROP_NORMAL] = floatArrayValue
+	if ref := getBinaryBodyReference(header, PNTS_PROP_RGBA); ref != nil {
+		if ret[PNTS_PROP_RGBA], err = readBinaryArray[[4]uint8](buff, *ref, PNTS_PROP_RGBA, int(pointsLength), 4); err != nil {
+			return nil, nil, err
+		}
 	}
-	byteArrayValue := getUnsignedByteArrayFeatureValue(header, buff, PNTS_PROP_NORMAL_OCT32P, int(pointsLength*2))
-	if byteArrayValue != nil {
-		ret[PNTS_PROP_NORMAL_OCT32P] = floatArrayValue
+	if ref := getBinaryBodyReference(header, PNTS_PROP_RGB); ref != nil {
+		if ret[PNTS_PROP_RGB], err = readBinaryArray[[3]uint8](buff, *ref, PNTS_PROP_RGB, int(pointsLength), 3); err != nil {
+			return nil, nil, err
+		}
 	}
-	unsignedIntArrayValue := getBatchLength(header, buff, int(pointsLength))
-	if unsignedIntArrayValue != nil {
-		ret[PNTS_PROP_BATCH_ID] = unsignedIntArrayValue
+	if ref := getBinaryBodyReference(header, PNTS_PROP_RGB565); ref != nil {
+		if ret[PNTS_PROP_RGB565], err = readBinaryArray[uint16](buff, *ref, PNTS_PROP_RGB565, int(pointsLength), 2); err != nil {
+			return nil, nil, err
+		}
 	}
-	return ret
+
+	if _, ok := header[PNTS_PROP_NORMAL]; ok {
+		if ret[PNTS_PROP_NORMAL], err = getFloatVec3ArrayFeatureValue(header, buff, PNTS_PROP_NORMAL, int(pointsLength)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if ref := getBinaryBodyReference(header, PNTS_PROP_NORMAL_OCT16P); ref != nil {
+		if ret[PNTS_PROP_NORMAL_OCT16P], err = readBinaryArray[[2]uint8](buff, *ref, PNTS_PROP_NORMAL_OCT16P, int(pointsLength), 2); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, ok := header[PNTS_PROP_NORMAL_OCT32P]; ok {
+		if ret[PNTS_PROP_NORMAL_OCT32P], err = getUnsignedShortVec2ArrayFeatureValue(header, buff, PNTS_PROP_NORMAL_OCT32P, int(pointsLength)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	batchIds, err := getBatchLength(header, buff, int(pointsLength))
+	if err != nil {
+		return nil, nil, err
+	}
+	if batchIds != nil {
+		if _, ok := header[PNTS_PROP_BATCH_LENGTH]; !ok {
+			return nil, nil, newTileError("", PNTS_PROP_BATCH_LENGTH, -1, ErrBadValue)
+		}
+		ret[PNTS_PROP_BATCH_ID] = batchIds
+	}
+	return ret, draco, nil
 }
 
-func PntsFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) []byte {
+func PntsFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) ([]byte, error) {
+	return pntsFeatureTableEncode(header, data, 0)
+}
+
+func pntsFeatureTableEncode(header map[string]interface{}, data map[string]interface{}, offset int) ([]byte, error) {
 	var out []byte
 	buf := bytes.NewBuffer(out)
-	offset := 0
+	// Every property starts at a multiple of its component size.
+	align := func(size int) {
+		for ; offset%size != 0; offset++ {
+			buf.WriteByte(0)
+		}
+	}
 
 	if t := data[PNTS_PROP_POSITION]; t != nil {
-		dt := t.([][3]float32)
+		dt, ok := t.([][3]float32)
+		if !ok {
+			return nil, newTileError("", PNTS_PROP_POSITION, -1, ErrBadValue)
+		}
+		align(4)
 		binary.Write(buf, littleEndian, dt)
 		header[PNTS_PROP_POSITION] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC3}
 		offset += (len(dt) * 3 * 4)
 	}
 
 	if t := data[PNTS_PROP_POSITION_QUANTIZED]; t != nil {
-		dt := t.([][3]uint16)
+		dt, ok := t.([][3]uint16)
+		if !ok {
+			return nil, newTileError("", PNTS_PROP_POSITION_QUANTIZED, -1, ErrBadValue)
+		}
+		align(2)
 		binary.Write(buf, littleEndian, dt)
 		header[PNTS_PROP_POSITION_QUANTIZED] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_VEC3}
 		offset += (len(dt) * 3 * 2)
 	}
 
 	if t := data[PNTS_PROP_RGBA]; t != nil {
-		dt := t.([][4]uint8)
+		dt, ok := t.([][4]uint8)
+		if !ok {
+			return nil, newTileError("", PNTS_PROP_RGBA, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[PNTS_PROP_RGBA] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE, ContainerType: CONTAINER_TYPE_VEC4}
 		offset += (len(dt) * 4)
 	}
 
 	if t := data[PNTS_PROP_RGB]; t != nil {
-		dt := t.([][3]uint8)
+		dt, ok := t.([][3]uint8)
+		if !ok {
+			return nil, newTileError("", PNTS_PROP_RGB, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[PNTS_PROP_RGB] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE, ContainerType: CONTAINER_TYPE_VEC3}
 		offset += (len(dt) * 3)
 	}
 
 	if t := data[PNTS_PROP_RGB565]; t != nil {
-		dt := t.([]uint16)
+		dt, ok := t.([]uint16)
+		if !ok {
+			return nil, newTileError("", PNTS_PROP_RGB565, -1, ErrBadValue)
+		}
+		align(2)
 		binary.Write(buf, littleEndian, dt)
 		header[PNTS_PROP_RGB565] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 2)
 	}
 
 	if t := data[PNTS_PROP_NORMAL]; t != nil {
-		dt := t.([][3]float32)
+		dt, ok := t.([][3]float32)
+		if !ok {
+			return nil, newTileError("", PNTS_PROP_NORMAL, -1, ErrBadValue)
+		}
+		align(4)
 		binary.Write(buf, littleEndian, dt)
 		header[PNTS_PROP_NORMAL] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_VEC3}
 		offset += (len(dt) * 3 * 4)
 	}
 
-	if t := data[PNTS_PROP_NORMAL_OCT32P]; t != nil {
-		dt := t.([][2]uint8)
+	if t := data[PNTS_PROP_NORMAL_OCT16P]; t != nil {
+		dt, ok := t.([][2]uint8)
+		if !ok {
+			return nil, newTileError("", PNTS_PROP_NORMAL_OCT16P, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
-		header[PNTS_PROP_NORMAL_OCT32P] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE, ContainerType: CONTAINER_TYPE_VEC2}
+		header[PNTS_PROP_NORMAL_OCT16P] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE, ContainerType: CONTAINER_TYPE_VEC2}
 		offset += (len(dt) * 2)
 	}
 
+	if t := data[PNTS_PROP_NORMAL_OCT32P]; t != nil {
+		dt, ok := t.([][2]uint16)
+		if !ok {
+			return nil, newTileError("", PNTS_PROP_NORMAL_OCT32P, -1, ErrBadValue)
+		}
+		align(2)
+		binary.Write(buf, littleEndian, dt)
+		header[PNTS_PROP_NORMAL_OCT32P] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_VEC2}
+		offset += (len(dt) * 4)
+	}
+
 	if data[PNTS_PROP_BATCH_ID] != nil {
 		switch dt := data[PNTS_PROP_BATCH_ID].(type) {
 		case []uint8:
@@ -254,23 +338,28 @@ func PntsFeatureTableEncode(header map[string]interface{}, data map[string]inter
 			header[PNTS_PROP_BATCH_ID] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE}
 			offset += len(dt)
 		case []uint16:
+			align(2)
 			binary.Write(buf, littleEndian, dt)
 			header[PNTS_PROP_BATCH_ID] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT}
 			offset += (len(dt) * 2)
 		case []uint32:
+			align(4)
 			binary.Write(buf, littleEndian, dt)
 			header[PNTS_PROP_BATCH_ID] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_INT}
 			offset += (len(dt) * 4)
+		default:
+			return nil, newTileError("", PNTS_PROP_BATCH_ID, -1, ErrBadValue)
 		}
 	}
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 type Pnts struct {
 	Header       PntsHeader
 	FeatureTable FeatureTable
 	BatchTable   BatchTable
+	Draco        *PntsDraco
 }
 
 func NewPnts() *Pnts {
@@ -322,21 +411,21 @@ func (m *Pnts) SetFeatureTable(view PntsFeatureTableView) {
 	}
 
 	if len(view.NormalOCT16P) > 0 {
-		m.FeatureTable.Header[PNTS_PROP_NORMAL_OCT32P] = BinaryBodyReference{ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE, ContainerType: CONTAINER_TYPE_VEC2}
-		m.FeatureTable.Data[PNTS_PROP_NORMAL_OCT32P] = view.NormalOCT16P
+		m.FeatureTable.Header[PNTS_PROP_NORMAL_OCT16P] = BinaryBodyReference{ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE, ContainerType: CONTAINER_TYPE_VEC2}
+		m.FeatureTable.Data[PNTS_PROP_NORMAL_OCT16P] = view.NormalOCT16P
 	}
 
 	if view.BatchId != nil {
 		switch t := view.BatchId.(type) {
 		case []uint8:
 			m.FeatureTable.Header[PNTS_PROP_BATCH_ID] = BinaryBodyReference{ComponentType: COMPONENT_TYPE_UNSIGNED_BYTE, ContainerType: CONTAINER_TYPE_SCALAR}
-			m.FeatureTable.Header[PNTS_PROP_BATCH_ID] = t
+			m.FeatureTable.Data[PNTS_PROP_BATCH_ID] = t
 		case []uint16:
 			m.FeatureTable.Header[PNTS_PROP_BATCH_ID] = BinaryBodyReference{ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
-			m.FeatureTable.Header[PNTS_PROP_BATCH_ID] = t
+			m.FeatureTable.Data[PNTS_PROP_BATCH_ID] = t
 		case []uint32:
 			m.FeatureTable.Header[PNTS_PROP_BATCH_ID] = BinaryBodyReference{ComponentType: COMPONENT_TYPE_UNSIGNED_INT, ContainerType: CONTAINER_TYPE_SCALAR}
-			m.FeatureTable.Header[PNTS_PROP_BATCH_ID] = t
+			m.FeatureTable.Data[PNTS_PROP_BATCH_ID] = t
 		case []int64:
 			max := maxBatchId(t)
 			if max > 0xFFFF {
@@ -409,7 +498,7 @@ func (m *Pnts) GetFeatureTableView() PntsFeatureTableView {
 		ret.Normal = t.([][3]float32)
 	}
 
-	if t := m.FeatureTable.Data[PNTS_PROP_NORMAL_OCT32P]; t != nil {
+	if t := m.FeatureTable.Data[PNTS_PROP_NORMAL_OCT16P]; t != nil {
 		ret.NormalOCT16P = t.([][2]uint8)
 	}
 
@@ -417,31 +506,223 @@ func (m *Pnts) GetFeatureTableView() PntsFeatureTableView {
 		ret.BatchId = m.FeatureTable.Data[PNTS_PROP_BATCH_ID]
 	}
 
-	ret.PointsLength = m.FeatureTable.Header[PNTS_PROP_POINTS_LENGTH].(uint32)
+	ret.PointsLength = uint32(m.PointsLength())
 
-	if m.FeatureTable.Header[PNTS_PROP_RTC_CENTER] != nil {
-		ret.RtcCenter = m.FeatureTable.Header[PNTS_PROP_RTC_CENTER].([]float64)
+	if v, ok := m.FeatureTable.getVec3(PNTS_PROP_RTC_CENTER); ok {
+		ret.RtcCenter = v[:]
 	}
 
-	if m.FeatureTable.Header[PNTS_PROP_QUANTIZED_VOLUME_OFFSET] != nil {
-		ret.QuantizedVolumeOffset = m.FeatureTable.Header[PNTS_PROP_QUANTIZED_VOLUME_OFFSET].([]float32)
+	if v, ok := m.FeatureTable.getVec3(PNTS_PROP_QUANTIZED_VOLUME_OFFSET); ok {
+		ret.QuantizedVolumeOffset = []float32{float32(v[0]), float32(v[1]), float32(v[2])}
 	}
 
-	if m.FeatureTable.Header[PNTS_PROP_QUANTIZED_VOLUME_SCALE] != nil {
-		ret.QuantizedVolumeScale = m.FeatureTable.Header[PNTS_PROP_QUANTIZED_VOLUME_SCALE].([]float32)
+	if v, ok := m.FeatureTable.getVec3(PNTS_PROP_QUANTIZED_VOLUME_SCALE); ok {
+		ret.QuantizedVolumeScale = []float32{float32(v[0]), float32(v[1]), float32(v[2])}
 	}
 
-	if m.FeatureTable.Header[PNTS_PROP_CONSTANT_RGBA] != nil {
-		ret.ConstantRGBA = m.FeatureTable.Header[PNTS_PROP_CONSTANT_RGBA].([]uint8)
+	if c, ok := m.getConstantRGBA(); ok {
+		ret.ConstantRGBA = c[:]
 	}
 
-	if m.FeatureTable.Header[PNTS_PROP_BATCH_LENGTH] != nil {
-		d := m.FeatureTable.Header[PNTS_PROP_BATCH_LENGTH].(uint32)
+	if n, ok := m.FeatureTable.getCount(PNTS_PROP_BATCH_LENGTH); ok {
+		d := uint32(n)
 		ret.BatchLength = &d
 	}
 	return ret
 }
 
+func (m *Pnts) getConstantRGBA() ([4]uint8, bool) {
+	if t, ok := m.FeatureTable.Data[PNTS_PROP_CONSTANT_RGBA].([4]uint8); ok {
+		return t, true
+	}
+	if m.FeatureTable.Header[PNTS_PROP_CONSTANT_RGBA] == nil {
+		return [4]uint8{}, false
+	}
+	v, err := getUnsignedByteVec4FeatureValue(m.FeatureTable.Header, nil, PNTS_PROP_CONSTANT_RGBA)
+	return v, err == nil
+}
+
+// PointsLength returns the number of points in the tile.
+func (m *Pnts) PointsLength() int {
+	n, _ := m.FeatureTable.getCount(PNTS_PROP_POINTS_LENGTH)
+	return n
+}
+
+// BatchLength returns the number of rows in the batch table: BATCH_LENGTH
+// when points are batched, otherwise one per point.
+func (m *Pnts) BatchLength() int {
+	if n, ok := m.FeatureTable.getCount(PNTS_PROP_BATCH_LENGTH); ok {
+		return n
+	}
+	return m.PointsLength()
+}
+
+// Positions returns the point positions with quantization and RTC_CENTER
+// applied.
+func (m *Pnts) Positions() ([][3]float64, error) {
+	var rtc [3]float64
+	if v, ok := m.FeatureTable.getVec3(PNTS_PROP_RTC_CENTER); ok {
+		rtc = v
+	}
+	n := m.PointsLength()
+	if t := m.FeatureTable.Data[PNTS_PROP_POSITION]; t != nil {
+		pos, ok := t.([][3]float32)
+		if !ok || len(pos) < n {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_POSITION, -1, ErrBadValue)
+		}
+		ret := make([][3]float64, n)
+		for i := range ret {
+			for j := 0; j < 3; j++ {
+				ret[i][j] = float64(pos[i][j]) + rtc[j]
+			}
+		}
+		return ret, nil
+	}
+	if t := m.FeatureTable.Data[PNTS_PROP_POSITION_QUANTIZED]; t != nil {
+		pos, ok := t.([][3]uint16)
+		if !ok || len(pos) < n {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_POSITION_QUANTIZED, -1, ErrBadValue)
+		}
+		offset, ok := m.FeatureTable.getVec3(PNTS_PROP_QUANTIZED_VOLUME_OFFSET)
+		if !ok {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_QUANTIZED_VOLUME_OFFSET, -1, ErrBadValue)
+		}
+		scale, ok := m.FeatureTable.getVec3(PNTS_PROP_QUANTIZED_VOLUME_SCALE)
+		if !ok {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_QUANTIZED_VOLUME_SCALE, -1, ErrBadValue)
+		}
+		ret := make([][3]float64, n)
+		for i := range ret {
+			for j := 0; j < 3; j++ {
+				ret[i][j] = float64(pos[i][j])*scale[j]/65535.0 + offset[j] + rtc[j]
+			}
+		}
+		return ret, nil
+	}
+	if n > 0 {
+		return nil, newTileError(PNTS_MAGIC, PNTS_PROP_POSITION, -1, ErrBadValue)
+	}
+	return nil, nil
+}
+
+// Colors returns one RGBA color per point from RGBA, RGB, RGB565 or
+// CONSTANT_RGBA, in that order of precedence. It returns nil when the
+// tile has no color.
+func (m *Pnts) Colors() ([][4]uint8, error) {
+	n := m.PointsLength()
+	ret := make([][4]uint8, n)
+	if t := m.FeatureTable.Data[PNTS_PROP_RGBA]; t != nil {
+		c, ok := t.([][4]uint8)
+		if !ok || len(c) < n {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_RGBA, -1, ErrBadValue)
+		}
+		copy(ret, c)
+		return ret, nil
+	}
+	if t := m.FeatureTable.Data[PNTS_PROP_RGB]; t != nil {
+		c, ok := t.([][3]uint8)
+		if !ok || len(c) < n {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_RGB, -1, ErrBadValue)
+		}
+		for i := range ret {
+			ret[i] = [4]uint8{c[i][0], c[i][1], c[i][2], 255}
+		}
+		return ret, nil
+	}
+	if t := m.FeatureTable.Data[PNTS_PROP_RGB565]; t != nil {
+		c, ok := t.([]uint16)
+		if !ok || len(c) < n {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_RGB565, -1, ErrBadValue)
+		}
+		for i := range ret {
+			r := c[i] >> 11 & 0x1f
+			g := c[i] >> 5 & 0x3f
+			b := c[i] & 0x1f
+			ret[i] = [4]uint8{uint8(r<<3 | r>>2), uint8(g<<2 | g>>4), uint8(b<<3 | b>>2), 255}
+		}
+		return ret, nil
+	}
+	if c, ok := m.getConstantRGBA(); ok {
+		for i := range ret {
+			ret[i] = c
+		}
+		return ret, nil
+	}
+	return nil, nil
+}
+
+// Normals returns the unit normal of each point from NORMAL or an oct
+// encoded normal. It returns nil when the tile has no normals.
+func (m *Pnts) Normals() ([][3]float32, error) {
+	n := m.PointsLength()
+	ret := make([][3]float32, n)
+	if t := m.FeatureTable.Data[PNTS_PROP_NORMAL]; t != nil {
+		nm, ok := t.([][3]float32)
+		if !ok || len(nm) < n {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_NORMAL, -1, ErrBadValue)
+		}
+		copy(ret, nm)
+		return ret, nil
+	}
+	if t := m.FeatureTable.Data[PNTS_PROP_NORMAL_OCT16P]; t != nil {
+		nm, ok := t.([][2]uint8)
+		if !ok || len(nm) < n {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_NORMAL_OCT16P, -1, ErrBadValue)
+		}
+		for i := range ret {
+			ret[i] = decodeValue(uint16(nm[i][0]) | uint16(nm[i][1])<<8)
+		}
+		return ret, nil
+	}
+	if t := m.FeatureTable.Data[PNTS_PROP_NORMAL_OCT32P]; t != nil {
+		nm, ok := t.([][2]uint16)
+		if !ok || len(nm) < n {
+			return nil, newTileError(PNTS_MAGIC, PNTS_PROP_NORMAL_OCT32P, -1, ErrBadValue)
+		}
+		for i := range ret {
+			ret[i] = decodeOct32P(nm[i][0], nm[i][1])
+		}
+		return ret, nil
+	}
+	return nil, nil
+}
+
+// BatchIds returns the batch id of each point, or nil when the points are
+// not batched.
+func (m *Pnts) BatchIds() ([]uint32, error) {
+	t := m.FeatureTable.Data[PNTS_PROP_BATCH_ID]
+	if t == nil {
+		return nil, nil
+	}
+	n := m.PointsLength()
+	ret := make([]uint32, n)
+	switch ids := t.(type) {
+	case []uint8:
+		if len(ids) < n {
+			break
+		}
+		for i := range ret {
+			ret[i] = uint32(ids[i])
+		}
+		return ret, nil
+	case []uint16:
+		if len(ids) < n {
+			break
+		}
+		for i := range ret {
+			ret[i] = uint32(ids[i])
+		}
+		return ret, nil
+	case []uint32:
+		if len(ids) < n {
+			break
+		}
+		copy(ret, ids)
+		return ret, nil
+	}
+	return nil, newTileError(PNTS_MAGIC, PNTS_PROP_BATCH_ID, -1, ErrBadValue)
+}
+
 func (m *Pnts) GetHeader() Header {
 	return &m.Header
 }
@@ -454,49 +735,92 @@ func (m *Pnts) GetBatchTable() *BatchTable {
 	return &m.BatchTable
 }
 
-func (m *Pnts) CalcSize() int64 {
-	m.FeatureTable.encode = PntsFeatureTableEncode
-	return m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader())
+func (m *Pnts) CalcSize() (int64, error) {
+	encode, err := m.featureTableEncoder()
+	if err != nil {
+		return 0, wrapTileError(PNTS_MAGIC, "featureTable", -1, err)
+	}
+	m.FeatureTable.encode = encode
+	if _, err := encode(m.FeatureTable.Header, m.FeatureTable.Data); err != nil {
+		return 0, wrapTileError(PNTS_MAGIC, "featureTable", -1, err)
+	}
+	return m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader()), nil
 }
 
 func (m *Pnts) Read(reader io.ReadSeeker) error {
+	start, _ := reader.Seek(0, io.SeekCurrent)
 	err := binary.Read(reader, littleEndian, &m.Header)
 	if err != nil {
+		return newTileError(PNTS_MAGIC, "header", start, err)
+	}
+	if err := checkMagic(PNTS_MAGIC, start, m.Header.Magic); err != nil {
+		return err
+	}
+	if err := checkVersion(PNTS_MAGIC, start, m.Header.Version); err != nil {
+		return err
+	}
+	if err := checkByteLength(reader, PNTS_MAGIC, start, m.GetHeader()); err != nil {
 		return err
 	}
 
-	m.FeatureTable.decode = PntsFeatureTableDecode
+	var draco *dracoPointCloudData
+	m.FeatureTable.decode = func(header map[string]interface{}, buff []byte) (map[string]interface{}, error) {
+		ret, pc, err := pntsFeatureTableDecode(header, buff)
+		draco = pc
+		return ret, err
+	}
 
 	if err := m.FeatureTable.Read(reader, m.GetHeader()); err != nil {
-		return err
+		return wrapTileError(PNTS_MAGIC, "featureTable", 0, err)
 	}
 
-	if err := m.BatchTable.Read(reader, m.GetHeader(), m.FeatureTable.GetBatchLength()); err != nil {
-		return err
+	if err := m.BatchTable.Read(reader, m.GetHeader(), m.BatchLength()); err != nil {
+		return wrapTileError(PNTS_MAGIC, "batchTable", 0, err)
+	}
+
+	m.Draco = nil
+	if draco != nil {
+		if err := m.setDracoValues(draco); err != nil {
+			return wrapTileError(PNTS_MAGIC, "batchTable", 0, err)
+		}
 	}
 
 	return nil
 }
 
+// ReadFromBytes reads a tile from data. Binary arrays are views over data
+// where possible, so data must not be modified while m is in use.
+func (m *Pnts) ReadFromBytes(data []byte) error {
+	return m.Read(newSliceReader(data))
+}
+
 func (m *Pnts) Write(writer io.Writer) error {
-	m.FeatureTable.encode = PntsFeatureTableEncode
-	_ = PntsFeatureTableEncode(m.FeatureTable.Header, m.FeatureTable.Data)
+	copy(m.Header.Magic[:], PNTS_MAGIC)
+	m.Header.Version = 1
+	encode, err := m.featureTableEncoder()
+	if err != nil {
+		return wrapTileError(PNTS_MAGIC, "featureTable", -1, err)
+	}
+	m.FeatureTable.encode = encode
+	if _, err := encode(m.FeatureTable.Header, m.FeatureTable.Data); err != nil {
+		return wrapTileError(PNTS_MAGIC, "featureTable", -1, err)
+	}
 	si := m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader())
 
 	m.Header.ByteLength = uint32(si)
 
-	err := binary.Write(writer, littleEndian, m.Header)
+	err = binary.Write(writer, littleEndian, m.Header)
 
 	if err != nil {
 		return err
 	}
 
-	if err := m.FeatureTable.Write(writer, nil); err != nil {
-		return err
+	if err := m.FeatureTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(PNTS_MAGIC, "featureTable", -1, err)
 	}
 
-	if err := m.BatchTable.Write(writer, nil); err != nil {
-		return err
+	if err := m.BatchTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(PNTS_MAGIC, "batchTable", -1, err)
 	}
 
 	return nil
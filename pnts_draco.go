@@ -0,0 +1,504 @@
+package tile3d
+
+import (
+	"bytes"
+	"math"
+	"sort"
+)
+
+const (
+	TABLE_EXTENSIONS        = "extensions"
+	DRACO_POINT_COMPRESSION = "3DTILES_draco_point_compression"
+	DRACO_PROP_PROPERTIES   = "properties"
+	DRACO_PROP_BYTE_OFFSET  = "byteOffset"
+	DRACO_PROP_BYTE_LENGTH  = "byteLength"
+)
+
+const (
+	// dracoMaxPointsPerByte bounds POINTS_LENGTH of compressed tiles.
+	dracoMaxPointsPerByte    = 64
+	dracoDefaultPositionBits = 14
+	dracoDefaultNormalBits   = 10
+	dracoDefaultGenericBits  = 12
+)
+
+// PntsDraco configures 3DTILES_draco_point_compression. When it is set,
+// Pnts.Write compresses POSITION, RGB, RGBA, NORMAL and BATCH_ID and the
+// batch table properties listed in BatchTableProperties; Pnts.Read sets it
+// for compressed tiles.
+type PntsDraco struct {
+	// Quantization bits of positions, normals and float batch table
+	// properties. 0 stores the values losslessly.
+	PositionBits int
+	NormalBits   int
+	GenericBits  int
+
+	BatchTableProperties []string
+}
+
+func NewPntsDraco() *PntsDraco {
+	return &PntsDraco{
+		PositionBits: dracoDefaultPositionBits,
+		NormalBits:   dracoDefaultNormalBits,
+		GenericBits:  dracoDefaultGenericBits,
+	}
+}
+
+var pntsDracoSemantics = []string{
+	PNTS_PROP_POSITION,
+	PNTS_PROP_RGBA,
+	PNTS_PROP_RGB,
+	PNTS_PROP_NORMAL,
+	PNTS_PROP_BATCH_ID,
+}
+
+func getDracoExtension(header map[string]interface{}) map[string]interface{} {
+	exts, ok := header[TABLE_EXTENSIONS].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	ext, _ := exts[DRACO_POINT_COMPRESSION].(map[string]interface{})
+	return ext
+}
+
+func getDracoExtensionNumber(ext map[string]interface{}, name string) (int, error) {
+	n, ok := ext[name].(float64)
+	if !ok || n < 0 || n > math.MaxInt32 || n != math.Trunc(n) {
+		return 0, newTileError("", DRACO_POINT_COMPRESSION, -1, ErrBadValue)
+	}
+	return int(n), nil
+}
+
+// getDracoProperties returns the properties of a feature or batch table
+// compressed by 3DTILES_draco_point_compression with their Draco attribute
+// ids, or nil if the table has no such extension.
+func getDracoProperties(header map[string]interface{}) (map[string]uint32, error) {
+	ext := getDracoExtension(header)
+	if ext == nil {
+		return nil, nil
+	}
+	props, ok := ext[DRACO_PROP_PROPERTIES].(map[string]interface{})
+	if !ok {
+		return nil, newTileError("", DRACO_POINT_COMPRESSION, -1, ErrBadValue)
+	}
+	ret := make(map[string]uint32, len(props))
+	for k := range props {
+		id, err := getDracoExtensionNumber(props, k)
+		if err != nil {
+			return nil, err
+		}
+		ret[k] = uint32(id)
+	}
+	return ret, nil
+}
+
+func setDracoExtension(header map[string]interface{}, ext map[string]interface{}) {
+	exts, ok := header[TABLE_EXTENSIONS].(map[string]interface{})
+	if !ok {
+		if ext == nil {
+			return
+		}
+		exts = make(map[string]interface{})
+		header[TABLE_EXTENSIONS] = exts
+	}
+	if ext == nil {
+		delete(exts, DRACO_POINT_COMPRESSION)
+		if len(exts) == 0 {
+			delete(header, TABLE_EXTENSIONS)
+		}
+		return
+	}
+	exts[DRACO_POINT_COMPRESSION] = ext
+}
+
+func decodePntsDraco(header map[string]interface{}, buff []byte, pointsLength int) (*dracoPointCloudData, error) {
+	ext := getDracoExtension(header)
+	byteOffset, err := getDracoExtensionNumber(ext, DRACO_PROP_BYTE_OFFSET)
+	if err != nil {
+		return nil, err
+	}
+	byteLength, err := getDracoExtensionNumber(ext, DRACO_PROP_BYTE_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	if byteOffset > len(buff) || byteLength > len(buff)-byteOffset {
+		return nil, newTileError("", DRACO_POINT_COMPRESSION, int64(byteOffset), ErrBadReference)
+	}
+	pc, err := decodeDraco(buff[byteOffset:byteOffset+byteLength], pointsLength)
+	if err != nil {
+		return nil, newTileError("", DRACO_POINT_COMPRESSION, int64(byteOffset), err)
+	}
+	return pc, nil
+}
+
+func getDracoAttribute(pc *dracoPointCloudData, props map[string]uint32, propName string, numComponents int) (*dracoAttribute, error) {
+	a := pc.getAttribute(props[propName])
+	if a == nil || a.NumComponents != numComponents {
+		return nil, newTileError("", propName, -1, ErrBadValue)
+	}
+	return a, nil
+}
+
+func dracoFloatVec3Array(a *dracoAttribute) [][3]float32 {
+	ret := make([][3]float32, len(a.Values)/3)
+	for i := range ret {
+		ret[i] = [3]float32{float32(a.Values[i*3]), float32(a.Values[i*3+1]), float32(a.Values[i*3+2])}
+	}
+	return ret
+}
+
+// setPntsDracoSemantics fills ret with the feature table semantics decoded
+// from pc.
+func setPntsDracoSemantics(ret map[string]interface{}, header map[string]interface{}, pc *dracoPointCloudData, props map[string]uint32) error {
+	for k := range props {
+		switch k {
+		case PNTS_PROP_POSITION, PNTS_PROP_NORMAL:
+			a, err := getDracoAttribute(pc, props, k, 3)
+			if err != nil {
+				return err
+			}
+			ret[k] = dracoFloatVec3Array(a)
+		case PNTS_PROP_RGB:
+			a, err := getDracoAttribute(pc, props, k, 3)
+			if err != nil {
+				return err
+			}
+			rgb := make([][3]uint8, pc.NumPoints)
+			for i := range rgb {
+				rgb[i] = [3]uint8{uint8(a.Values[i*3]), uint8(a.Values[i*3+1]), uint8(a.Values[i*3+2])}
+			}
+			ret[k] = rgb
+		case PNTS_PROP_RGBA:
+			a, err := getDracoAttribute(pc, props, k, 4)
+			if err != nil {
+				return err
+			}
+			rgba := make([][4]uint8, pc.NumPoints)
+			for i := range rgba {
+				rgba[i] = [4]uint8{uint8(a.Values[i*4]), uint8(a.Values[i*4+1]), uint8(a.Values[i*4+2]), uint8(a.Values[i*4+3])}
+			}
+			ret[k] = rgba
+		case PNTS_PROP_BATCH_ID:
+			a, err := getDracoAttribute(pc, props, k, 1)
+			if err != nil {
+				return err
+			}
+			componentType := COMPONENT_TYPE_UNSIGNED_SHORT
+			if ref := getBinaryBodyReference(header, k); ref != nil && ref.ComponentType != "" {
+				componentType = ref.ComponentType
+			}
+			switch componentType {
+			case COMPONENT_TYPE_UNSIGNED_BYTE, COMPONENT_TYPE_UNSIGNED_SHORT, COMPONENT_TYPE_UNSIGNED_INT:
+				ret[k] = dracoBatchTableValues(componentType, a.Values)
+			default:
+				return newTileError("", k, -1, ErrBadReference)
+			}
+		default:
+			return newTileError("", k, -1, ErrBadValue)
+		}
+	}
+	return nil
+}
+
+// dracoBatchTableValues converts decoded values to a flat slice of the
+// given component type.
+func dracoBatchTableValues(componentType string, values []float64) interface{} {
+	switch componentType {
+	case COMPONENT_TYPE_BYTE:
+		ret := make([]int8, len(values))
+		for i, v := range values {
+			ret[i] = int8(v)
+		}
+		return ret
+	case COMPONENT_TYPE_UNSIGNED_BYTE:
+		ret := make([]uint8, len(values))
+		for i, v := range values {
+			ret[i] = uint8(v)
+		}
+		return ret
+	case COMPONENT_TYPE_SHORT:
+		ret := make([]int16, len(values))
+		for i, v := range values {
+			ret[i] = int16(v)
+		}
+		return ret
+	case COMPONENT_TYPE_UNSIGNED_SHORT:
+		ret := make([]uint16, len(values))
+		for i, v := range values {
+			ret[i] = uint16(v)
+		}
+		return ret
+	case COMPONENT_TYPE_INT:
+		ret := make([]int32, len(values))
+		for i, v := range values {
+			ret[i] = int32(v)
+		}
+		return ret
+	case COMPONENT_TYPE_UNSIGNED_INT:
+		ret := make([]uint32, len(values))
+		for i, v := range values {
+			ret[i] = uint32(v)
+		}
+		return ret
+	case COMPONENT_TYPE_FLOAT:
+		ret := make([]float32, len(values))
+		for i, v := range values {
+			ret[i] = float32(v)
+		}
+		return ret
+	case COMPONENT_TYPE_DOUBLE:
+		return append([]float64(nil), values...)
+	}
+	return nil
+}
+
+// dracoAttributeValues returns the values of a flat slice and the Draco
+// data type they are stored as.
+func dracoAttributeValues(data interface{}) ([]float64, uint8) {
+	var ret []float64
+	switch t := data.(type) {
+	case []int8:
+		for _, v := range t {
+			ret = append(ret, float64(v))
+		}
+		return ret, dracoInt8
+	case []uint8:
+		for _, v := range t {
+			ret = append(ret, float64(v))
+		}
+		return ret, dracoUint8
+	case []int16:
+		for _, v := range t {
+			ret = append(ret, float64(v))
+		}
+		return ret, dracoInt16
+	case []uint16:
+		for _, v := range t {
+			ret = append(ret, float64(v))
+		}
+		return ret, dracoUint16
+	case []int32:
+		for _, v := range t {
+			ret = append(ret, float64(v))
+		}
+		return ret, dracoInt32
+	case []uint32:
+		for _, v := range t {
+			ret = append(ret, float64(v))
+		}
+		return ret, dracoUint32
+	case []float32:
+		for _, v := range t {
+			ret = append(ret, float64(v))
+		}
+		return ret, dracoFloat32
+	case []float64:
+		return append(ret, t...), dracoFloat64
+	}
+	return nil, 0
+}
+
+// setDracoValues fills the batch table properties decoded from pc and sets
+// m.Draco from the compressed tile.
+func (m *Pnts) setDracoValues(pc *dracoPointCloudData) error {
+	props, err := getDracoProperties(m.BatchTable.Header)
+	if err != nil {
+		return err
+	}
+	if m.BatchTable.Data == nil && len(props) > 0 {
+		m.BatchTable.Data = make(map[string]interface{})
+	}
+	for k, id := range props {
+		ref := getBinaryBodyReference(m.BatchTable.Header, k)
+		if ref == nil {
+			return newTileError("", k, -1, ErrBadReference)
+		}
+		a := pc.getAttribute(id)
+		if a == nil || a.NumComponents != ContainerTypeSize(ref.ContainerType) {
+			return newTileError("", k, -1, ErrBadValue)
+		}
+		values := dracoBatchTableValues(ref.ComponentType, a.Values)
+		if values == nil {
+			return newTileError("", k, -1, ErrBadReference)
+		}
+		m.BatchTable.Data[k] = values
+	}
+
+	m.Draco = &PntsDraco{}
+	ftProps, _ := getDracoProperties(m.FeatureTable.Header)
+	if id, ok := ftProps[PNTS_PROP_POSITION]; ok {
+		m.Draco.PositionBits = pc.getAttribute(id).QuantizationBits
+	}
+	if id, ok := ftProps[PNTS_PROP_NORMAL]; ok {
+		m.Draco.NormalBits = pc.getAttribute(id).QuantizationBits
+	}
+	for k, id := range props {
+		m.Draco.BatchTableProperties = append(m.Draco.BatchTableProperties, k)
+		if a := pc.getAttribute(id); a.QuantizationBits > m.Draco.GenericBits {
+			m.Draco.GenericBits = a.QuantizationBits
+		}
+	}
+	sort.Strings(m.Draco.BatchTableProperties)
+	return nil
+}
+
+// encodeDraco compresses the feature and batch table properties selected
+// by m.Draco. It returns the Draco data and the feature table semantics it
+// holds, and sets the extension objects of both tables.
+func (m *Pnts) encodeDraco() ([]byte, map[string]bool, error) {
+	pointsLength := m.PointsLength()
+	pc := &dracoPointCloudData{NumPoints: pointsLength}
+	ftProps := make(map[string]interface{})
+	btProps := make(map[string]interface{})
+	compressed := make(map[string]bool)
+	add := func(props map[string]interface{}, name string, a *dracoAttribute) error {
+		if len(a.Values) != pointsLength*a.NumComponents {
+			return newTileError("", name, -1, ErrBadValue)
+		}
+		a.UniqueId = uint32(len(pc.Attributes))
+		pc.Attributes = append(pc.Attributes, a)
+		props[name] = float64(a.UniqueId)
+		return nil
+	}
+
+	data := m.FeatureTable.Data
+	for _, k := range pntsDracoSemantics {
+		a := &dracoAttribute{}
+		switch t := data[k].(type) {
+		case nil:
+			continue
+		case [][3]float32:
+			a.DataType, a.NumComponents = dracoFloat32, 3
+			if k == PNTS_PROP_POSITION {
+				a.Type, a.QuantizationBits = dracoAttributePosition, m.Draco.PositionBits
+			} else if k == PNTS_PROP_NORMAL {
+				a.Type, a.QuantizationBits = dracoAttributeNormal, m.Draco.NormalBits
+			} else {
+				return nil, nil, newTileError("", k, -1, ErrBadValue)
+			}
+			a.Values = make([]float64, 0, len(t)*3)
+			for _, v := range t {
+				a.Values = append(a.Values, float64(v[0]), float64(v[1]), float64(v[2]))
+			}
+		case [][3]uint8:
+			if k != PNTS_PROP_RGB {
+				return nil, nil, newTileError("", k, -1, ErrBadValue)
+			}
+			a.Type, a.DataType, a.NumComponents, a.Normalized = dracoAttributeColor, dracoUint8, 3, true
+			a.Values = make([]float64, 0, len(t)*3)
+			for _, v := range t {
+				a.Values = append(a.Values, float64(v[0]), float64(v[1]), float64(v[2]))
+			}
+		case [][4]uint8:
+			if k != PNTS_PROP_RGBA {
+				return nil, nil, newTileError("", k, -1, ErrBadValue)
+			}
+			a.Type, a.DataType, a.NumComponents, a.Normalized = dracoAttributeColor, dracoUint8, 4, true
+			a.Values = make([]float64, 0, len(t)*4)
+			for _, v := range t {
+				a.Values = append(a.Values, float64(v[0]), float64(v[1]), float64(v[2]), float64(v[3]))
+			}
+		default:
+			if k != PNTS_PROP_BATCH_ID {
+				return nil, nil, newTileError("", k, -1, ErrBadValue)
+			}
+			a.Type, a.NumComponents = dracoAttributeGeneric, 1
+			a.Values, a.DataType = dracoAttributeValues(t)
+			if a.DataType != dracoUint8 && a.DataType != dracoUint16 && a.DataType != dracoUint32 {
+				return nil, nil, newTileError("", k, -1, ErrBadValue)
+			}
+		}
+		if err := add(ftProps, k, a); err != nil {
+			return nil, nil, err
+		}
+		compressed[k] = true
+	}
+
+	for _, k := range m.Draco.BatchTableProperties {
+		ref := getBinaryBodyReference(m.BatchTable.Header, k)
+		if ref == nil {
+			return nil, nil, newTileError("", k, -1, ErrBadReference)
+		}
+		a := &dracoAttribute{Type: dracoAttributeGeneric, NumComponents: ContainerTypeSize(ref.ContainerType)}
+		a.Values, a.DataType = dracoAttributeValues(m.BatchTable.Data[k])
+		if a.DataType == 0 || a.NumComponents == 0 || dracoDataTypeSize[a.DataType] != ComponentTypeSize(ref.ComponentType) {
+			return nil, nil, newTileError("", k, -1, ErrBadValue)
+		}
+		if a.DataType == dracoFloat32 {
+			a.QuantizationBits = m.Draco.GenericBits
+		}
+		if err := add(btProps, k, a); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	blob, err := encodeDraco(pc)
+	if err != nil {
+		return nil, nil, newTileError("", DRACO_POINT_COMPRESSION, -1, err)
+	}
+	setDracoExtension(m.FeatureTable.Header, map[string]interface{}{
+		DRACO_PROP_PROPERTIES:  ftProps,
+		DRACO_PROP_BYTE_OFFSET: float64(0),
+		DRACO_PROP_BYTE_LENGTH: float64(len(blob)),
+	})
+	if len(btProps) > 0 {
+		setDracoExtension(m.BatchTable.Header, map[string]interface{}{DRACO_PROP_PROPERTIES: btProps})
+	} else if m.BatchTable.Header != nil {
+		setDracoExtension(m.BatchTable.Header, nil)
+	}
+	return blob, compressed, nil
+}
+
+// featureTableEncoder returns the feature table encoder of m, which puts
+// the Draco data first in the binary body when m.Draco is set.
+func (m *Pnts) featureTableEncoder() (featureTableEncode, error) {
+	if m.FeatureTable.Header == nil {
+		m.FeatureTable.Header = make(map[string]interface{})
+	}
+	if m.Draco == nil || m.PointsLength() == 0 {
+		setDracoExtension(m.FeatureTable.Header, nil)
+		if m.BatchTable.Header != nil {
+			setDracoExtension(m.BatchTable.Header, nil)
+		}
+		return PntsFeatureTableEncode, nil
+	}
+	blob, compressed, err := m.encodeDraco()
+	if err != nil {
+		return nil, err
+	}
+	return func(header map[string]interface{}, data map[string]interface{}) ([]byte, error) {
+		rest := make(map[string]interface{}, len(data))
+		for k, v := range data {
+			if !compressed[k] {
+				rest[k] = v
+			}
+		}
+		var out []byte
+		buf := bytes.NewBuffer(out)
+		buf.Write(blob)
+		buf.Write(createPaddingBytes(nil, uint32(len(blob)), 8, 0))
+		bts, err := pntsFeatureTableEncode(header, rest, buf.Len())
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(bts)
+		for k := range compressed {
+			ref := BinaryBodyReference{}
+			if k == PNTS_PROP_BATCH_ID {
+				ref.ComponentType = batchIdComponentType(data[k])
+			}
+			header[k] = ref
+		}
+		return buf.Bytes(), nil
+	}, nil
+}
+
+func batchIdComponentType(data interface{}) string {
+	switch data.(type) {
+	case []uint8:
+		return COMPONENT_TYPE_UNSIGNED_BYTE
+	case []uint32:
+		return COMPONENT_TYPE_UNSIGNED_INT
+	}
+	return COMPONENT_TYPE_UNSIGNED_SHORT
+}
@@ -1,8 +1,12 @@
 package tile3d
 
 import (
+	"bytes"
+	"math"
 	"os"
+	"path/filepath"
 	"testing"
+	"unsafe"
 )
 
 func TestPnts(t *testing.T) {
@@ -10,3 +14,188 @@ func TestPnts(t *testing.T) {
 	f, _ := os.Open("./data/7.pnts")
 	p.Read(f)
 }
+
+func TestPntsReadFromBytes(t *testing.T) {
+	p := NewPnts()
+	p.SetFeatureTable(PntsFeatureTableView{Position: [][3]float32{{1, 2, 3}, {4, 5, 6}}, PointsLength: 2})
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "0.pnts")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, unmap, err := MmapFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unmap()
+	r := &Pnts{}
+	if err := r.ReadFromBytes(data); err != nil {
+		t.Fatal(err)
+	}
+	pos := r.FeatureTable.Data[PNTS_PROP_POSITION].([][3]float32)
+	if len(pos) != 2 || pos[1][2] != 6 {
+		t.Fatalf("positions %v", pos)
+	}
+	start := uintptr(unsafe.Pointer(&data[0]))
+	if p := uintptr(unsafe.Pointer(&pos[0])); p < start || p >= start+uintptr(len(data)) {
+		t.Error("positions copied out of data")
+	}
+}
+
+func TestPntsAccessors(t *testing.T) {
+	p := NewPnts()
+	batchLength := uint32(2)
+	p.SetFeatureTable(PntsFeatureTableView{
+		PositionQuantized:     [][3]uint16{{0, 0, 0}, {65535, 65535, 65535}, {0, 65535, 0}},
+		QuantizedVolumeOffset: []float32{-1, -2, -3},
+		QuantizedVolumeScale:  []float32{2, 4, 6},
+		RtcCenter:             []float64{1000, 2000, 3000},
+		RGB565:                []uint16{0xf800, 0x07e0, 0x001f},
+		NormalOCT16P:          [][2]uint8{{128, 128}, {128, 128}, {128, 128}},
+		BatchId:               []uint16{0, 1, 1},
+		BatchLength:           &batchLength,
+		PointsLength:          3,
+	})
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Pnts{}
+	if err := r.ReadFromBytes(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	pos, err := r.Positions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos[0] != [3]float64{999, 1998, 2997} || pos[1] != [3]float64{1001, 2002, 3003} || pos[2] != [3]float64{999, 2002, 2997} {
+		t.Errorf("positions %v", pos)
+	}
+	colors, err := r.Colors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if colors[0] != [4]uint8{255, 0, 0, 255} || colors[1] != [4]uint8{0, 255, 0, 255} || colors[2] != [4]uint8{0, 0, 255, 255} {
+		t.Errorf("colors %v", colors)
+	}
+	normals, err := r.Normals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if normals[0][2] < 0.99 {
+		t.Errorf("normals %v", normals)
+	}
+	ids, err := r.BatchIds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[2] != 1 || r.BatchLength() != 2 {
+		t.Errorf("batch ids %v length %d", ids, r.BatchLength())
+	}
+	view := r.GetFeatureTableView()
+	if view.PointsLength != 3 || view.RtcCenter[2] != 3000 || *view.BatchLength != 2 {
+		t.Errorf("view %+v", view)
+	}
+}
+
+func TestPntsDraco(t *testing.T) {
+	p := NewPnts()
+	p.SetFeatureTable(PntsFeatureTableView{
+		Position:     [][3]float32{{0, 0, 0}, {10, 20, 30}, {5, 5, 5}},
+		RGBA:         [][4]uint8{{255, 0, 0, 255}, {0, 255, 0, 128}, {0, 0, 255, 0}},
+		Normal:       [][3]float32{{0, 0, 1}, {1, 0, 0}, {0, 1, 0}},
+		PointsLength: 3,
+	})
+	p.BatchTable.Header = map[string]interface{}{
+		"intensity": BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR},
+	}
+	p.BatchTable.Data = map[string]interface{}{
+		"intensity": []float32{0.5, 1, 0.25},
+	}
+	p.Draco = NewPntsDraco()
+	p.Draco.BatchTableProperties = []string{"intensity"}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Pnts{}
+	if err := r.ReadFromBytes(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if r.Draco == nil || r.Draco.PositionBits != 14 || len(r.Draco.BatchTableProperties) != 1 {
+		t.Fatalf("draco %+v", r.Draco)
+	}
+	pos, err := r.Positions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(pos[1][2]-30) > 0.01 || math.Abs(pos[2][0]-5) > 0.01 {
+		t.Errorf("positions %v", pos)
+	}
+	colors, _ := r.Colors()
+	if colors[1] != [4]uint8{0, 255, 0, 128} {
+		t.Errorf("colors %v", colors)
+	}
+	normals, _ := r.Normals()
+	if normals[1][0] < 0.99 {
+		t.Errorf("normals %v", normals)
+	}
+	intensity := r.BatchTable.Data["intensity"].([]float32)
+	if math.Abs(float64(intensity[2])-0.25) > 0.001 {
+		t.Errorf("intensity %v", intensity)
+	}
+
+	r.Draco = nil
+	buf.Reset()
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	u := &Pnts{}
+	if err := u.ReadFromBytes(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if u.Draco != nil || len(u.FeatureTable.Data[PNTS_PROP_POSITION].([][3]float32)) != 3 {
+		t.Errorf("uncompressed %+v", u.FeatureTable.Data)
+	}
+}
+
+func TestPntsPropertyAlignment(t *testing.T) {
+	p := NewPnts()
+	p.SetFeatureTable(PntsFeatureTableView{
+		Position:     [][3]float32{{0, 0, 0}, {1, 2, 3}, {4, 5, 6}},
+		RGB:          [][3]uint8{{255, 0, 0}, {0, 255, 0}, {0, 0, 255}},
+		Normal:       [][3]float32{{0, 0, 1}, {1, 0, 0}, {0, 1, 0}},
+		PointsLength: 3,
+	})
+	p.FeatureTable.Data[PNTS_PROP_NORMAL_OCT32P] = [][2]uint16{{1, 2}, {3, 4}, {5, 6}}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := &Pnts{}
+	if err := r.ReadFromBytes(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]uint32{PNTS_PROP_NORMAL: 4, PNTS_PROP_NORMAL_OCT32P: 2} {
+		ref := getBinaryBodyReference(r.FeatureTable.Header, name)
+		if ref == nil || ref.ByteOffset%size != 0 {
+			t.Errorf("%s %+v", name, ref)
+		}
+	}
+	normals, err := r.Normals()
+	if err != nil || normals[2] != [3]float32{0, 1, 0} {
+		t.Errorf("normals %v %v", normals, err)
+	}
+	if oct := r.FeatureTable.Data[PNTS_PROP_NORMAL_OCT32P].([][2]uint16); oct[2] != [2]uint16{5, 6} {
+		t.Errorf("oct32p %v", oct)
+	}
+	if colors, _ := r.Colors(); colors[1] != [4]uint8{0, 255, 0, 255} {
+		t.Errorf("colors %v", colors)
+	}
+}
@@ -0,0 +1,56 @@
+package tile3d
+
+import (
+	"bytes"
+	"io"
+	"unsafe"
+)
+
+var hostLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// sliceReader is the reader behind the ReadFromBytes methods. Sections read
+// with readSection are sub slices of data rather than copies, so the typed
+// arrays decoded from them are views over data too.
+type sliceReader struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newSliceReader(data []byte) *sliceReader {
+	return &sliceReader{Reader: bytes.NewReader(data), data: data}
+}
+
+func readSection(reader io.ReadSeeker, n int) ([]byte, error) {
+	if r, ok := reader.(*sliceReader); ok {
+		offset, _ := r.Seek(0, io.SeekCurrent)
+		end := offset + int64(n)
+		if n < 0 || end > int64(len(r.data)) {
+			r.Seek(0, io.SeekEnd)
+			return nil, io.ErrUnexpectedEOF
+		}
+		r.Seek(end, io.SeekStart)
+		return r.data[offset:end:end], nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// viewBinaryArray returns body as a []T without copying when the host is
+// little endian and body is suitably aligned for T.
+func viewBinaryArray[T any](body []byte, length int) ([]T, bool) {
+	var zero T
+	if !hostLittleEndian || length == 0 || len(body) != length*int(unsafe.Sizeof(zero)) {
+		return nil, false
+	}
+	ptr := unsafe.Pointer(&body[0])
+	if uintptr(ptr)%unsafe.Alignof(zero) != 0 {
+		return nil, false
+	}
+	return unsafe.Slice((*T)(ptr), length), true
+}
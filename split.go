@@ -0,0 +1,365 @@
+package tile3d
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/flywave/gltf"
+	"github.com/flywave/gltf/modeler"
+)
+
+type B3dmSplitMode int
+
+const (
+	// B3DM_SPLIT_BATCH_ID splits the features in ranges of batch ids.
+	B3DM_SPLIT_BATCH_ID B3dmSplitMode = iota
+	// B3DM_SPLIT_GRID splits the features by the grid cell of their
+	// centroid.
+	B3DM_SPLIT_GRID
+	// B3DM_SPLIT_OCTREE splits the features by the octree node of their
+	// centroid.
+	B3DM_SPLIT_OCTREE
+)
+
+// B3dmSplitOptions controls B3dm.Split.
+type B3dmSplitOptions struct {
+	Mode B3dmSplitMode
+	// MaxFeatures is the number of features of a batch id range and the
+	// most features of an octree leaf.
+	MaxFeatures int
+	// GridSize is the number of grid cells along x, y and z.
+	GridSize [3]int
+	// MaxDepth is the deepest level of the octree.
+	MaxDepth int
+	// GeometricError is the geometric error of the root of the sub-tileset,
+	// halved at every level below it for the tiles without content, or the
+	// length of the diagonal of the bounds of the tile when it is 0. Tiles
+	// with content have none.
+	GeometricError float64
+	// ContentUri returns the uri of tile i in the sub-tileset, i.b3dm when
+	// it is nil.
+	ContentUri func(i int) string
+}
+
+func NewB3dmSplitOptions() *B3dmSplitOptions {
+	return &B3dmSplitOptions{
+		Mode:        B3DM_SPLIT_BATCH_ID,
+		MaxFeatures: 1000,
+		GridSize:    [3]int{2, 2, 1},
+		MaxDepth:    8,
+		ContentUri:  b3dmSplitContentUri,
+	}
+}
+
+func b3dmSplitContentUri(i int) string {
+	return strconv.Itoa(i) + ".b3dm"
+}
+
+// b3dmBounds is the bounding box of vertices in the z-up frame of 3D Tiles,
+// with the sum of their positions.
+type b3dmBounds struct {
+	min, max, sum [3]float64
+	count         int
+}
+
+func (b *b3dmBounds) add(p [3]float64) {
+	for i := range p {
+		if b.count == 0 || p[i] < b.min[i] {
+			b.min[i] = p[i]
+		}
+		if b.count == 0 || p[i] > b.max[i] {
+			b.max[i] = p[i]
+		}
+		b.sum[i] += p[i]
+	}
+	b.count++
+}
+
+func (b *b3dmBounds) merge(o b3dmBounds) {
+	if o.count == 0 {
+		return
+	}
+	if b.count == 0 {
+		*b = o
+		return
+	}
+	for i := range b.min {
+		b.min[i] = math.Min(b.min[i], o.min[i])
+		b.max[i] = math.Max(b.max[i], o.max[i])
+		b.sum[i] += o.sum[i]
+	}
+	b.count += o.count
+}
+
+func (b *b3dmBounds) centroid() [3]float64 {
+	return [3]float64{b.sum[0] / float64(b.count), b.sum[1] / float64(b.count), b.sum[2] / float64(b.count)}
+}
+
+// diagonal returns the length of the diagonal of b.
+func (b *b3dmBounds) diagonal() float64 {
+	d := 0.0
+	for i := range b.min {
+		d += (b.max[i] - b.min[i]) * (b.max[i] - b.min[i])
+	}
+	return math.Sqrt(d)
+}
+
+// box returns b as the box of a bounding volume.
+func (b *b3dmBounds) box() []float64 {
+	ret := make([]float64, 12)
+	for i := range b.min {
+		ret[i] = (b.min[i] + b.max[i]) / 2
+		ret[3+i*4] = (b.max[i] - b.min[i]) / 2
+	}
+	return ret
+}
+
+// featureBounds returns the bounds of the vertices of every feature of m,
+// and of all its vertices, with the RTC center of m applied.
+func (m *B3dm) featureBounds() ([]b3dmBounds, b3dmBounds, error) {
+	var all b3dmBounds
+	features := make([]b3dmBounds, m.BatchLength())
+	center, err := m.center()
+	if err != nil {
+		return nil, all, err
+	}
+	doc := m.Model
+	for _, mn := range gltfMeshNodes(doc) {
+		if int(mn.mesh) >= len(doc.Meshes) {
+			return nil, all, newTileError(B3DM_MAGIC, "mesh", -1, ErrBadReference)
+		}
+		matrix := mat4Mul(yUpToZUp, mn.matrix)
+		a := func(r, c int) float64 { return matrix[c*4+r] }
+		for _, p := range doc.Meshes[mn.mesh].Primitives {
+			index, ok := p.Attributes[gltf.POSITION]
+			if !ok || int(index) >= len(doc.Accessors) {
+				continue
+			}
+			positions, err := modeler.ReadPosition(doc, doc.Accessors[index], nil)
+			if err != nil {
+				return nil, all, newTileError(B3DM_MAGIC, "POSITION", -1, err)
+			}
+			var ids []uint32
+			for _, name := range []string{GLTF_ATTR_BATCHID, "BATCHID"} {
+				if index, ok := p.Attributes[name]; ok {
+					if ids, err = readFeatureIds(doc, index); err != nil {
+						return nil, all, wrapTileError(B3DM_MAGIC, "glTF", -1, err)
+					}
+					break
+				}
+			}
+			for i, v := range positions {
+				var w [3]float64
+				for r := 0; r < 3; r++ {
+					w[r] = a(r, 0)*float64(v[0]) + a(r, 1)*float64(v[1]) + a(r, 2)*float64(v[2]) + a(r, 3) + center[r]
+				}
+				all.add(w)
+				if i < len(ids) && int(ids[i]) < len(features) {
+					features[ids[i]].add(w)
+				}
+			}
+		}
+	}
+	return features, all, nil
+}
+
+// splitNode is a tile of the sub-tileset of B3dm.Split, a leaf with the
+// features of a b3dm or a node with children.
+type splitNode struct {
+	features []int
+	children []*splitNode
+}
+
+// Split breaks m into b3dm tiles with the geometry and batch table rows of
+// groups of its features, renumbered in order, and returns them with a
+// sub-tileset whose tiles reference them by opts.ContentUri. Features are
+// grouped by batch id ranges, grid cells or octree nodes of the centroid
+// of their vertices, and tiles without features are not created.
+// Primitives without _BATCHID are kept in every tile.
+func (m *B3dm) Split(opts *B3dmSplitOptions) ([]*B3dm, *Tileset, error) {
+	if opts == nil {
+		opts = NewB3dmSplitOptions()
+	}
+	if m.Model == nil {
+		return nil, nil, newTileError(B3DM_MAGIC, "glTF", -1, ErrMissingModel)
+	}
+	bounds, all, err := m.featureBounds()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Features without vertices are at the center of the tile.
+	centroids := make([][3]float64, len(bounds))
+	for id, b := range bounds {
+		centroids[id] = all.centroid()
+		if b.count > 0 {
+			centroids[id] = b.centroid()
+		}
+	}
+	ids := make([]int, len(bounds))
+	for id := range ids {
+		ids[id] = id
+	}
+
+	root := &splitNode{}
+	switch opts.Mode {
+	case B3DM_SPLIT_BATCH_ID:
+		if opts.MaxFeatures <= 0 {
+			return nil, nil, newTileError(B3DM_MAGIC, "MaxFeatures", -1, ErrBadValue)
+		}
+		for start := 0; start < len(ids); start += opts.MaxFeatures {
+			end := start + opts.MaxFeatures
+			if end > len(ids) {
+				end = len(ids)
+			}
+			root.children = append(root.children, &splitNode{features: ids[start:end]})
+		}
+	case B3DM_SPLIT_GRID:
+		for _, n := range opts.GridSize {
+			if n <= 0 {
+				return nil, nil, newTileError(B3DM_MAGIC, "GridSize", -1, ErrBadValue)
+			}
+		}
+		root.children = splitGrid(ids, centroids, opts.GridSize)
+	case B3DM_SPLIT_OCTREE:
+		if opts.MaxFeatures <= 0 {
+			return nil, nil, newTileError(B3DM_MAGIC, "MaxFeatures", -1, ErrBadValue)
+		}
+		root = splitOctree(ids, centroids, opts.MaxFeatures, opts.MaxDepth)
+	default:
+		return nil, nil, newTileError(B3DM_MAGIC, "Mode", -1, ErrBadValue)
+	}
+
+	geometricError := opts.GeometricError
+	if geometricError == 0 {
+		geometricError = all.diagonal()
+	}
+	contentUri := opts.ContentUri
+	if contentUri == nil {
+		contentUri = b3dmSplitContentUri
+	}
+
+	var tiles []*B3dm
+	var build func(n *splitNode, depth int) (Tile, b3dmBounds, bool, error)
+	build = func(n *splitNode, depth int) (Tile, b3dmBounds, bool, error) {
+		var ret Tile
+		var b b3dmBounds
+		if n.children == nil {
+			if len(n.features) == 0 {
+				return ret, b, false, nil
+			}
+			keep := make(map[int]bool, len(n.features))
+			for _, id := range n.features {
+				keep[id] = true
+			}
+			t, err := m.subset(func(id int) bool { return keep[id] })
+			if err != nil {
+				return ret, b, false, err
+			}
+			if _, b, err = t.featureBounds(); err != nil {
+				return ret, b, false, err
+			}
+			ret.Content = &Content{Url: contentUri(len(tiles))}
+			tiles = append(tiles, t)
+		} else {
+			ret.GeometricError = math.Ldexp(geometricError, -depth)
+			for _, c := range n.children {
+				child, cb, ok, err := build(c, depth+1)
+				if err != nil {
+					return ret, b, false, err
+				}
+				if ok {
+					ret.Children = append(ret.Children, child)
+					b.merge(cb)
+				}
+			}
+			if len(ret.Children) == 0 {
+				return ret, b, false, nil
+			}
+		}
+		ret.Refine = TILE_REFINE_ADD
+		ret.BoundingVolume.SetBox(b.box())
+		return ret, b, true, nil
+	}
+	rootTile, _, ok, err := build(root, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		rootTile = Tile{GeometricError: geometricError, Refine: TILE_REFINE_ADD}
+		rootTile.BoundingVolume.SetBox(all.box())
+	}
+	ts := &Tileset{
+		Asset:          Asset{Version: "1.0"},
+		GeometricError: geometricError,
+		Root:           rootTile,
+	}
+	return tiles, ts, nil
+}
+
+// splitGrid groups ids by the cell of size cells over the bounds of their
+// centroids, in cell order.
+func splitGrid(ids []int, centroids [][3]float64, size [3]int) []*splitNode {
+	var b b3dmBounds
+	for _, id := range ids {
+		b.add(centroids[id])
+	}
+	cells := make(map[int]*splitNode)
+	for _, id := range ids {
+		cell := 0
+		for i := 2; i >= 0; i-- {
+			c := 0
+			if extent := b.max[i] - b.min[i]; extent > 0 {
+				c = int((centroids[id][i] - b.min[i]) / extent * float64(size[i]))
+			}
+			if c >= size[i] {
+				c = size[i] - 1
+			}
+			cell = cell*size[i] + c
+		}
+		if cells[cell] == nil {
+			cells[cell] = &splitNode{}
+		}
+		cells[cell].features = append(cells[cell].features, id)
+	}
+	keys := make([]int, 0, len(cells))
+	for k := range cells {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	ret := make([]*splitNode, len(keys))
+	for i, k := range keys {
+		ret[i] = cells[k]
+	}
+	return ret
+}
+
+// splitOctree groups ids in an octree over the bounds of their centroids
+// whose leaves have at most maxFeatures, unless they are at maxDepth or
+// their centroids are all the same.
+func splitOctree(ids []int, centroids [][3]float64, maxFeatures, maxDepth int) *splitNode {
+	var b b3dmBounds
+	for _, id := range ids {
+		b.add(centroids[id])
+	}
+	if len(ids) <= maxFeatures || maxDepth <= 0 || b.min == b.max {
+		return &splitNode{features: ids}
+	}
+	var octants [8][]int
+	for _, id := range ids {
+		o := 0
+		for i := 0; i < 3; i++ {
+			if centroids[id][i] > (b.min[i]+b.max[i])/2 {
+				o |= 1 << i
+			}
+		}
+		octants[o] = append(octants[o], id)
+	}
+	ret := &splitNode{children: []*splitNode{}}
+	for _, o := range octants {
+		if len(o) > 0 {
+			ret.children = append(ret.children, splitOctree(o, centroids, maxFeatures, maxDepth-1))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,93 @@
+package tile3d
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestB3dmSplit(t *testing.T) {
+	baked, err := newBakeI3dm().Bake(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := baked.(*B3dm)
+	_, all, err := m.featureBounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewB3dmSplitOptions()
+	opts.MaxFeatures = 2
+	opts.GeometricError = 10
+	tiles, ts, err := m.Split(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiles) != 2 || tiles[0].BatchLength() != 2 || tiles[1].BatchLength() != 1 {
+		t.Fatalf("%d tiles", len(tiles))
+	}
+	r := newSampleTile(t, writeTile(t, tiles[1])).(*B3dm)
+	if v := r.BatchTable.GetProperty("name", 0); v != "c" {
+		t.Errorf("name %v", v)
+	}
+	if _, ids := bakedVertices(t, r); len(ids) == 0 || ids[0] != 0 {
+		t.Errorf("batch ids %v", ids)
+	}
+	root := ts.Root
+	if len(root.Children) != 2 || root.Children[1].Content.Url != "1.b3dm" || root.GeometricError != 10 {
+		t.Errorf("root %+v", root)
+	}
+	if !reflect.DeepEqual(root.BoundingVolume.GetBox(), all.box()) {
+		t.Errorf("box %v, want %v", root.BoundingVolume.GetBox(), all.box())
+	}
+	js, err := ts.ToJson()
+	if err != nil || !strings.Contains(js, `"uri":"0.b3dm"`) {
+		t.Errorf("tileset %s %v", js, err)
+	}
+
+	// Feature 1 has no vertices and is placed at the center of the tile.
+	opts.Mode = B3DM_SPLIT_GRID
+	opts.GridSize = [3]int{1, 2, 1}
+	if tiles, _, err = m.Split(opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(tiles) != 2 || tiles[0].BatchLength() != 2 || tiles[0].BatchTable.GetProperty("name", 1) != "c" {
+		t.Fatalf("grid of %d tiles", len(tiles))
+	}
+
+	opts.Mode = B3DM_SPLIT_OCTREE
+	opts.MaxFeatures = 1
+	if tiles, ts, err = m.Split(opts); err != nil {
+		t.Fatal(err)
+	}
+	leaves := 0
+	var walk func(tile Tile)
+	walk = func(tile Tile) {
+		if tile.Content != nil {
+			leaves++
+		}
+		for _, c := range tile.Children {
+			if c.Content == nil && c.GeometricError != tile.GeometricError/2 {
+				t.Errorf("geometric error %v below %v", c.GeometricError, tile.GeometricError)
+			}
+			walk(c)
+		}
+	}
+	walk(ts.Root)
+	if len(tiles) != 3 || leaves != 3 {
+		t.Errorf("octree of %d tiles, %d leaves", len(tiles), leaves)
+	}
+
+	// The geometric error defaults to the diagonal of the tile and the
+	// uris to the index of the tile.
+	if _, ts, err = m.Split(&B3dmSplitOptions{Mode: B3DM_SPLIT_OCTREE, MaxFeatures: 1, MaxDepth: 8}); err != nil {
+		t.Fatal(err)
+	}
+	if ts.GeometricError != all.diagonal() || ts.Root.GeometricError != all.diagonal() || ts.GeometricError == 0 {
+		t.Errorf("geometric error %v, want %v", ts.Root.GeometricError, all.diagonal())
+	}
+	if js, _ := ts.ToJson(); !strings.Contains(js, `"uri":"2.b3dm"`) {
+		t.Errorf("tileset %s", js)
+	}
+}
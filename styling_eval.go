@@ -0,0 +1,1006 @@
+package tile3d
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// StylingUndefined is the undefined value of styling expressions.
+type StylingUndefined struct{}
+
+// ExpressionFeature is the feature an expression is evaluated for. Property
+// returns the value of a property and false when the feature has none.
+type ExpressionFeature interface {
+	Property(name string) (interface{}, bool)
+}
+
+// ExpressionClassFeature is a feature that is an instance of a class, for
+// isExactClass, isClass and getExactClassName.
+type ExpressionClassFeature interface {
+	ExpressionFeature
+	IsExactClass(name string) bool
+	IsClass(name string) bool
+	ExactClassName() (string, bool)
+}
+
+// ExpressionProperties is a feature given by its property values, like a
+// row returned by BatchTable.Feature or metadata properties.
+type ExpressionProperties map[string]interface{}
+
+func (p ExpressionProperties) Property(name string) (interface{}, bool) {
+	v, ok := p[name]
+	return v, ok
+}
+
+// BatchTableFeature is feature Id of a batch table with the properties and
+// classes it has in the 3DTILES_batch_table_hierarchy of the table.
+type BatchTableFeature struct {
+	Table     *BatchTable
+	Hierarchy *BatchTableHierarchy
+	Id        int
+}
+
+func NewBatchTableFeature(t *BatchTable, id int) (*BatchTableFeature, error) {
+	h, err := t.Hierarchy()
+	if err != nil {
+		return nil, err
+	}
+	return &BatchTableFeature{Table: t, Hierarchy: h, Id: id}, nil
+}
+
+func (f *BatchTableFeature) Property(name string) (interface{}, bool) {
+	if c, err := f.Table.Column(name); err == nil {
+		if v, err := c.Value(f.Id); err == nil {
+			return v, true
+		}
+	}
+	if f.Hierarchy != nil {
+		return f.Hierarchy.Property(f.Id, name)
+	}
+	return nil, false
+}
+
+func (f *BatchTableFeature) IsExactClass(name string) bool {
+	return f.Hierarchy != nil && f.Hierarchy.IsExactClass(f.Id, name)
+}
+
+func (f *BatchTableFeature) IsClass(name string) bool {
+	return f.Hierarchy != nil && f.Hierarchy.IsClass(f.Id, name)
+}
+
+func (f *BatchTableFeature) ExactClassName() (string, bool) {
+	if f.Hierarchy == nil {
+		return "", false
+	}
+	name, err := f.Hierarchy.ClassName(f.Id)
+	return name, err == nil
+}
+
+// ExpressionContext is what an expression is evaluated against.
+type ExpressionContext struct {
+	Feature ExpressionFeature
+	// TilesetTime is ${tiles3d_tileset_time}, the seconds since the tileset
+	// was loaded.
+	TilesetTime float64
+}
+
+func NewExpressionContext(feature ExpressionFeature) *ExpressionContext {
+	return &ExpressionContext{Feature: feature}
+}
+
+// Evaluate parses e and evaluates it for feature.
+func (e Expression) Evaluate(feature ExpressionFeature) (interface{}, error) {
+	n, err := e.Parse()
+	if err != nil {
+		return nil, err
+	}
+	v, err := n.Evaluate(NewExpressionContext(feature))
+	if ee, ok := err.(*ExpressionError); ok {
+		ee.Expression = string(e)
+	}
+	return v, err
+}
+
+// Evaluate returns the value of n for ctx: nil for null, StylingUndefined,
+// bool, float64, string, [2]float64, [3]float64 or [4]float64 for vectors
+// and colors, *StylingRegExp, []interface{} for arrays, or the value of an
+// object property. Colors are RGBA vectors with components in [0, 1].
+// Property values are converted the same way, numbers to float64 and
+// slices of 2 to 4 numbers, like binary vectors, to vectors.
+func (n *ExpressionNode) Evaluate(ctx *ExpressionContext) (interface{}, error) {
+	if ctx == nil {
+		ctx = NewExpressionContext(nil)
+	}
+	switch n.Type {
+	case EXP_LITERAL_NULL:
+		return nil, nil
+	case EXP_LITERAL_UNDEFINED:
+		return StylingUndefined{}, nil
+	case EXP_LITERAL_BOOLEAN, EXP_LITERAL_NUMBER, EXP_LITERAL_STRING, EXP_LITERAL_REGEX:
+		return n.Value, nil
+	case EXP_BUILTIN_VARIABLE:
+		return ctx.TilesetTime, nil
+	case EXP_VARIABLE:
+		return ctx.property(n.Value.(string)), nil
+	case EXP_VARIABLE_IN_STRING:
+		return n.evalVariableInString(ctx)
+	case EXP_UNARY:
+		return n.evalUnary(ctx)
+	case EXP_BINARY:
+		return n.evalBinary(ctx)
+	case EXP_TERNARY:
+		return n.evalTernary(ctx)
+	case EXP_CONDITIONAL:
+		test, err := n.Test.Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := test.(bool)
+		if !ok {
+			return nil, n.errorf("condition must be a boolean, got %s", expressionTypeName(test))
+		}
+		if b {
+			return n.Left.Evaluate(ctx)
+		}
+		return n.Right.Evaluate(ctx)
+	case EXP_MEMBER:
+		return n.evalMember(ctx)
+	case EXP_FUNCTION_CALL:
+		return n.evalFunctionCall(ctx)
+	case EXP_ARRAY:
+		return n.evalArgs(ctx)
+	case EXP_REGEX:
+		args, err := n.evalArgs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		strs := []string{"", ""}
+		for i, a := range args {
+			s, ok := a.(string)
+			if !ok {
+				return nil, n.errorf("regexp() takes strings, got %s", expressionTypeName(a))
+			}
+			strs[i] = s
+		}
+		re, err := NewStylingRegExp(strs[0], strs[1])
+		if err != nil {
+			return nil, n.errorf("invalid regular expression: %v", err)
+		}
+		return re, nil
+	case EXP_LITERAL_COLOR:
+		return n.evalColor(ctx)
+	case EXP_LITERAL_VECTOR:
+		return n.evalVector(ctx)
+	}
+	return nil, n.errorf("unknown node type %d", n.Type)
+}
+
+func (n *ExpressionNode) errorf(format string, args ...interface{}) error {
+	return &ExpressionError{Offset: n.Offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (ctx *ExpressionContext) property(name string) interface{} {
+	if ctx.Feature == nil {
+		return StylingUndefined{}
+	}
+	v, ok := ctx.Feature.Property(name)
+	if !ok {
+		return StylingUndefined{}
+	}
+	return expressionValue(v)
+}
+
+// expressionValue converts a property value to the values of expressions.
+func expressionValue(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, bool, string, float64, StylingUndefined, [2]float64, [3]float64, [4]float64,
+		*StylingRegExp, []interface{}, map[string]interface{}:
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	if isNumberKind(rv.Kind()) {
+		return rv.Convert(float64Type).Float()
+	}
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return v
+	}
+	if isNumberKind(rv.Type().Elem().Kind()) && rv.Len() >= 2 && rv.Len() <= 4 {
+		c := make([]float64, rv.Len())
+		for i := range c {
+			c[i] = rv.Index(i).Convert(float64Type).Float()
+		}
+		return makeVector(c)
+	}
+	ret := make([]interface{}, rv.Len())
+	for i := range ret {
+		ret[i] = expressionValue(rv.Index(i).Interface())
+	}
+	return ret
+}
+
+func expressionType(v interface{}) (ExpressionType, bool) {
+	switch v.(type) {
+	case bool:
+		return VAR_BOOLEAN, true
+	case nil:
+		return VAR_NULL, true
+	case StylingUndefined:
+		return VAR_UNDEFINED, true
+	case float64:
+		return VAR_NUMBER, true
+	case string:
+		return VAR_STRING, true
+	case []interface{}:
+		return VAR_ARRAY, true
+	case [2]float64:
+		return VAR_VEC2, true
+	case [3]float64:
+		return VAR_VEC3, true
+	case [4]float64:
+		return VAR_VEC4, true
+	case *StylingRegExp:
+		return VAR_REGEXP, true
+	}
+	return 0, false
+}
+
+func expressionTypeName(v interface{}) string {
+	t, ok := expressionType(v)
+	if !ok {
+		return "object"
+	}
+	switch t {
+	case VAR_BOOLEAN:
+		return "boolean"
+	case VAR_NULL:
+		return "null"
+	case VAR_UNDEFINED:
+		return "undefined"
+	case VAR_NUMBER:
+		return "number"
+	case VAR_STRING:
+		return "string"
+	case VAR_ARRAY:
+		return "array"
+	case VAR_VEC2:
+		return "vec2"
+	case VAR_VEC3:
+		return "vec3"
+	case VAR_VEC4:
+		return "vec4"
+	}
+	return "regexp"
+}
+
+// vectorComponents returns the components of a vector.
+func vectorComponents(v interface{}) ([]float64, bool) {
+	switch t := v.(type) {
+	case [2]float64:
+		return t[:], true
+	case [3]float64:
+		return t[:], true
+	case [4]float64:
+		return t[:], true
+	}
+	return nil, false
+}
+
+func makeVector(c []float64) interface{} {
+	switch len(c) {
+	case 2:
+		return [2]float64{c[0], c[1]}
+	case 3:
+		return [3]float64{c[0], c[1], c[2]}
+	}
+	return [4]float64{c[0], c[1], c[2], c[3]}
+}
+
+// formatExpressionNumber formats f like JavaScript.
+func formatExpressionNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0"
+	}
+	if a := math.Abs(f); a >= 1e21 || a < 1e-6 {
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		// Go pads the exponent to 2 digits.
+		i := strings.IndexByte(s, 'e')
+		exp := strings.TrimLeft(s[i+2:], "0")
+		return s[:i+2] + exp
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// expressionString converts v to a string like String() of JavaScript,
+// vectors are written as (x, y, z).
+func expressionString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case StylingUndefined:
+		return "undefined"
+	case bool:
+		return strconv.FormatBool(t)
+	case float64:
+		return formatExpressionNumber(t)
+	case string:
+		return t
+	case *StylingRegExp:
+		return t.String()
+	case []interface{}:
+		strs := make([]string, len(t))
+		for i, e := range t {
+			switch e.(type) {
+			case nil, StylingUndefined:
+			default:
+				strs[i] = expressionString(expressionValue(e))
+			}
+		}
+		return strings.Join(strs, ",")
+	}
+	if c, ok := vectorComponents(v); ok {
+		strs := make([]string, len(c))
+		for i, f := range c {
+			strs[i] = formatExpressionNumber(f)
+		}
+		return "(" + strings.Join(strs, ", ") + ")"
+	}
+	return "[object Object]"
+}
+
+// expressionNumber converts v to a number like Number() of JavaScript.
+func expressionNumber(v interface{}) float64 {
+	switch t := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if t {
+			return 1
+		}
+		return 0
+	case float64:
+		return t
+	case string:
+		s := strings.TrimSpace(t)
+		switch s {
+		case "":
+			return 0
+		case "Infinity", "+Infinity":
+			return math.Inf(1)
+		case "-Infinity":
+			return math.Inf(-1)
+		}
+		if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+			if n, err := strconv.ParseUint(s[2:], 16, 64); err == nil {
+				return float64(n)
+			}
+			return math.NaN()
+		}
+		// ParseFloat accepts forms JavaScript does not.
+		if strings.ContainsAny(s, "_xXpPnN") {
+			return math.NaN()
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return math.NaN()
+}
+
+// expressionBoolean converts v to a boolean like Boolean() of JavaScript.
+func expressionBoolean(v interface{}) bool {
+	switch t := v.(type) {
+	case nil, StylingUndefined:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0 && !math.IsNaN(t)
+	case string:
+		return t != ""
+	}
+	return true
+}
+
+func expressionEqual(a, b interface{}) bool {
+	ta, oka := expressionType(a)
+	tb, okb := expressionType(b)
+	if !oka || !okb || ta != tb {
+		return false
+	}
+	switch x := a.(type) {
+	case *StylingRegExp:
+		y := b.(*StylingRegExp)
+		return x == y || (x.Pattern == y.Pattern && x.Flags == y.Flags)
+	case []interface{}:
+		return reflect.DeepEqual(a, b)
+	}
+	// Comparable values, where NaN is not equal to itself.
+	return a == b
+}
+
+func (n *ExpressionNode) evalArgs(ctx *ExpressionContext) ([]interface{}, error) {
+	ret := make([]interface{}, len(n.Args))
+	for i, a := range n.Args {
+		v, err := a.Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = v
+	}
+	return ret, nil
+}
+
+func (n *ExpressionNode) evalVariableInString(ctx *ExpressionContext) (interface{}, error) {
+	s := n.Value.(string)
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return nil, n.errorf("unterminated variable in string")
+		}
+		v, err := ParseExpression(s[i : i+end+1])
+		if err != nil {
+			return nil, n.errorf("invalid variable %q in string", s[i:i+end+1])
+		}
+		value, err := v.Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(s[:i])
+		b.WriteString(expressionString(value))
+		s = s[i+end+1:]
+	}
+}
+
+// componentwise applies fn to a number or to every component of a vector.
+func (n *ExpressionNode) componentwise(v interface{}, fn func(float64) float64) (interface{}, error) {
+	if f, ok := v.(float64); ok {
+		return fn(f), nil
+	}
+	c, ok := vectorComponents(v)
+	if !ok {
+		return nil, n.errorf("%s requires a number or a vector, got %s", n.Value, expressionTypeName(v))
+	}
+	ret := make([]float64, len(c))
+	for i, f := range c {
+		ret[i] = fn(f)
+	}
+	return makeVector(ret), nil
+}
+
+// componentwise2 applies fn to two numbers or to the components of two
+// vectors of the same size.
+func (n *ExpressionNode) componentwise2(a, b interface{}, fn func(x, y float64) float64) (interface{}, error) {
+	x, okx := a.(float64)
+	y, oky := b.(float64)
+	if okx && oky {
+		return fn(x, y), nil
+	}
+	ca, oka := vectorComponents(a)
+	cb, okb := vectorComponents(b)
+	if !oka || !okb || len(ca) != len(cb) {
+		return nil, n.errorf("%s requires two numbers or two vectors of the same size, got %s and %s", n.Value, expressionTypeName(a), expressionTypeName(b))
+	}
+	ret := make([]float64, len(ca))
+	for i := range ca {
+		ret[i] = fn(ca[i], cb[i])
+	}
+	return makeVector(ret), nil
+}
+
+func (n *ExpressionNode) evalUnary(ctx *ExpressionContext) (interface{}, error) {
+	name := n.Value.(string)
+	if name == FUNC_GET_EXACTCLASSNAME {
+		if f, ok := ctx.Feature.(ExpressionClassFeature); ok {
+			if name, ok := f.ExactClassName(); ok {
+				return name, nil
+			}
+		}
+		return StylingUndefined{}, nil
+	}
+	v, err := n.Left.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case OP_NOT:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, n.errorf("operator ! requires a boolean, got %s", expressionTypeName(v))
+		}
+		return !b, nil
+	case OP_NEGATIVE:
+		return n.componentwise(v, func(f float64) float64 { return -f })
+	case OP_POSITIVE:
+		return n.componentwise(v, func(f float64) float64 { return f })
+	case FUNC_IS_EXACTCLASS, FUNC_IS_CLASS:
+		s, ok := v.(string)
+		if !ok {
+			return nil, n.errorf("%s() requires a string, got %s", name, expressionTypeName(v))
+		}
+		f, ok := ctx.Feature.(ExpressionClassFeature)
+		if !ok {
+			return false, nil
+		}
+		if name == FUNC_IS_CLASS {
+			return f.IsClass(s), nil
+		}
+		return f.IsExactClass(s), nil
+	case FUNC_IS_NAN:
+		return math.IsNaN(expressionNumber(v)), nil
+	case FUNC_IS_FINITE:
+		f := expressionNumber(v)
+		return !math.IsNaN(f) && !math.IsInf(f, 0), nil
+	case FUNC_BOOLEAN:
+		return expressionBoolean(v), nil
+	case FUNC_NUMBER:
+		return expressionNumber(v), nil
+	case FUNC_STRING:
+		return expressionString(v), nil
+	case FUNC_LENGTH, FUNC_NORMALIZE:
+		if f, ok := v.(float64); ok {
+			if name == FUNC_LENGTH {
+				return math.Abs(f), nil
+			}
+			return 1.0, nil
+		}
+		c, ok := vectorComponents(v)
+		if !ok {
+			return nil, n.errorf("%s() requires a number or a vector, got %s", name, expressionTypeName(v))
+		}
+		l := 0.0
+		for _, f := range c {
+			l += f * f
+		}
+		l = math.Sqrt(l)
+		if name == FUNC_LENGTH {
+			return l, nil
+		}
+		return n.componentwise(v, func(f float64) float64 { return f / l })
+	}
+	fn, ok := unaryMath[name]
+	if !ok {
+		return nil, n.errorf("unknown function %q", name)
+	}
+	return n.componentwise(v, fn)
+}
+
+var unaryMath = map[string]func(float64) float64{
+	FUNC_ABS:     math.Abs,
+	FUNC_SQRT:    math.Sqrt,
+	FUNC_COS:     math.Cos,
+	FUNC_SIN:     math.Sin,
+	FUNC_TAN:     math.Tan,
+	FUNC_ACOS:    math.Acos,
+	FUNC_ASIN:    math.Asin,
+	FUNC_ATAN:    math.Atan,
+	FUNC_RADIANS: func(f float64) float64 { return f * math.Pi / 180 },
+	FUNC_DEGREES: func(f float64) float64 { return f * 180 / math.Pi },
+	FUNC_SIGN: func(f float64) float64 {
+		switch {
+		case f > 0:
+			return 1
+		case f < 0:
+			return -1
+		}
+		return f
+	},
+	FUNC_FLOOR: math.Floor,
+	FUNC_CEIL:  math.Ceil,
+	// Math.round of JavaScript rounds halves up.
+	FUNC_ROUND: func(f float64) float64 { return math.Floor(f + 0.5) },
+	FUNC_EXP:   math.Exp,
+	FUNC_EXP2:  math.Exp2,
+	FUNC_LOG:   math.Log,
+	FUNC_LOG2:  math.Log2,
+	FUNC_FRACT: func(f float64) float64 { return f - math.Floor(f) },
+}
+
+func (n *ExpressionNode) evalBinary(ctx *ExpressionContext) (interface{}, error) {
+	name := n.Value.(string)
+	left, err := n.Left.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if name == OP_AND || name == OP_OR {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, n.errorf("operator %s requires booleans, got %s", name, expressionTypeName(left))
+		}
+		if l == (name == OP_OR) {
+			return l, nil
+		}
+	}
+	right, err := n.Right.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case OP_AND, OP_OR:
+		r, ok := right.(bool)
+		if !ok {
+			return nil, n.errorf("operator %s requires booleans, got %s", name, expressionTypeName(right))
+		}
+		return r, nil
+	case OP_EQ:
+		return expressionEqual(left, right), nil
+	case OP_NEQ:
+		return !expressionEqual(left, right), nil
+	case OP_REGEXP, OP_REGEXP_NOT:
+		re, ok := left.(*StylingRegExp)
+		other := right
+		if !ok {
+			re, ok = right.(*StylingRegExp)
+			other = left
+		}
+		if !ok {
+			return nil, n.errorf("operator %s requires a regexp, got %s and %s", name, expressionTypeName(left), expressionTypeName(right))
+		}
+		return re.Regexp.MatchString(expressionString(other)) == (name == OP_REGEXP), nil
+	case OP_LESS, OP_LESS_EQ, OP_GREATER, OP_GREATER_EQ:
+		l, okl := left.(float64)
+		r, okr := right.(float64)
+		if !okl || !okr {
+			return nil, n.errorf("operator %s requires numbers, got %s and %s", name, expressionTypeName(left), expressionTypeName(right))
+		}
+		switch name {
+		case OP_LESS:
+			return l < r, nil
+		case OP_LESS_EQ:
+			return l <= r, nil
+		case OP_GREATER:
+			return l > r, nil
+		}
+		return l >= r, nil
+	case OP_ADD:
+		_, ls := left.(string)
+		_, rs := right.(string)
+		if ls || rs {
+			return expressionString(left) + expressionString(right), nil
+		}
+		return n.componentwise2(left, right, func(x, y float64) float64 { return x + y })
+	case OP_SUB:
+		return n.componentwise2(left, right, func(x, y float64) float64 { return x - y })
+	case OP_MUL, OP_DIV:
+		fn := func(x, y float64) float64 { return x * y }
+		if name == OP_DIV {
+			fn = func(x, y float64) float64 { return x / y }
+		}
+		// Vectors are scaled by numbers.
+		if f, ok := right.(float64); ok {
+			if _, ok := vectorComponents(left); ok {
+				return n.componentwise(left, func(x float64) float64 { return fn(x, f) })
+			}
+		}
+		if f, ok := left.(float64); ok && name == OP_MUL {
+			if _, ok := vectorComponents(right); ok {
+				return n.componentwise(right, func(x float64) float64 { return fn(f, x) })
+			}
+		}
+		return n.componentwise2(left, right, fn)
+	case OP_MOD:
+		return n.componentwise2(left, right, math.Mod)
+	case FUNC_ATAN2:
+		return n.componentwise2(left, right, math.Atan2)
+	case FUNC_POW:
+		return n.componentwise2(left, right, math.Pow)
+	case FUNC_MIN:
+		return n.componentwise2(left, right, math.Min)
+	case FUNC_MAX:
+		return n.componentwise2(left, right, math.Max)
+	case FUNC_DISTANCE, FUNC_DOT:
+		v, err := n.componentwise2(left, right, func(x, y float64) float64 {
+			if name == FUNC_DOT {
+				return x * y
+			}
+			return (x - y) * (x - y)
+		})
+		if err != nil {
+			return nil, err
+		}
+		sum := 0.0
+		if c, ok := vectorComponents(v); ok {
+			for _, f := range c {
+				sum += f
+			}
+		} else {
+			sum = v.(float64)
+		}
+		if name == FUNC_DISTANCE {
+			return math.Sqrt(sum), nil
+		}
+		return sum, nil
+	case FUNC_CROSS:
+		a, oka := left.([3]float64)
+		b, okb := right.([3]float64)
+		if !oka || !okb {
+			return nil, n.errorf("cross() requires two vec3, got %s and %s", expressionTypeName(left), expressionTypeName(right))
+		}
+		return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}, nil
+	}
+	return nil, n.errorf("unknown operator %q", name)
+}
+
+func (n *ExpressionNode) evalTernary(ctx *ExpressionContext) (interface{}, error) {
+	args, err := n.evalArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch n.Value {
+	case FUNC_CLAMP:
+		v, err := n.componentwise2(args[0], args[1], math.Max)
+		if err != nil {
+			return nil, err
+		}
+		return n.componentwise2(v, args[2], math.Min)
+	case FUNC_MIX:
+		// The weight is a number or a vector like the values.
+		if a, ok := args[2].(float64); ok {
+			return n.componentwise2(args[0], args[1], func(x, y float64) float64 { return x*(1-a) + y*a })
+		}
+		d, err := n.componentwise2(args[1], args[0], func(y, x float64) float64 { return y - x })
+		if err != nil {
+			return nil, err
+		}
+		d, err = n.componentwise2(d, args[2], func(d, a float64) float64 { return d * a })
+		if err != nil {
+			return nil, err
+		}
+		return n.componentwise2(args[0], d, func(x, d float64) float64 { return x + d })
+	}
+	return nil, n.errorf("unknown function %q", n.Value)
+}
+
+var vectorMembers = map[string]int{"x": 0, "y": 1, "z": 2, "w": 3, "r": 0, "g": 1, "b": 2, "a": 3}
+
+// evalMember returns a member of a vector, an array or an object, and
+// undefined for missing members and other values.
+func (n *ExpressionNode) evalMember(ctx *ExpressionContext) (interface{}, error) {
+	obj, err := n.Left.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.Right.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	switch k := key.(type) {
+	case float64:
+		if k == math.Trunc(k) && k >= 0 && k <= math.MaxInt32 {
+			index = int(k)
+		}
+	case string:
+		if i, ok := vectorMembers[k]; ok {
+			if _, ok := vectorComponents(obj); ok {
+				index = i
+			}
+		} else if i, err := strconv.Atoi(k); err == nil && strconv.Itoa(i) == k {
+			index = i
+		}
+	}
+	switch o := obj.(type) {
+	case []interface{}:
+		if index >= 0 && index < len(o) {
+			return expressionValue(o[index]), nil
+		}
+	case map[string]interface{}:
+		if v, ok := o[expressionString(key)]; ok {
+			return expressionValue(v), nil
+		}
+	default:
+		if c, ok := vectorComponents(obj); ok && index >= 0 && index < len(c) {
+			return c[index], nil
+		}
+	}
+	return StylingUndefined{}, nil
+}
+
+func (n *ExpressionNode) evalFunctionCall(ctx *ExpressionContext) (interface{}, error) {
+	obj, err := n.Left.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	args, err := n.evalArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n.Value == FUNC_TO_STRING {
+		return expressionString(obj), nil
+	}
+	re, ok := obj.(*StylingRegExp)
+	if !ok {
+		return nil, n.errorf("%s() requires a regexp, got %s", n.Value, expressionTypeName(obj))
+	}
+	s := expressionString(args[0])
+	if n.Value == FUNC_TEST {
+		return re.Regexp.MatchString(s), nil
+	}
+	// exec returns the first captured group.
+	match := re.Regexp.FindStringSubmatch(s)
+	if match == nil {
+		return nil, nil
+	}
+	if len(match) < 2 {
+		return StylingUndefined{}, nil
+	}
+	return match[1], nil
+}
+
+func (n *ExpressionNode) evalVector(ctx *ExpressionContext) (interface{}, error) {
+	args, err := n.evalArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	size := vectorSizes[n.Value.(string)]
+	var c []float64
+	for _, a := range args {
+		if f, ok := a.(float64); ok {
+			c = append(c, f)
+			continue
+		}
+		v, ok := vectorComponents(a)
+		if !ok {
+			return nil, n.errorf("%s() takes numbers and vectors, got %s", n.Value, expressionTypeName(a))
+		}
+		c = append(c, v...)
+	}
+	if len(args) == 1 && len(c) == 1 {
+		for len(c) < size {
+			c = append(c, c[0])
+		}
+	}
+	if len(c) != size {
+		return nil, n.errorf("%s() takes %d components, got %d", n.Value, size, len(c))
+	}
+	return makeVector(c), nil
+}
+
+func (n *ExpressionNode) evalColor(ctx *ExpressionContext) (interface{}, error) {
+	args, err := n.evalArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name := n.Value.(string)
+	if name == FUNC_COLOR {
+		if len(args) == 0 {
+			return [4]float64{1, 1, 1, 1}, nil
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, n.errorf("color() requires a string, got %s", expressionTypeName(args[0]))
+		}
+		c, ok := parseCssColor(s)
+		if !ok {
+			return nil, n.errorf("invalid color %q", s)
+		}
+		if len(args) == 2 {
+			a, ok := args[1].(float64)
+			if !ok {
+				return nil, n.errorf("color() alpha must be a number, got %s", expressionTypeName(args[1]))
+			}
+			c[3] = a
+		}
+		return c, nil
+	}
+	c := [4]float64{0, 0, 0, 1}
+	for i, a := range args {
+		f, ok := a.(float64)
+		if !ok {
+			return nil, n.errorf("%s() requires numbers, got %s", name, expressionTypeName(a))
+		}
+		c[i] = f
+	}
+	if name == FUNC_RGB || name == FUNC_RGBA {
+		return [4]float64{c[0] / 255, c[1] / 255, c[2] / 255, c[3]}, nil
+	}
+	r, g, b := hslToRgb(c[0], c[1], c[2])
+	return [4]float64{r, g, b, c[3]}, nil
+}
+
+// hslToRgb converts a hue, saturation and lightness in [0, 1] to RGB.
+func hslToRgb(h, s, l float64) (float64, float64, float64) {
+	if s == 0 {
+		return l, l, l
+	}
+	q := l * (1 + s)
+	if l >= 0.5 {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+	hue := func(t float64) float64 {
+		t -= math.Floor(t)
+		switch {
+		case t < 1.0/6:
+			return p + (q-p)*6*t
+		case t < 0.5:
+			return q
+		case t < 2.0/3:
+			return p + (q-p)*(2.0/3-t)*6
+		}
+		return p
+	}
+	return hue(h + 1.0/3), hue(h), hue(h - 1.0/3)
+}
+
+// parseCssColor parses a CSS color: a name, #rgb, #rgba, #rrggbb,
+// #rrggbbaa, rgb(), rgba(), hsl() or hsla().
+func parseCssColor(s string) ([4]float64, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "transparent" {
+		return [4]float64{}, true
+	}
+	if v, ok := cssColors[s]; ok {
+		return [4]float64{float64(v>>16) / 255, float64(v>>8&0xff) / 255, float64(v&0xff) / 255, 1}, true
+	}
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		digits := 2
+		if len(hex) == 3 || len(hex) == 4 {
+			digits = 1
+		} else if len(hex) != 6 && len(hex) != 8 {
+			return [4]float64{}, false
+		}
+		c := [4]float64{1, 1, 1, 1}
+		for i := 0; i*digits < len(hex); i++ {
+			v, err := strconv.ParseUint(hex[i*digits:(i+1)*digits], 16, 8)
+			if err != nil {
+				return c, false
+			}
+			if digits == 1 {
+				v *= 17
+			}
+			c[i] = float64(v) / 255
+		}
+		return c, true
+	}
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return [4]float64{}, false
+	}
+	fn := strings.TrimSpace(s[:open])
+	parts := strings.Split(s[open+1:len(s)-1], ",")
+	want := map[string]int{"rgb": 3, "rgba": 4, "hsl": 3, "hsla": 4}[fn]
+	if want == 0 || len(parts) != want {
+		return [4]float64{}, false
+	}
+	c := [4]float64{0, 0, 0, 1}
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		percent := strings.HasSuffix(p, "%")
+		f, err := strconv.ParseFloat(strings.TrimSuffix(p, "%"), 64)
+		if err != nil {
+			return c, false
+		}
+		switch {
+		case percent:
+			f /= 100
+		case i == 3:
+		case fn == "rgb" || fn == "rgba":
+			f /= 255
+		case i == 0:
+			f /= 360
+		}
+		c[i] = f
+	}
+	if fn == "hsl" || fn == "hsla" {
+		c[0], c[1], c[2] = hslToRgb(c[0], c[1], c[2])
+	}
+	return c, true
+}
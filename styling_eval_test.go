@@ -0,0 +1,122 @@
+package tile3d
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	feature := ExpressionProperties{
+		"height":   int32(25),
+		"name":     "Tower 7",
+		"position": []float32{1, 2, 3},
+		"tags":     []interface{}{"a", "b"},
+		"info":     map[string]interface{}{"year": 1999.0},
+		"flag":     true,
+		"名称":       "塔",
+	}
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		{"1 + 2 * 3", 7.0},
+		{"-7 % 3", -1.0},
+		{"${height} > 20 && ${flag}", true},
+		{"${missing} === undefined", true},
+		{"false && ${missing}", false},
+		{"${height} > 30 ? 'high' : 'low'", "low"},
+		{"'h' + ${height}", "h25"},
+		{"'v' + vec2(1.5, 2)", "v(1.5, 2)"},
+		{"String(1e21) + ',' + String(0.0000001)", "1e+21,1e-7"},
+		{"'${name} is ${height}m'", "Tower 7 is 25m"},
+		{"${名称} + '${名称}'", "塔塔"},
+		{"Number('0x10') + Number(true)", 17.0},
+		{"isNaN('abc') && !isNaN('12') && isFinite(1) && !isFinite(1/0)", true},
+		{"Boolean('') || Boolean(0 / 0)", false},
+		{"${name} =~ regExp('^tower', 'i')", true},
+		{"regExp('\\\\d+') !~ ${name}", false},
+		{"regExp('(\\\\d+)').exec(${name})", "7"},
+		{"regExp('x').exec(${name})", nil},
+		{"${tags}[1] + ${info}.year + ${info}['month']", "b1999undefined"},
+		{"${position}.y + ${position}[2]", 5.0},
+		{"${position} * 2 - vec3(1)", [3]float64{1, 3, 5}},
+		{"vec4(vec2(1, 2), 3, 4).w", 4.0},
+		{"vec3(1, 2, 3) === vec3(1, 2, 3) && 0 / 0 !== 0 / 0", true},
+		{"cross(vec3(1, 0, 0), vec3(0, 1, 0))", [3]float64{0, 0, 1}},
+		{"dot(vec2(1, 2), vec2(3, 4)) + distance(vec2(0), vec2(3, 4))", 16.0},
+		{"clamp(vec2(-1, 5), vec2(0), vec2(1))", [2]float64{0, 1}},
+		{"mix(0, 10, 0.25) + round(-2.5) + fract(1.75) + sign(-3)", 0.25},
+		{"length(normalize(vec3(3, 4, 0)))", 1.0},
+		{"color('red', 0.5)", [4]float64{1, 0, 0, 0.5}},
+		{"color('#00f') === color('rgb(0, 0, 255)')", true},
+		{"color('hsl(120, 100%, 50%)')", [4]float64{0, 1, 0, 1}},
+		{"rgba(255, 0, 255, 0.5).a + rgb(51, 0, 0).r", 0.7},
+		{"hsla(0, 1, 0.5, 1)", [4]float64{1, 0, 0, 1}},
+		{"color().rgb", StylingUndefined{}},
+		{"isClass('room')", false},
+		{"${tiles3d_tileset_time} * 2", 0.0},
+	}
+	for _, tt := range tests {
+		v, err := Expression(tt.expr).Evaluate(feature)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if f, ok := v.(float64); ok {
+			if w, ok := tt.want.(float64); ok && math.Abs(f-w) < 1e-9 {
+				continue
+			}
+		}
+		if !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.expr, v, tt.want)
+		}
+	}
+
+	n, _ := ParseExpression("${tiles3d_tileset_time} + 1")
+	ctx := NewExpressionContext(nil)
+	ctx.TilesetTime = 2
+	if v, err := n.Evaluate(ctx); v != 3.0 || err != nil {
+		t.Errorf("tileset time %v %v", v, err)
+	}
+
+	// Features of a hierarchy have the properties and classes of their
+	// ancestors.
+	bt := &BatchTable{Header: map[string]interface{}{"id": []interface{}{10.0, 11.0, 12.0, 13.0}}}
+	if err := bt.SetHierarchy(newBuildingHierarchy()); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewBatchTableFeature(bt, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for expr, want := range map[string]interface{}{
+		"${id}":              12.0,
+		"${area} + ${level}": 31.0,
+		"${name}":            "tower",
+		"isExactClass('room') && isClass('floor')": true,
+		"isExactClass('floor')":                    false,
+		"getExactClassName()":                      "room",
+	} {
+		if v, err := Expression(expr).Evaluate(f); !reflect.DeepEqual(v, want) || err != nil {
+			t.Errorf("%s = %#v, %v", expr, v, err)
+		}
+	}
+
+	for expr, offset := range map[string]int{
+		"!1":                        0,
+		"1 < 'a'":                   0,
+		"vec2(1, 2) + vec3(1)":      0,
+		"${flag} ? (1 ? 2 : 3) : 2": 11,
+		"color('nocolor')":          0,
+		"vec3(1, 2)":                0,
+		"cross(vec2(1), vec2(2))":   0,
+	} {
+		_, err := Expression(expr).Evaluate(feature)
+		var e *ExpressionError
+		if !errors.As(err, &e) || !errors.Is(err, ErrBadExpression) || e.Offset != offset || e.Expression != expr {
+			t.Errorf("%s: %v", expr, err)
+		}
+	}
+}
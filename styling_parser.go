@@ -0,0 +1,878 @@
+package tile3d
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	FUNC_COLOR     = "color"
+	FUNC_RGB       = "rgb"
+	FUNC_RGBA      = "rgba"
+	FUNC_HSL       = "hsl"
+	FUNC_HSLA      = "hsla"
+	FUNC_VEC2      = "vec2"
+	FUNC_VEC3      = "vec3"
+	FUNC_VEC4      = "vec4"
+	FUNC_REGEXP    = "regExp"
+	FUNC_TEST      = "test"
+	FUNC_EXEC      = "exec"
+	FUNC_TO_STRING = "toString"
+)
+
+const BUILTIN_TILESET_TIME = "tiles3d_tileset_time"
+
+// ExpressionError is a syntax error at the byte Offset of a styling
+// expression.
+type ExpressionError struct {
+	Expression string
+	Offset     int
+	Msg        string
+}
+
+func (e *ExpressionError) Error() string {
+	if e.Expression == "" {
+		return fmt.Sprintf("styling: %s at offset %d", e.Msg, e.Offset)
+	}
+	return fmt.Sprintf("styling: %s at offset %d of %q", e.Msg, e.Offset, e.Expression)
+}
+
+func (e *ExpressionError) Unwrap() error {
+	return ErrBadExpression
+}
+
+// StylingRegExp is a regular expression of a styling expression. The
+// flags i, m and s are applied to Regexp, g and y have no effect.
+type StylingRegExp struct {
+	Pattern string
+	Flags   string
+	Regexp  *regexp.Regexp
+}
+
+func NewStylingRegExp(pattern, flags string) (*StylingRegExp, error) {
+	prefix := ""
+	for _, f := range flags {
+		switch f {
+		case 'i', 'm', 's':
+			if !strings.ContainsRune(prefix, f) {
+				prefix += string(f)
+			}
+		case 'g', 'y', 'u':
+		default:
+			return nil, fmt.Errorf("invalid regexp flag %q", f)
+		}
+	}
+	expr := pattern
+	if prefix != "" {
+		expr = "(?" + prefix + ")" + pattern
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &StylingRegExp{Pattern: pattern, Flags: flags, Regexp: re}, nil
+}
+
+func (r *StylingRegExp) String() string {
+	return "/" + r.Pattern + "/" + r.Flags
+}
+
+// ExpressionNode is a node of the AST of a styling expression.
+//
+// Value is the operator or function name of unary, binary and ternary
+// nodes, the name of variables, "." or "[]" for members, the method of
+// function calls, the constructor of colors and vectors and the value of
+// literals: nil, bool, float64, string or *StylingRegExp. Operands are in
+// Left and Right, and the condition of a conditional in Test. Arguments of
+// ternary functions, function calls, colors, vectors and regular
+// expressions and the elements of arrays are in Args.
+type ExpressionNode struct {
+	Type  ExpressionNodeType
+	Value interface{}
+	Left  *ExpressionNode
+	Right *ExpressionNode
+	Test  *ExpressionNode
+	Args  []*ExpressionNode
+	// Offset is the byte offset of the node in the expression.
+	Offset int
+}
+
+var (
+	unaryFunctions = map[string]bool{
+		FUNC_IS_NAN: true, FUNC_IS_FINITE: true, FUNC_IS_EXACTCLASS: true, FUNC_IS_CLASS: true,
+		FUNC_GET_EXACTCLASSNAME: true, FUNC_BOOLEAN: true, FUNC_NUMBER: true, FUNC_STRING: true,
+		FUNC_ABS: true, FUNC_SQRT: true, FUNC_COS: true, FUNC_SIN: true, FUNC_TAN: true,
+		FUNC_ACOS: true, FUNC_ASIN: true, FUNC_ATAN: true, FUNC_RADIANS: true, FUNC_DEGREES: true,
+		FUNC_SIGN: true, FUNC_FLOOR: true, FUNC_CEIL: true, FUNC_ROUND: true, FUNC_EXP: true,
+		FUNC_EXP2: true, FUNC_LOG: true, FUNC_LOG2: true, FUNC_FRACT: true, FUNC_LENGTH: true,
+		FUNC_NORMALIZE: true,
+	}
+	binaryFunctions = map[string]bool{
+		FUNC_ATAN2: true, FUNC_POW: true, FUNC_MIN: true, FUNC_MAX: true,
+		FUNC_DISTANCE: true, FUNC_DOT: true, FUNC_CROSS: true,
+	}
+	ternaryFunctions = map[string]bool{FUNC_CLAMP: true, FUNC_MIX: true}
+	// colorArgs is the range of the number of arguments of color
+	// constructors.
+	colorArgs = map[string][2]int{
+		FUNC_COLOR: {0, 2}, FUNC_RGB: {3, 3}, FUNC_RGBA: {4, 4}, FUNC_HSL: {3, 3}, FUNC_HSLA: {4, 4},
+	}
+	vectorSizes = map[string]int{FUNC_VEC2: 2, FUNC_VEC3: 3, FUNC_VEC4: 4}
+	// builtinNumbers are the constants of Math and Number.
+	builtinNumbers = map[string]float64{
+		"Math.PI":                  math.Pi,
+		"Math.E":                   math.E,
+		"Number.POSITIVE_INFINITY": math.Inf(1),
+		"Number.NEGATIVE_INFINITY": math.Inf(-1),
+	}
+)
+
+type exprTokenType int
+
+const (
+	tokenEOF exprTokenType = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenPunct
+	tokenVariable
+)
+
+type exprToken struct {
+	typ    exprTokenType
+	text   string
+	str    string
+	num    float64
+	offset int
+}
+
+// exprPuncts are the operators and punctuation, longest first.
+var exprPuncts = []string{
+	"===", "!==",
+	"==", "!=", "=~", "!~", ">=", "<=", "&&", "||",
+	"!", "-", "+", "*", "/", "%", ">", "<", "?", ":", "(", ")", "[", "]", ",", ".", "}",
+}
+
+type exprLexer struct {
+	src    string
+	pos    int
+	tokens []exprToken
+}
+
+func (l *exprLexer) errorf(offset int, format string, args ...interface{}) error {
+	return &ExpressionError{Expression: l.src, Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+// isIdentStart and isIdentPart accept letters and digits of any script,
+// so variables can be named in any language.
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// lexExpression splits src into tokens.
+func lexExpression(src string) ([]exprToken, error) {
+	l := &exprLexer{src: src}
+	for {
+		for l.pos < len(src) && strings.IndexByte(" \t\r\n", src[l.pos]) >= 0 {
+			l.pos++
+		}
+		if l.pos >= len(src) {
+			l.tokens = append(l.tokens, exprToken{typ: tokenEOF, offset: l.pos})
+			return l.tokens, nil
+		}
+		start := l.pos
+		c := src[l.pos]
+		r, size := utf8.DecodeRuneInString(src[l.pos:])
+		switch {
+		case isDigit(c) || (c == '.' && l.pos+1 < len(src) && isDigit(src[l.pos+1])):
+			if err := l.number(); err != nil {
+				return nil, err
+			}
+		case c == '\'' || c == '"':
+			if err := l.string(c); err != nil {
+				return nil, err
+			}
+		case c == '$' && strings.HasPrefix(src[l.pos:], "${"):
+			l.pos += 2
+			l.tokens = append(l.tokens, exprToken{typ: tokenVariable, text: "${", offset: start})
+		case isIdentStart(r):
+			for l.pos < len(src) && isIdentPart(r) {
+				l.pos += size
+				r, size = utf8.DecodeRuneInString(src[l.pos:])
+			}
+			l.tokens = append(l.tokens, exprToken{typ: tokenIdent, text: src[start:l.pos], offset: start})
+		default:
+			punct := ""
+			for _, p := range exprPuncts {
+				if strings.HasPrefix(src[l.pos:], p) {
+					punct = p
+					break
+				}
+			}
+			switch punct {
+			case "":
+				return nil, l.errorf(start, "unexpected character %q", r)
+			case "==", "!=":
+				return nil, l.errorf(start, "operator %s is not supported, use %s=", punct, punct)
+			}
+			l.pos += len(punct)
+			l.tokens = append(l.tokens, exprToken{typ: tokenPunct, text: punct, offset: start})
+		}
+	}
+}
+
+func (l *exprLexer) number() error {
+	start := l.pos
+	src := l.src
+	if strings.HasPrefix(src[l.pos:], "0x") || strings.HasPrefix(src[l.pos:], "0X") {
+		l.pos += 2
+		for l.pos < len(src) && strings.IndexByte("0123456789abcdefABCDEF", src[l.pos]) >= 0 {
+			l.pos++
+		}
+		n, err := strconv.ParseUint(src[start+2:l.pos], 16, 64)
+		if err != nil {
+			return l.errorf(start, "invalid number %q", src[start:l.pos])
+		}
+		l.tokens = append(l.tokens, exprToken{typ: tokenNumber, text: src[start:l.pos], num: float64(n), offset: start})
+		return nil
+	}
+	for l.pos < len(src) && isDigit(src[l.pos]) {
+		l.pos++
+	}
+	if l.pos < len(src) && src[l.pos] == '.' {
+		l.pos++
+		for l.pos < len(src) && isDigit(src[l.pos]) {
+			l.pos++
+		}
+	}
+	if l.pos < len(src) && (src[l.pos] == 'e' || src[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(src) && (src[l.pos] == '+' || src[l.pos] == '-') {
+			l.pos++
+		}
+		digits := l.pos
+		for l.pos < len(src) && isDigit(src[l.pos]) {
+			l.pos++
+		}
+		if digits == l.pos {
+			return l.errorf(start, "invalid number %q", src[start:l.pos])
+		}
+	}
+	if r, _ := utf8.DecodeRuneInString(src[l.pos:]); isIdentStart(r) {
+		return l.errorf(l.pos, "unexpected character %q after number", r)
+	}
+	n, err := strconv.ParseFloat(src[start:l.pos], 64)
+	if err != nil {
+		return l.errorf(start, "invalid number %q", src[start:l.pos])
+	}
+	l.tokens = append(l.tokens, exprToken{typ: tokenNumber, text: src[start:l.pos], num: n, offset: start})
+	return nil
+}
+
+func (l *exprLexer) string(quote byte) error {
+	start := l.pos
+	src := l.src
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(src) {
+			return l.errorf(start, "unterminated string")
+		}
+		c := src[l.pos]
+		if c == quote {
+			l.pos++
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			l.pos++
+			continue
+		}
+		if l.pos+1 >= len(src) {
+			return l.errorf(start, "unterminated string")
+		}
+		l.pos += 2
+		switch e := src[l.pos-1]; e {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		case '0':
+			b.WriteByte(0)
+		case 'u':
+			if l.pos+4 > len(src) {
+				return l.errorf(l.pos-2, "invalid unicode escape")
+			}
+			r, err := strconv.ParseUint(src[l.pos:l.pos+4], 16, 32)
+			if err != nil {
+				return l.errorf(l.pos-2, "invalid unicode escape")
+			}
+			b.WriteRune(rune(r))
+			l.pos += 4
+		default:
+			b.WriteByte(e)
+		}
+	}
+	l.tokens = append(l.tokens, exprToken{typ: tokenString, text: src[start:l.pos], str: b.String(), offset: start})
+	return nil
+}
+
+type exprParser struct {
+	src    string
+	tokens []exprToken
+	pos    int
+}
+
+// ParseExpression parses a 3D Tiles styling expression.
+func ParseExpression(src string) (*ExpressionNode, error) {
+	tokens, err := lexExpression(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	n, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, p.unexpected(t)
+	}
+	return n, nil
+}
+
+// Parse parses the expression e, see ParseExpression.
+func (e Expression) Parse() (*ExpressionNode, error) {
+	return ParseExpression(string(e))
+}
+
+func (p *exprParser) errorf(offset int, format string, args ...interface{}) error {
+	return &ExpressionError{Expression: p.src, Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) unexpected(t exprToken) error {
+	if t.typ == tokenEOF {
+		return p.errorf(t.offset, "unexpected end of expression")
+	}
+	return p.errorf(t.offset, "unexpected %q", t.text)
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token when it is one of the punctuations.
+func (p *exprParser) accept(puncts ...string) (exprToken, bool) {
+	t := p.peek()
+	if t.typ != tokenPunct {
+		return t, false
+	}
+	for _, s := range puncts {
+		if t.text == s {
+			p.pos++
+			return t, true
+		}
+	}
+	return t, false
+}
+
+func (p *exprParser) expect(punct string) (exprToken, error) {
+	t, ok := p.accept(punct)
+	if !ok {
+		if t.typ == tokenEOF {
+			return t, p.errorf(t.offset, "expected %q at end of expression", punct)
+		}
+		return t, p.errorf(t.offset, "expected %q, found %q", punct, t.text)
+	}
+	return t, nil
+}
+
+func (p *exprParser) conditional() (*ExpressionNode, error) {
+	test, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return test, nil
+	}
+	left, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	right, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	return &ExpressionNode{Type: EXP_CONDITIONAL, Test: test, Left: left, Right: right, Offset: test.Offset}, nil
+}
+
+// binaryLevels are the binary operators from the lowest precedence.
+var binaryLevels = [][]string{
+	{OP_OR},
+	{OP_AND},
+	{OP_EQ, OP_NEQ, OP_REGEXP, OP_REGEXP_NOT},
+	{OP_LESS, OP_LESS_EQ, OP_GREATER, OP_GREATER_EQ},
+	{OP_ADD, OP_SUB},
+	{OP_MUL, OP_DIV, OP_MOD},
+}
+
+func (p *exprParser) binary(level int) (*ExpressionNode, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(binaryLevels[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &ExpressionNode{Type: EXP_BINARY, Value: op.text, Left: left, Right: right, Offset: left.Offset}
+	}
+}
+
+func (p *exprParser) unary() (*ExpressionNode, error) {
+	if op, ok := p.accept(OP_NOT, OP_NEGATIVE, OP_POSITIVE); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &ExpressionNode{Type: EXP_UNARY, Value: op.text, Left: operand, Offset: op.offset}, nil
+	}
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	return p.postfix(n)
+}
+
+// postfix parses the member accesses and method calls following n.
+func (p *exprParser) postfix(n *ExpressionNode) (*ExpressionNode, error) {
+	for {
+		t, ok := p.accept(".", "[")
+		if !ok {
+			return n, nil
+		}
+		if t.text == "[" {
+			key, err := p.conditional()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &ExpressionNode{Type: EXP_MEMBER, Value: "[]", Left: n, Right: key, Offset: n.Offset}
+			continue
+		}
+		name := p.next()
+		if name.typ != tokenIdent {
+			return nil, p.errorf(name.offset, "expected a property name after \".\"")
+		}
+		if _, ok := p.accept("("); !ok {
+			key := &ExpressionNode{Type: EXP_LITERAL_STRING, Value: name.text, Offset: name.offset}
+			n = &ExpressionNode{Type: EXP_MEMBER, Value: ".", Left: n, Right: key, Offset: n.Offset}
+			continue
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		switch name.text {
+		case FUNC_TEST, FUNC_EXEC:
+			if len(args) != 1 {
+				return nil, p.errorf(name.offset, "%s() takes 1 argument, got %d", name.text, len(args))
+			}
+		case FUNC_TO_STRING:
+			if len(args) != 0 {
+				return nil, p.errorf(name.offset, "%s() takes no arguments, got %d", name.text, len(args))
+			}
+		default:
+			return nil, p.errorf(name.offset, "unknown method %q", name.text)
+		}
+		n = &ExpressionNode{Type: EXP_FUNCTION_CALL, Value: name.text, Left: n, Args: args, Offset: n.Offset}
+	}
+}
+
+// arguments parses the arguments of a call after its "(".
+func (p *exprParser) arguments() ([]*ExpressionNode, error) {
+	return p.list(")")
+}
+
+// list parses expressions separated by commas up to end.
+func (p *exprParser) list(end string) ([]*ExpressionNode, error) {
+	args := []*ExpressionNode{}
+	if _, ok := p.accept(end); ok {
+		return args, nil
+	}
+	for {
+		n, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, n)
+		t, ok := p.accept(",", end)
+		if !ok {
+			if t.typ == tokenEOF {
+				return nil, p.errorf(t.offset, "expected %q at end of expression", end)
+			}
+			return nil, p.errorf(t.offset, "expected \",\" or %q, found %q", end, t.text)
+		}
+		if t.text == end {
+			return args, nil
+		}
+	}
+}
+
+func (p *exprParser) primary() (*ExpressionNode, error) {
+	t := p.next()
+	switch t.typ {
+	case tokenNumber:
+		return &ExpressionNode{Type: EXP_LITERAL_NUMBER, Value: t.num, Offset: t.offset}, nil
+	case tokenString:
+		return p.stringLiteral(t)
+	case tokenVariable:
+		return p.variable(t)
+	case tokenIdent:
+		return p.identifier(t)
+	case tokenPunct:
+		switch t.text {
+		case "(":
+			n, err := p.conditional()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case "[":
+			elems, err := p.list("]")
+			if err != nil {
+				return nil, err
+			}
+			return &ExpressionNode{Type: EXP_ARRAY, Args: elems, Offset: t.offset}, nil
+		}
+	}
+	return nil, p.unexpected(t)
+}
+
+// stringLiteral returns a string, or a string with ${} variables replaced
+// on evaluation.
+func (p *exprParser) stringLiteral(t exprToken) (*ExpressionNode, error) {
+	i := strings.Index(t.str, "${")
+	if i < 0 {
+		return &ExpressionNode{Type: EXP_LITERAL_STRING, Value: t.str, Offset: t.offset}, nil
+	}
+	for s := t.str; i >= 0; i = strings.Index(s, "${") {
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return nil, p.errorf(t.offset, "unterminated variable in string")
+		}
+		if strings.TrimSpace(s[i+2:i+end]) == "" {
+			return nil, p.errorf(t.offset, "empty variable in string")
+		}
+		s = s[i+end+1:]
+	}
+	return &ExpressionNode{Type: EXP_VARIABLE_IN_STRING, Value: t.str, Offset: t.offset}, nil
+}
+
+// variable parses ${name}, ${feature['name']} or ${feature.name} after
+// "${", possibly followed by member accesses.
+func (p *exprParser) variable(start exprToken) (*ExpressionNode, error) {
+	name := p.next()
+	if name.typ != tokenIdent {
+		return nil, p.errorf(name.offset, "expected a variable name after \"${\"")
+	}
+	n := &ExpressionNode{Type: EXP_VARIABLE, Value: name.text, Offset: start.offset}
+	if name.text == BUILTIN_TILESET_TIME {
+		n.Type = EXP_BUILTIN_VARIABLE
+	} else if name.text == "feature" {
+		// ${feature['name']} and ${feature.name} are ${name}.
+		save := p.pos
+		if t, ok := p.accept(".", "["); ok {
+			key := p.next()
+			switch {
+			case t.text == "." && key.typ == tokenIdent:
+				n.Value = key.text
+			case t.text == "[" && key.typ == tokenString:
+				if _, ok := p.accept("]"); ok {
+					n.Value = key.str
+					break
+				}
+				p.pos = save
+			default:
+				p.pos = save
+			}
+		}
+	}
+	n, err := p.postfix(n)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (p *exprParser) identifier(t exprToken) (*ExpressionNode, error) {
+	switch t.text {
+	case "true", "false":
+		return &ExpressionNode{Type: EXP_LITERAL_BOOLEAN, Value: t.text == "true", Offset: t.offset}, nil
+	case "null":
+		return &ExpressionNode{Type: EXP_LITERAL_NULL, Offset: t.offset}, nil
+	case "undefined":
+		return &ExpressionNode{Type: EXP_LITERAL_UNDEFINED, Offset: t.offset}, nil
+	case "Math", "Number":
+		if _, ok := p.accept("."); ok {
+			name := p.next()
+			if v, ok := builtinNumbers[t.text+"."+name.text]; ok && name.typ == tokenIdent {
+				return &ExpressionNode{Type: EXP_LITERAL_NUMBER, Value: v, Offset: t.offset}, nil
+			}
+			return nil, p.errorf(name.offset, "unknown constant %s.%s", t.text, name.text)
+		}
+	}
+	if _, ok := p.accept("("); !ok {
+		return nil, p.errorf(t.offset, "unknown identifier %q", t.text)
+	}
+	args, err := p.arguments()
+	if err != nil {
+		return nil, err
+	}
+	name := t.text
+	n := &ExpressionNode{Value: name, Offset: t.offset}
+	count := func(min, max int) error {
+		if len(args) >= min && len(args) <= max {
+			return nil
+		}
+		if min == max {
+			return p.errorf(t.offset, "%s() takes %d arguments, got %d", name, min, len(args))
+		}
+		return p.errorf(t.offset, "%s() takes %d to %d arguments, got %d", name, min, max, len(args))
+	}
+	switch {
+	case unaryFunctions[name]:
+		min := 1
+		if name == FUNC_GET_EXACTCLASSNAME {
+			min = 0
+		}
+		if err := count(min, 1); err != nil {
+			return nil, err
+		}
+		n.Type = EXP_UNARY
+		if len(args) > 0 {
+			n.Left = args[0]
+		}
+	case binaryFunctions[name]:
+		if err := count(2, 2); err != nil {
+			return nil, err
+		}
+		n.Type, n.Left, n.Right = EXP_BINARY, args[0], args[1]
+	case ternaryFunctions[name]:
+		if err := count(3, 3); err != nil {
+			return nil, err
+		}
+		n.Type, n.Args = EXP_TERNARY, args
+	case colorArgs[name] != [2]int{}:
+		r := colorArgs[name]
+		if err := count(r[0], r[1]); err != nil {
+			return nil, err
+		}
+		n.Type, n.Args = EXP_LITERAL_COLOR, args
+	case vectorSizes[name] > 0:
+		if err := count(1, vectorSizes[name]); err != nil {
+			return nil, err
+		}
+		n.Type, n.Args = EXP_LITERAL_VECTOR, args
+	case name == FUNC_REGEXP:
+		if err := count(1, 2); err != nil {
+			return nil, err
+		}
+		n.Type, n.Args = EXP_REGEX, args
+		var strs []string
+		for _, a := range args {
+			if a.Type != EXP_LITERAL_STRING {
+				return n, nil
+			}
+			strs = append(strs, a.Value.(string))
+		}
+		strs = append(strs, "")
+		re, err := NewStylingRegExp(strs[0], strs[1])
+		if err != nil {
+			return nil, p.errorf(args[0].Offset, "invalid regular expression: %v", err)
+		}
+		n.Type, n.Value, n.Args = EXP_LITERAL_REGEX, re, nil
+	default:
+		return nil, p.errorf(t.offset, "unknown function %q", name)
+	}
+	return n, nil
+}
+
+// precedence returns the binding of n when printed, higher binds tighter.
+func (n *ExpressionNode) precedence() int {
+	switch n.Type {
+	case EXP_CONDITIONAL:
+		return 1
+	case EXP_BINARY:
+		op, _ := n.Value.(string)
+		for i, level := range binaryLevels {
+			for _, o := range level {
+				if o == op {
+					return i + 2
+				}
+			}
+		}
+	case EXP_UNARY:
+		if op, _ := n.Value.(string); op == OP_NOT || op == OP_NEGATIVE || op == OP_POSITIVE {
+			return len(binaryLevels) + 2
+		}
+	}
+	// Functions, members and literals.
+	return len(binaryLevels) + 3
+}
+
+func quoteExpressionString(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if (i == 0 && !isIdentStart(r)) || !isIdentPart(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// String returns n as an expression that parses back to n.
+func (n *ExpressionNode) String() string {
+	// wrap parenthesizes an operand binding less than min.
+	wrap := func(c *ExpressionNode, min int) string {
+		if c.precedence() < min {
+			return "(" + c.String() + ")"
+		}
+		return c.String()
+	}
+	join := func(args []*ExpressionNode) string {
+		strs := make([]string, len(args))
+		for i, a := range args {
+			strs[i] = a.String()
+		}
+		return strings.Join(strs, ", ")
+	}
+	name, _ := n.Value.(string)
+	switch n.Type {
+	case EXP_LITERAL_NULL:
+		return "null"
+	case EXP_LITERAL_UNDEFINED:
+		return "undefined"
+	case EXP_LITERAL_BOOLEAN:
+		return strconv.FormatBool(n.Value.(bool))
+	case EXP_LITERAL_NUMBER:
+		v := n.Value.(float64)
+		switch {
+		case math.IsInf(v, 1):
+			return "Number.POSITIVE_INFINITY"
+		case math.IsInf(v, -1):
+			return "Number.NEGATIVE_INFINITY"
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case EXP_LITERAL_STRING, EXP_VARIABLE_IN_STRING:
+		return quoteExpressionString(name)
+	case EXP_LITERAL_REGEX:
+		re := n.Value.(*StylingRegExp)
+		if re.Flags == "" {
+			return FUNC_REGEXP + "(" + quoteExpressionString(re.Pattern) + ")"
+		}
+		return FUNC_REGEXP + "(" + quoteExpressionString(re.Pattern) + ", " + quoteExpressionString(re.Flags) + ")"
+	case EXP_BUILTIN_VARIABLE:
+		return "${" + name + "}"
+	case EXP_VARIABLE:
+		if isIdentifier(name) && name != "feature" {
+			return "${" + name + "}"
+		}
+		return "${feature[" + quoteExpressionString(name) + "]}"
+	case EXP_UNARY:
+		if p := n.precedence(); p == len(binaryLevels)+2 {
+			return name + wrap(n.Left, p)
+		}
+		if n.Left == nil {
+			return name + "()"
+		}
+		return name + "(" + n.Left.String() + ")"
+	case EXP_BINARY:
+		if p := n.precedence(); p < len(binaryLevels)+3 {
+			return wrap(n.Left, p) + " " + name + " " + wrap(n.Right, p+1)
+		}
+		return name + "(" + n.Left.String() + ", " + n.Right.String() + ")"
+	case EXP_CONDITIONAL:
+		return wrap(n.Test, 2) + " ? " + n.Left.String() + " : " + n.Right.String()
+	case EXP_MEMBER:
+		if key, ok := n.Right.Value.(string); ok && name == "." && n.Right.Type == EXP_LITERAL_STRING {
+			return wrap(n.Left, len(binaryLevels)+3) + "." + key
+		}
+		return wrap(n.Left, len(binaryLevels)+3) + "[" + n.Right.String() + "]"
+	case EXP_FUNCTION_CALL:
+		return wrap(n.Left, len(binaryLevels)+3) + "." + name + "(" + join(n.Args) + ")"
+	case EXP_ARRAY:
+		return "[" + join(n.Args) + "]"
+	case EXP_TERNARY, EXP_LITERAL_COLOR, EXP_LITERAL_VECTOR, EXP_REGEX:
+		return name + "(" + join(n.Args) + ")"
+	}
+	return ""
+}
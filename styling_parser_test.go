@@ -0,0 +1,85 @@
+package tile3d
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	n, err := ParseExpression("${feature['height']} > 10 && ${name} =~ regExp('^b', 'i') ? color('red', 0.5) : rgb(0, 0, 255)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Type != EXP_CONDITIONAL || n.Left.Type != EXP_LITERAL_COLOR || n.Right.Value != FUNC_RGB || len(n.Right.Args) != 3 {
+		t.Fatalf("conditional %+v", n)
+	}
+	and := n.Test
+	if and.Type != EXP_BINARY || and.Value != OP_AND {
+		t.Fatalf("test %+v", and)
+	}
+	if v := and.Left.Left; v.Type != EXP_VARIABLE || v.Value != "height" {
+		t.Errorf("variable %+v", v)
+	}
+	re := and.Right.Right
+	if re.Type != EXP_LITERAL_REGEX || !re.Value.(*StylingRegExp).Regexp.MatchString("Bob") {
+		t.Errorf("regexp %+v", re)
+	}
+
+	exprs := map[string]string{
+		"1 + 2 * 3":                                       "1 + 2 * 3",
+		"(1 + 2) * 3":                                     "(1 + 2) * 3",
+		"1 - (2 - 3)":                                     "1 - (2 - 3)",
+		"-${a}.x[0] % 2":                                  "-${a}.x[0] % 2",
+		"!(${a} === null) || undefined":                   "!(${a} === null) || undefined",
+		"clamp(pow(2, 3), 0, Math.PI)":                    "clamp(pow(2, 3), 0, 3.141592653589793)",
+		"vec3(vec2(1), 2).z":                              "vec3(vec2(1), 2).z",
+		"'a ${name} \\'b\\''":                             "'a ${name} \\'b\\''",
+		"${feature['a b']}":                               "${feature['a b']}",
+		"${名称} + ${feature['高度']}":                        "${名称} + ${高度}",
+		"regExp(${p}).test(String(1e3))":                  "regExp(${p}).test(String(1000))",
+		"[1, 'x', true][1]":                               "[1, 'x', true][1]",
+		"isExactClass('door') ? 1 : 2 ? 3 : 4":            "isExactClass('door') ? 1 : 2 ? 3 : 4",
+		"getExactClassName() !== ${tiles3d_tileset_time}": "getExactClassName() !== ${tiles3d_tileset_time}",
+	}
+	for src, want := range exprs {
+		n, err := ParseExpression(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got := n.String(); got != want {
+			t.Errorf("%s printed as %s", src, got)
+		}
+		if _, err := ParseExpression(n.String()); err != nil {
+			t.Errorf("%s: %v", n, err)
+		}
+	}
+
+	errs := map[string]int{
+		"1 +":                  3,
+		"(1 + 2":               6,
+		"1 == 2":               2,
+		"foo":                  0,
+		"abs(1, 2)":            0,
+		"vec2(1, 2, 3)":        0,
+		"${a}.b(1)":            5,
+		"'abc":                 0,
+		"1 # 2":                2,
+		"${}":                  2,
+		"regExp('(')":          7,
+		"color('red') 1":       13,
+		"'${a'":                0,
+		"tiles3d_tileset_time": 0,
+	}
+	for src, offset := range errs {
+		_, err := ParseExpression(src)
+		var e *ExpressionError
+		if !errors.As(err, &e) || !errors.Is(err, ErrBadExpression) {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if e.Offset != offset {
+			t.Errorf("%s: %v, want offset %d", src, err, offset)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package tile3d
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+)
+
+// TileSection is the byte range of a section of a tile, relative to the
+// start of the stream passed to ReadTileInfo.
+type TileSection struct {
+	Name   string
+	Offset int64
+	Length int64
+}
+
+// TileInfo is what ReadTileInfo can tell about a tile without decoding its
+// binary bodies or glTF.
+type TileInfo struct {
+	Magic       string
+	Version     uint32
+	ByteLength  uint32
+	Offset      int64
+	Header      Header
+	GltfUri     string
+	Sections    []TileSection
+	FeatureJSON map[string]interface{}
+	BatchJSON   map[string]interface{}
+
+	// FeaturesLength is the number of models, instances, points or
+	// vector features, BatchLength the number of batch table rows.
+	FeaturesLength int
+	BatchLength    int
+
+	Tiles []*TileInfo
+}
+
+func (t *TileInfo) GetSection(name string) (TileSection, bool) {
+	for _, s := range t.Sections {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return TileSection{}, false
+}
+
+// ReadTileInfo reads the header and JSON of a tile and seeks past its
+// binary sections, leaving reader at the end of the tile.
+func ReadTileInfo(reader io.ReadSeeker) (*TileInfo, error) {
+	start, _ := reader.Seek(0, io.SeekCurrent)
+	var prefix [8]byte
+	if _, err := io.ReadFull(reader, prefix[:]); err != nil {
+		return nil, newTileError("", "header", start, err)
+	}
+	if _, err := reader.Seek(start, io.SeekStart); err != nil {
+		return nil, newTileError("", "header", start, err)
+	}
+
+	info := &TileInfo{Magic: string(prefix[:4]), Version: littleEndian.Uint32(prefix[4:]), Offset: start}
+	switch info.Magic {
+	case B3DM_MAGIC:
+		info.Header = &B3dmHeader{}
+	case I3DM_MAGIC:
+		info.Header = &I3dmHeader{}
+	case PNTS_MAGIC:
+		info.Header = &PntsHeader{}
+	case GEOM_MAGIC:
+		info.Header = &GeomHeader{}
+	case VCTR_MAGIC:
+		info.Header = &VctrHeader{}
+	case CMPT_MAGIC:
+		info.Header = &CmptHeader{}
+	default:
+		return nil, newTileError(info.Magic, "magic", start, ErrBadMagic)
+	}
+	if err := binary.Read(reader, littleEndian, info.Header); err != nil {
+		return nil, newTileError(info.Magic, "header", start, err)
+	}
+	if err := checkVersion(info.Magic, start, info.Version); err != nil {
+		return nil, err
+	}
+	info.ByteLength = info.Header.GetByteLength()
+
+	var sections []uint32
+	if h, ok := info.Header.(*VctrHeader); ok {
+		sections = []uint32{h.PolygonIndicesByteLength, h.PolygonPositionsByteLength, h.PolylinePositionsByteLength, h.PointPositionsByteLength}
+	}
+	if err := checkByteLength(reader, info.Magic, start, info.Header, sections...); err != nil {
+		return nil, err
+	}
+
+	if info.Magic == CMPT_MAGIC {
+		if err := info.readTiles(reader, start); err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+
+	if err := info.readTables(reader, start); err != nil {
+		return nil, err
+	}
+
+	end := start + int64(info.ByteLength)
+	if _, err := reader.Seek(end, io.SeekStart); err != nil {
+		return nil, newTileError(info.Magic, "byteLength", start+8, err)
+	}
+	return info, nil
+}
+
+func (t *TileInfo) addSection(name string, offset int64, length uint32) int64 {
+	t.Sections = append(t.Sections, TileSection{Name: name, Offset: offset, Length: int64(length)})
+	return offset + int64(length)
+}
+
+func (t *TileInfo) readTables(reader io.ReadSeeker, start int64) error {
+	h := t.Header
+	offset := start + h.CalcSize()
+
+	var ft FeatureTable
+	if err := ft.readJSONHeader(reader, int(h.GetFeatureTableJSONByteLength())); err != nil {
+		return wrapTileError(t.Magic, "featureTable", 0, err)
+	}
+	t.FeatureJSON = ft.Header
+	offset = t.addSection("featureTableJSON", offset, h.GetFeatureTableJSONByteLength())
+	offset = t.addSection("featureTableBinary", offset, h.GetFeatureTableBinaryByteLength())
+
+	if n := h.GetBatchTableJSONByteLength(); n > 0 {
+		if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+			return newTileError(t.Magic, "batchTable JSON", offset, err)
+		}
+		jdata := make([]byte, n)
+		if _, err := io.ReadFull(reader, jdata); err != nil {
+			return newTileError(t.Magic, "batchTable JSON", offset, err)
+		}
+		var bt BatchTable
+		if err := bt.readJSONHeader(bytes.NewReader(jdata)); err != nil {
+			return wrapTileError(t.Magic, "batchTable JSON", offset, err)
+		}
+		t.BatchJSON = bt.Header
+	}
+	offset = t.addSection("batchTableJSON", offset, h.GetBatchTableJSONByteLength())
+	offset = t.addSection("batchTableBinary", offset, h.GetBatchTableBinaryByteLength())
+
+	body := uint32(start + int64(t.ByteLength) - offset)
+	switch hd := h.(type) {
+	case *VctrHeader:
+		offset = t.addSection("polygonIndices", offset, hd.PolygonIndicesByteLength)
+		offset = t.addSection("polygonPositions", offset, hd.PolygonPositionsByteLength)
+		offset = t.addSection("polylinePositions", offset, hd.PolylinePositionsByteLength)
+		t.addSection("pointPositions", offset, hd.PointPositionsByteLength)
+	case *I3dmHeader:
+		if hd.GltfFormat == I3DM_GLTF_URI {
+			if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+				return newTileError(t.Magic, "glTF", offset, err)
+			}
+			uri := make([]byte, body)
+			if _, err := io.ReadFull(reader, uri); err != nil {
+				return newTileError(t.Magic, "glTF", offset, err)
+			}
+			t.GltfUri = strings.TrimRight(string(uri), " \x00")
+			t.addSection("glTFUri", offset, body)
+		} else {
+			t.addSection("glTF", offset, body)
+		}
+	case *B3dmHeader:
+		t.addSection("glTF", offset, body)
+	}
+
+	return t.readCounts()
+}
+
+func (t *TileInfo) readCounts() error {
+	var props []string
+	switch t.Magic {
+	case B3DM_MAGIC:
+		props = []string{B3DM_PROP_BATCH_LENGTH}
+	case I3DM_MAGIC:
+		props = []string{I3DM_PROP_INSTANCES_LENGTH}
+	case PNTS_MAGIC:
+		props = []string{PNTS_PROP_POINTS_LENGTH}
+	case GEOM_MAGIC:
+		props = []string{GEOM_PROP_BOXES_LENGTH, GEOM_PROP_CYLINDERS_LENGTH, GEOM_PROP_ELLIPSOIDS_LENGTH, GEOM_PROP_SPHERES_LENGTH}
+	case VCTR_MAGIC:
+		props = []string{VCTR_PROP_POLYGONS_LENGTH, VCTR_PROP_POLYLINES_LENGTH, VCTR_PROP_POINTS_LENGTH}
+	}
+	for _, p := range props {
+		n, err := getCountFeatureValue(t.FeatureJSON, p, math.MaxInt32)
+		if err != nil {
+			return wrapTileError(t.Magic, p, -1, err)
+		}
+		t.FeaturesLength += int(n)
+	}
+
+	if _, ok := t.FeatureJSON[B3DM_PROP_BATCH_LENGTH]; ok {
+		n, err := getCountFeatureValue(t.FeatureJSON, B3DM_PROP_BATCH_LENGTH, math.MaxInt32)
+		if err != nil {
+			return wrapTileError(t.Magic, B3DM_PROP_BATCH_LENGTH, -1, err)
+		}
+		t.BatchLength = int(n)
+	} else if t.Magic != B3DM_MAGIC && t.FeatureJSON[I3DM_PROP_BATCH_ID] == nil {
+		t.BatchLength = t.FeaturesLength
+	}
+	return nil
+}
+
+func (t *TileInfo) readTiles(reader io.ReadSeeker, start int64) error {
+	h := t.Header.(*CmptHeader)
+	end := start + int64(h.ByteLength)
+	if int64(h.TilesLength)*12 > end-start-h.CalcSize() {
+		return newTileError(CMPT_MAGIC, "tilesLength", start+12, ErrBadValue)
+	}
+	for i := 0; i < int(h.TilesLength); i++ {
+		tile, err := ReadTileInfo(reader)
+		if err != nil {
+			return wrapTileError(CMPT_MAGIC, "tiles", 0, err)
+		}
+		if tile.Offset+int64(tile.ByteLength) > end {
+			return newTileError(CMPT_MAGIC, "tiles", tile.Offset+8, ErrBadValue)
+		}
+		t.Tiles = append(t.Tiles, tile)
+		t.FeaturesLength += tile.FeaturesLength
+	}
+	_, err := reader.Seek(end, io.SeekStart)
+	return err
+}
@@ -0,0 +1,36 @@
+package tile3d
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestReadTileInfo(t *testing.T) {
+	f, err := os.Open("./data/composite.cmpt")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+	info, err := ReadTileInfo(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != int64(info.ByteLength) {
+		t.Errorf("stopped at %d, tile ends at %d", pos, info.ByteLength)
+	}
+	if len(info.Tiles) != 2 || info.Tiles[0].Magic != B3DM_MAGIC || info.Tiles[1].Magic != I3DM_MAGIC {
+		t.Fatalf("tiles %v", info.Tiles)
+	}
+	b3dm := info.Tiles[0]
+	if b3dm.BatchLength != 10 || b3dm.BatchJSON["Height"] == nil {
+		t.Errorf("b3dm batch table %d %v", b3dm.BatchLength, b3dm.BatchJSON)
+	}
+	gltf, ok := b3dm.GetSection("glTF")
+	if !ok || gltf.Offset+gltf.Length != b3dm.Offset+int64(b3dm.ByteLength) {
+		t.Errorf("glTF section %v", gltf)
+	}
+	if info.Tiles[1].FeaturesLength != 25 {
+		t.Errorf("instances %d", info.Tiles[1].FeaturesLength)
+	}
+}
@@ -6,7 +6,7 @@ type TileModel interface {
 	GetHeader() Header
 	GetFeatureTable() *FeatureTable
 	GetBatchTable() *BatchTable
-	CalcSize() int64
+	CalcSize() (int64, error)
 	Read(reader io.ReadSeeker) error
 	Write(writer io.Writer) error
 }
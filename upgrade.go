@@ -0,0 +1,476 @@
+package tile3d
+
+import (
+	"math"
+
+	"github.com/flywave/gltf"
+	"github.com/flywave/gltf/ext/unlit"
+	"github.com/flywave/gltf/modeler"
+)
+
+// UpgradeOptions controls the conversion of tiles to 3D Tiles 1.1 glTF
+// content.
+type UpgradeOptions struct {
+	// RtcAsTranslation moves RTC_CENTER into the translation of a new root
+	// node instead of the CESIUM_RTC extension. Node translations are
+	// single precision.
+	RtcAsTranslation bool
+}
+
+func NewUpgradeOptions() *UpgradeOptions {
+	return &UpgradeOptions{}
+}
+
+// yUpToZUp rotates the y-up axis of glTF to the z-up axis of 3D Tiles.
+var yUpToZUp = [16]float64{1, 0, 0, 0, 0, 0, 1, 0, 0, -1, 0, 0, 0, 0, 0, 1}
+
+var zUpToYUp = [16]float64{1, 0, 0, 0, 0, 0, -1, 0, 0, 1, 0, 0, 0, 0, 0, 1}
+
+// mat4Mul returns a*b for column major matrices.
+func mat4Mul(a, b [16]float64) [16]float64 {
+	var ret [16]float64
+	for c := 0; c < 4; c++ {
+		for r := 0; r < 4; r++ {
+			for k := 0; k < 4; k++ {
+				ret[c*4+r] += a[k*4+r] * b[c*4+k]
+			}
+		}
+	}
+	return ret
+}
+
+// nodeMatrix returns the local transform of n.
+func nodeMatrix(n *gltf.Node) [16]float64 {
+	var ret [16]float64
+	if m := n.MatrixOrDefault(); m != gltf.DefaultMatrix {
+		for i := range ret {
+			ret[i] = float64(m[i])
+		}
+		return ret
+	}
+	t, q, s := n.TranslationOrDefault(), n.RotationOrDefault(), n.ScaleOrDefault()
+	x, y, z, w := float64(q[0]), float64(q[1]), float64(q[2]), float64(q[3])
+	rot := [9]float64{
+		1 - 2*(y*y+z*z), 2 * (x*y + z*w), 2 * (x*z - y*w),
+		2 * (x*y - z*w), 1 - 2*(x*x+z*z), 2 * (y*z + x*w),
+		2 * (x*z + y*w), 2 * (y*z - x*w), 1 - 2*(x*x+y*y),
+	}
+	for c := 0; c < 3; c++ {
+		for r := 0; r < 3; r++ {
+			ret[c*4+r] = rot[c*3+r] * float64(s[c])
+		}
+		ret[12+c] = float64(t[c])
+	}
+	ret[15] = 1
+	return ret
+}
+
+// mat4Decompose splits an affine matrix without shear into translation,
+// rotation quaternion and scale.
+func mat4Decompose(m [16]float64) (t [3]float64, q [4]float64, s [3]float64) {
+	t = [3]float64{m[12], m[13], m[14]}
+	var rot [3][3]float64
+	for c := 0; c < 3; c++ {
+		s[c] = math.Sqrt(m[c*4]*m[c*4] + m[c*4+1]*m[c*4+1] + m[c*4+2]*m[c*4+2])
+		for r := 0; r < 3; r++ {
+			if s[c] != 0 {
+				rot[c][r] = m[c*4+r] / s[c]
+			}
+		}
+	}
+	if det := rot[0][0]*(rot[1][1]*rot[2][2]-rot[2][1]*rot[1][2]) -
+		rot[1][0]*(rot[0][1]*rot[2][2]-rot[2][1]*rot[0][2]) +
+		rot[2][0]*(rot[0][1]*rot[1][2]-rot[1][1]*rot[0][2]); det < 0 {
+		s[0] = -s[0]
+		rot[0] = [3]float64{-rot[0][0], -rot[0][1], -rot[0][2]}
+	}
+
+	// rot[c][r] is the element at row r and column c.
+	trace := rot[0][0] + rot[1][1] + rot[2][2]
+	switch {
+	case trace > 0:
+		k := 0.5 / math.Sqrt(trace+1)
+		q = [4]float64{(rot[1][2] - rot[2][1]) * k, (rot[2][0] - rot[0][2]) * k, (rot[0][1] - rot[1][0]) * k, 0.25 / k}
+	case rot[0][0] > rot[1][1] && rot[0][0] > rot[2][2]:
+		k := 2 * math.Sqrt(1+rot[0][0]-rot[1][1]-rot[2][2])
+		q = [4]float64{0.25 * k, (rot[1][0] + rot[0][1]) / k, (rot[2][0] + rot[0][2]) / k, (rot[1][2] - rot[2][1]) / k}
+	case rot[1][1] > rot[2][2]:
+		k := 2 * math.Sqrt(1+rot[1][1]-rot[0][0]-rot[2][2])
+		q = [4]float64{(rot[1][0] + rot[0][1]) / k, 0.25 * k, (rot[2][1] + rot[1][2]) / k, (rot[2][0] - rot[0][2]) / k}
+	default:
+		k := 2 * math.Sqrt(1+rot[2][2]-rot[0][0]-rot[1][1])
+		q = [4]float64{(rot[2][0] + rot[0][2]) / k, (rot[2][1] + rot[1][2]) / k, 0.25 * k, (rot[0][1] - rot[1][0]) / k}
+	}
+	return t, q, s
+}
+
+// copyGltf returns a deep copy of doc with its buffers embedded.
+func copyGltf(doc *gltf.Document) (*gltf.Document, error) {
+	glb, err := getGltfBinary(doc, 8)
+	if err != nil {
+		return nil, err
+	}
+	return loadGltfFromByte(glb)
+}
+
+// setGltfRtc places the scene of doc at the z-up center rtc.
+func setGltfRtc(doc *gltf.Document, rtc [3]float64, opts *UpgradeOptions) {
+	if rtc == [3]float64{} {
+		return
+	}
+	if !opts.RtcAsTranslation {
+		if doc.Extensions == nil {
+			doc.Extensions = make(gltf.Extensions)
+		}
+		doc.Extensions[CESIUM_RTC] = &CesiumRTC{Center: rtc}
+		addExtensionUsed(doc, CESIUM_RTC)
+		for _, e := range doc.ExtensionsRequired {
+			if e == CESIUM_RTC {
+				return
+			}
+		}
+		doc.ExtensionsRequired = append(doc.ExtensionsRequired, CESIUM_RTC)
+		return
+	}
+	scene := gltfScene(doc)
+	root := &gltf.Node{
+		Children:    scene.Nodes,
+		Translation: [3]float32{float32(rtc[0]), float32(rtc[2]), float32(-rtc[1])},
+	}
+	doc.Nodes = append(doc.Nodes, root)
+	scene.Nodes = []uint32{uint32(len(doc.Nodes) - 1)}
+}
+
+// gltfScene returns the default scene of doc, adding one if needed.
+func gltfScene(doc *gltf.Document) *gltf.Scene {
+	if doc.Scene != nil && int(*doc.Scene) < len(doc.Scenes) {
+		return doc.Scenes[*doc.Scene]
+	}
+	if len(doc.Scenes) == 0 {
+		doc.Scenes = append(doc.Scenes, &gltf.Scene{})
+	}
+	return doc.Scenes[0]
+}
+
+// writeFeatureIds writes ids as an accessor of a type allowed for feature
+// id attributes. Ids above 65535 are written as floats, so ids of 2^24 and
+// above, which floats cannot hold exactly, are an error.
+func writeFeatureIds(doc *gltf.Document, target gltf.Target, ids []uint32) (uint32, error) {
+	max := uint32(0)
+	for _, id := range ids {
+		if id > max {
+			max = id
+		}
+	}
+	if max <= math.MaxUint16 {
+		data := make([]uint16, len(ids))
+		for i, id := range ids {
+			data[i] = uint16(id)
+		}
+		return modeler.WriteAccessor(doc, target, data), nil
+	}
+	if max >= 1<<24 {
+		return 0, newTileError("", GLTF_ATTR_FEATURE_ID, -1, ErrBadValue)
+	}
+	data := make([]float32, len(ids))
+	for i, id := range ids {
+		data[i] = float32(id)
+	}
+	return modeler.WriteAccessor(doc, target, data), nil
+}
+
+// newFeatureId returns a feature id set of count features, read from the
+// _FEATURE_ID_0 attribute when it is set or from the vertex index.
+func newFeatureId(count int, attribute, propertyTable bool) FeatureId {
+	ret := FeatureId{FeatureCount: uint32(count)}
+	if attribute {
+		ret.Attribute = gltf.Index(0)
+	}
+	if propertyTable {
+		ret.PropertyTable = gltf.Index(0)
+	}
+	return ret
+}
+
+// Upgrade converts m to a 3D Tiles 1.1 glTF. _BATCHID becomes a feature id
+// attribute of EXT_mesh_features and the batch table becomes a property
+// table of EXT_structural_metadata.
+func (m *B3dm) Upgrade(opts *UpgradeOptions) (*gltf.Document, error) {
+	if opts == nil {
+		opts = NewUpgradeOptions()
+	}
+	if m.Model == nil {
+		return nil, newTileError(B3DM_MAGIC, "glTF", -1, ErrMissingModel)
+	}
+	doc, err := copyGltf(m.Model)
+	if err != nil {
+		return nil, newTileError(B3DM_MAGIC, "glTF", -1, err)
+	}
+	n := m.BatchLength()
+	hasTable, err := setBatchTableMetadata(doc, &m.BatchTable, n)
+	if err != nil {
+		return nil, wrapTileError(B3DM_MAGIC, "batchTable", -1, err)
+	}
+
+	if n > 0 {
+		for _, mesh := range doc.Meshes {
+			for _, p := range mesh.Primitives {
+				index, ok := p.Attributes[GLTF_ATTR_BATCHID]
+				if !ok {
+					if index, ok = p.Attributes["BATCHID"]; !ok {
+						continue
+					}
+					delete(p.Attributes, "BATCHID")
+				}
+				delete(p.Attributes, GLTF_ATTR_BATCHID)
+				p.Attributes[GLTF_ATTR_FEATURE_ID] = index
+				if p.Extensions == nil {
+					p.Extensions = make(gltf.Extensions)
+				}
+				p.Extensions[EXT_MESH_FEATURES] = &MeshFeatures{
+					FeatureIds: []FeatureId{newFeatureId(n, true, hasTable)},
+				}
+				addExtensionUsed(doc, EXT_MESH_FEATURES)
+			}
+		}
+	}
+
+	if rtc, ok := m.FeatureTable.getVec3(B3DM_PROP_RTC_CENTER); ok {
+		setGltfRtc(doc, rtc, opts)
+	}
+	return doc, nil
+}
+
+// center returns the point the instance positions of m are relative to,
+// RTC_CENTER plus QUANTIZED_VOLUME_OFFSET for quantized positions.
+func (m *I3dm) center() [3]float64 {
+	center, _ := m.FeatureTable.getVec3(I3DM_PROP_RTC_CENTER)
+	if _, ok := m.FeatureTable.Data[I3DM_PROP_POSITION_QUANTIZED]; ok {
+		offset, _ := m.FeatureTable.getVec3(I3DM_PROP_QUANTIZED_VOLUME_OFFSET)
+		for i := range center {
+			center[i] += offset[i]
+		}
+	}
+	return center
+}
+
+type gltfMeshNode struct {
+	mesh   uint32
+	matrix [16]float64
+}
+
+// gltfMeshNodes returns the nodes of the scene of doc referencing a mesh
+// with their global matrices.
+func gltfMeshNodes(doc *gltf.Document) []gltfMeshNode {
+	var meshNodes []gltfMeshNode
+	var walk func(index uint32, parent [16]float64, depth int)
+	walk = func(index uint32, parent [16]float64, depth int) {
+		if int(index) >= len(doc.Nodes) || depth > len(doc.Nodes) {
+			return
+		}
+		node := doc.Nodes[index]
+		matrix := mat4Mul(parent, nodeMatrix(node))
+		if node.Mesh != nil {
+			meshNodes = append(meshNodes, gltfMeshNode{*node.Mesh, matrix})
+		}
+		for _, c := range node.Children {
+			walk(c, matrix, depth+1)
+		}
+	}
+	identity := [16]float64{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+	for _, root := range gltfScene(doc).Nodes {
+		walk(root, identity, 0)
+	}
+	return meshNodes
+}
+
+// Upgrade converts m to a 3D Tiles 1.1 glTF where every mesh node of the
+// embedded model is instanced with EXT_mesh_gpu_instancing. Instances are
+// features of EXT_instance_features and the batch table becomes a property
+// table of EXT_structural_metadata. The scene is flattened to the mesh
+// nodes, so animations and skins are not kept.
+func (m *I3dm) Upgrade(opts *UpgradeOptions) (*gltf.Document, error) {
+	if opts == nil {
+		opts = NewUpgradeOptions()
+	}
+	if m.Model == nil {
+		return nil, newTileError(I3DM_MAGIC, "glTF", -1, ErrMissingModel)
+	}
+	doc, err := copyGltf(m.Model)
+	if err != nil {
+		return nil, newTileError(I3DM_MAGIC, "glTF", -1, err)
+	}
+	transforms, err := m.InstanceTransforms()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := m.BatchIds()
+	if err != nil {
+		return nil, err
+	}
+	n := m.BatchLength()
+	hasTable, err := setBatchTableMetadata(doc, &m.BatchTable, n)
+	if err != nil {
+		return nil, wrapTileError(I3DM_MAGIC, "batchTable", -1, err)
+	}
+	if ids == nil && hasTable {
+		ids = make([]uint32, len(transforms))
+		for i := range ids {
+			ids[i] = uint32(i)
+		}
+	}
+
+	// Instance positions are stored relative to the center, which keeps
+	// them precise in single precision.
+	center := m.center()
+	for i := range transforms {
+		for j := 0; j < 3; j++ {
+			transforms[i][12+j] -= center[j]
+		}
+		// The model is converted to z-up before the instance transform,
+		// which is applied in the y-up space of glTF.
+		transforms[i] = mat4Mul(zUpToYUp, mat4Mul(transforms[i], yUpToZUp))
+	}
+
+	meshNodes := gltfMeshNodes(doc)
+	nodes := make([]*gltf.Node, 0, len(meshNodes))
+	roots := make([]uint32, 0, len(meshNodes))
+	for _, mn := range meshNodes {
+		translations := make([][3]float32, len(transforms))
+		rotations := make([][4]float32, len(transforms))
+		scales := make([][3]float32, len(transforms))
+		for i, t := range transforms {
+			tr, q, s := mat4Decompose(mat4Mul(t, mn.matrix))
+			translations[i] = [3]float32{float32(tr[0]), float32(tr[1]), float32(tr[2])}
+			rotations[i] = [4]float32{float32(q[0]), float32(q[1]), float32(q[2]), float32(q[3])}
+			scales[i] = [3]float32{float32(s[0]), float32(s[1]), float32(s[2])}
+		}
+		attrs := map[string]uint32{
+			"TRANSLATION": modeler.WriteAccessor(doc, gltf.TargetNone, translations),
+			"ROTATION":    modeler.WriteAccessor(doc, gltf.TargetNone, rotations),
+			"SCALE":       modeler.WriteAccessor(doc, gltf.TargetNone, scales),
+		}
+		node := &gltf.Node{Mesh: gltf.Index(mn.mesh), Extensions: gltf.Extensions{}}
+		if ids != nil {
+			i, err := writeFeatureIds(doc, gltf.TargetNone, ids)
+			if err != nil {
+				return nil, err
+			}
+			attrs[GLTF_ATTR_FEATURE_ID] = i
+			node.Extensions[EXT_INSTANCE_FEATURES] = &InstanceFeatures{
+				FeatureIds: []FeatureId{newFeatureId(n, true, hasTable)},
+			}
+			addExtensionUsed(doc, EXT_INSTANCE_FEATURES)
+		}
+		node.Extensions[EXT_MESH_GPU_INSTANCING] = &MeshGpuInstancing{Attributes: attrs}
+		roots = append(roots, uint32(len(nodes)))
+		nodes = append(nodes, node)
+	}
+	doc.Nodes = nodes
+	doc.Skins = nil
+	doc.Animations = nil
+	doc.Scenes = []*gltf.Scene{{Nodes: roots}}
+	doc.Scene = gltf.Index(0)
+	if len(nodes) > 0 {
+		addExtensionUsed(doc, EXT_MESH_GPU_INSTANCING)
+		doc.ExtensionsRequired = append(doc.ExtensionsRequired, EXT_MESH_GPU_INSTANCING)
+	}
+
+	setGltfRtc(doc, center, opts)
+	return doc, nil
+}
+
+// Upgrade converts m to a 3D Tiles 1.1 glTF with a single point primitive.
+// Batched points get a feature id attribute of EXT_mesh_features,
+// otherwise every point is a feature, and the batch table becomes a
+// property table of EXT_structural_metadata.
+func (m *Pnts) Upgrade(opts *UpgradeOptions) (*gltf.Document, error) {
+	if opts == nil {
+		opts = NewUpgradeOptions()
+	}
+	positions, err := m.Positions()
+	if err != nil {
+		return nil, err
+	}
+	colors, err := m.Colors()
+	if err != nil {
+		return nil, err
+	}
+	normals, err := m.Normals()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := m.BatchIds()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := gltf.NewDocument()
+	n := m.BatchLength()
+	hasTable, err := setBatchTableMetadata(doc, &m.BatchTable, n)
+	if err != nil {
+		return nil, wrapTileError(PNTS_MAGIC, "batchTable", -1, err)
+	}
+	if len(positions) == 0 {
+		return doc, nil
+	}
+
+	center, _ := m.FeatureTable.getVec3(PNTS_PROP_RTC_CENTER)
+	if _, ok := m.FeatureTable.Data[PNTS_PROP_POSITION_QUANTIZED]; ok {
+		offset, _ := m.FeatureTable.getVec3(PNTS_PROP_QUANTIZED_VOLUME_OFFSET)
+		for i := range center {
+			center[i] += offset[i]
+		}
+	}
+	pos := make([][3]float32, len(positions))
+	for i, p := range positions {
+		x, y, z := p[0]-center[0], p[1]-center[1], p[2]-center[2]
+		pos[i] = [3]float32{float32(x), float32(z), float32(-y)}
+	}
+	prim := &gltf.Primitive{
+		Attributes: gltf.Attribute{gltf.POSITION: modeler.WritePosition(doc, pos)},
+		Mode:       gltf.PrimitivePoints,
+		Material:   gltf.Index(0),
+	}
+	if colors != nil {
+		prim.Attributes[gltf.COLOR_0] = modeler.WriteColor(doc, colors)
+	}
+	material := &gltf.Material{
+		PBRMetallicRoughness: &gltf.PBRMetallicRoughness{
+			BaseColorFactor: &[4]float32{1, 1, 1, 1},
+			MetallicFactor:  gltf.Float(0),
+		},
+	}
+	if m.FeatureTable.Data[PNTS_PROP_RGBA] != nil {
+		material.AlphaMode = gltf.AlphaBlend
+	}
+	if normals != nil {
+		for i, v := range normals {
+			normals[i] = [3]float32{v[0], v[2], -v[1]}
+		}
+		prim.Attributes[gltf.NORMAL] = modeler.WriteNormal(doc, normals)
+	} else {
+		material.Extensions = gltf.Extensions{unlit.ExtensionName: unlit.Unlit{}}
+		addExtensionUsed(doc, unlit.ExtensionName)
+	}
+	if ids != nil {
+		i, err := writeFeatureIds(doc, gltf.TargetArrayBuffer, ids)
+		if err != nil {
+			return nil, err
+		}
+		prim.Attributes[GLTF_ATTR_FEATURE_ID] = i
+	}
+	if ids != nil || hasTable {
+		prim.Extensions = gltf.Extensions{EXT_MESH_FEATURES: &MeshFeatures{
+			FeatureIds: []FeatureId{newFeatureId(n, ids != nil, hasTable)},
+		}}
+		addExtensionUsed(doc, EXT_MESH_FEATURES)
+	}
+
+	doc.Meshes = []*gltf.Mesh{{Primitives: []*gltf.Primitive{prim}}}
+	doc.Materials = []*gltf.Material{material}
+	doc.Nodes = []*gltf.Node{{Mesh: gltf.Index(0)}}
+	doc.Scenes[0].Nodes = []uint32{0}
+	setGltfRtc(doc, center, opts)
+	return doc, nil
+}
@@ -0,0 +1,307 @@
+package tile3d
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/flywave/gltf"
+	"github.com/flywave/gltf/modeler"
+)
+
+// upgradedGltf writes doc as GLB and reads it back.
+func upgradedGltf(t *testing.T, doc *gltf.Document) *gltf.Document {
+	t.Helper()
+	glb, err := getGltfBinary(doc, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := loadGltfFromByte(glb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func decodeExtension(t *testing.T, ext interface{}, v interface{}) {
+	t.Helper()
+	raw, ok := ext.(json.RawMessage)
+	if !ok {
+		t.Fatalf("extension %T", ext)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readMetadataView(t *testing.T, doc *gltf.Document, index uint32) []byte {
+	t.Helper()
+	data, err := modeler.ReadBufferView(doc, doc.BufferViews[index])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestB3dmUpgrade(t *testing.T) {
+	g := &batchedMesh{}
+	identity := [16]float64{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+	g.add(newGeomUnitBox(), identity, 0)
+	g.add(newGeomUnitBox(), identity, 1)
+	m := NewB3dm()
+	m.Model = gltf.NewDocument()
+	setBatchedMesh(m.Model, g.primitive(m.Model, gltf.PrimitiveTriangles))
+	m.SetFeatureTable(B3dmFeatureTableView{BatchLength: 2, RtcCenter: []float64{1, 2, 3}})
+	m.BatchTable.Header = map[string]interface{}{
+		"name":   []interface{}{"a", "b"},
+		"flag":   BinaryBodyReference{ComponentType: COMPONENT_TYPE_BYTE, ContainerType: CONTAINER_TYPE_SCALAR},
+		"height": BinaryBodyReference{ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR},
+		"extensions": map[string]interface{}{BATCH_TABLE_HIERARCHY: map[string]interface{}{
+			HIERARCHY_INSTANCE_LENGTH: 3.0,
+			HIERARCHY_CLASSES: []interface{}{
+				map[string]interface{}{"name": "wall", "length": 2.0, "instances": map[string]interface{}{"color": []interface{}{"red", "blue"}}},
+				map[string]interface{}{"name": "building", "length": 1.0, "instances": map[string]interface{}{"id": []interface{}{7.0}}},
+			},
+			HIERARCHY_CLASSIDS:  []interface{}{0.0, 0.0, 1.0},
+			HIERARCHY_PARENTIDS: []interface{}{2.0, 2.0, 2.0},
+		}},
+	}
+	m.BatchTable.Data = map[string]interface{}{"flag": []int8{-1, 1}, "height": []float32{1.5, 2.5}}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := NewB3dm()
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	up, err := r.Upgrade(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := upgradedGltf(t, up)
+
+	prim := doc.Meshes[0].Primitives[0]
+	if _, ok := prim.Attributes[GLTF_ATTR_BATCHID]; ok {
+		t.Error("_BATCHID kept")
+	}
+	if _, ok := prim.Attributes[GLTF_ATTR_FEATURE_ID]; !ok {
+		t.Error("missing _FEATURE_ID_0")
+	}
+	var features MeshFeatures
+	decodeExtension(t, prim.Extensions[EXT_MESH_FEATURES], &features)
+	if len(features.FeatureIds) != 1 || features.FeatureIds[0].FeatureCount != 2 || features.FeatureIds[0].PropertyTable == nil {
+		t.Errorf("features %+v", features)
+	}
+	var rtc CesiumRTC
+	decodeExtension(t, doc.Extensions[CESIUM_RTC], &rtc)
+	if rtc.Center != [3]float64{1, 2, 3} {
+		t.Errorf("rtc %v", rtc.Center)
+	}
+
+	var meta StructuralMetadata
+	decodeExtension(t, doc.Extensions[EXT_STRUCTURAL_METADATA], &meta)
+	class := meta.Schema.Classes[METADATA_CLASS_FEATURE]
+	table := meta.PropertyTables[0]
+	if table.Count != 2 || len(class.Properties) != 5 {
+		t.Fatalf("metadata %+v", meta)
+	}
+	if p := class.Properties["height"]; p.Type != METADATA_TYPE_SCALAR || p.ComponentType != METADATA_COMPONENT_FLOAT32 {
+		t.Errorf("height %+v", p)
+	}
+	heights := readMetadataView(t, doc, table.Properties["height"].Values)
+	if math.Float32frombits(binary.LittleEndian.Uint32(heights[4:])) != 2.5 {
+		t.Errorf("heights %v", heights)
+	}
+	if flags := readMetadataView(t, doc, table.Properties["flag"].Values); int8(flags[0]) != -1 {
+		t.Errorf("flags %v", flags)
+	}
+	names := readMetadataView(t, doc, table.Properties["name"].Values)
+	offsets := readMetadataView(t, doc, *table.Properties["name"].StringOffsets)
+	if string(names) != "ab" || binary.LittleEndian.Uint32(offsets[4:]) != 1 {
+		t.Errorf("names %q %v", names, offsets)
+	}
+	if p := class.Properties["id"]; p.ComponentType != METADATA_COMPONENT_INT32 {
+		t.Errorf("id %+v", p)
+	}
+	if ids := readMetadataView(t, doc, table.Properties["id"].Values); binary.LittleEndian.Uint32(ids[4:]) != 7 {
+		t.Errorf("inherited id %v", ids)
+	}
+	if colors := readMetadataView(t, doc, table.Properties["color"].Values); string(colors) != "redblue" {
+		t.Errorf("colors %q", colors)
+	}
+
+	translated, err := r.Upgrade(&UpgradeOptions{RtcAsTranslation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := translated.Nodes[translated.Scenes[0].Nodes[0]]
+	if root.Translation != [3]float32{1, 3, -2} || translated.Extensions[CESIUM_RTC] != nil {
+		t.Errorf("translation %v", root.Translation)
+	}
+}
+
+func TestJSONMetadataColumn(t *testing.T) {
+	col := newJSONMetadataColumn([]interface{}{[]interface{}{1.0, 2.5}, []interface{}{3.0}})
+	if !col.property.Array || col.property.Count != 0 || col.property.ComponentType != METADATA_COMPONENT_FLOAT64 || len(col.arrayOffsets) != 12 {
+		t.Errorf("variable array %+v", col.property)
+	}
+	col = newJSONMetadataColumn([]interface{}{1.0, nil})
+	if col.property.NoData != math.MinInt32 {
+		t.Errorf("noData %+v", col.property)
+	}
+	col = newJSONMetadataColumn([]interface{}{true, false, true})
+	if col.property.Type != METADATA_TYPE_BOOLEAN || col.values[0] != 5 {
+		t.Errorf("boolean %+v %v", col.property, col.values)
+	}
+	col = newJSONMetadataColumn([]interface{}{map[string]interface{}{"a": 1.0}, "b"})
+	if col.property.Type != METADATA_TYPE_STRING || string(col.values) != `{"a":1}b` {
+		t.Errorf("fallback %+v %q", col.property, col.values)
+	}
+	used := map[string]bool{}
+	if id := metadataPropertyId("2 floors", used); id != "_2_floors" {
+		t.Errorf("id %q", id)
+	}
+	if id := metadataPropertyId("2-floors", used); id != "_2_floors_1" {
+		t.Errorf("id %q", id)
+	}
+}
+
+func TestWriteFeatureIds(t *testing.T) {
+	doc := gltf.NewDocument()
+	for _, tt := range []struct {
+		max  uint32
+		want gltf.ComponentType
+	}{
+		{math.MaxUint16, gltf.ComponentUshort},
+		{1<<24 - 1, gltf.ComponentFloat},
+	} {
+		i, err := writeFeatureIds(doc, gltf.TargetNone, []uint32{0, tt.max})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := doc.Accessors[i].ComponentType; c != tt.want {
+			t.Errorf("%d: %v", tt.max, c)
+		}
+	}
+	if _, err := writeFeatureIds(doc, gltf.TargetNone, []uint32{1 << 24}); !errors.Is(err, ErrBadValue) {
+		t.Errorf("id 2^24 %v", err)
+	}
+}
+
+func TestI3dmUpgrade(t *testing.T) {
+	m := &I3dm{Model: openGltf("./data/box.glb")}
+	m.Header.GltfFormat = I3DM_GLTF_EMBEDDED
+	m.SetFeatureTable(I3dmFeatureTableView{
+		Position:        [][3]float32{{10, 0, 0}, {0, 20, 0}},
+		RtcCenter:       []float64{100, 200, 300},
+		NormalUp:        [][3]float32{{0, 0, 1}, {0, 0, 1}},
+		NormalRight:     [][3]float32{{0, 1, 0}, {0, 1, 0}},
+		ScaleNONUniform: [][3]float32{{1, 2, 3}, {1, 1, 1}},
+		InstanceLength:  2,
+	})
+	m.BatchTable.Header = map[string]interface{}{"name": []interface{}{"x", "y"}}
+
+	up, err := m.Upgrade(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := upgradedGltf(t, up)
+	var instancing MeshGpuInstancing
+	var node *gltf.Node
+	for _, root := range doc.Scenes[0].Nodes {
+		if doc.Nodes[root].Extensions[EXT_MESH_GPU_INSTANCING] != nil {
+			node = doc.Nodes[root]
+		}
+	}
+	if node == nil {
+		t.Fatal("no instanced node")
+	}
+	decodeExtension(t, node.Extensions[EXT_MESH_GPU_INSTANCING], &instancing)
+	var features InstanceFeatures
+	decodeExtension(t, node.Extensions[EXT_INSTANCE_FEATURES], &features)
+	if features.FeatureIds[0].FeatureCount != 2 || doc.Accessors[instancing.Attributes[GLTF_ATTR_FEATURE_ID]].Count != 2 {
+		t.Errorf("features %+v", features)
+	}
+
+	// The box model has no node transform, so its vertices are placed by
+	// the instance transform in y-up space.
+	translations, err := modeler.ReadAccessor(doc, doc.Accessors[instancing.Attributes["TRANSLATION"]], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr := translations.([][3]float32); tr[0] != [3]float32{10, 0, 0} || tr[1] != [3]float32{0, 0, -20} {
+		t.Errorf("translations %v", tr)
+	}
+	rotations, err := modeler.ReadAccessor(doc, doc.Accessors[instancing.Attributes["ROTATION"]], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scales, err := modeler.ReadAccessor(doc, doc.Accessors[instancing.Attributes["SCALE"]], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, s := rotations.([][4]float32)[0], scales.([][3]float32)[0]
+	mats, _ := m.InstanceTransforms()
+	for _, v := range [][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}} {
+		// Compare C*I*v with T*C*v, where C rotates y-up to z-up.
+		x := [3]float64{v[0] * float64(s[0]), v[1] * float64(s[1]), v[2] * float64(s[2])}
+		r := mat4Mul(yUpToZUp, nodeMatrix(&gltf.Node{Rotation: q}))
+		var got, want [3]float64
+		cv := mat4Mul(yUpToZUp, [16]float64{v[0], v[1], v[2], 0})
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				got[i] += r[j*4+i] * x[j]
+				want[i] += mats[0][j*4+i] * cv[j]
+			}
+		}
+		for i := range got {
+			if math.Abs(got[i]-want[i]) > 1e-5 {
+				t.Fatalf("axis %v: %v != %v", v, got, want)
+			}
+		}
+	}
+}
+
+func TestPntsUpgrade(t *testing.T) {
+	m := NewPnts()
+	batchLength := uint32(1)
+	m.SetFeatureTable(PntsFeatureTableView{
+		Position:     [][3]float32{{0, 0, 0}, {1, 2, 3}},
+		RGB:          [][3]uint8{{255, 0, 0}, {0, 255, 0}},
+		BatchId:      []uint16{0, 0},
+		BatchLength:  &batchLength,
+		PointsLength: 2,
+		RtcCenter:    []float64{5, 5, 5},
+	})
+	m.BatchTable.Header = map[string]interface{}{"class": []interface{}{"ground"}}
+	up, err := m.Upgrade(&UpgradeOptions{RtcAsTranslation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := upgradedGltf(t, up)
+	prim := doc.Meshes[0].Primitives[0]
+	if prim.Mode != gltf.PrimitivePoints {
+		t.Errorf("mode %v", prim.Mode)
+	}
+	pos, err := modeler.ReadPosition(doc, doc.Accessors[prim.Attributes[gltf.POSITION]], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos[1] != [3]float32{1, 3, -2} {
+		t.Errorf("positions %v", pos)
+	}
+	var features MeshFeatures
+	decodeExtension(t, prim.Extensions[EXT_MESH_FEATURES], &features)
+	if features.FeatureIds[0].FeatureCount != 1 || features.FeatureIds[0].Attribute == nil {
+		t.Errorf("features %+v", features)
+	}
+	root := doc.Nodes[doc.Scenes[0].Nodes[0]]
+	if root.Translation != [3]float32{5, 5, -5} {
+		t.Errorf("translation %v", root.Translation)
+	}
+}
@@ -3,8 +3,9 @@ package tile3d
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"io"
+	"math"
+	"reflect"
 )
 
 const (
@@ -120,7 +121,7 @@ func (h *VctrHeader) SetPointPositionsByteLength(n uint32) {
 }
 
 type VctrFeatureTableView struct {
-	Region               *[6]float32
+	Region               *[6]float64
 	RtcCenter            [3]float64
 	PointsLength         uint32
 	PointBatchId         interface{}
@@ -136,112 +137,206 @@ type VctrFeatureTableView struct {
 	PolygonCounts        []uint32
 }
 
-func VctrFeatureTableDecode(header map[string]interface{}, buff []byte) map[string]interface{} {
+func VctrFeatureTableDecode(header map[string]interface{}, buff []byte) (map[string]interface{}, error) {
 	ret := make(map[string]interface{})
-	polygonsLength := getIntegerScalarFeatureValue(header, buff, VCTR_PROP_POLYGONS_LENGTH)
-	polylinesLength := getIntegerScalarFeatureValue(header, buff, VCTR_PROP_POLYLINES_LENGTH)
-	pointsLength := getIntegerScalarFeatureValue(header, buff, VCTR_PROP_POINTS_LENGTH)
+	polygonsLength, err := getCountFeatureValue(header, VCTR_PROP_POLYGONS_LENGTH, len(buff))
+	if err != nil {
+		return nil, err
+	}
+	polylinesLength, err := getCountFeatureValue(header, VCTR_PROP_POLYLINES_LENGTH, len(buff))
+	if err != nil {
+		return nil, err
+	}
+	pointsLength, err := getCountFeatureValue(header, VCTR_PROP_POINTS_LENGTH, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
 
-	ret[VCTR_PROP_REGION] = getFloatArrayFeatureValue(header, buff, VCTR_PROP_REGION, 6)
-	ret[VCTR_PROP_RTC_CENTER] = getFloatVec3FeatureValue(header, buff, VCTR_PROP_RTC_CENTER)
+	if ret[VCTR_PROP_REGION], err = getDoubleArrayFeatureValue(header, buff, VCTR_PROP_REGION, 6); err != nil {
+		return nil, err
+	}
+	if header[VCTR_PROP_RTC_CENTER] != nil {
+		if ret[VCTR_PROP_RTC_CENTER], err = getFloat64Vec3FeatureValue(header, buff, VCTR_PROP_RTC_CENTER); err != nil {
+			return nil, err
+		}
+	}
 
 	if pointsLength > 0 {
 		ret[VCTR_PROP_POINTS_LENGTH] = pointsLength
-		ret[VCTR_PROP_POINT_BATCH_IDS] = getUnsignedShortBatchIDs(header, buff, VCTR_PROP_POINT_BATCH_IDS, int(pointsLength))
+		if ret[VCTR_PROP_POINT_BATCH_IDS], err = getUnsignedShortBatchIDs(header, buff, VCTR_PROP_POINT_BATCH_IDS, int(pointsLength)); err != nil {
+			return nil, err
+		}
 	}
 
 	if polylinesLength > 0 {
 		ret[VCTR_PROP_POLYLINES_LENGTH] = polylinesLength
-		ret[VCTR_PROP_POLYLINE_BATCH_IDS] = getUnsignedShortBatchIDs(header, buff, VCTR_PROP_POLYLINE_BATCH_IDS, int(polylinesLength))
-		ret[VCTR_PROP_POLYLINE_COUNTS] = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYLINE_COUNTS, int(polylinesLength))
-		ret[VCTR_PROP_POLYLINE_COUNT] = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYLINE_COUNT, int(polylinesLength))
-		ret[VCTR_PROP_POLYLINE_WIDTHS] = getUnsignedShortArrayFeatureValue(header, buff, VCTR_PROP_POLYLINE_WIDTHS, int(polygonsLength))
+		if ret[VCTR_PROP_POLYLINE_BATCH_IDS], err = getUnsignedShortBatchIDs(header, buff, VCTR_PROP_POLYLINE_BATCH_IDS, int(polylinesLength)); err != nil {
+			return nil, err
+		}
+		if ret[VCTR_PROP_POLYLINE_COUNTS], err = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYLINE_COUNTS, int(polylinesLength)); err != nil {
+			return nil, err
+		}
+		if ret[VCTR_PROP_POLYLINE_COUNT], err = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYLINE_COUNT, int(polylinesLength)); err != nil {
+			return nil, err
+		}
+		if ret[VCTR_PROP_POLYLINE_WIDTHS], err = getUnsignedShortArrayFeatureValue(header, buff, VCTR_PROP_POLYLINE_WIDTHS, int(polylinesLength)); err != nil {
+			return nil, err
+		}
 	}
 
 	if polygonsLength > 0 {
 		ret[VCTR_PROP_POLYGONS_LENGTH] = polygonsLength
-		ret[VCTR_PROP_POLYGON_BATCH_IDS] = getUnsignedShortBatchIDs(header, buff, VCTR_PROP_POLYGON_BATCH_IDS, int(polygonsLength))
-		ret[VCTR_PROP_POLYGON_COUNTS] = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_COUNTS, int(polygonsLength))
-		ret[VCTR_PROP_POLYGON_COUNT] = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_COUNT, int(polygonsLength))
-		ret[VCTR_PROP_POLYGON_INDEX_COUNTS] = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_INDEX_COUNTS, int(polygonsLength))
-		ret[VCTR_PROP_POLYGON_INDEX_COUNT] = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_INDEX_COUNT, int(polygonsLength))
+		if ret[VCTR_PROP_POLYGON_BATCH_IDS], err = getUnsignedShortBatchIDs(header, buff, VCTR_PROP_POLYGON_BATCH_IDS, int(polygonsLength)); err != nil {
+			return nil, err
+		}
+		if ret[VCTR_PROP_POLYGON_COUNTS], err = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_COUNTS, int(polygonsLength)); err != nil {
+			return nil, err
+		}
+		if ret[VCTR_PROP_POLYGON_COUNT], err = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_COUNT, int(polygonsLength)); err != nil {
+			return nil, err
+		}
+		if ret[VCTR_PROP_POLYGON_INDEX_COUNTS], err = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_INDEX_COUNTS, int(polygonsLength)); err != nil {
+			return nil, err
+		}
+		if ret[VCTR_PROP_POLYGON_INDEX_COUNT], err = getUnsignedIntArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_INDEX_COUNT, int(polygonsLength)); err != nil {
+			return nil, err
+		}
 
-		ret[VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS] = getFloatArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS, int(polygonsLength))
-		ret[VCTR_PROP_POLYGON_MINIMUM_HEIGHTS] = getFloatArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_MINIMUM_HEIGHTS, int(polygonsLength))
+		if ret[VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS], err = getFloatArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS, int(polygonsLength)); err != nil {
+			return nil, err
+		}
+		if ret[VCTR_PROP_POLYGON_MINIMUM_HEIGHTS], err = getFloatArrayFeatureValue(header, buff, VCTR_PROP_POLYGON_MINIMUM_HEIGHTS, int(polygonsLength)); err != nil {
+			return nil, err
+		}
 	}
 
-	return ret
+	for _, k := range []string{VCTR_PROP_POINT_BATCH_IDS, VCTR_PROP_POLYLINE_BATCH_IDS, VCTR_PROP_POLYGON_BATCH_IDS} {
+		if ids, ok := ret[k].([]uint16); ok && ids == nil {
+			delete(ret, k)
+		}
+	}
+	return ret, nil
 }
 
-func VctrFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) []byte {
+func VctrFeatureTableEncode(header map[string]interface{}, data map[string]interface{}) ([]byte, error) {
 	var out []byte
 	buf := bytes.NewBuffer(out)
 	offset := 0
 
+	// Empty arrays are not written, and arrays read with the legacy count
+	// names are written with the names of the specification.
+	values := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.Len() == 0 {
+			delete(header, k)
+			continue
+		}
+		values[k] = v
+	}
+	for legacy, name := range map[string]string{
+		VCTR_PROP_POLYGON_COUNT:       VCTR_PROP_POLYGON_COUNTS,
+		VCTR_PROP_POLYGON_INDEX_COUNT: VCTR_PROP_POLYGON_INDEX_COUNTS,
+		VCTR_PROP_POLYLINE_COUNT:      VCTR_PROP_POLYLINE_COUNTS,
+	} {
+		if values[name] == nil {
+			values[name] = values[legacy]
+		}
+		delete(values, legacy)
+		delete(header, legacy)
+	}
+	data = values
+
 	if t := data[VCTR_PROP_POINT_BATCH_IDS]; t != nil {
-		dt := t.([]uint16)
+		dt, ok := t.([]uint16)
+		if !ok {
+			return nil, newTileError("", VCTR_PROP_POINT_BATCH_IDS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[VCTR_PROP_POINT_BATCH_IDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 2)
 	}
 
 	if t := data[VCTR_PROP_POLYLINE_BATCH_IDS]; t != nil {
-		dt := t.([]uint16)
+		dt, ok := t.([]uint16)
+		if !ok {
+			return nil, newTileError("", VCTR_PROP_POLYLINE_BATCH_IDS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[VCTR_PROP_POLYLINE_BATCH_IDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 2)
 	}
 
 	if t := data[VCTR_PROP_POLYLINE_COUNTS]; t != nil {
-		dt := t.([]uint32)
+		dt, ok := t.([]uint32)
+		if !ok {
+			return nil, newTileError("", VCTR_PROP_POLYLINE_COUNTS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[VCTR_PROP_POLYLINE_COUNTS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_INT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 4)
 	}
 
 	if t := data[VCTR_PROP_POLYLINE_WIDTHS]; t != nil {
-		dt := t.([]uint16)
+		dt, ok := t.([]uint16)
+		if !ok {
+			return nil, newTileError("", VCTR_PROP_POLYLINE_WIDTHS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[VCTR_PROP_POLYLINE_WIDTHS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 2)
 	}
 
 	if t := data[VCTR_PROP_POLYGON_BATCH_IDS]; t != nil {
-		dt := t.([]uint16)
+		dt, ok := t.([]uint16)
+		if !ok {
+			return nil, newTileError("", VCTR_PROP_POLYGON_BATCH_IDS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[VCTR_PROP_POLYGON_BATCH_IDS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_SHORT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 2)
 	}
 
 	if t := data[VCTR_PROP_POLYGON_COUNTS]; t != nil {
-		dt := t.([]uint32)
+		dt, ok := t.([]uint32)
+		if !ok {
+			return nil, newTileError("", VCTR_PROP_POLYGON_COUNTS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[VCTR_PROP_POLYGON_COUNTS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_INT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 4)
 	}
 
 	if t := data[VCTR_PROP_POLYGON_INDEX_COUNTS]; t != nil {
-		dt := t.([]uint32)
+		dt, ok := t.([]uint32)
+		if !ok {
+			return nil, newTileError("", VCTR_PROP_POLYGON_INDEX_COUNTS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[VCTR_PROP_POLYGON_INDEX_COUNTS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_UNSIGNED_INT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 4)
 	}
 
 	if t := data[VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS]; t != nil {
-		dt := t.([]float32)
+		dt, ok := t.([]float32)
+		if !ok {
+			return nil, newTileError("", VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 4)
 	}
 
 	if t := data[VCTR_PROP_POLYGON_MINIMUM_HEIGHTS]; t != nil {
-		dt := t.([]float32)
+		dt, ok := t.([]float32)
+		if !ok {
+			return nil, newTileError("", VCTR_PROP_POLYGON_MINIMUM_HEIGHTS, -1, ErrBadValue)
+		}
 		binary.Write(buf, littleEndian, dt)
 		header[VCTR_PROP_POLYGON_MINIMUM_HEIGHTS] = BinaryBodyReference{ByteOffset: uint32(offset), ComponentType: COMPONENT_TYPE_FLOAT, ContainerType: CONTAINER_TYPE_SCALAR}
 		offset += (len(dt) * 4)
 	}
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 type VctrIndices struct {
@@ -260,10 +355,11 @@ func (m VctrIndices) CalcSize(header Header) uint32 {
 
 func (m *VctrIndices) Read(reader io.ReadSeeker, header Header) error {
 	ch := header.(*VctrHeader)
+	offset, _ := reader.Seek(0, io.SeekCurrent)
 	m.p = make([][3]uint32, int(ch.GetPolygonIndicesByteLength()/3/4))
 	err := binary.Read(reader, littleEndian, m.p)
 	if err != nil {
-		return err
+		return newTileError(VCTR_MAGIC, "polygonIndices", offset, err)
 	}
 	return nil
 }
@@ -302,16 +398,17 @@ func (m *VctrPolygons) CalcSize(header Header) uint32 {
 
 func (m *VctrPolygons) Read(reader io.ReadSeeker, header Header) error {
 	ch := header.(*VctrHeader)
+	offset, _ := reader.Seek(0, io.SeekCurrent)
 	us := make([]uint16, int(ch.GetPolygonPositionsByteLength()/2/2))
 	vs := make([]uint16, int(ch.GetPolygonPositionsByteLength()/2/2))
 
 	err := binary.Read(reader, littleEndian, us)
 	if err != nil {
-		return err
+		return newTileError(VCTR_MAGIC, "polygonPositions", offset, err)
 	}
 	err = binary.Read(reader, littleEndian, vs)
 	if err != nil {
-		return err
+		return newTileError(VCTR_MAGIC, "polygonPositions", offset, err)
 	}
 	m.decode(us, vs)
 	return nil
@@ -357,19 +454,23 @@ func (m *VctrPolylines) CalcSize(header Header) uint32 {
 
 func (m *VctrPolylines) Read(reader io.ReadSeeker, header Header) error {
 	ch := header.(*VctrHeader)
-	us := make([]uint16, int(ch.GetPolylinePositionsByteLength()/2/4))
-	vs := make([]uint16, int(ch.GetPolylinePositionsByteLength()/2/4))
-	hs := make([]uint16, int(ch.GetPolylinePositionsByteLength()/2/4))
+	offset, _ := reader.Seek(0, io.SeekCurrent)
+	us := make([]uint16, int(ch.GetPolylinePositionsByteLength()/2/3))
+	vs := make([]uint16, int(ch.GetPolylinePositionsByteLength()/2/3))
+	hs := make([]uint16, int(ch.GetPolylinePositionsByteLength()/2/3))
 
 	err := binary.Read(reader, littleEndian, us)
 	if err != nil {
-		return err
+		return newTileError(VCTR_MAGIC, "polylinePositions", offset, err)
 	}
 	err = binary.Read(reader, littleEndian, vs)
 	if err != nil {
-		return err
+		return newTileError(VCTR_MAGIC, "polylinePositions", offset, err)
+	}
+	err = binary.Read(reader, littleEndian, hs)
+	if err != nil {
+		return newTileError(VCTR_MAGIC, "polylinePositions", offset, err)
 	}
-
 	m.decode(us, vs, hs)
 	return nil
 }
@@ -413,29 +514,28 @@ func (m *VctrPoints) decode(us, vs, hs []uint16) {
 
 func (m *VctrPoints) CalcSize(header Header) uint32 {
 	ct := uint32(len(m.p) * 3 * 2)
-	header.(*VctrHeader).PolylinePositionsByteLength = ct
+	header.(*VctrHeader).PointPositionsByteLength = ct
 	return ct
-
 }
 
 func (m *VctrPoints) Read(reader io.ReadSeeker, header Header) error {
 	ch := header.(*VctrHeader)
+	offset, _ := reader.Seek(0, io.SeekCurrent)
 	us := make([]uint16, int(ch.PointPositionsByteLength/2/3))
 	vs := make([]uint16, int(ch.PointPositionsByteLength/2/3))
 	hs := make([]uint16, int(ch.PointPositionsByteLength/2/3))
 
 	err := binary.Read(reader, littleEndian, us)
 	if err != nil {
-		fmt.Println(err.Error())
-		return err
+		return newTileError(VCTR_MAGIC, "pointPositions", offset, err)
 	}
 	err = binary.Read(reader, littleEndian, vs)
 	if err != nil {
-		return err
+		return newTileError(VCTR_MAGIC, "pointPositions", offset, err)
 	}
 	err = binary.Read(reader, littleEndian, hs)
 	if err != nil {
-		return err
+		return newTileError(VCTR_MAGIC, "pointPositions", offset, err)
 	}
 	m.decode(us, vs, hs)
 	return nil
@@ -538,24 +638,26 @@ func (m *Vctr) SetFeatureTable(view VctrFeatureTableView) {
 func (m *Vctr) GetFeatureTableView() VctrFeatureTableView {
 	ret := VctrFeatureTableView{}
 
-	if t := m.FeatureTable.Data[VCTR_PROP_POLYGONS_LENGTH]; t != nil {
-		ret.PolygonsLength = t.(uint32)
-	}
-
-	if t := m.FeatureTable.Data[VCTR_PROP_POLYLINES_LENGTH]; t != nil {
-		ret.PolylinesLength = t.(uint32)
-	}
+	ret.PolygonsLength = uint32(m.getCount(VCTR_PROP_POLYGONS_LENGTH))
+	ret.PolylinesLength = uint32(m.getCount(VCTR_PROP_POLYLINES_LENGTH))
+	ret.PointsLength = uint32(m.getCount(VCTR_PROP_POINTS_LENGTH))
 
-	if t := m.FeatureTable.Data[VCTR_PROP_POINTS_LENGTH]; t != nil {
-		ret.PointsLength = t.(uint32)
+	switch t := m.FeatureTable.Data[VCTR_PROP_RTC_CENTER].(type) {
+	case [3]float64:
+		ret.RtcCenter = t
+	case nil:
+		if m.FeatureTable.Header[VCTR_PROP_RTC_CENTER] != nil {
+			ret.RtcCenter, _ = getFloat64Vec3FeatureValue(m.FeatureTable.Header, nil, VCTR_PROP_RTC_CENTER)
+		}
 	}
 
-	if t := m.FeatureTable.Data[VCTR_PROP_RTC_CENTER]; t != nil {
-		copy(ret.RtcCenter[:], t.([]float64))
+	t, ok := m.FeatureTable.Data[VCTR_PROP_REGION].([]float64)
+	if !ok && m.FeatureTable.Header[VCTR_PROP_REGION] != nil {
+		t, _ = getDoubleArrayFeatureValue(m.FeatureTable.Header, nil, VCTR_PROP_REGION, 6)
 	}
-
-	if t := m.FeatureTable.Data[VCTR_PROP_REGION]; t != nil {
-		copy(ret.Region[:], t.([]float32))
+	if len(t) == 6 {
+		ret.Region = new([6]float64)
+		copy(ret.Region[:], t)
 	}
 
 	if t := m.FeatureTable.Data[VCTR_PROP_POINT_BATCH_IDS]; t != nil {
@@ -597,6 +699,17 @@ func (m *Vctr) GetFeatureTableView() VctrFeatureTableView {
 	return ret
 }
 
+func (m *Vctr) getCount(propName string) int {
+	n, _ := m.FeatureTable.getCount(propName)
+	return n
+}
+
+// BatchLength returns the number of rows in the batch table, one per
+// polygon, polyline and point.
+func (m *Vctr) BatchLength() int {
+	return m.getCount(VCTR_PROP_POLYGONS_LENGTH) + m.getCount(VCTR_PROP_POLYLINES_LENGTH) + m.getCount(VCTR_PROP_POINTS_LENGTH)
+}
+
 func (m *Vctr) GetHeader() Header {
 	return &m.Header
 }
@@ -625,8 +738,18 @@ func (m *Vctr) GetPoints() VctrPoints {
 	return m.Points
 }
 
-func (m *Vctr) CalcSize() uint32 {
+// indicesPadding returns the padding that puts the polygon indices on a 4
+// byte boundary of the tile.
+func (m *Vctr) indicesPadding() uint32 {
+	offset := uint32(m.Header.CalcSize()) + m.Header.FeatureTableJSONByteLength + m.Header.FeatureTableBinaryByteLength +
+		m.Header.BatchTableJSONByteLength + m.Header.BatchTableBinaryByteLength
+	return calcPadding(offset, 4)
+}
+
+func (m *Vctr) CalcSize() (int64, error) {
+	m.FeatureTable.encode = VctrFeatureTableEncode
 	si := uint32(m.Header.CalcSize() + m.FeatureTable.CalcSize(m.GetHeader()) + m.BatchTable.CalcSize(m.GetHeader()))
+	si += m.indicesPadding()
 
 	if m.Indices.p != nil {
 		si += m.Indices.CalcSize(m.GetHeader())
@@ -643,23 +766,37 @@ func (m *Vctr) CalcSize() uint32 {
 	if m.Points.p != nil {
 		si += m.Points.CalcSize(m.GetHeader())
 	}
-	return si
+	return int64(si), nil
 }
 
 func (m *Vctr) Read(reader io.ReadSeeker) error {
+	start, _ := reader.Seek(0, io.SeekCurrent)
 	err := binary.Read(reader, littleEndian, &m.Header)
 	if err != nil {
+		return newTileError(VCTR_MAGIC, "header", start, err)
+	}
+	if err := checkMagic(VCTR_MAGIC, start, m.Header.Magic); err != nil {
+		return err
+	}
+	if err := checkVersion(VCTR_MAGIC, start, m.Header.Version); err != nil {
+		return err
+	}
+	if err := checkByteLength(reader, VCTR_MAGIC, start, m.GetHeader(), m.Header.PolygonIndicesByteLength, m.Header.PolygonPositionsByteLength, m.Header.PolylinePositionsByteLength, m.Header.PointPositionsByteLength); err != nil {
 		return err
 	}
 
 	m.FeatureTable.decode = VctrFeatureTableDecode
 
 	if err := m.FeatureTable.Read(reader, m.GetHeader()); err != nil {
-		return err
+		return wrapTileError(VCTR_MAGIC, "featureTable", 0, err)
 	}
 
-	if err := m.BatchTable.Read(reader, m.GetHeader(), m.FeatureTable.GetBatchLength()); err != nil {
-		return err
+	if err := m.BatchTable.Read(reader, m.GetHeader(), m.BatchLength()); err != nil {
+		return wrapTileError(VCTR_MAGIC, "batchTable", 0, err)
+	}
+
+	if _, err := reader.Seek(int64(m.indicesPadding()), io.SeekCurrent); err != nil {
+		return newTileError(VCTR_MAGIC, "polygonIndices", start, err)
 	}
 
 	if err := m.Indices.Read(reader, m.GetHeader()); err != nil {
@@ -681,24 +818,41 @@ func (m *Vctr) Read(reader io.ReadSeeker) error {
 	return nil
 }
 
+// ReadFromBytes reads a tile from data. Binary arrays are views over data
+// where possible, so data must not be modified while m is in use.
+func (m *Vctr) ReadFromBytes(data []byte) error {
+	return m.Read(newSliceReader(data))
+}
+
 func (m *Vctr) Write(writer io.Writer) error {
+	copy(m.Header.Magic[:], VCTR_MAGIC)
+	m.Header.Version = 1
 	m.FeatureTable.encode = VctrFeatureTableEncode
-	_ = VctrFeatureTableEncode(m.FeatureTable.Header, m.FeatureTable.Data)
-	si := m.CalcSize()
+	if _, err := VctrFeatureTableEncode(m.FeatureTable.copyHeader(), m.FeatureTable.Data); err != nil {
+		return wrapTileError(VCTR_MAGIC, "featureTable", -1, err)
+	}
+	si, err := m.CalcSize()
+	if err != nil {
+		return err
+	}
 
 	m.Header.ByteLength = uint32(si)
 
-	err := binary.Write(writer, littleEndian, m.Header)
+	err = binary.Write(writer, littleEndian, m.Header)
 
 	if err != nil {
 		return err
 	}
 
-	if err := m.FeatureTable.Write(writer, nil); err != nil {
-		return err
+	if err := m.FeatureTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(VCTR_MAGIC, "featureTable", -1, err)
+	}
+
+	if err := m.BatchTable.Write(writer, m.GetHeader()); err != nil {
+		return wrapTileError(VCTR_MAGIC, "batchTable", -1, err)
 	}
 
-	if err := m.BatchTable.Write(writer, nil); err != nil {
+	if _, err := writer.Write(make([]byte, m.indicesPadding())); err != nil {
 		return err
 	}
 
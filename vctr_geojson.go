@@ -0,0 +1,750 @@
+package tile3d
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+)
+
+const (
+	VCTR_MAX_SHORT = 32767
+)
+
+type VctrFeatureType int
+
+const (
+	VCTR_FEATURE_POLYGON VctrFeatureType = iota
+	VCTR_FEATURE_POLYLINE
+	VCTR_FEATURE_POINT
+)
+
+// VctrFeature is a polygon, polyline or point of a vector tile with its
+// positions dequantized against the tile REGION.
+type VctrFeature struct {
+	Type    VctrFeatureType
+	BatchId uint16
+	// Positions are longitude and latitude in degrees and height in meters.
+	// Polygon positions are at MinimumHeight and include the vertices of
+	// its holes.
+	Positions [][3]float64
+	// Triangles index into Positions of a polygon.
+	Triangles     [][3]uint32
+	MinimumHeight float64
+	MaximumHeight float64
+	Width         uint16
+	Properties    map[string]interface{}
+}
+
+type vctrRegion [6]float64
+
+func (r vctrRegion) lonLat(u, v int) (float64, float64) {
+	lon := r[0] + (r[2]-r[0])*float64(u)/VCTR_MAX_SHORT
+	lat := r[1] + (r[3]-r[1])*float64(v)/VCTR_MAX_SHORT
+	return lon * 180 / math.Pi, lat * 180 / math.Pi
+}
+
+func (r vctrRegion) height(h int) float64 {
+	return r[4] + (r[5]-r[4])*float64(h)/VCTR_MAX_SHORT
+}
+
+func (m *Vctr) getCounts(propName, legacyName string, n int) ([]uint32, error) {
+	t, _ := m.FeatureTable.Data[propName].([]uint32)
+	if t == nil {
+		t, _ = m.FeatureTable.Data[legacyName].([]uint32)
+	}
+	if len(t) < n {
+		return nil, newTileError(VCTR_MAGIC, propName, -1, ErrBadValue)
+	}
+	return t[:n], nil
+}
+
+// BatchIds returns the batch ids of polygons, polylines and points. When a
+// tile has no batch ids the features are numbered in that order.
+func (m *Vctr) BatchIds() (polygons, polylines, points []uint16, err error) {
+	view := m.GetFeatureTableView()
+	lengths := []int{int(view.PolygonsLength), int(view.PolylinesLength), int(view.PointsLength)}
+	names := []string{VCTR_PROP_POLYGON_BATCH_IDS, VCTR_PROP_POLYLINE_BATCH_IDS, VCTR_PROP_POINT_BATCH_IDS}
+	explicit := []interface{}{view.PolygonBatchId, view.PolylineBatchId, view.PointBatchId}
+
+	ret := make([][]uint16, 3)
+	if explicit[0] == nil && explicit[1] == nil && explicit[2] == nil {
+		next := 0
+		for i, n := range lengths {
+			ret[i] = make([]uint16, n)
+			for j := range ret[i] {
+				ret[i][j] = uint16(next + j)
+			}
+			next += n
+		}
+		return ret[0], ret[1], ret[2], nil
+	}
+	for i, n := range lengths {
+		if n == 0 {
+			continue
+		}
+		t, ok := explicit[i].([]uint16)
+		if !ok || len(t) < n {
+			return nil, nil, nil, newTileError(VCTR_MAGIC, names[i], -1, ErrBadValue)
+		}
+		ret[i] = t[:n]
+	}
+	return ret[0], ret[1], ret[2], nil
+}
+
+// Features decodes the polygons, polylines and points of m, in that order,
+// and joins them to their batch table properties.
+func (m *Vctr) Features() ([]VctrFeature, error) {
+	view := m.GetFeatureTableView()
+	if view.Region == nil {
+		return nil, newTileError(VCTR_MAGIC, VCTR_PROP_REGION, -1, ErrBadValue)
+	}
+	region := vctrRegion(*view.Region)
+	polygonIds, polylineIds, pointIds, err := m.BatchIds()
+	if err != nil {
+		return nil, err
+	}
+	var ret []VctrFeature
+
+	if n := int(view.PolygonsLength); n > 0 {
+		counts, err := m.getCounts(VCTR_PROP_POLYGON_COUNTS, VCTR_PROP_POLYGON_COUNT, n)
+		if err != nil {
+			return nil, err
+		}
+		indexCounts, err := m.getCounts(VCTR_PROP_POLYGON_INDEX_COUNTS, VCTR_PROP_POLYGON_INDEX_COUNT, n)
+		if err != nil {
+			return nil, err
+		}
+		if view.PolygonMinimumHeight != nil && len(view.PolygonMinimumHeight) < n {
+			return nil, newTileError(VCTR_MAGIC, VCTR_PROP_POLYGON_MINIMUM_HEIGHTS, -1, ErrBadValue)
+		}
+		if view.PolygonMaximumHeight != nil && len(view.PolygonMaximumHeight) < n {
+			return nil, newTileError(VCTR_MAGIC, VCTR_PROP_POLYGON_MAXIMUM_HEIGHTS, -1, ErrBadValue)
+		}
+		offset, indexOffset := 0, 0
+		for i := 0; i < n; i++ {
+			count, indexCount := int(counts[i]), int(indexCounts[i])
+			if offset+count > len(m.Polygons.p) || indexCount%3 != 0 || (indexOffset+indexCount)/3 > len(m.Indices.p) {
+				return nil, newTileError(VCTR_MAGIC, "polygonPositions", -1, ErrBadValue)
+			}
+			f := VctrFeature{
+				Type:          VCTR_FEATURE_POLYGON,
+				BatchId:       polygonIds[i],
+				MinimumHeight: region[4],
+				MaximumHeight: region[5],
+			}
+			if view.PolygonMinimumHeight != nil {
+				f.MinimumHeight = float64(view.PolygonMinimumHeight[i])
+			}
+			if view.PolygonMaximumHeight != nil {
+				f.MaximumHeight = float64(view.PolygonMaximumHeight[i])
+			}
+			f.Positions = make([][3]float64, count)
+			for j, p := range m.Polygons.p[offset : offset+count] {
+				f.Positions[j][0], f.Positions[j][1] = region.lonLat(p[0], p[1])
+				f.Positions[j][2] = f.MinimumHeight
+			}
+			f.Triangles = make([][3]uint32, indexCount/3)
+			for j, tri := range m.Indices.p[indexOffset/3 : (indexOffset+indexCount)/3] {
+				for k := range tri {
+					if tri[k] < uint32(offset) || tri[k] >= uint32(offset+count) {
+						return nil, newTileError(VCTR_MAGIC, "polygonIndices", -1, ErrBadValue)
+					}
+					f.Triangles[j][k] = tri[k] - uint32(offset)
+				}
+			}
+			ret = append(ret, f)
+			offset += count
+			indexOffset += indexCount
+		}
+	}
+
+	if n := int(view.PolylinesLength); n > 0 {
+		counts, err := m.getCounts(VCTR_PROP_POLYLINE_COUNTS, VCTR_PROP_POLYLINE_COUNT, n)
+		if err != nil {
+			return nil, err
+		}
+		if view.PolylineWidths != nil && len(view.PolylineWidths) < n {
+			return nil, newTileError(VCTR_MAGIC, VCTR_PROP_POLYLINE_WIDTHS, -1, ErrBadValue)
+		}
+		offset := 0
+		for i := 0; i < n; i++ {
+			count := int(counts[i])
+			if offset+count > len(m.Polylines.p) {
+				return nil, newTileError(VCTR_MAGIC, "polylinePositions", -1, ErrBadValue)
+			}
+			f := VctrFeature{Type: VCTR_FEATURE_POLYLINE, BatchId: polylineIds[i]}
+			if view.PolylineWidths != nil {
+				f.Width = view.PolylineWidths[i]
+			}
+			f.Positions = make([][3]float64, count)
+			for j, p := range m.Polylines.p[offset : offset+count] {
+				f.Positions[j][0], f.Positions[j][1] = region.lonLat(p[0], p[1])
+				f.Positions[j][2] = region.height(p[2])
+			}
+			ret = append(ret, f)
+			offset += count
+		}
+	}
+
+	if n := int(view.PointsLength); n > 0 {
+		if n > len(m.Points.p) {
+			return nil, newTileError(VCTR_MAGIC, "pointPositions", -1, ErrBadValue)
+		}
+		for i, p := range m.Points.p[:n] {
+			f := VctrFeature{Type: VCTR_FEATURE_POINT, BatchId: pointIds[i], Positions: make([][3]float64, 1)}
+			f.Positions[0][0], f.Positions[0][1] = region.lonLat(p[0], p[1])
+			f.Positions[0][2] = region.height(p[2])
+			ret = append(ret, f)
+		}
+	}
+
+	batchLength := m.BatchLength()
+	for i := range ret {
+		ret[i].Properties = batchTableRow(&m.BatchTable, int(ret[i].BatchId), batchLength)
+	}
+	return ret, nil
+}
+
+// batchTableRow returns the properties of batchId that have one value per
+// feature.
+func batchTableRow(t *BatchTable, batchId, batchLength int) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for k, v := range t.Data {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice || rv.Len() != batchLength || batchId >= batchLength {
+			continue
+		}
+		ret[k] = rv.Index(batchId).Interface()
+	}
+	return ret
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type          string                 `json:"type"`
+	Id            uint16                 `json:"id"`
+	Geometry      geoJSONGeometry        `json:"geometry"`
+	Properties    map[string]interface{} `json:"properties"`
+	MinimumHeight *float64               `json:"minimumHeight,omitempty"`
+	MaximumHeight *float64               `json:"maximumHeight,omitempty"`
+	Width         *uint16                `json:"width,omitempty"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// WriteGeoJSON writes the features of m as a GeoJSON FeatureCollection.
+// Feature ids are batch ids. Polygons are written as 2D rings with their
+// extrusion heights in the minimumHeight and maximumHeight members, and
+// as a MultiPolygon when their triangles have several outer rings.
+func (m *Vctr) WriteGeoJSON(writer io.Writer) error {
+	features, err := m.Features()
+	if err != nil {
+		return err
+	}
+	fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, 0, len(features))}
+	for i := range features {
+		f := &features[i]
+		gf := geoJSONFeature{Type: "Feature", Id: f.BatchId, Properties: f.Properties}
+		switch f.Type {
+		case VCTR_FEATURE_POLYGON:
+			polygons := vctrPolygonRings(f)
+			if len(polygons) == 1 {
+				gf.Geometry = geoJSONGeometry{Type: "Polygon", Coordinates: polygons[0]}
+			} else {
+				gf.Geometry = geoJSONGeometry{Type: "MultiPolygon", Coordinates: polygons}
+			}
+			gf.MinimumHeight, gf.MaximumHeight = &f.MinimumHeight, &f.MaximumHeight
+		case VCTR_FEATURE_POLYLINE:
+			gf.Geometry = geoJSONGeometry{Type: "LineString", Coordinates: f.Positions}
+			if f.Width != 0 {
+				gf.Width = &f.Width
+			}
+		case VCTR_FEATURE_POINT:
+			gf.Geometry = geoJSONGeometry{Type: "Point", Coordinates: f.Positions[0]}
+		}
+		fc.Features = append(fc.Features, gf)
+	}
+	enc := json.NewEncoder(writer)
+	return enc.Encode(fc)
+}
+
+// vctrPolygonRings returns the closed rings of polygon f grouped by outer
+// ring, counter-clockwise outer rings followed by their clockwise holes.
+// The rings follow the edges used by a single triangle, since the
+// positions of a polygon hold the vertices of its holes too. Positions
+// without triangles are a single ring.
+func vctrPolygonRings(f *VctrFeature) [][][][2]float64 {
+	closeRing := func(ring [][2]float64) [][2]float64 {
+		if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+			ring = append(ring, ring[0])
+		}
+		return ring
+	}
+	if len(f.Triangles) == 0 {
+		ring := make([][2]float64, 0, len(f.Positions)+1)
+		for _, p := range f.Positions {
+			ring = append(ring, [2]float64{p[0], p[1]})
+		}
+		return [][][][2]float64{{closeRing(ring)}}
+	}
+
+	// Vertices at the same position are the same vertex, like the closing
+	// position of a ring.
+	vertex := make([]uint32, len(f.Positions))
+	first := make(map[[2]float64]uint32)
+	for i, p := range f.Positions {
+		key := [2]float64{p[0], p[1]}
+		if j, ok := first[key]; ok {
+			vertex[i] = j
+		} else {
+			first[key] = uint32(i)
+			vertex[i] = uint32(i)
+		}
+	}
+	xy := func(i uint32) [2]float64 { return [2]float64{f.Positions[i][0], f.Positions[i][1]} }
+
+	edges := make(map[[2]uint32]int)
+	var boundary [][2]uint32
+	for _, t := range f.Triangles {
+		if int(t[0]) >= len(f.Positions) || int(t[1]) >= len(f.Positions) || int(t[2]) >= len(f.Positions) {
+			continue
+		}
+		a, b, c := vertex[t[0]], vertex[t[1]], vertex[t[2]]
+		pa, pb, pc := xy(a), xy(b), xy(c)
+		area := (pb[0]-pa[0])*(pc[1]-pa[1]) - (pc[0]-pa[0])*(pb[1]-pa[1])
+		if area == 0 {
+			continue
+		}
+		if area < 0 {
+			b, c = c, b
+		}
+		for _, e := range [][2]uint32{{a, b}, {b, c}, {c, a}} {
+			if edges[[2]uint32{e[1], e[0]}] > 0 {
+				edges[[2]uint32{e[1], e[0]}]--
+				continue
+			}
+			edges[e]++
+			boundary = append(boundary, e)
+		}
+	}
+
+	// Counter-clockwise triangles leave the outer rings counter-clockwise
+	// and the holes clockwise.
+	next := make(map[uint32][]uint32)
+	for _, e := range boundary {
+		if edges[e] > 0 {
+			edges[e]--
+			next[e[0]] = append(next[e[0]], e[1])
+		}
+	}
+	var outers, holes [][][2]float64
+	for _, e := range boundary {
+		start := e[0]
+		if len(next[start]) == 0 {
+			continue
+		}
+		ring := [][2]float64{xy(start)}
+		for v := start; len(next[v]) > 0; {
+			w := next[v][0]
+			next[v] = next[v][1:]
+			if w == start {
+				break
+			}
+			ring = append(ring, xy(w))
+			v = w
+		}
+		if len(ring) < 3 {
+			continue
+		}
+		if ringArea(ring) > 0 {
+			outers = append(outers, closeRing(ring))
+		} else {
+			holes = append(holes, closeRing(ring))
+		}
+	}
+
+	ret := make([][][][2]float64, len(outers))
+	for i, o := range outers {
+		ret[i] = [][][2]float64{o}
+	}
+	for _, h := range holes {
+		i := 0
+		for i < len(outers) && !ringContains(outers[i], h[0]) {
+			i++
+		}
+		if i == len(outers) {
+			for l, r := 0, len(h)-1; l < r; l, r = l+1, r-1 {
+				h[l], h[r] = h[r], h[l]
+			}
+			ret = append(ret, [][][2]float64{h})
+			continue
+		}
+		ret[i] = append(ret[i], h)
+	}
+	return ret
+}
+
+// ringArea returns the signed area of ring, positive when it is
+// counter-clockwise.
+func ringArea(ring [][2]float64) float64 {
+	area := 0.0
+	for i, p := range ring {
+		q := ring[(i+1)%len(ring)]
+		area += p[0]*q[1] - q[0]*p[1]
+	}
+	return area / 2
+}
+
+func ringContains(ring [][2]float64, p [2]float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+type geoJSONObject struct {
+	Type        string                 `json:"type"`
+	Features    []geoJSONObject        `json:"features"`
+	Geometry    *geoJSONObject         `json:"geometry"`
+	Geometries  []geoJSONObject        `json:"geometries"`
+	Coordinates json.RawMessage        `json:"coordinates"`
+	Properties  map[string]interface{} `json:"properties"`
+	// The extrusion of polygons and the width of polylines, as written by
+	// WriteGeoJSON.
+	MinimumHeight *float64 `json:"minimumHeight"`
+	MaximumHeight *float64 `json:"maximumHeight"`
+	Width         *uint16  `json:"width"`
+}
+
+type vctrSource struct {
+	typ                          VctrFeatureType
+	rings                        [][][3]float64
+	properties                   map[string]interface{}
+	minimumHeight, maximumHeight *float64
+	width                        *uint16
+}
+
+type vctrSources []vctrSource
+
+func geoJSONPosition(p []float64) ([3]float64, error) {
+	if len(p) < 2 {
+		return [3]float64{}, ErrBadValue
+	}
+	ret := [3]float64{p[0], p[1], 0}
+	if len(p) > 2 {
+		ret[2] = p[2]
+	}
+	return ret, nil
+}
+
+func geoJSONRing(ps [][]float64, closed bool) ([][3]float64, error) {
+	ret := make([][3]float64, 0, len(ps))
+	for _, p := range ps {
+		pos, err := geoJSONPosition(p)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, pos)
+	}
+	if closed && len(ret) > 1 && ret[0] == ret[len(ret)-1] {
+		ret = ret[:len(ret)-1]
+	}
+	return ret, nil
+}
+
+func geoJSONPolygon(rings [][][]float64) ([][][3]float64, error) {
+	ret := make([][][3]float64, 0, len(rings))
+	for _, r := range rings {
+		ring, err := geoJSONRing(r, true)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ring)
+	}
+	return ret, nil
+}
+
+func (s *vctrSources) addGeometry(g *geoJSONObject, properties map[string]interface{}) error {
+	add := func(typ VctrFeatureType, rings [][][3]float64) {
+		*s = append(*s, vctrSource{typ: typ, rings: rings, properties: properties})
+	}
+	switch g.Type {
+	case "Point":
+		var c []float64
+		if err := json.Unmarshal(g.Coordinates, &c); err != nil {
+			return err
+		}
+		p, err := geoJSONPosition(c)
+		if err != nil {
+			return err
+		}
+		add(VCTR_FEATURE_POINT, [][][3]float64{{p}})
+	case "MultiPoint":
+		var c [][]float64
+		if err := json.Unmarshal(g.Coordinates, &c); err != nil {
+			return err
+		}
+		for _, pc := range c {
+			p, err := geoJSONPosition(pc)
+			if err != nil {
+				return err
+			}
+			add(VCTR_FEATURE_POINT, [][][3]float64{{p}})
+		}
+	case "LineString":
+		var c [][]float64
+		if err := json.Unmarshal(g.Coordinates, &c); err != nil {
+			return err
+		}
+		line, err := geoJSONRing(c, false)
+		if err != nil {
+			return err
+		}
+		add(VCTR_FEATURE_POLYLINE, [][][3]float64{line})
+	case "MultiLineString":
+		var c [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &c); err != nil {
+			return err
+		}
+		for _, lc := range c {
+			line, err := geoJSONRing(lc, false)
+			if err != nil {
+				return err
+			}
+			add(VCTR_FEATURE_POLYLINE, [][][3]float64{line})
+		}
+	case "Polygon":
+		var c [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &c); err != nil {
+			return err
+		}
+		rings, err := geoJSONPolygon(c)
+		if err != nil {
+			return err
+		}
+		add(VCTR_FEATURE_POLYGON, rings)
+	case "MultiPolygon":
+		var c [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &c); err != nil {
+			return err
+		}
+		for _, pc := range c {
+			rings, err := geoJSONPolygon(pc)
+			if err != nil {
+				return err
+			}
+			add(VCTR_FEATURE_POLYGON, rings)
+		}
+	case "GeometryCollection":
+		for i := range g.Geometries {
+			if err := s.addGeometry(&g.Geometries[i], properties); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrBadValue
+	}
+	return nil
+}
+
+func (s *vctrSources) add(o *geoJSONObject) error {
+	switch o.Type {
+	case "FeatureCollection":
+		for i := range o.Features {
+			if err := s.add(&o.Features[i]); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if o.Geometry == nil {
+			return nil
+		}
+		n := len(*s)
+		if err := s.addGeometry(o.Geometry, o.Properties); err != nil {
+			return err
+		}
+		for i := n; i < len(*s); i++ {
+			(*s)[i].minimumHeight, (*s)[i].maximumHeight, (*s)[i].width = o.MinimumHeight, o.MaximumHeight, o.Width
+		}
+	default:
+		return s.addGeometry(o, nil)
+	}
+	return nil
+}
+
+func (r vctrRegion) quantize(p [3]float64) ([3]int, error) {
+	var ret [3]int
+	lon, lat := p[0]*math.Pi/180, p[1]*math.Pi/180
+	for i, t := range [3][3]float64{{lon, r[0], r[2]}, {lat, r[1], r[3]}, {p[2], r[4], r[5]}} {
+		if t[2] == t[1] {
+			continue
+		}
+		f := (t[0] - t[1]) / (t[2] - t[1])
+		if f < -1e-9 || f > 1+1e-9 {
+			return ret, ErrBadValue
+		}
+		ret[i] = int(math.Round(math.Max(0, math.Min(1, f)) * VCTR_MAX_SHORT))
+	}
+	return ret, nil
+}
+
+// NewVctrFromGeoJSON builds a vector tile from a GeoJSON FeatureCollection,
+// Feature or geometry. Coordinates are quantized to region, given as
+// west, south, east and north in radians followed by the minimum and
+// maximum height, or to the bounds of the features when region is nil.
+// Every polygon, polyline and point gets its own batch id in input order,
+// so the parts of a multi geometry repeat the properties of their feature.
+// The minimumHeight and maximumHeight members of features extrude their
+// polygons, which otherwise span the heights of region, and width sets the
+// pixel width of polylines, 2 by default.
+func NewVctrFromGeoJSON(reader io.Reader, region *[6]float64) (*Vctr, error) {
+	var obj geoJSONObject
+	if err := json.NewDecoder(reader).Decode(&obj); err != nil {
+		return nil, newTileError(VCTR_MAGIC, "geojson", -1, err)
+	}
+	var sources vctrSources
+	if err := sources.add(&obj); err != nil {
+		return nil, newTileError(VCTR_MAGIC, "geojson", -1, err)
+	}
+	if len(sources) > math.MaxUint16+1 {
+		return nil, newTileError(VCTR_MAGIC, "geojson", -1, ErrBadValue)
+	}
+
+	var r vctrRegion
+	if region != nil {
+		r = vctrRegion(*region)
+	} else {
+		r = vctrRegion{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1), math.Inf(1), math.Inf(-1)}
+		for _, s := range sources {
+			for _, ring := range s.rings {
+				for _, p := range ring {
+					lon, lat := p[0]*math.Pi/180, p[1]*math.Pi/180
+					r[0], r[1], r[4] = math.Min(r[0], lon), math.Min(r[1], lat), math.Min(r[4], p[2])
+					r[2], r[3], r[5] = math.Max(r[2], lon), math.Max(r[3], lat), math.Max(r[5], p[2])
+				}
+			}
+			for _, h := range []*float64{s.minimumHeight, s.maximumHeight} {
+				if h != nil && s.typ == VCTR_FEATURE_POLYGON {
+					r[4], r[5] = math.Min(r[4], *h), math.Max(r[5], *h)
+				}
+			}
+		}
+		if math.IsInf(r[0], 1) {
+			r = vctrRegion{}
+		}
+	}
+
+	m := &Vctr{}
+	view := VctrFeatureTableView{Region: (*[6]float64)(&r)}
+	var polygonIds, polylineIds, pointIds []uint16
+	var minimumHeights, maximumHeights []float32
+	var widths []uint16
+	extruded, wide := false, false
+	for i, s := range sources {
+		switch s.typ {
+		case VCTR_FEATURE_POLYGON:
+			offset := uint32(len(m.Polygons.p))
+			var count uint32
+			flat := make([][][2]float64, len(s.rings))
+			for j, ring := range s.rings {
+				flat[j] = make([][2]float64, len(ring))
+				for k, p := range ring {
+					q, err := r.quantize(p)
+					if err != nil {
+						return nil, newTileError(VCTR_MAGIC, "polygonPositions", -1, err)
+					}
+					m.Polygons.Add([2]int{q[0], q[1]})
+					flat[j][k] = [2]float64{p[0], p[1]}
+					count++
+				}
+			}
+			indices := earcut(flat)
+			for j := 0; j < len(indices); j += 3 {
+				m.Indices.Add([3]uint32{offset + indices[j], offset + indices[j+1], offset + indices[j+2]})
+			}
+			view.PolygonCounts = append(view.PolygonCounts, count)
+			view.PolygonIndexCounts = append(view.PolygonIndexCounts, uint32(len(indices)))
+			polygonIds = append(polygonIds, uint16(i))
+			minimum, maximum := r[4], r[5]
+			if s.minimumHeight != nil {
+				minimum, extruded = *s.minimumHeight, true
+			}
+			if s.maximumHeight != nil {
+				maximum, extruded = *s.maximumHeight, true
+			}
+			minimumHeights = append(minimumHeights, float32(minimum))
+			maximumHeights = append(maximumHeights, float32(maximum))
+		case VCTR_FEATURE_POLYLINE:
+			for _, p := range s.rings[0] {
+				q, err := r.quantize(p)
+				if err != nil {
+					return nil, newTileError(VCTR_MAGIC, "polylinePositions", -1, err)
+				}
+				m.Polylines.Add(q)
+			}
+			view.PolylineCounts = append(view.PolylineCounts, uint32(len(s.rings[0])))
+			polylineIds = append(polylineIds, uint16(i))
+			width := uint16(2)
+			if s.width != nil {
+				width, wide = *s.width, true
+			}
+			widths = append(widths, width)
+		case VCTR_FEATURE_POINT:
+			q, err := r.quantize(s.rings[0][0])
+			if err != nil {
+				return nil, newTileError(VCTR_MAGIC, "pointPositions", -1, err)
+			}
+			m.Points.Add(q)
+			pointIds = append(pointIds, uint16(i))
+		}
+	}
+	view.PolygonsLength = uint32(len(polygonIds))
+	view.PolylinesLength = uint32(len(polylineIds))
+	view.PointsLength = uint32(len(pointIds))
+	if len(polygonIds) > 0 {
+		view.PolygonBatchId = polygonIds
+	}
+	if len(polylineIds) > 0 {
+		view.PolylineBatchId = polylineIds
+	}
+	if len(pointIds) > 0 {
+		view.PointBatchId = pointIds
+	}
+	if extruded {
+		view.PolygonMinimumHeight, view.PolygonMaximumHeight = minimumHeights, maximumHeights
+	}
+	if wide {
+		view.PolylineWidths = widths
+	}
+	m.SetFeatureTable(view)
+
+	keys := make(map[string]struct{})
+	for _, s := range sources {
+		for k := range s.properties {
+			keys[k] = struct{}{}
+		}
+	}
+	m.BatchTable.Header = make(map[string]interface{})
+	m.BatchTable.Data = make(map[string]interface{})
+	for k := range keys {
+		values := make([]interface{}, len(sources))
+		for i, s := range sources {
+			values[i] = s.properties[k]
+		}
+		m.BatchTable.Header[k] = values
+		m.BatchTable.Data[k] = values
+	}
+	return m, nil
+}
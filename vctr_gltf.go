@@ -0,0 +1,220 @@
+package tile3d
+
+import (
+	"math"
+
+	"github.com/flywave/gltf"
+)
+
+// VctrGltfOptions controls how vector features are turned into meshes.
+type VctrGltfOptions struct {
+	// PolylineRibbons builds polylines as flat ribbons instead of lines.
+	PolylineRibbons bool
+	// RibbonWidth is the width of ribbons in meters.
+	RibbonWidth float64
+	// MetersPerPixel, when it is not 0, reinterprets POLYLINE_WIDTHS, which
+	// are screen space widths in pixels, as ribbon widths of that many
+	// meters per pixel. Otherwise every ribbon is RibbonWidth wide.
+	MetersPerPixel float64
+}
+
+func NewVctrGltfOptions() *VctrGltfOptions {
+	return &VctrGltfOptions{PolylineRibbons: true, RibbonWidth: 2}
+}
+
+func vctrCartesian(p [3]float64) [3]float64 {
+	return cartographicToCartesian(p[0]*math.Pi/180, p[1]*math.Pi/180, p[2])
+}
+
+// Center returns the Earth-centered, Earth-fixed center of the tile
+// REGION, which glTF positions are relative to.
+func (m *Vctr) Center() ([3]float64, error) {
+	view := m.GetFeatureTableView()
+	if view.Region == nil {
+		return [3]float64{}, newTileError(VCTR_MAGIC, VCTR_PROP_REGION, -1, ErrBadValue)
+	}
+	r := view.Region
+	return cartographicToCartesian((r[0]+r[2])/2, (r[1]+r[3])/2, (r[4]+r[5])/2), nil
+}
+
+func (g *batchedMesh) addVertex(p, center, n [3]float64, batchId uint16) uint32 {
+	g.positions = append(g.positions, [3]float32{float32(p[0] - center[0]), float32(p[1] - center[1]), float32(p[2] - center[2])})
+	g.normals = append(g.normals, [3]float32{float32(n[0]), float32(n[1]), float32(n[2])})
+	g.batchIds = append(g.batchIds, float32(batchId))
+	return uint32(len(g.positions) - 1)
+}
+
+// addPolygonVolume extrudes f between its minimum and maximum heights.
+// The walls follow the edges used by a single triangle, which are the outer
+// ring and the holes.
+func (g *batchedMesh) addPolygonVolume(f *VctrFeature, center [3]float64) {
+	bottom := make([][3]float64, len(f.Positions))
+	top := make([][3]float64, len(f.Positions))
+	up := make([][3]float64, len(f.Positions))
+	for i, p := range f.Positions {
+		bottom[i] = vctrCartesian([3]float64{p[0], p[1], f.MinimumHeight})
+		top[i] = vctrCartesian([3]float64{p[0], p[1], f.MaximumHeight})
+		up[i] = geodeticSurfaceNormal(top[i])
+	}
+	down := func(n [3]float64) [3]float64 { return [3]float64{-n[0], -n[1], -n[2]} }
+
+	// Triangles are wound counter-clockwise seen from above, so that
+	// boundary edges run with the outside on their right.
+	triangles := make([][3]uint32, len(f.Triangles))
+	for i, t := range f.Triangles {
+		a, b, c := top[t[0]], top[t[1]], top[t[2]]
+		n := cross64([3]float64{b[0] - a[0], b[1] - a[1], b[2] - a[2]}, [3]float64{c[0] - a[0], c[1] - a[1], c[2] - a[2]})
+		if n[0]*up[t[0]][0]+n[1]*up[t[0]][1]+n[2]*up[t[0]][2] < 0 {
+			t[1], t[2] = t[2], t[1]
+		}
+		triangles[i] = t
+	}
+
+	edges := make(map[[2]uint32]int)
+	for _, t := range triangles {
+		for k := 0; k < 3; k++ {
+			a, b := t[k], t[(k+1)%3]
+			if _, ok := edges[[2]uint32{b, a}]; ok {
+				delete(edges, [2]uint32{b, a})
+				continue
+			}
+			edges[[2]uint32{a, b}]++
+		}
+		var ti, bi [3]uint32
+		for k, v := range t {
+			ti[k] = g.addVertex(top[v], center, up[v], f.BatchId)
+			bi[k] = g.addVertex(bottom[v], center, down(up[v]), f.BatchId)
+		}
+		g.indices = append(g.indices, ti[0], ti[1], ti[2], bi[0], bi[2], bi[1])
+	}
+
+	// Walls are emitted in triangle order so the output is deterministic.
+	for _, t := range triangles {
+		for k := 0; k < 3; k++ {
+			a, b := t[k], t[(k+1)%3]
+			if edges[[2]uint32{a, b}] == 0 {
+				continue
+			}
+			delete(edges, [2]uint32{a, b})
+			n := normalize64(cross64(
+				[3]float64{bottom[b][0] - bottom[a][0], bottom[b][1] - bottom[a][1], bottom[b][2] - bottom[a][2]},
+				up[a],
+			))
+			i0 := g.addVertex(bottom[a], center, n, f.BatchId)
+			i1 := g.addVertex(bottom[b], center, n, f.BatchId)
+			i2 := g.addVertex(top[b], center, n, f.BatchId)
+			i3 := g.addVertex(top[a], center, n, f.BatchId)
+			g.indices = append(g.indices, i0, i1, i2, i0, i2, i3)
+		}
+	}
+}
+
+// addRibbon adds a flat strip of width meters along f, one quad per segment.
+func (g *batchedMesh) addRibbon(f *VctrFeature, center [3]float64, width float64) {
+	for i := 0; i+1 < len(f.Positions); i++ {
+		a, b := vctrCartesian(f.Positions[i]), vctrCartesian(f.Positions[i+1])
+		up := geodeticSurfaceNormal(a)
+		side := normalize64(cross64([3]float64{b[0] - a[0], b[1] - a[1], b[2] - a[2]}, up))
+		if side == [3]float64{} {
+			continue
+		}
+		h := width / 2
+		off := [3]float64{side[0] * h, side[1] * h, side[2] * h}
+		i0 := g.addVertex([3]float64{a[0] + off[0], a[1] + off[1], a[2] + off[2]}, center, up, f.BatchId)
+		i1 := g.addVertex([3]float64{b[0] + off[0], b[1] + off[1], b[2] + off[2]}, center, up, f.BatchId)
+		i2 := g.addVertex([3]float64{b[0] - off[0], b[1] - off[1], b[2] - off[2]}, center, up, f.BatchId)
+		i3 := g.addVertex([3]float64{a[0] - off[0], a[1] - off[1], a[2] - off[2]}, center, up, f.BatchId)
+		g.indices = append(g.indices, i0, i1, i2, i0, i2, i3)
+	}
+}
+
+func (g *batchedMesh) addLine(f *VctrFeature, center [3]float64) {
+	base := uint32(len(g.positions))
+	for _, p := range f.Positions {
+		g.addVertex(vctrCartesian(p), center, [3]float64{}, f.BatchId)
+	}
+	for i := 1; i < len(f.Positions); i++ {
+		g.indices = append(g.indices, base+uint32(i-1), base+uint32(i))
+	}
+}
+
+// ToGltf converts the features of m into a glTF mesh with a _BATCHID
+// attribute. Polygons become volumes between their minimum and maximum
+// heights, polylines ribbons or lines and points a point primitive.
+// Positions are relative to Center and converted to the y-up axis of glTF.
+func (m *Vctr) ToGltf(opts *VctrGltfOptions) (*gltf.Document, error) {
+	if opts == nil {
+		opts = NewVctrGltfOptions()
+	}
+	features, err := m.Features()
+	if err != nil {
+		return nil, err
+	}
+	center, err := m.Center()
+	if err != nil {
+		return nil, err
+	}
+
+	polygons, lines, points := &batchedMesh{}, &batchedMesh{}, &batchedMesh{}
+	for i := range features {
+		f := &features[i]
+		switch f.Type {
+		case VCTR_FEATURE_POLYGON:
+			polygons.addPolygonVolume(f, center)
+		case VCTR_FEATURE_POLYLINE:
+			if !opts.PolylineRibbons {
+				lines.addLine(f, center)
+				continue
+			}
+			width := opts.RibbonWidth
+			if f.Width != 0 && opts.MetersPerPixel != 0 {
+				width = float64(f.Width) * opts.MetersPerPixel
+			}
+			polygons.addRibbon(f, center, width)
+		case VCTR_FEATURE_POINT:
+			points.addVertex(vctrCartesian(f.Positions[0]), center, [3]float64{}, f.BatchId)
+		}
+	}
+
+	doc := gltf.NewDocument()
+	var prims []*gltf.Primitive
+	for _, p := range []struct {
+		mesh *batchedMesh
+		mode gltf.PrimitiveMode
+	}{
+		{polygons, gltf.PrimitiveTriangles},
+		{lines, gltf.PrimitiveLines},
+		{points, gltf.PrimitivePoints},
+	} {
+		if len(p.mesh.positions) == 0 {
+			continue
+		}
+		if p.mode != gltf.PrimitiveTriangles {
+			p.mesh.normals = nil
+		}
+		p.mesh.toYUp()
+		prims = append(prims, p.mesh.primitive(doc, p.mode))
+	}
+	if len(prims) > 0 {
+		setBatchedMesh(doc, prims...)
+	}
+	return doc, nil
+}
+
+// ToB3dm converts m into a b3dm with the same batch table and RTC_CENTER
+// set to Center.
+func (m *Vctr) ToB3dm(opts *VctrGltfOptions) (*B3dm, error) {
+	doc, err := m.ToGltf(opts)
+	if err != nil {
+		return nil, err
+	}
+	center, err := m.Center()
+	if err != nil {
+		return nil, err
+	}
+	b := NewB3dm()
+	b.SetFeatureTable(B3dmFeatureTableView{BatchLength: m.BatchLength(), RtcCenter: center[:]})
+	b.BatchTable = m.BatchTable
+	b.Model = doc
+	return b, nil
+}
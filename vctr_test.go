@@ -1,9 +1,15 @@
 package tile3d
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/flywave/gltf"
 )
 
 func TestRead(t *testing.T) {
@@ -21,3 +27,346 @@ func TestRead(t *testing.T) {
 	vt2 := &Vctr{}
 	vt2.Read(rd2)
 }
+
+func TestVctrFeatures(t *testing.T) {
+	region := [6]float64{0, 0, math.Pi / 180, math.Pi / 180, 10, 110}
+	m := &Vctr{}
+	m.SetFeatureTable(VctrFeatureTableView{
+		Region:               &region,
+		PolygonsLength:       1,
+		PolylinesLength:      1,
+		PointsLength:         2,
+		PolygonCounts:        []uint32{4},
+		PolygonIndexCounts:   []uint32{6},
+		PolygonMinimumHeight: []float32{20},
+		PolygonMaximumHeight: []float32{30},
+		PolylineCounts:       []uint32{2},
+		PolylineWidths:       []uint16{3},
+	})
+	for _, p := range [][2]int{{0, 0}, {VCTR_MAX_SHORT, 0}, {VCTR_MAX_SHORT, VCTR_MAX_SHORT}, {0, VCTR_MAX_SHORT}} {
+		m.Polygons.Add(p)
+	}
+	m.Indices.Add([3]uint32{0, 1, 2})
+	m.Indices.Add([3]uint32{0, 2, 3})
+	m.Polylines.Add([3]int{0, 0, 0})
+	m.Polylines.Add([3]int{VCTR_MAX_SHORT, VCTR_MAX_SHORT, VCTR_MAX_SHORT})
+	m.Points.Add([3]int{VCTR_MAX_SHORT / 2, 0, 0})
+	m.Points.Add([3]int{0, VCTR_MAX_SHORT, VCTR_MAX_SHORT})
+	m.BatchTable.Header = map[string]interface{}{"name": []interface{}{"a", "b", "c", "d"}}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := &Vctr{}
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	features, err := r.Features()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 4 {
+		t.Fatalf("features %d", len(features))
+	}
+	polygon := features[0]
+	if polygon.Type != VCTR_FEATURE_POLYGON || len(polygon.Positions) != 4 || len(polygon.Triangles) != 2 {
+		t.Fatalf("polygon %+v", polygon)
+	}
+	if math.Abs(polygon.Positions[2][0]-1) > 1e-9 || math.Abs(polygon.Positions[2][1]-1) > 1e-9 || polygon.MaximumHeight != 30 {
+		t.Errorf("polygon %+v", polygon)
+	}
+	line := features[1]
+	if line.Type != VCTR_FEATURE_POLYLINE || line.Width != 3 || line.Positions[0][2] != 10 || line.Positions[1][2] != 110 {
+		t.Errorf("polyline %+v", line)
+	}
+	point := features[3]
+	if point.Type != VCTR_FEATURE_POINT || point.BatchId != 3 || point.Properties["name"] != "d" || point.Positions[0][2] != 110 {
+		t.Errorf("point %+v", point)
+	}
+
+	var out bytes.Buffer
+	if err := r.WriteGeoJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Type string `json:"type"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &fc); err != nil {
+		t.Fatal(err)
+	}
+	types := []string{"Polygon", "LineString", "Point", "Point"}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 4 {
+		t.Fatalf("geojson %s", out.String())
+	}
+	for i, f := range fc.Features {
+		if f.Geometry.Type != types[i] {
+			t.Errorf("feature %d type %s", i, f.Geometry.Type)
+		}
+	}
+	if fc.Features[1].Properties["name"] != "b" {
+		t.Errorf("properties %v", fc.Features[1].Properties)
+	}
+}
+
+func TestVctrFromGeoJSON(t *testing.T) {
+	src := `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {"name": "park"}, "minimumHeight": 2, "maximumHeight": 20, "geometry": {"type": "Polygon", "coordinates": [
+			[[0, 0], [1, 0], [1, 1], [0, 1], [0, 0]],
+			[[0.25, 0.25], [0.25, 0.75], [0.75, 0.75], [0.75, 0.25], [0.25, 0.25]]
+		]}},
+		{"type": "Feature", "properties": {"name": "road", "lanes": 2}, "width": 3, "geometry": {"type": "LineString", "coordinates": [[0, 0, 5], [1, 1, 15]]}},
+		{"type": "Feature", "properties": {"name": "trees"}, "geometry": {"type": "MultiPoint", "coordinates": [[0.5, 0.5, 10], [0.1, 0.9, 10]]}}
+	]}`
+	m, err := NewVctrFromGeoJSON(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	view := m.GetFeatureTableView()
+	if view.PolygonsLength != 1 || view.PolylinesLength != 1 || view.PointsLength != 2 {
+		t.Fatalf("view %+v", view)
+	}
+	if view.PolygonIndexCounts[0] != 8*3 {
+		t.Errorf("polygon index count %d", view.PolygonIndexCounts[0])
+	}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := &Vctr{}
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	features, err := r.Features()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 4 {
+		t.Fatalf("features %d", len(features))
+	}
+	polygon := features[0]
+	if len(polygon.Positions) != 8 || len(polygon.Triangles) != 8 || polygon.Properties["name"] != "park" {
+		t.Errorf("polygon %+v", polygon)
+	}
+	if polygon.MinimumHeight != 2 || polygon.MaximumHeight != 20 {
+		t.Errorf("polygon heights %v %v", polygon.MinimumHeight, polygon.MaximumHeight)
+	}
+	line := features[1]
+	if line.Properties["lanes"] != 2.0 || math.Abs(line.Positions[1][0]-1) > 1e-6 || math.Abs(line.Positions[1][2]-15) > 1e-3 || line.Width != 3 {
+		t.Errorf("polyline %+v", line)
+	}
+
+	// Extrusion and widths survive a GeoJSON round trip.
+	var geojson bytes.Buffer
+	if err := r.WriteGeoJSON(&geojson); err != nil {
+		t.Fatal(err)
+	}
+	back, err := NewVctrFromGeoJSON(&geojson, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backFeatures, err := back.Features(); err != nil || backFeatures[0].MinimumHeight != 2 || backFeatures[0].MaximumHeight != 20 || backFeatures[1].Width != 3 {
+		t.Errorf("GeoJSON round trip %+v %v", backFeatures, err)
+	}
+	for _, f := range features[2:] {
+		if f.Properties["name"] != "trees" || f.Properties["lanes"] != nil {
+			t.Errorf("point %+v", f)
+		}
+	}
+	if math.Abs(features[3].Positions[0][0]-0.1) > 1e-4 || math.Abs(features[3].Positions[0][1]-0.9) > 1e-4 {
+		t.Errorf("point position %v", features[3].Positions[0])
+	}
+
+	region := [6]float64{0, 0, 0.001, 0.001, 0, 1}
+	if _, err := NewVctrFromGeoJSON(strings.NewReader(src), &region); err == nil {
+		t.Error("expected error for positions outside region")
+	}
+}
+
+func TestVctrToGltf(t *testing.T) {
+	src := `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {"id": 1}, "geometry": {"type": "Polygon", "coordinates": [[[0, 0, 0], [0.001, 0, 0], [0.001, 0.001, 0], [0, 0.001, 0], [0, 0, 0]]]}},
+		{"type": "Feature", "properties": {"id": 2}, "geometry": {"type": "LineString", "coordinates": [[0, 0, 10], [0.001, 0.001, 20]]}},
+		{"type": "Feature", "properties": {"id": 3}, "geometry": {"type": "Point", "coordinates": [0.0005, 0.0005, 5]}}
+	]}`
+	m, err := NewVctrFromGeoJSON(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := m.ToGltf(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prims := doc.Meshes[0].Primitives
+	if len(prims) != 2 || prims[1].Mode != gltf.PrimitivePoints {
+		t.Fatalf("primitives %d", len(prims))
+	}
+	if n := doc.Accessors[prims[0].Attributes[gltf.POSITION]].Count; n != 12+16+4 {
+		t.Errorf("triangle vertices %d", n)
+	}
+	if _, ok := prims[0].Attributes[GLTF_ATTR_BATCHID]; !ok {
+		t.Error("missing _BATCHID")
+	}
+
+	doc, err = m.ToGltf(&VctrGltfOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prims = doc.Meshes[0].Primitives
+	if len(prims) != 3 || prims[1].Mode != gltf.PrimitiveLines {
+		t.Fatalf("primitives %d", len(prims))
+	}
+
+	features, _ := m.Features()
+	center, _ := m.Center()
+	g := &batchedMesh{}
+	g.addPolygonVolume(&features[0], center)
+	var mid [3]float64
+	for _, p := range g.positions {
+		for i := range mid {
+			mid[i] += float64(p[i]) / float64(len(g.positions))
+		}
+	}
+	for i := 0; i < len(g.indices); i += 3 {
+		a, b, c := g.positions[g.indices[i]], g.positions[g.indices[i+1]], g.positions[g.indices[i+2]]
+		n := cross64(
+			[3]float64{float64(b[0] - a[0]), float64(b[1] - a[1]), float64(b[2] - a[2])},
+			[3]float64{float64(c[0] - a[0]), float64(c[1] - a[1]), float64(c[2] - a[2])},
+		)
+		d := [3]float64{float64(a[0]) - mid[0], float64(a[1]) - mid[1], float64(a[2]) - mid[2]}
+		if n[0]*d[0]+n[1]*d[1]+n[2]*d[2] <= 0 {
+			t.Fatalf("triangle %d faces inwards", i/3)
+		}
+	}
+
+	b, err := m.ToB3dm(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view := b.GetFeatureTableView(); view.BatchLength != 3 || len(view.RtcCenter) != 3 {
+		t.Errorf("b3dm view %+v", view)
+	}
+}
+
+func TestVctrSampleFeatures(t *testing.T) {
+	b, err := os.ReadFile("./data/tile.vctr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Vctr{}
+	if err := m.ReadFromBytes(b); err != nil {
+		t.Fatal(err)
+	}
+	features, err := m.Features()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 3 {
+		t.Fatalf("features %d", len(features))
+	}
+	types := []VctrFeatureType{VCTR_FEATURE_POLYGON, VCTR_FEATURE_POLYLINE, VCTR_FEATURE_POINT}
+	ids := []uint16{2, 1, 0}
+	for i, f := range features {
+		if f.Type != types[i] || f.BatchId != ids[i] {
+			t.Errorf("feature %d: type %d batch id %d", i, f.Type, f.BatchId)
+		}
+	}
+	if p := features[0].Positions[1]; math.Abs(p[0]+0.02) > 1e-9 || math.Abs(p[1]+0.01) > 1e-9 {
+		t.Errorf("polygon position %v", p)
+	}
+
+	b, err = os.ReadFile("./data/polygon_children.vctr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = &Vctr{}
+	if err := m.ReadFromBytes(b); err != nil {
+		t.Fatal(err)
+	}
+	if features, err = m.Features(); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"upper left", "upper right", "lower right", "lower left"}
+	for i, f := range features {
+		if f.Properties["name"] != names[i] {
+			t.Errorf("feature %d name %v", i, f.Properties["name"])
+		}
+	}
+}
+
+func TestVctrGeoJSONHoles(t *testing.T) {
+	src := `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {"name": "park"}, "geometry": {"type": "Polygon", "coordinates": [
+			[[0, 0], [1, 0], [1, 1], [0, 1], [0, 0]],
+			[[0.25, 0.25], [0.25, 0.75], [0.75, 0.75], [0.75, 0.25], [0.25, 0.25]]
+		]}}
+	]}`
+	m, err := NewVctrFromGeoJSON(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := &Vctr{}
+	if err := r.Read(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := r.WriteGeoJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Features []struct {
+			Geometry struct {
+				Type        string         `json:"type"`
+				Coordinates [][][2]float64 `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatal(err)
+	}
+	g := fc.Features[0].Geometry
+	if g.Type != "Polygon" || len(g.Coordinates) != 2 {
+		t.Fatalf("geometry %+v", g)
+	}
+	// The outer ring is counter-clockwise and the hole clockwise, within
+	// the quantization of the tile.
+	areas := []float64{1, -0.25}
+	corners := [][][2]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 1}}, {{0.25, 0.25}, {0.25, 0.75}, {0.75, 0.75}, {0.75, 0.25}}}
+	for i, ring := range g.Coordinates {
+		if len(ring) != 5 || ring[0] != ring[4] || math.Abs(ringArea(ring)-areas[i]) > 1e-4 {
+			t.Errorf("ring %d %v", i, ring)
+			continue
+		}
+		for _, c := range corners[i] {
+			found := false
+			for _, p := range ring {
+				found = found || math.Abs(p[0]-c[0]) < 1e-4 && math.Abs(p[1]-c[1]) < 1e-4
+			}
+			if !found {
+				t.Errorf("ring %d %v misses %v", i, ring, c)
+			}
+		}
+	}
+
+	// Two outer rings are a MultiPolygon.
+	f := VctrFeature{
+		Type:      VCTR_FEATURE_POLYGON,
+		Positions: [][3]float64{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {2, 0, 0}, {3, 0, 0}, {2, 1, 0}},
+		Triangles: [][3]uint32{{0, 2, 1}, {3, 4, 5}},
+	}
+	if polygons := vctrPolygonRings(&f); len(polygons) != 2 || len(polygons[0]) != 1 || len(polygons[1][0]) != 4 || ringArea(polygons[0][0]) <= 0 {
+		t.Errorf("polygons %v", polygons)
+	}
+}
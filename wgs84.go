@@ -0,0 +1,69 @@
+package tile3d
+
+import "math"
+
+const (
+	WGS84_RADIUS_X = 6378137.0
+	WGS84_RADIUS_Y = 6378137.0
+	WGS84_RADIUS_Z = 6356752.3142451793
+)
+
+func normalize64(v [3]float64) [3]float64 {
+	l := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	if l == 0 {
+		return v
+	}
+	return [3]float64{v[0] / l, v[1] / l, v[2] / l}
+}
+
+func cross64(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+// geodeticSurfaceNormal returns the WGS84 ellipsoid normal through the
+// Earth-centered, Earth-fixed position p.
+func geodeticSurfaceNormal(p [3]float64) [3]float64 {
+	return normalize64([3]float64{
+		p[0] / (WGS84_RADIUS_X * WGS84_RADIUS_X),
+		p[1] / (WGS84_RADIUS_Y * WGS84_RADIUS_Y),
+		p[2] / (WGS84_RADIUS_Z * WGS84_RADIUS_Z),
+	})
+}
+
+// eastNorthUp returns the east, north and up axes of the local frame at
+// the Earth-centered, Earth-fixed position p.
+func eastNorthUp(p [3]float64) [3][3]float64 {
+	if math.Abs(p[0]) < smallMetricDistance && math.Abs(p[1]) < smallMetricDistance {
+		sign := 1.0
+		if p[2] < 0 {
+			sign = -1
+		}
+		return [3][3]float64{{0, 1, 0}, {-sign, 0, 0}, {0, 0, sign}}
+	}
+	up := geodeticSurfaceNormal(p)
+	east := normalize64([3]float64{-p[1], p[0], 0})
+	north := cross64(up, east)
+	return [3][3]float64{east, north, up}
+}
+
+// cartographicToCartesian returns the Earth-centered, Earth-fixed position
+// of longitude and latitude in radians and height in meters.
+func cartographicToCartesian(lon, lat, height float64) [3]float64 {
+	cosLat := math.Cos(lat)
+	n := [3]float64{cosLat * math.Cos(lon), cosLat * math.Sin(lon), math.Sin(lat)}
+	k := [3]float64{
+		WGS84_RADIUS_X * WGS84_RADIUS_X * n[0],
+		WGS84_RADIUS_Y * WGS84_RADIUS_Y * n[1],
+		WGS84_RADIUS_Z * WGS84_RADIUS_Z * n[2],
+	}
+	gamma := math.Sqrt(n[0]*k[0] + n[1]*k[1] + n[2]*k[2])
+	return [3]float64{
+		k[0]/gamma + n[0]*height,
+		k[1]/gamma + n[1]*height,
+		k[2]/gamma + n[2]*height,
+	}
+}